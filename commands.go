@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"YeahMusic/internal/analysis"
+	"YeahMusic/internal/migrate"
+	"YeahMusic/internal/oidc"
+	"YeahMusic/internal/services"
+	"YeahMusic/internal/storage"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// runCommand handles `YeahMusic <command> [flags]`; without a command the
+// binary starts the web server as before.
+func runCommand(name string, args []string) int {
+	switch name {
+	case "migrate":
+		return migrateCmd(args)
+	case "migrate-legacy":
+		return migrateLegacyCmd(args)
+	case "set-role":
+		return setRoleCmd(args)
+	case "reset-2fa":
+		return reset2FACmd(args)
+	case "mock-idp":
+		return mockIdPCmd(args)
+	case "gc-uploads":
+		return gcUploadsCmd(args)
+	case "mock-s3":
+		return mockS3Cmd(args)
+	case "measure-loudness":
+		return measureLoudnessCmd(args)
+	case "probe-gapless":
+		return probeGaplessCmd(args)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+		fmt.Fprintln(os.Stderr, "commands: migrate, migrate-legacy, set-role, reset-2fa, mock-idp, gc-uploads, mock-s3, measure-loudness, probe-gapless")
+		return 2
+	}
+}
+
+func connectMongo() (*mongo.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
+	defer cancel()
+
+	c, err := mongo.Connect(ctx, options.Client().ApplyURI(getMongoURI()))
+	if err != nil {
+		return nil, err
+	}
+	if err := c.Ping(ctx, nil); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func migrateCmd(args []string) int {
+	fl := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dbName := fl.String("db", getMongoDBName(), "database to migrate")
+	fl.Usage = func() {
+		fmt.Fprintln(fl.Output(), "usage: migrate [-db name] [up|status|check]")
+		fl.PrintDefaults()
+	}
+	_ = fl.Parse(args)
+
+	c, err := connectMongo()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mongo:", err)
+		return 1
+	}
+	defer c.Disconnect(context.Background())
+
+	r := migrate.NewRunner(c.Database(*dbName))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	switch fl.Arg(0) {
+	case "", "up":
+		applied, err := r.Up(ctx)
+		for _, st := range applied {
+			fmt.Printf("applied %d %s\n", st.Version, st.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate:", err)
+			return 1
+		}
+		if len(applied) == 0 {
+			fmt.Println("up to date")
+		}
+	case "status":
+		applied, err := r.Applied(ctx)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "migrate:", err)
+			return 1
+		}
+		done := map[int]bool{}
+		for _, a := range applied {
+			done[a.Version] = true
+			fmt.Printf("applied  %d %s (%s)\n", a.Version, a.Name, a.AppliedAt.Format(time.RFC3339))
+		}
+		for _, st := range migrate.Steps {
+			if !done[st.Version] {
+				fmt.Printf("pending  %d %s\n", st.Version, st.Name)
+			}
+		}
+	case "check":
+		if err := r.Check(ctx); err != nil {
+			fmt.Fprintln(os.Stderr, "migrate:", err)
+			return 1
+		}
+		fmt.Println("up to date")
+	default:
+		fl.Usage()
+		return 2
+	}
+	return 0
+}
+
+func migrateLegacyCmd(args []string) int {
+	fl := flag.NewFlagSet("migrate-legacy", flag.ExitOnError)
+	dryRun := fl.Bool("dry-run", false, "report what would change without writing")
+	rollback := fl.Bool("rollback", false, "undo a previous migration")
+	source := fl.String("source", getMongoDBName(), "legacy database written by main.go")
+	target := fl.String("target", "YeahMusic", "database used by internal/services")
+	_ = fl.Parse(args)
+
+	c, err := connectMongo()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mongo:", err)
+		return 1
+	}
+	defer c.Disconnect(context.Background())
+
+	m := migrate.NewLegacyMigrator(c.Database(*source), c.Database(*target))
+	ctx := context.Background()
+
+	var rep *migrate.LegacyReport
+	if *rollback {
+		rep, err = m.Rollback(ctx, *dryRun)
+	} else {
+		rep, err = m.Run(ctx, *dryRun)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "migrate-legacy:", err)
+		return 1
+	}
+	fmt.Print(rep.String())
+	return 0
+}
+
+// setRoleCmd is how the first admin gets made; sign-up never grants more
+// than a pending artist request.
+func setRoleCmd(args []string) int {
+	fl := flag.NewFlagSet("set-role", flag.ExitOnError)
+	email := fl.String("email", "", "account to change")
+	role := fl.String("role", "", "user, artist or admin")
+	legacy := fl.Bool("legacy", false, "change the account in the database of the legacy server instead of STORE_BACKEND")
+	_ = fl.Parse(args)
+	if *email == "" || !services.ValidRole(*role) {
+		fl.Usage()
+		return 2
+	}
+
+	var repo services.Repository
+	if *legacy {
+		c, err := connectMongo()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "mongo:", err)
+			return 1
+		}
+		repo = services.NewStoreWithDatabase(c.Database(getMongoDBName()))
+	} else {
+		r, err := services.OpenRepository(services.StoreConfigFromEnv())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "store:", err)
+			return 1
+		}
+		repo = r
+	}
+	defer repo.Close()
+
+	u, err := services.NewAdminService(repo).SetRole(*email, *role)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "set-role:", err)
+		return 1
+	}
+	fmt.Printf("%s is now %s\n", u.Email, u.Role)
+	return 0
+}
+
+// reset2FACmd is the way back in for an admin who lost both the
+// authenticator and the recovery codes.
+func reset2FACmd(args []string) int {
+	fl := flag.NewFlagSet("reset-2fa", flag.ExitOnError)
+	email := fl.String("email", "", "account to reset")
+	_ = fl.Parse(args)
+	if *email == "" {
+		fl.Usage()
+		return 2
+	}
+
+	repo, err := services.OpenRepository(services.StoreConfigFromEnv())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "store:", err)
+		return 1
+	}
+	defer repo.Close()
+
+	u, err := repo.GetUserByEmail(strings.ToLower(strings.TrimSpace(*email)))
+	if err == nil {
+		u, err = services.NewAdminService(repo).ResetTOTP(u.ID)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "reset-2fa:", err)
+		return 1
+	}
+	fmt.Printf("two-factor authentication is off for %s\n", u.Email)
+	return 0
+}
+
+// gcUploadsCmd removes the uploads no track, album or playlist uses any
+// more from the blob store BLOB_STORE picks. The legacy server and
+// STORE_BACKEND share it, so the references of both count; nothing is
+// removed when either cannot be read.
+func gcUploadsCmd(args []string) int {
+	fl := flag.NewFlagSet("gc-uploads", flag.ExitOnError)
+	dryRun := fl.Bool("dry-run", false, "report what would be removed without removing it")
+	grace := fl.Duration("grace", 24*time.Hour, "keep unreferenced files written more recently than this")
+	dir := fl.String("dir", getUploadDir(), "upload directory of the local blob store")
+	legacyDB := fl.String("legacy-db", getMongoDBName(), "database of the legacy server whose references count too; empty to skip")
+	_ = fl.Parse(args)
+
+	repo, err := services.OpenRepository(services.StoreConfigFromEnv())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "store:", err)
+		return 1
+	}
+	defer repo.Close()
+	refs, err := repo.MediaRefs()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gc-uploads:", err)
+		return 1
+	}
+
+	if *legacyDB != "" {
+		c, err := connectMongo()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "mongo (use -legacy-db= without a legacy server):", err)
+			return 1
+		}
+		defer c.Disconnect(context.Background())
+		legacy, err := services.NewStoreWithDatabase(c.Database(*legacyDB)).MediaRefs()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "gc-uploads:", err)
+			return 1
+		}
+		for u, n := range legacy {
+			refs[u] += n
+		}
+	}
+
+	store, err := storage.FromEnv(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "blob store:", err)
+		return 1
+	}
+	rep, err := storage.Collect(store, refs, *grace, *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gc-uploads:", err)
+		if rep == nil {
+			return 1
+		}
+	}
+	verb := "removed"
+	if rep.DryRun {
+		verb = "would remove"
+	}
+	for _, o := range rep.Removed {
+		fmt.Printf("%s %s (%d bytes, %s old)\n", verb, o.Name, o.Size, time.Since(o.ModTime).Round(time.Minute))
+	}
+	for _, o := range rep.Young {
+		fmt.Printf("keeping %s for now, written %s ago\n", o.Name, time.Since(o.ModTime).Round(time.Second))
+	}
+	fmt.Printf("%d files, %d in use, %d unreferenced within the grace period; %s %d files, %d bytes\n",
+		rep.Scanned, rep.Referenced, len(rep.Young), verb, len(rep.Removed), rep.Freed)
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// measureLoudnessCmd measures the tracks uploaded before loudness was, and
+// their albums.
+func measureLoudnessCmd(args []string) int {
+	measured, skipped, failed := 0, 0, 0
+	ok := backfill("measure-loudness", "measure tracks that were measured before again", args,
+		func(c *services.CatalogService, blobs storage.BlobStore, all bool) {
+			c.BackfillLoudness(blobs, all, func(what string, err error) {
+				switch {
+				case errors.Is(err, analysis.ErrUnsupported):
+					skipped++
+				case err != nil:
+					failed++
+					fmt.Printf("%s: %v\n", what, err)
+				default:
+					measured++
+					fmt.Println("measured", what)
+				}
+			})
+		})
+	if !ok {
+		return 1
+	}
+	fmt.Printf("%d measured, %d skipped as not MP3s here, %d failed\n", measured, skipped, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// probeGaplessCmd reads the gapless info of the MP3s uploaded before it was.
+func probeGaplessCmd(args []string) int {
+	found, none, skipped, failed := 0, 0, 0, 0
+	ok := backfill("probe-gapless", "probe tracks that have gapless info already again", args,
+		func(c *services.CatalogService, blobs storage.BlobStore, all bool) {
+			c.BackfillGapless(blobs, all, func(what string, err error) {
+				switch {
+				case errors.Is(err, analysis.ErrUnsupported):
+					skipped++
+				case errors.Is(err, services.ErrNoGapless):
+					none++
+				case err != nil:
+					failed++
+					fmt.Printf("%s: %v\n", what, err)
+				default:
+					found++
+					fmt.Println("probed", what)
+				}
+			})
+		})
+	if !ok {
+		return 1
+	}
+	fmt.Printf("%d with gapless info, %d without a LAME tag, %d skipped as not MP3s here, %d failed\n",
+		found, none, skipped, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// backfill runs fn on the catalog of the store and on that of the legacy
+// database, for the commands that work out what older uploads lack. It
+// reports false when those cannot be opened.
+func backfill(name, allUsage string, args []string, fn func(c *services.CatalogService, blobs storage.BlobStore, all bool)) bool {
+	fl := flag.NewFlagSet(name, flag.ExitOnError)
+	all := fl.Bool("all", false, allUsage)
+	dir := fl.String("dir", getUploadDir(), "upload directory of the local blob store")
+	legacyDB := fl.String("legacy-db", getMongoDBName(), "database of the legacy server whose tracks to do too; empty to skip")
+	_ = fl.Parse(args)
+
+	blobs, err := storage.FromEnv(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "blob store:", err)
+		return false
+	}
+	repo, err := services.OpenRepository(services.StoreConfigFromEnv())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "store:", err)
+		return false
+	}
+	defer repo.Close()
+	catalogs := []*services.CatalogService{services.NewCatalogService(repo)}
+
+	if *legacyDB != "" {
+		c, err := connectMongo()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "mongo (use -legacy-db= without a legacy server):", err)
+			return false
+		}
+		defer c.Disconnect(context.Background())
+		catalogs = append(catalogs, services.NewCatalogService(services.NewStoreWithDatabase(c.Database(*legacyDB))))
+	}
+
+	for _, c := range catalogs {
+		fn(c, blobs, *all)
+	}
+	return true
+}
+
+// mockIdPCmd runs a local OpenID provider that signs in anyone, for trying
+// the social login flow without registering an app anywhere.
+func mockIdPCmd(args []string) int {
+	fl := flag.NewFlagSet("mock-idp", flag.ExitOnError)
+	addr := fl.String("addr", "localhost:9400", "listen address")
+	clientID := fl.String("client-id", "yeahmusic", "client id the app is configured with")
+	email := fl.String("email", "mock.user@example.com", "address to sign in as when the request has no login_hint")
+	_ = fl.Parse(args)
+
+	p, err := oidc.NewMockProvider("http://"+*addr, *clientID)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "mock-idp:", err)
+		return 1
+	}
+	p.Email = *email
+	fmt.Println("configure the app with:")
+	fmt.Println("  OIDC_PROVIDERS=mock")
+	fmt.Printf("  OIDC_MOCK_ISSUER=%s\n", p.Issuer)
+	fmt.Printf("  OIDC_MOCK_CLIENT_ID=%s\n", *clientID)
+	if err := http.ListenAndServe(*addr, p); err != nil {
+		fmt.Fprintln(os.Stderr, "mock-idp:", err)
+		return 1
+	}
+	return 0
+}
+
+// mockS3Cmd runs an in-memory S3 stand-in, for trying BLOB_STORE=s3 without
+// a bucket anywhere. Its content is gone when it stops.
+func mockS3Cmd(args []string) int {
+	fl := flag.NewFlagSet("mock-s3", flag.ExitOnError)
+	addr := fl.String("addr", "localhost:9500", "listen address")
+	bucket := fl.String("bucket", "yeahmusic", "bucket to serve")
+	accessKey := fl.String("access-key", "mock", "access key id requests are signed with")
+	secretKey := fl.String("secret-key", "mock-secret", "secret access key")
+	_ = fl.Parse(args)
+
+	m := storage.NewMockS3(*bucket, *accessKey, *secretKey)
+	fmt.Println("configure the app with:")
+	fmt.Println("  BLOB_STORE=s3")
+	fmt.Printf("  S3_ENDPOINT=http://%s\n", *addr)
+	fmt.Printf("  S3_BUCKET=%s\n", *bucket)
+	fmt.Printf("  S3_ACCESS_KEY_ID=%s\n", *accessKey)
+	fmt.Printf("  S3_SECRET_ACCESS_KEY=%s\n", *secretKey)
+	if err := http.ListenAndServe(*addr, m); err != nil {
+		fmt.Fprintln(os.Stderr, "mock-s3:", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,151 @@
+// Package analysis decodes an uploaded track once for everything worked out
+// from its audio: the waveform peaks and the loudness measure. Both are kept
+// as blobs next to the audio blob.
+package analysis
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"YeahMusic/internal/audio"
+	"YeahMusic/internal/loudness"
+	"YeahMusic/internal/storage"
+	"YeahMusic/internal/waveform"
+)
+
+// ErrUnsupported is returned for audio other than MP3, which is not decoded.
+var ErrUnsupported = errors.New("analysis: only MP3s are decoded")
+
+// Peaks returns the stored peaks of the audio blob at waveform.Level(spp).
+// It never decodes: peaks that are not there yet, because Run has not got
+// to the blob, come back as os.ErrNotExist.
+func Peaks(s storage.BlobStore, audio string, spp int) (*waveform.Peaks, error) {
+	b, err := read(s, waveform.Name(audio, waveform.Level(spp)))
+	if err != nil {
+		return nil, err
+	}
+	p := &waveform.Peaks{}
+	return p, p.UnmarshalBinary(b)
+}
+
+// Measure returns the loudness measure of the audio blob, analysing it
+// first when it is not stored yet.
+func Measure(s storage.BlobStore, audio string) (*loudness.Measure, error) {
+	b, err := read(s, loudness.Name(audio))
+	if errors.Is(err, os.ErrNotExist) {
+		if err = Run(s, audio); err == nil {
+			b, err = read(s, loudness.Name(audio))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &loudness.Measure{}
+	return m, m.UnmarshalBinary(b)
+}
+
+func read(s storage.BlobStore, name string) ([]byte, error) {
+	f, _, err := s.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// slots bounds the uploads decoded at once, so a busy day of uploads does
+// not take every CPU.
+var slots = make(chan struct{}, 2)
+
+type call struct {
+	done chan struct{}
+	err  error
+}
+
+var (
+	mu    sync.Mutex
+	calls = map[string]*call{}
+)
+
+// Run stores the peaks and the loudness measure of the audio blob unless
+// they are there already. Calls for a blob being decoded wait for that.
+func Run(s storage.BlobStore, audio string) error {
+	if !strings.EqualFold(filepath.Ext(audio), ".mp3") {
+		return ErrUnsupported
+	}
+	mu.Lock()
+	if c, ok := calls[audio]; ok {
+		mu.Unlock()
+		<-c.done
+		return c.err
+	}
+	c := &call{done: make(chan struct{})}
+	calls[audio] = c
+	mu.Unlock()
+
+	c.err = run(s, audio)
+	mu.Lock()
+	delete(calls, audio)
+	mu.Unlock()
+	close(c.done)
+	return c.err
+}
+
+func run(s storage.BlobStore, name string) error {
+	// The measure is written last and says the peaks are there too.
+	if _, err := s.Stat(loudness.Name(name)); err == nil {
+		return nil
+	}
+	slots <- struct{}{}
+	defer func() { <-slots }()
+
+	f, info, err := s.Get(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// The decoder doubles mono, which the meter has to know.
+	probed, err := audio.Probe(storage.ReaderAt(f), info.Size)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	d, err := audio.NewDecoder(bufio.NewReaderSize(f, 64<<10))
+	if err != nil {
+		return err
+	}
+	peaks := waveform.NewBuilder(d.SampleRate())
+	meter := loudness.NewMeter(d.SampleRate(), probed.Channels)
+	buf := make([]int16, 2*4096)
+	for {
+		n, err := d.Read(buf)
+		peaks.Write(buf[:n])
+		meter.Write(buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	levels, err := peaks.Peaks()
+	if err != nil {
+		return err
+	}
+	for _, p := range levels {
+		b, _ := p.MarshalBinary()
+		if err := s.WriteFile(waveform.Name(name, p.SamplesPerPixel), b); err != nil {
+			return err
+		}
+	}
+	b, _ := meter.Measure().MarshalBinary()
+	return s.WriteFile(loudness.Name(name), b)
+}
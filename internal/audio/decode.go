@@ -0,0 +1,48 @@
+package audio
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+// Decoder turns an MP3 into 16-bit samples, interleaved as left and right;
+// mono files come out as two equal channels.
+type Decoder struct {
+	d   *mp3.Decoder
+	buf []byte
+}
+
+// NewDecoder starts decoding r past its ID3v2 tag.
+func NewDecoder(r io.Reader) (*Decoder, error) {
+	// Hidden from mp3 so it does not read the whole file for a length
+	// first.
+	d, err := mp3.NewDecoder(struct{ io.Reader }{r})
+	if err != nil {
+		return nil, err
+	}
+	return &Decoder{d: d}, nil
+}
+
+func (d *Decoder) SampleRate() int { return d.d.SampleRate() }
+
+// Read decodes up to len(p) samples, an even number, into p.
+func (d *Decoder) Read(p []int16) (int, error) {
+	n := len(p) &^ 1
+	if cap(d.buf) < 2*n {
+		d.buf = make([]byte, 2*n)
+	}
+	b, err := io.ReadFull(d.d, d.buf[:2*n])
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	b &^= 3
+	for i := 0; i < b/2; i++ {
+		p[i] = int16(binary.LittleEndian.Uint16(d.buf[2*i:]))
+	}
+	if b == 0 && err == nil {
+		err = io.EOF
+	}
+	return b / 2, err
+}
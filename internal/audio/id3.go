@@ -0,0 +1,328 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Picture is an embedded cover image (APIC).
+type Picture struct {
+	MIME string
+	Data []byte
+}
+
+// Tags is what ID3 tags say about a track. Zero values mean absent.
+type Tags struct {
+	Title   string
+	Artist  string
+	Album   string
+	Genre   string
+	Track   int
+	Year    int
+	Lyrics  string
+	Picture *Picture
+}
+
+// merge fills the fields t lacks from o.
+func (t *Tags) merge(o *Tags) {
+	if t.Title == "" {
+		t.Title = o.Title
+	}
+	if t.Artist == "" {
+		t.Artist = o.Artist
+	}
+	if t.Album == "" {
+		t.Album = o.Album
+	}
+	if t.Genre == "" {
+		t.Genre = o.Genre
+	}
+	if t.Track == 0 {
+		t.Track = o.Track
+	}
+	if t.Year == 0 {
+		t.Year = o.Year
+	}
+	if t.Lyrics == "" {
+		t.Lyrics = o.Lyrics
+	}
+	if t.Picture == nil {
+		t.Picture = o.Picture
+	}
+}
+
+// maxTagSize bounds what is read for an ID3v2 tag; covers beyond that are
+// not worth the memory.
+const maxTagSize = 16 << 20
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// id3v2Size returns the full size of the ID3v2 tag at the start of hdr, or
+// 0 when there is none.
+func id3v2Size(hdr []byte) int {
+	if len(hdr) < 10 || string(hdr[:3]) != "ID3" || hdr[3] == 0xff || hdr[4] == 0xff {
+		return 0
+	}
+	n := 10 + syncsafe(hdr[6:10])
+	if hdr[5]&0x10 != 0 { // footer
+		n += 10
+	}
+	return n
+}
+
+// removeUnsync undoes the unsynchronisation scheme: every 0xFF 0x00 was a
+// plain 0xFF.
+func removeUnsync(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+// readID3v2 parses versions 2.3 and 2.4. Older and newer tags are skipped
+// without error.
+func readID3v2(r io.Reader) (*Tags, int, error) {
+	hdr := make([]byte, 10)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, 0, err
+	}
+	size := id3v2Size(hdr)
+	if size == 0 {
+		return nil, 0, nil
+	}
+	body := size - 10
+	if hdr[5]&0x10 != 0 {
+		body -= 10
+	}
+	ver, flags := hdr[3], hdr[5]
+	if body > maxTagSize || (ver != 3 && ver != 4) {
+		return nil, size, nil
+	}
+	b := make([]byte, body)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, size, err
+	}
+	if ver == 3 && flags&0x80 != 0 {
+		b = removeUnsync(b)
+	}
+	if flags&0x40 != 0 && len(b) >= 4 { // extended header
+		n := int(binary.BigEndian.Uint32(b))
+		if ver == 4 {
+			n = syncsafe(b)
+		} else {
+			n += 4
+		}
+		if n > len(b) {
+			return nil, size, nil
+		}
+		b = b[n:]
+	}
+
+	t := &Tags{}
+	for len(b) >= 10 && b[0] != 0 {
+		id := string(b[:4])
+		n := int(binary.BigEndian.Uint32(b[4:8]))
+		if ver == 4 {
+			n = syncsafe(b[4:8])
+		}
+		fflags := b[9]
+		if n < 0 || 10+n > len(b) {
+			break
+		}
+		data := b[10 : 10+n]
+		b = b[10+n:]
+		if ver == 3 && fflags&0xc0 != 0 || ver == 4 && fflags&0x0c != 0 {
+			continue // compressed or encrypted
+		}
+		if ver == 4 {
+			if fflags&0x01 != 0 && len(data) >= 4 { // data length indicator
+				data = data[4:]
+			}
+			if fflags&0x02 != 0 || flags&0x80 != 0 {
+				data = removeUnsync(data)
+			}
+		}
+		t.frame(id, data)
+	}
+	return t, size, nil
+}
+
+func (t *Tags) frame(id string, data []byte) {
+	switch id {
+	case "TIT2":
+		t.Title = textFrame(data)
+	case "TPE1":
+		t.Artist = textFrame(data)
+	case "TALB":
+		t.Album = textFrame(data)
+	case "TCON":
+		t.Genre = genreName(textFrame(data))
+	case "TRCK":
+		n, _, _ := strings.Cut(textFrame(data), "/")
+		t.Track, _ = strconv.Atoi(strings.TrimSpace(n))
+	case "TYER", "TDRC":
+		if y := textFrame(data); len(y) >= 4 {
+			t.Year, _ = strconv.Atoi(y[:4])
+		}
+	case "USLT":
+		// encoding, language[3], descriptor, text
+		if len(data) > 4 && t.Lyrics == "" {
+			enc := data[0]
+			_, rest := splitTerminated(enc, data[4:])
+			t.Lyrics = strings.TrimSpace(decodeText(enc, rest))
+		}
+	case "APIC":
+		// encoding, mime\0, picture type, description, data
+		if len(data) < 4 {
+			return
+		}
+		enc := data[0]
+		mime, rest, ok := bytes.Cut(data[1:], []byte{0})
+		if !ok || len(rest) < 2 {
+			return
+		}
+		kind := rest[0]
+		_, img := splitTerminated(enc, rest[1:])
+		if len(img) == 0 {
+			return
+		}
+		// Prefer the front cover (type 3) over whatever came first.
+		if t.Picture != nil && kind != 3 {
+			return
+		}
+		m := strings.ToLower(string(mime))
+		switch m {
+		case "", "image/jpg", "jpg", "jpeg":
+			m = "image/jpeg"
+		case "png":
+			m = "image/png"
+		}
+		t.Picture = &Picture{MIME: m, Data: img}
+	}
+}
+
+// splitTerminated cuts a string terminated by the null of encoding enc
+// (two bytes for UTF-16) off the front of b.
+func splitTerminated(enc byte, b []byte) (string, []byte) {
+	if enc == 1 || enc == 2 {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return decodeText(enc, b[:i]), b[i+2:]
+			}
+		}
+		return decodeText(enc, b), nil
+	}
+	s, rest, _ := bytes.Cut(b, []byte{0})
+	return decodeText(enc, s), rest
+}
+
+// textFrame decodes a T*** frame. 2.4 allows several null separated
+// values; the first one is used.
+func textFrame(data []byte) string {
+	if len(data) < 1 {
+		return ""
+	}
+	s, _ := splitTerminated(data[0], data[1:])
+	return strings.TrimSpace(s)
+}
+
+func decodeText(enc byte, b []byte) string {
+	switch enc {
+	case 0:
+		return latin1(b)
+	case 1, 2:
+		be := enc == 2
+		if len(b) >= 2 {
+			switch {
+			case b[0] == 0xff && b[1] == 0xfe:
+				be, b = false, b[2:]
+			case b[0] == 0xfe && b[1] == 0xff:
+				be, b = true, b[2:]
+			}
+		}
+		u := make([]uint16, len(b)/2)
+		for i := range u {
+			if be {
+				u[i] = binary.BigEndian.Uint16(b[2*i:])
+			} else {
+				u[i] = binary.LittleEndian.Uint16(b[2*i:])
+			}
+		}
+		return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+	default:
+		return strings.TrimRight(string(b), "\x00")
+	}
+}
+
+func latin1(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return strings.TrimRight(string(r), "\x00")
+}
+
+// readID3v1 parses the 128 byte tag at the end of a file, including the
+// v1.1 track number.
+func readID3v1(b []byte) *Tags {
+	if len(b) != 128 || string(b[:3]) != "TAG" {
+		return nil
+	}
+	field := func(s []byte) string { return strings.TrimSpace(latin1(bytes.TrimRight(s, "\x00 "))) }
+	t := &Tags{
+		Title:  field(b[3:33]),
+		Artist: field(b[33:63]),
+		Album:  field(b[63:93]),
+	}
+	t.Year, _ = strconv.Atoi(field(b[93:97]))
+	if b[125] == 0 && b[126] != 0 {
+		t.Track = int(b[126])
+	}
+	if int(b[127]) < len(genres) {
+		t.Genre = genres[b[127]]
+	}
+	return t
+}
+
+// genreName resolves the "(17)" and "17" references of ID3v2 TCON frames.
+func genreName(s string) string {
+	ref := s
+	if strings.HasPrefix(s, "(") {
+		if i := strings.IndexByte(s, ')'); i > 0 {
+			if rest := strings.TrimSpace(s[i+1:]); rest != "" {
+				return rest
+			}
+			ref = s[1:i]
+		}
+	}
+	if n, err := strconv.Atoi(ref); err == nil {
+		if n >= 0 && n < len(genres) {
+			return genres[n]
+		}
+		return ""
+	}
+	return s
+}
+
+// genres are the ID3v1 genres and the first Winamp extensions.
+var genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"Alternative Rock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychedelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebop", "Latin", "Revival",
+	"Celtic", "Bluegrass", "Avantgarde", "Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock",
+	"Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour", "Speech", "Chanson", "Opera",
+	"Chamber Music", "Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam",
+	"Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A Cappella", "Euro-House", "Dance Hall", "Goa", "Drum & Bass",
+}
@@ -0,0 +1,254 @@
+package audio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+	"time"
+)
+
+var ErrNotMPEG = errors.New("audio: no MPEG audio frames found")
+
+// frameHeader is a decoded MPEG audio frame header.
+type frameHeader struct {
+	version    int // 1, 2, or 25 for MPEG 2.5
+	layer      int
+	bitrate    int // kbit/s
+	sampleRate int
+	padding    int
+	channels   int
+}
+
+var bitrates = map[[2]int][]int{
+	{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+var sampleRates = map[int][]int{
+	1:  {44100, 48000, 32000},
+	2:  {22050, 24000, 16000},
+	25: {11025, 12000, 8000},
+}
+
+// parseFrameHeader decodes 4 bytes; free-format frames are not supported.
+func parseFrameHeader(b []byte) (frameHeader, bool) {
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return frameHeader{}, false
+	}
+	var h frameHeader
+	switch (b[1] >> 3) & 3 {
+	case 0:
+		h.version = 25
+	case 2:
+		h.version = 2
+	case 3:
+		h.version = 1
+	default:
+		return frameHeader{}, false
+	}
+	h.layer = 4 - int((b[1]>>1)&3)
+	if h.layer == 4 {
+		return frameHeader{}, false
+	}
+	bi, si := int(b[2]>>4), int((b[2]>>2)&3)
+	if bi == 0 || bi == 15 || si == 3 {
+		return frameHeader{}, false
+	}
+	tv := h.version
+	if tv == 25 {
+		tv = 2
+	}
+	h.bitrate = bitrates[[2]int{tv, h.layer}][bi]
+	h.sampleRate = sampleRates[h.version][si]
+	h.padding = int((b[2] >> 1) & 1)
+	h.channels = 2
+	if b[3]>>6 == 3 {
+		h.channels = 1
+	}
+	return h, true
+}
+
+func (h frameHeader) samples() int {
+	switch {
+	case h.layer == 1:
+		return 384
+	case h.layer == 3 && h.version != 1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+func (h frameHeader) size() int {
+	if h.layer == 1 {
+		return (12*h.bitrate*1000/h.sampleRate + h.padding) * 4
+	}
+	return h.samples()/8*h.bitrate*1000/h.sampleRate + h.padding
+}
+
+// sideInfo is the length of the layer III side information, which the
+// Xing header follows.
+func (h frameHeader) sideInfo() int {
+	switch {
+	case h.version == 1 && h.channels == 2:
+		return 32
+	case h.version == 1, h.channels == 2:
+		return 17
+	default:
+		return 9
+	}
+}
+
+// Stream describes the MPEG audio itself.
+type Stream struct {
+	Duration   time.Duration
+	Bitrate    int // average, kbit/s
+	SampleRate int
+	Channels   int
+	Frames     int
+	// VBR is set when the length came from a Xing or VBRI header.
+	VBR bool
+	// Gapless is set when the Xing header is followed by a LAME tag.
+	Gapless *Gapless
+}
+
+// Gapless is what a LAME tag says about the track's place in the decoded
+// audio. The encoder puts Delay samples of silence before it and Padding
+// after it to fill the last frame. Samples is the length of the track
+// itself, per channel.
+type Gapless struct {
+	Delay   int
+	Padding int
+	Samples int64
+}
+
+// DecoderDelay is how many samples a layer III decoder outputs before the
+// first sample it was given, on top of the encoder's Delay.
+const DecoderDelay = 529
+
+// lameEncoders are the encoders known to write a LAME tag; FFmpeg writes
+// its own name in the version field.
+var lameEncoders = []string{"LAME", "Lavf", "Lavc", "GOGO"}
+
+// xingHeader reads the frame count of a Xing/Info or VBRI header in the
+// first frame, which then carries no audio, and the LAME tag that may
+// follow a Xing header.
+func xingHeader(h frameHeader, frame []byte) (frames int, gapless *Gapless, ok bool) {
+	if off := 4 + h.sideInfo(); off+12 <= len(frame) {
+		tag := string(frame[off : off+4])
+		flags := binary.BigEndian.Uint32(frame[off+4:])
+		if (tag == "Xing" || tag == "Info") && flags&1 != 0 {
+			frames = int(binary.BigEndian.Uint32(frame[off+8:]))
+			// The frame count, the byte count, the seek table and the
+			// quality come in that order when their flags are set.
+			lame := off + 8
+			for i, n := range []int{4, 4, 100, 4} {
+				if flags&(1<<i) != 0 {
+					lame += n
+				}
+			}
+			return frames, lameTag(frame[min(lame, len(frame)):], frames*h.samples()), true
+		}
+	}
+	if off := 4 + 32; off+18 <= len(frame) && string(frame[off:off+4]) == "VBRI" {
+		return int(binary.BigEndian.Uint32(frame[off+14:])), nil, true
+	}
+	return 0, nil, false
+}
+
+// lameTag reads the delay and the padding from the LAME tag in b, which
+// starts with the encoder version and has them at byte 21 in two 12-bit
+// fields. total is the number of samples in the frames.
+func lameTag(b []byte, total int) *Gapless {
+	if len(b) < 24 || !slices.Contains(lameEncoders, string(b[:4])) {
+		return nil
+	}
+	g := &Gapless{
+		Delay:   int(b[21])<<4 | int(b[22])>>4,
+		Padding: int(b[22]&0x0f)<<8 | int(b[23]),
+	}
+	if g.Delay+g.Padding >= total {
+		return nil
+	}
+	g.Samples = int64(total - g.Delay - g.Padding)
+	return g
+}
+
+// maxSyncSearch is how far past the tags the first frame is looked for.
+const maxSyncSearch = 64 << 10
+
+// readStream walks the frames of r, which holds audio only (no tags). A
+// Xing or VBRI header gives the length right away; otherwise every frame
+// is counted.
+func readStream(r io.Reader) (*Stream, error) {
+	br := bufio.NewReaderSize(r, 64<<10)
+
+	// Find two consecutive frames to be sure the first one is real.
+	var first frameHeader
+	skipped := 0
+	for {
+		b, err := br.Peek(4)
+		if err != nil {
+			return nil, ErrNotMPEG
+		}
+		if h, ok := parseFrameHeader(b); ok {
+			if nb, err := br.Peek(h.size() + 4); err == nil {
+				if _, ok := parseFrameHeader(nb[h.size():]); ok {
+					first = h
+					break
+				}
+			} else if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, bufio.ErrBufferFull) {
+				first = h
+				break
+			}
+		}
+		if skipped++; skipped > maxSyncSearch {
+			return nil, ErrNotMPEG
+		}
+		br.Discard(1)
+	}
+
+	s := &Stream{SampleRate: first.sampleRate, Channels: first.channels}
+	frame, _ := br.Peek(first.size())
+	if n, gapless, ok := xingHeader(first, frame); ok && n > 0 {
+		s.Frames, s.VBR, s.Gapless = n, true, gapless
+		samples := int64(n) * int64(first.samples())
+		if gapless != nil {
+			samples = gapless.Samples
+		}
+		s.Duration = time.Duration(samples * int64(time.Second) / int64(first.sampleRate))
+		return s, nil
+	}
+
+	var samples, bytes int64
+	hdr := make([]byte, 4)
+	for {
+		if _, err := io.ReadFull(br, hdr); err != nil {
+			break
+		}
+		h, ok := parseFrameHeader(hdr)
+		if !ok {
+			break
+		}
+		if _, err := br.Discard(h.size() - 4); err != nil {
+			break
+		}
+		s.Frames++
+		samples += int64(h.samples())
+		bytes += int64(h.size())
+	}
+	if s.Frames == 0 {
+		return nil, ErrNotMPEG
+	}
+	s.Duration = time.Duration(samples * int64(time.Second) / int64(first.sampleRate))
+	if secs := s.Duration.Seconds(); secs > 0 {
+		s.Bitrate = int(float64(bytes*8) / secs / 1000)
+	}
+	return s, nil
+}
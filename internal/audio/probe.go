@@ -0,0 +1,53 @@
+// Package audio reads what uploaded MP3 files carry: ID3v1 and ID3v2.3/2.4
+// tags, the embedded cover, and the exact length and the gapless info from
+// the MPEG frames.
+package audio
+
+import (
+	"io"
+	"math"
+)
+
+type Info struct {
+	Tags
+	Stream
+}
+
+// Seconds is the duration rounded to whole seconds.
+func (i *Info) Seconds() int {
+	return int(math.Round(i.Duration.Seconds()))
+}
+
+// Probe reads the MP3 of size bytes in r. ErrNotMPEG means no audio frames
+// were found; tags are returned all the same.
+func Probe(r io.ReaderAt, size int64) (*Info, error) {
+	info := &Info{}
+	start, end := int64(0), size
+
+	v2, n, err := readID3v2(io.NewSectionReader(r, 0, size))
+	if err == nil && v2 != nil {
+		info.Tags = *v2
+	}
+	if err == nil {
+		start = int64(n)
+	}
+	if size-start >= 128 {
+		b := make([]byte, 128)
+		if _, err := r.ReadAt(b, size-128); err == nil {
+			if v1 := readID3v1(b); v1 != nil {
+				info.Tags.merge(v1)
+				end -= 128
+			}
+		}
+	}
+
+	st, err := readStream(io.NewSectionReader(r, start, end-start))
+	if err != nil {
+		return info, err
+	}
+	info.Stream = *st
+	if secs := info.Duration.Seconds(); info.Bitrate == 0 && secs > 0 {
+		info.Bitrate = int(float64((end-start)*8) / secs / 1000)
+	}
+	return info, nil
+}
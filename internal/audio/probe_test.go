@@ -0,0 +1,266 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// The fixtures are built here rather than kept as files so every byte the
+// cases depend on is in sight.
+
+func syncsafeBytes(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+// id3Frame is a frame of a version 3 or 4 tag.
+func id3Frame(ver byte, id string, data []byte) []byte {
+	b := []byte(id)
+	if ver == 4 {
+		b = append(b, syncsafeBytes(len(data))...)
+	} else {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(data)))
+	}
+	return append(append(b, 0, 0), data...)
+}
+
+func id3Tag(ver, flags byte, frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	if flags&0x80 != 0 {
+		body = bytes.ReplaceAll(body, []byte{0xff}, []byte{0xff, 0x00})
+	}
+	hdr := append([]byte{'I', 'D', '3', ver, 0, flags}, syncsafeBytes(len(body))...)
+	return append(hdr, body...)
+}
+
+func latin1Text(s string) []byte { return append([]byte{0}, s...) }
+func utf8Text(s string) []byte   { return append([]byte{3}, s...) }
+
+func utf16Text(s string) []byte {
+	b := []byte{1, 0xff, 0xfe}
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+func id3v1(title, artist, album, year string, track, genre byte) []byte {
+	b := make([]byte, 128)
+	copy(b, "TAG")
+	copy(b[3:33], title)
+	copy(b[33:63], artist)
+	copy(b[63:93], album)
+	copy(b[93:97], year)
+	b[126], b[127] = track, genre
+	return b
+}
+
+// MPEG 1 layer III, 128 kbit/s, 44.1 kHz: 417 byte frames of 1152 samples.
+var (
+	stereo128 = []byte{0xff, 0xfb, 0x90, 0x00}
+	mono128   = []byte{0xff, 0xfb, 0x90, 0xc0}
+	// MPEG 2 layer III, 64 kbit/s, 22.05 kHz: 208 byte frames of 576.
+	stereo64 = []byte{0xff, 0xf3, 0x80, 0x00}
+)
+
+// frames is n frames with header hdr and silence; info, when set, goes into
+// the first one after the side information of sideInfo bytes.
+func frames(hdr []byte, n, sideInfo int, info []byte) []byte {
+	h, _ := parseFrameHeader(hdr)
+	var b []byte
+	for i := range n {
+		f := make([]byte, h.size())
+		copy(f, hdr)
+		if i == 0 && info != nil {
+			copy(f[4+sideInfo:], info)
+		}
+		b = append(b, f...)
+	}
+	return b
+}
+
+// xing is an Info header with every field but the bytes and the quality,
+// followed by a LAME tag with delay and padding.
+func xing(frames, delay, padding int) []byte {
+	b := []byte("Info")
+	b = binary.BigEndian.AppendUint32(b, 1|4) // frames, seek table
+	b = binary.BigEndian.AppendUint32(b, uint32(frames))
+	b = append(b, make([]byte, 100)...)
+	lame := make([]byte, 36)
+	copy(lame, "LAME3.100")
+	lame[21] = byte(delay >> 4)
+	lame[22] = byte(delay&0x0f)<<4 | byte(padding>>8)
+	lame[23] = byte(padding)
+	return append(b, lame...)
+}
+
+func vbri(frames int) []byte {
+	b := make([]byte, 18)
+	copy(b, "VBRI")
+	binary.BigEndian.PutUint32(b[14:], uint32(frames))
+	return b
+}
+
+func TestProbeTags(t *testing.T) {
+	jpeg := []byte{0xff, 0xd8, 0xff, 0xe0, 1, 2, 3}
+	apic := func(kind byte, mime string, data []byte) []byte {
+		b := append([]byte{0}, mime...)
+		b = append(b, 0, kind, 'c', 0)
+		return append(b, data...)
+	}
+	uslt := func(text []byte) []byte {
+		return append([]byte{text[0], 'e', 'n', 'g', 0}, text[1:]...)
+	}
+	audio := frames(stereo128, 3, 32, nil)
+
+	tests := []struct {
+		name string
+		file []byte
+		want Tags
+	}{
+		{
+			name: "v2.3 latin-1",
+			file: append(id3Tag(3, 0,
+				id3Frame(3, "TIT2", latin1Text("Caf\xe9")),
+				id3Frame(3, "TPE1", latin1Text("OG Buda")),
+				id3Frame(3, "TALB", latin1Text("Demo")),
+				id3Frame(3, "TCON", latin1Text("(17)")),
+				id3Frame(3, "TRCK", latin1Text("3/12")),
+				id3Frame(3, "TYER", latin1Text("2023")),
+				id3Frame(3, "USLT", uslt(latin1Text("la la"))),
+			), audio...),
+			want: Tags{Title: "Café", Artist: "OG Buda", Album: "Demo", Genre: "Rock", Track: 3, Year: 2023, Lyrics: "la la"},
+		},
+		{
+			name: "v2.3 unsynchronised, UTF-16, front cover preferred",
+			file: append(id3Tag(3, 0x80,
+				id3Frame(3, "TIT2", utf16Text("Всё Норм")),
+				id3Frame(3, "APIC", apic(0, "image/png", []byte{0x89, 'P', 'N', 'G'})),
+				id3Frame(3, "APIC", apic(3, "jpg", jpeg)),
+			), audio...),
+			want: Tags{Title: "Всё Норм", Picture: &Picture{MIME: "image/jpeg", Data: jpeg}},
+		},
+		{
+			name: "v2.4 UTF-8, syncsafe sizes, several values",
+			file: append(id3Tag(4, 0,
+				id3Frame(4, "TIT2", utf8Text("Вода\x00Water")),
+				id3Frame(4, "TCON", utf8Text("Hip-Hop")),
+				id3Frame(4, "TDRC", utf8Text("2024-05-01")),
+				id3Frame(4, "USLT", uslt(utf8Text("строка"))),
+				id3Frame(4, "TXXX", utf8Text(string(bytes.Repeat([]byte("x"), 200)))),
+				id3Frame(4, "TPE1", utf8Text("Ernar Beats")),
+			), audio...),
+			want: Tags{Title: "Вода", Artist: "Ernar Beats", Genre: "Hip-Hop", Year: 2024, Lyrics: "строка"},
+		},
+		{
+			name: "v1.1 fills what v2 lacks",
+			file: append(append(id3Tag(3, 0, id3Frame(3, "TIT2", latin1Text("From v2"))), audio...),
+				id3v1("From v1", "Artist", "Album", "1999", 7, 13)...),
+			want: Tags{Title: "From v2", Artist: "Artist", Album: "Album", Genre: "Pop", Track: 7, Year: 1999},
+		},
+		{
+			name: "v2.2 is skipped",
+			file: append(append([]byte{'I', 'D', '3', 2, 0, 0}, append(syncsafeBytes(6), "TT2\x00\x00\x00"...)...), audio...),
+			want: Tags{},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := Probe(bytes.NewReader(tc.file), int64(len(tc.file)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(info.Tags, tc.want) {
+				t.Errorf("tags %+v, want %+v", info.Tags, tc.want)
+			}
+			if info.Frames != 3 {
+				t.Errorf("%d frames after the tag, want 3", info.Frames)
+			}
+		})
+	}
+}
+
+func TestProbeStream(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		want Stream
+	}{
+		{
+			name: "CBR frames counted",
+			file: frames(stereo128, 10, 32, nil),
+			want: Stream{
+				Duration: 11520 * time.Second / 44100, Bitrate: 127, SampleRate: 44100, Channels: 2, Frames: 10,
+			},
+		},
+		{
+			name: "Xing with LAME tag",
+			file: frames(stereo128, 3, 32, xing(100, 576, 1000)),
+			want: Stream{
+				Duration: 113624 * time.Second / 44100, SampleRate: 44100, Channels: 2, Frames: 100, VBR: true,
+				Gapless: &Gapless{Delay: 576, Padding: 1000, Samples: 113624},
+			},
+		},
+		{
+			name: "mono Xing after shorter side information",
+			file: frames(mono128, 3, 17, xing(50, 576, 1152)),
+			want: Stream{
+				Duration: 55872 * time.Second / 44100, SampleRate: 44100, Channels: 1, Frames: 50, VBR: true,
+				Gapless: &Gapless{Delay: 576, Padding: 1152, Samples: 55872},
+			},
+		},
+		{
+			name: "MPEG 2 Xing with LAME tag",
+			file: frames(stereo64, 3, 17, xing(200, 576, 600)),
+			want: Stream{
+				Duration: 114024 * time.Second / 22050, SampleRate: 22050, Channels: 2, Frames: 200, VBR: true,
+				Gapless: &Gapless{Delay: 576, Padding: 600, Samples: 114024},
+			},
+		},
+		{
+			name: "LAME tag longer than the audio is ignored",
+			file: frames(stereo128, 3, 32, xing(1, 1000, 1000)),
+			want: Stream{
+				Duration: 1152 * time.Second / 44100, SampleRate: 44100, Channels: 2, Frames: 1, VBR: true,
+			},
+		},
+		{
+			name: "VBRI",
+			file: frames(stereo128, 3, 32, vbri(40)),
+			want: Stream{
+				Duration: 40 * 1152 * time.Second / 44100, SampleRate: 44100, Channels: 2, Frames: 40, VBR: true,
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := Probe(bytes.NewReader(tc.file), int64(len(tc.file)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tc.want
+			if want.Bitrate == 0 {
+				// Set from the file size when the header gave the length.
+				want.Bitrate = info.Bitrate
+			}
+			if !reflect.DeepEqual(info.Stream, want) {
+				t.Errorf("stream %+v, want %+v", info.Stream, want)
+			}
+		})
+	}
+}
+
+func TestProbeNotMPEG(t *testing.T) {
+	file := append(id3Tag(4, 0, id3Frame(4, "TIT2", utf8Text("Only tags"))), make([]byte, 1000)...)
+	info, err := Probe(bytes.NewReader(file), int64(len(file)))
+	if !errors.Is(err, ErrNotMPEG) {
+		t.Fatalf("error %v, want ErrNotMPEG", err)
+	}
+	if info.Title != "Only tags" {
+		t.Errorf("title %q, want the tags all the same", info.Title)
+	}
+}
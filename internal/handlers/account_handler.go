@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+
+	"YeahMusic/internal/services"
+)
+
+type forgotPasswordReq struct {
+	Email string `json:"email"`
+}
+
+// ForgotPassword answers the same way whether or not the address exists.
+func (a *App) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req forgotPasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		writeErr(w, 400, "email required")
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if !a.allow(w, r, "password-reset", "ip:"+a.clientIP(r), "email:"+email) {
+		return
+	}
+	if err := a.AccountS.RequestPasswordReset(email); err != nil {
+		log.Println("password reset:", err)
+	}
+	writeJSON(w, 202, map[string]any{"status": "if the account exists, a reset link was sent"})
+}
+
+type resetPasswordReq struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (a *App) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || req.Password == "" {
+		writeErr(w, 400, "token and password required")
+		return
+	}
+	err := a.AccountS.ResetPassword(req.Token, req.Password)
+	if err == services.ErrNotFound {
+		writeErr(w, 400, "invalid or expired token")
+		return
+	}
+	if mapServiceErr(w, err) {
+		return
+	}
+	writeJSON(w, 200, map[string]any{"status": "password changed"})
+}
+
+// VerifyEmail takes the token from the mailed link (GET) or a JSON body.
+func (a *App) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" && r.Method == http.MethodPost {
+		var req struct {
+			Token string `json:"token"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		token = req.Token
+	}
+	if token == "" {
+		writeErr(w, 400, "token required")
+		return
+	}
+	err := a.AccountS.VerifyEmail(token)
+	if err == services.ErrNotFound {
+		writeErr(w, 400, "invalid or expired token")
+		return
+	}
+	if mapServiceErr(w, err) {
+		return
+	}
+	writeJSON(w, 200, map[string]any{"status": "email verified"})
+}
+
+func (a *App) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	u := userFromCtx(r)
+	if u.EmailVerified {
+		writeJSON(w, 200, map[string]any{"status": "already verified"})
+		return
+	}
+	if !a.allow(w, r, "verify-resend", "user:"+u.ID.Hex()) {
+		return
+	}
+	if mapServiceErr(w, a.AccountS.SendVerification(u)) {
+		return
+	}
+	writeJSON(w, 202, map[string]any{"status": "sent"})
+}
@@ -1,7 +1,67 @@
 package handlers
 
-import "net/http"
+import (
+	"net/http"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
 
 func (a *App) AdminPing(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, 200, a.AdminS.Ping())
 }
+
+func (a *App) ListRoleRequests(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, a.AdminS.ListRoleRequests())
+}
+
+// DecideRoleRequest handles POST /api/admin/role-requests/{userID}/approve
+// and /reject.
+func (a *App) DecideRoleRequest(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/role-requests/"), "/"), "/")
+	if len(parts) != 2 {
+		writeErr(w, 404, "not found")
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(parts[0])
+	if err != nil {
+		writeErr(w, 400, "bad id")
+		return
+	}
+
+	switch parts[1] {
+	case "approve":
+		u, err := a.AdminS.ApproveRole(id)
+		if mapServiceErr(w, err) {
+			return
+		}
+		writeJSON(w, 200, u)
+	case "reject":
+		u, err := a.AdminS.RejectRole(id)
+		if mapServiceErr(w, err) {
+			return
+		}
+		writeJSON(w, 200, u)
+	default:
+		writeErr(w, 404, "not found")
+	}
+}
+
+// ResetUserTOTP handles DELETE /api/admin/users/{userID}/totp.
+func (a *App) ResetUserTOTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/admin/users/"), "/"), "/")
+	if len(parts) != 2 || parts[1] != "totp" {
+		writeErr(w, 404, "not found")
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(parts[0])
+	if err != nil {
+		writeErr(w, 400, "bad id")
+		return
+	}
+	u, err := a.AdminS.ResetTOTP(id)
+	if mapServiceErr(w, err) {
+		return
+	}
+	writeJSON(w, 200, u)
+}
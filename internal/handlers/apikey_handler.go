@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"YeahMusic/internal/services"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type createAPIKeyReq struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"`
+}
+
+func (a *App) ListAPIKeyScopes(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, map[string]any{"scopes": services.Scopes()})
+}
+
+// CreateAPIKey is the only response that contains the full key.
+func (a *App) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req createAPIKeyReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeErr(w, 400, "bad json")
+		return
+	}
+	if strings.TrimSpace(req.Name) == "" || len(req.Scopes) == 0 || req.ExpiresInDays < 0 {
+		writeErr(w, 400, "name and scopes required")
+		return
+	}
+	ttl := time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	k, err := a.KeyS.Create(userFromCtx(r), req.Name, req.Scopes, ttl)
+	if err == services.ErrInvalidScope {
+		writeErr(w, 400, "unknown scope")
+		return
+	}
+	if err == services.ErrForbidden {
+		writeErr(w, 403, "scope not allowed for your role or too many keys")
+		return
+	}
+	if mapServiceErr(w, err) {
+		return
+	}
+	writeJSON(w, 201, k)
+}
+
+func (a *App) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, a.KeyS.List(userFromCtx(r).ID))
+}
+
+func (a *App) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	u := userFromCtx(r)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 {
+		writeErr(w, 400, "bad path")
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(parts[2])
+	if err != nil {
+		writeErr(w, 400, "bad id")
+		return
+	}
+	if mapServiceErr(w, a.KeyS.Revoke(u.ID, id)) {
+		return
+	}
+	writeJSON(w, 200, map[string]any{"status": "revoked"})
+}
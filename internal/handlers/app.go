@@ -1,23 +1,79 @@
 package handlers
 
 import (
+	"log"
+	"net/netip"
+
+	"YeahMusic/internal/media"
+	"YeahMusic/internal/models"
+	"YeahMusic/internal/ratelimit"
 	"YeahMusic/internal/services"
+	"YeahMusic/internal/storage"
+	"YeahMusic/internal/stream"
+	"YeahMusic/internal/tus"
+	"YeahMusic/internal/upload"
 )
 
 type App struct {
-	Store    *services.Store
+	Store    services.Repository
 	AuthS    *services.AuthService
+	AccountS *services.AccountService
 	CatalogS *services.CatalogService
 	PlayS    *services.PlaylistService
 	AdminS   *services.AdminService
+	OIDCS    *services.OIDCService
+	KeyS     *services.APIKeyService
+	Policy   *services.Policy
+	Limiter  *ratelimit.Limiter
+	Media    *media.Signer
+	Uploads  *upload.Validator
+	Blobs    storage.BlobStore
+	Tus      *tus.Store
+	Plays    *stream.Accounting
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For and
+	// X-Real-IP clientIP believes.
+	TrustedProxies []netip.Prefix
+	// OIDCLogin is the login an OIDC callback hands the app, the response
+	// of a password login when nil.
+	OIDCLogin func(sec *models.Session, u *models.User) any
+
+	oidcKey []byte
 }
 
-func NewApp(store *services.Store) *App {
+func NewApp(store services.Repository) *App {
+	auth := services.NewAuthService(store)
+	limits, err := services.RateLimitStoreFromEnv(store)
+	if err != nil {
+		log.Println("rate limit store, falling back to memory:", err)
+		limits = ratelimit.NewMemoryStore()
+	}
+	auth.SetLimitStore(limits)
+	catalog := services.NewCatalogService(store)
+	blobs, err := storage.FromEnv(uploadDir)
+	if err != nil {
+		log.Println("blob store, falling back to", uploadDir+":", err)
+		blobs = storage.NewLocal(uploadDir)
+	}
 	return &App{
 		Store:    store,
-		AuthS:    services.NewAuthService(store),
-		CatalogS: services.NewCatalogService(store),
+		AuthS:    auth,
+		AccountS: services.NewAccountService(store, services.MailerFromEnv()),
+		CatalogS: catalog,
 		PlayS:    services.NewPlaylistService(store),
 		AdminS:   services.NewAdminService(store),
+		OIDCS:    services.NewOIDCService(store, auth, services.OIDCProvidersFromEnv()...),
+		KeyS:     services.NewAPIKeyService(store),
+		Policy:   services.NewPolicy(store),
+		Limiter:  ratelimit.NewLimiter(limits, ratelimit.RulesFromEnv(defaultRateLimits)),
+		Media:    media.SignerFromEnv(),
+		Uploads:  upload.NewValidator(upload.LimitsFromEnv()),
+		Blobs:    blobs,
+		Tus:      tus.StoreFromEnv(),
+		Plays:    stream.NewAccounting(catalog),
+
+		TrustedProxies: TrustedProxiesFromEnv(),
+
+		oidcKey: oidcCookieKey(),
 	}
 }
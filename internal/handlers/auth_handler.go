@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"strings"
 
+	"YeahMusic/internal/models"
 	"YeahMusic/internal/services"
 )
 
@@ -35,6 +38,9 @@ func (a *App) Register(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, 500, "server error")
 		return
 	}
+	if err := a.AccountS.SendVerification(u); err != nil {
+		log.Println("verification mail:", err)
+	}
 	writeJSON(w, 201, u)
 }
 
@@ -49,12 +55,35 @@ func (a *App) Login(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, 400, "bad json")
 		return
 	}
-	sec, u, err := a.AuthS.Login(req.Email, req.Password)
+	sec, u, err := a.AuthS.Login(req.Email, req.Password, a.clientInfo(r))
+	if writeMFAChallenge(w, err) || writeLimited(w, err) {
+		return
+	}
 	if err != nil {
 		writeErr(w, 401, "invalid credentials")
 		return
 	}
-	writeJSON(w, 200, map[string]any{
-		"token": sec.Token, "expires_at": sec.ExpiresAt, "user": u,
-	})
+	writeJSON(w, 200, tokenResponse(sec, u))
+}
+
+// writeMFAChallenge answers a login that still needs a second factor; the
+// client completes it at /api/login/2fa with mfa_token.
+func writeMFAChallenge(w http.ResponseWriter, err error) bool {
+	var c *services.MFAChallenge
+	if !errors.As(err, &c) {
+		return false
+	}
+	writeJSON(w, 200, map[string]any{"mfa_required": true, "mfa_token": c.Token, "mfa_expires_at": c.ExpiresAt})
+	return true
+}
+
+func tokenResponse(sec *models.Session, u *models.User) map[string]any {
+	res := map[string]any{
+		"token": sec.Token, "expires_at": sec.AccessExpiresAt,
+		"refresh_token": sec.RefreshToken, "refresh_expires_at": sec.ExpiresAt,
+	}
+	if u != nil {
+		res["user"] = u
+	}
+	return res
 }
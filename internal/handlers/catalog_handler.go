@@ -1,16 +1,15 @@
 package handlers
 
 import (
-	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
+	"YeahMusic/internal/audio"
 	"YeahMusic/internal/models"
+	"YeahMusic/internal/services"
+	"YeahMusic/internal/storage"
+	"YeahMusic/internal/upload"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
@@ -23,7 +22,7 @@ func (a *App) ListAlbums(w http.ResponseWriter, r *http.Request) {
 	if q := r.URL.Query().Get("artist_id"); q != "" {
 		id, _ = primitive.ObjectIDFromHex(q)
 	}
-	writeJSON(w, 200, a.CatalogS.ListAlbums(id))
+	writeJSON(w, 200, a.signAlbums(r, a.CatalogS.ListAlbums(id)))
 }
 
 func (a *App) ListTracks(w http.ResponseWriter, r *http.Request) {
@@ -31,54 +30,168 @@ func (a *App) ListTracks(w http.ResponseWriter, r *http.Request) {
 	if q := r.URL.Query().Get("album_id"); q != "" {
 		id, _ = primitive.ObjectIDFromHex(q)
 	}
-	writeJSON(w, 200, a.CatalogS.ListTracks(id))
+	writeJSON(w, 200, a.signTracks(r, a.CatalogS.ListTracks(id)))
 }
 
+// UploadTrack takes title, artist, lyrics and cover from the form and, for
+// whatever the form leaves out, from the ID3 tags of the file. Without an
+// album_id, a tagged album of the uploader is reused or created unless
+// auto_album=false.
 func (a *App) UploadTrack(w http.ResponseWriter, r *http.Request) {
-	r.ParseMultipartForm(10 << 20)
-	title := r.FormValue("title")
-	artist := r.FormValue("artist")
-	if title == "" {
-		writeErr(w, 400, "title required")
+	var album *models.Album
+	form, ok := a.readUpload(w, r, upload.Spec{
+		Files: map[string]upload.Kind{"audio": upload.Audio, "cover": upload.Image},
+		// An album that is not the uploader's stops the upload before the
+		// audio is read, when the form names it first.
+		Field: func(name, value string) error {
+			if name != "album_id" {
+				return nil
+			}
+			found, ok := a.formAlbum(w, r, value)
+			if !ok {
+				return errAnswered
+			}
+			album = found
+			return nil
+		},
+	})
+	if !ok {
 		return
 	}
-
-	file, header, err := r.FormFile("audio")
-	if err != nil {
+	defer form.Close()
+	file := form.Files["audio"]
+	if file == nil {
 		writeErr(w, 400, "audio required")
 		return
 	}
-	defer file.Close()
 
-	ext := filepath.Ext(header.Filename)
-	filename := fmt.Sprintf("%d_%s%s", time.Now().UnixNano(), "audio", ext)
-	dst, _ := os.Create(filepath.Join("public", "uploads", filename))
-	io.Copy(dst, file)
-	dst.Close()
-	audioURL := "/uploads/" + filename
+	up := trackUpload{
+		Title: form.Value("title"), Artist: form.Value("artist"), Lyrics: form.Value("lyrics"),
+		AlbumID: form.Value("album_id"), AutoAlbum: form.Value("auto_album") != "false",
+		album: album, cover: form.Files["cover"],
+	}
+	track, ok := a.addTrack(w, r, up, file.Blob, file.Result)
+	if !ok {
+		return
+	}
+	writeJSON(w, 201, a.signTrack(r, track))
+}
+
+// trackUpload is what an upload says about a track besides the audio: the
+// form of UploadTrack or the metadata of a resumable upload.
+type trackUpload struct {
+	Title, Artist, Lyrics string
+	AlbumID               string
+	AutoAlbum             bool
+	// album is the album of AlbumID once a form has looked it up.
+	album *models.Album
+	// cover is the cover file of a form.
+	cover *upload.File
+}
+
+// addTrack commits the checked audio in blob and adds the track the upload
+// describes, answering r itself when that fails.
+func (a *App) addTrack(w http.ResponseWriter, r *http.Request, form trackUpload, blob storage.Writer, res *upload.Result) (*models.Track, bool) {
+	u := userFromCtx(r)
+	album := form.album
+	if album == nil {
+		var ok bool
+		if album, ok = a.formAlbum(w, r, form.AlbumID); !ok {
+			return nil, false
+		}
+	}
+
+	// Only MP3s are probed; other formats go by the form alone.
+	info := res.Audio
+	if info == nil {
+		info = &audio.Info{}
+	}
+
+	track := &models.Track{
+		Title: strings.TrimSpace(form.Title), ArtistID: u.ID, ArtistName: strings.TrimSpace(form.Artist),
+		Lyrics: form.Lyrics,
+	}
+	a.CatalogS.FillFromTags(track, info)
+	if track.Title == "" {
+		writeErr(w, 400, "title required")
+		return nil, false
+	}
+	if track.ArtistName == "" {
+		track.ArtistName = u.Name
+	}
+
+	var err error
+	newAlbum := false
+	if album == nil && info.Album != "" && form.AutoAlbum && a.mayCreateAlbum(r) {
+		if album, err = a.CatalogS.AlbumByTitle(u.ID, info.Album); err != nil {
+			newAlbum = true
+		}
+	}
+
+	if track.CoverURL, err = a.saveImage(form.cover); writeUploadErr(w, err) {
+		return nil, false
+	}
+	filename, err := blob.Commit(res.Format.Exts[0])
+	if writeUploadErr(w, err) {
+		return nil, false
+	}
+	track.AudioURL = "/uploads/" + filename
+
+	if track.CoverURL == "" && album != nil {
+		track.CoverURL = album.CoverURL
+	}
+	if track.CoverURL == "" && info.Picture != nil {
+		track.CoverURL = a.saveCover(info.Picture)
+	}
 
-	coverURL := ""
-	cFile, cHeader, err := r.FormFile("cover")
-	if err == nil {
-		defer cFile.Close()
-		cExt := filepath.Ext(cHeader.Filename)
-		cFilename := fmt.Sprintf("%d_%s%s", time.Now().UnixNano(), "cover", cExt)
-		cDst, _ := os.Create(filepath.Join("public", "uploads", cFilename))
-		io.Copy(cDst, cFile)
-		cDst.Close()
-		coverURL = "/uploads/" + cFilename
+	if newAlbum {
+		album, err = a.CatalogS.CreateAlbum(&models.Album{
+			ArtistID: u.ID, Title: info.Album, ReleaseYear: track.Year, CoverURL: track.CoverURL,
+		})
+		if mapServiceErr(w, err) {
+			return nil, false
+		}
+	}
+	if album != nil {
+		track.AlbumID = album.ID
+	}
+	if track, err = a.CatalogS.AddTrack(track); mapServiceErr(w, err) {
+		return nil, false
 	}
+	a.CatalogS.Analyze(a.Blobs, track)
+	return track, true
+}
 
-	lyricsContent := ""
-	lFile, _, err := r.FormFile("lyrics")
-	if err == nil {
-		defer lFile.Close()
-		bytes, _ := io.ReadAll(lFile)
-		lyricsContent = string(bytes)
+// formAlbum looks up the album an upload names by id, which the uploader has
+// to be allowed to edit, answering r when it is not. No id gives no album.
+func (a *App) formAlbum(w http.ResponseWriter, r *http.Request, id string) (*models.Album, bool) {
+	q := strings.TrimSpace(id)
+	if q == "" {
+		return nil, true
+	}
+	albumID, err := primitive.ObjectIDFromHex(q)
+	if err != nil {
+		writeErr(w, 400, "bad album id")
+		return nil, false
 	}
+	if !keyAllows(w, r, services.PermEditAlbum) {
+		return nil, false
+	}
+	album, err := a.Policy.Album(userFromCtx(r), services.PermEditAlbum, albumID)
+	if mapServiceErr(w, err) {
+		return nil, false
+	}
+	return album, true
+}
 
-	track := &models.Track{Title: title, ArtistName: artist, AudioURL: audioURL, CoverURL: coverURL, Lyrics: lyricsContent}
-	writeJSON(w, 201, a.CatalogS.AddTrack(track))
+// mayCreateAlbum is the Require check for PermCreateAlbum without the error
+// response, for uploads that create albums on the side.
+func (a *App) mayCreateAlbum(r *http.Request) bool {
+	if a.Policy.Require(userFromCtx(r), services.PermCreateAlbum) != nil {
+		return false
+	}
+	k := apiKeyFromCtx(r)
+	return k == nil || services.ScopesAllow(k.Scopes, services.PermCreateAlbum)
 }
 
 func (a *App) UpdateTrackHandler(w http.ResponseWriter, r *http.Request) {
@@ -92,34 +205,25 @@ func (a *App) UpdateTrackHandler(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, 400, "bad id")
 		return
 	}
-
-	r.ParseMultipartForm(10 << 20)
-	coverURL := ""
-	cFile, cHeader, err := r.FormFile("cover")
-	if err == nil {
-		defer cFile.Close()
-		cExt := filepath.Ext(cHeader.Filename)
-		cFilename := fmt.Sprintf("%d_%s%s", time.Now().UnixNano(), "cover_upd", cExt)
-		cDst, _ := os.Create(filepath.Join("public", "uploads", cFilename))
-		io.Copy(cDst, cFile)
-		cDst.Close()
-		coverURL = "/uploads/" + cFilename
+	if _, err := a.Policy.Track(userFromCtx(r), services.PermEditTrack, id); mapServiceErr(w, err) {
+		return
 	}
 
-	lyricsContent := ""
-	lFile, _, err := r.FormFile("lyrics")
-	if err == nil {
-		defer lFile.Close()
-		bytes, _ := io.ReadAll(lFile)
-		lyricsContent = string(bytes)
+	form, ok := a.readUpload(w, r, coverSpec)
+	if !ok {
+		return
+	}
+	defer form.Close()
+	coverURL, err := a.saveImage(form.Files["cover"])
+	if writeUploadErr(w, err) {
+		return
 	}
 
-	updated, err := a.CatalogS.UpdateTrack(id, coverURL, lyricsContent)
-	if err != nil {
-		writeErr(w, 404, "track not found")
+	updated, err := a.CatalogS.UpdateTrack(id, coverURL, form.Value("lyrics"))
+	if mapServiceErr(w, err) {
 		return
 	}
-	writeJSON(w, 200, updated)
+	writeJSON(w, 200, a.signTrack(r, updated))
 }
 
 func (a *App) DeleteTrackHandler(w http.ResponseWriter, r *http.Request) {
@@ -134,34 +238,41 @@ func (a *App) DeleteTrackHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := a.CatalogS.DeleteTrack(id); err != nil {
-		writeErr(w, 404, "track not found")
+	t, err := a.Policy.Track(userFromCtx(r), services.PermDeleteTrack, id)
+	if mapServiceErr(w, err) {
+		return
+	}
+	if mapServiceErr(w, a.CatalogS.DeleteTrack(id)) {
 		return
 	}
+	if !t.AlbumID.IsZero() {
+		a.CatalogS.AnalyzeAlbum(a.Blobs, t.AlbumID)
+	}
 	writeJSON(w, 200, map[string]string{"status": "deleted"})
 }
 
 func (a *App) CreateAlbumHandler(w http.ResponseWriter, r *http.Request) {
-	r.ParseMultipartForm(10 << 20)
-	title := r.FormValue("title")
+	form, ok := a.readUpload(w, r, coverSpec)
+	if !ok {
+		return
+	}
+	defer form.Close()
+	title := form.Value("title")
 	if title == "" {
 		writeErr(w, 400, "title required")
 		return
 	}
-	year, _ := strconv.Atoi(r.FormValue("release_year"))
+	year, _ := strconv.Atoi(form.Value("release_year"))
 
-	coverURL := ""
-	cFile, cHeader, err := r.FormFile("cover")
-	if err == nil {
-		defer cFile.Close()
-		cExt := filepath.Ext(cHeader.Filename)
-		cFilename := fmt.Sprintf("%d_%s%s", time.Now().UnixNano(), "album", cExt)
-		cDst, _ := os.Create(filepath.Join("public", "uploads", cFilename))
-		io.Copy(cDst, cFile)
-		cDst.Close()
-		coverURL = "/uploads/" + cFilename
+	coverURL, err := a.saveImage(form.Files["cover"])
+	if writeUploadErr(w, err) {
+		return
 	}
 
-	album := &models.Album{Title: title, ReleaseYear: year, CoverURL: coverURL}
-	writeJSON(w, 201, a.CatalogS.CreateAlbum(album))
+	album := &models.Album{ArtistID: userFromCtx(r).ID, Title: title, ReleaseYear: year, CoverURL: coverURL}
+	album, err = a.CatalogS.CreateAlbum(album)
+	if mapServiceErr(w, err) {
+		return
+	}
+	writeJSON(w, 201, a.signAlbum(r, album))
 }
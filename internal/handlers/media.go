@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"net/http"
+
+	"YeahMusic/internal/media"
+	"YeahMusic/internal/models"
+	"YeahMusic/internal/storage"
+	"YeahMusic/internal/stream"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MediaFiles serves /uploads/ from the blob store and /audio/ from public
+// once the signature checks out.
+func (a *App) MediaFiles(fs http.Handler) http.Handler {
+	return a.Media.Handler(fs, a.mediaLive)
+}
+
+// mediaLive ends the links handed to a user or session that is gone.
+func (a *App) mediaLive(c *media.Claims) bool {
+	userID, err := primitive.ObjectIDFromHex(c.User)
+	if err != nil {
+		return false
+	}
+	var sessionID primitive.ObjectID
+	if c.Session != "" {
+		if sessionID, err = primitive.ObjectIDFromHex(c.Session); err != nil {
+			return false
+		}
+	}
+	return a.AuthS.Active(userID, sessionID)
+}
+
+// signURL signs a stored media URL for the caller of r.
+func (a *App) signURL(r *http.Request, raw string) string {
+	var user, session string
+	if u := userFromCtx(r); u != nil {
+		user = u.ID.Hex()
+	}
+	if sec := sessionFromCtx(r); sec != nil {
+		session = sec.ID.Hex()
+	}
+	return a.Media.Sign(raw, user, session)
+}
+
+// mediaURL is where the caller of r fetches a stored media URL: the blob
+// store's URL for uploads, signed when it is served here.
+func (a *App) mediaURL(r *http.Request, raw string) string {
+	return a.signURL(r, storage.URL(a.Blobs, raw, a.Media.TTL))
+}
+
+// signCovers returns the URLs of the variants of a stored cover.
+func (a *App) signCovers(r *http.Request, cover string) map[string]string {
+	vs := storage.Variants(a.Blobs, cover)
+	if vs == nil {
+		return nil
+	}
+	res := make(map[string]string, len(vs))
+	for size, u := range vs {
+		res[size] = a.mediaURL(r, u)
+	}
+	return res
+}
+
+// The sign* helpers return signed copies and leave the stored values alone.
+
+func (a *App) signTrack(r *http.Request, t *models.Track) *models.Track {
+	c := *t
+	c.AudioURL = a.mediaURL(r, t.AudioURL)
+	c.CoverURL = a.mediaURL(r, t.CoverURL)
+	c.Covers = a.signCovers(r, t.CoverURL)
+	if !t.ID.IsZero() {
+		c.StreamURL = a.signURL(r, stream.Path(t.ID))
+	}
+	return &c
+}
+
+func (a *App) signTracks(r *http.Request, ts []*models.Track) []*models.Track {
+	res := make([]*models.Track, len(ts))
+	for i, t := range ts {
+		res[i] = a.signTrack(r, t)
+	}
+	return res
+}
+
+func (a *App) signAlbum(r *http.Request, al *models.Album) *models.Album {
+	c := *al
+	c.CoverURL = a.mediaURL(r, al.CoverURL)
+	c.Covers = a.signCovers(r, al.CoverURL)
+	return &c
+}
+
+func (a *App) signAlbums(r *http.Request, als []*models.Album) []*models.Album {
+	res := make([]*models.Album, len(als))
+	for i, al := range als {
+		res[i] = a.signAlbum(r, al)
+	}
+	return res
+}
+
+func (a *App) signPlaylist(r *http.Request, p *models.Playlist) *models.Playlist {
+	c := *p
+	c.CoverURL = a.mediaURL(r, p.CoverURL)
+	c.Covers = a.signCovers(r, p.CoverURL)
+	return &c
+}
+
+func (a *App) signPlaylists(r *http.Request, ps []*models.Playlist) []*models.Playlist {
+	res := make([]*models.Playlist, len(ps))
+	for i, p := range ps {
+		res[i] = a.signPlaylist(r, p)
+	}
+	return res
+}
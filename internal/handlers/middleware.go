@@ -6,43 +6,117 @@ import (
 	"errors"
 	"net/http"
 	"strings"
-	"time"
 
 	"YeahMusic/internal/models"
 )
 
 type ctxKey string
 
-const ctxUserKey ctxKey = "user"
+const (
+	ctxUserKey    ctxKey = "user"
+	ctxSessionKey ctxKey = "session"
+	ctxAPIKeyKey  ctxKey = "api_key"
+)
 
+// Auth accepts a session access token or an API key as the bearer token.
+// With an API key there is no session in the context, only the key.
 func (a *App) Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		h := r.Header.Get("Authorization")
-		if h == "" || !strings.HasPrefix(h, "Bearer ") {
-			writeErr(w, http.StatusUnauthorized, "missing token")
+		r, msg := a.authenticate(r)
+		if msg != "" {
+			writeErr(w, http.StatusUnauthorized, msg)
 			return
 		}
-		tok := strings.TrimPrefix(h, "Bearer ")
-		sec, err := a.Store.GetSession(tok)
-		if err != nil || !sec.ExpiresAt.After(time.Now()) {
-			writeErr(w, http.StatusUnauthorized, "expired session")
-			return
+		next.ServeHTTP(w, r)
+	})
+}
+
+// OptionalAuth is Auth for public routes: a valid token identifies the
+// caller, anything else is served anonymously.
+func (a *App) OptionalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ar, msg := a.authenticate(r); msg == "" {
+			r = ar
 		}
-		u, err := a.Store.GetUserByID(sec.UserID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticate returns r with the caller in its context, or why not.
+func (a *App) authenticate(r *http.Request) (*http.Request, string) {
+	h := r.Header.Get("Authorization")
+	if h == "" || !strings.HasPrefix(h, "Bearer ") {
+		return r, "missing token"
+	}
+	tok := strings.TrimPrefix(h, "Bearer ")
+	if strings.HasPrefix(tok, services.APIKeyPrefix) {
+		k, u, err := a.KeyS.Authenticate(tok)
 		if err != nil {
-			writeErr(w, http.StatusUnauthorized, "user not found")
-			return
+			return r, "invalid api key"
 		}
 		ctx := context.WithValue(r.Context(), ctxUserKey, u)
-		next.ServeHTTP(w, r.WithContext(ctx))
+		ctx = context.WithValue(ctx, ctxAPIKeyKey, k)
+		return r.WithContext(ctx), ""
+	}
+	sec, u, err := a.AuthS.Authenticate(tok)
+	if err != nil {
+		return r, "expired session"
+	}
+	ctx := context.WithValue(r.Context(), ctxUserKey, u)
+	ctx = context.WithValue(ctx, ctxSessionKey, sec)
+	return r.WithContext(ctx), ""
+}
+
+// Require checks a role permission, and the scopes of an API key, and must
+// be wrapped by Auth. Ownership of individual resources is checked by the
+// handlers through a.Policy.
+func (a *App) Require(perm services.Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mapServiceErr(w, a.Policy.Require(userFromCtx(r), perm)) || !keyAllows(w, r, perm) {
+			return
+		}
+		next.ServeHTTP(w, r)
 	})
 }
 
+// SessionOnly keeps API keys away from account management: signing out,
+// sessions and the keys themselves.
+func (a *App) SessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sessionFromCtx(r) == nil {
+			writeErr(w, http.StatusForbidden, "not available with an api key")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// keyAllows writes a 403 when the request came with an API key whose scopes
+// do not cover perm.
+func keyAllows(w http.ResponseWriter, r *http.Request, perm services.Permission) bool {
+	k := apiKeyFromCtx(r)
+	if k == nil || services.ScopesAllow(k.Scopes, perm) {
+		return true
+	}
+	writeErr(w, http.StatusForbidden, "api key scope does not allow this")
+	return false
+}
+
 func userFromCtx(r *http.Request) *models.User {
 	u, _ := r.Context().Value(ctxUserKey).(*models.User)
 	return u
 }
 
+func sessionFromCtx(r *http.Request) *models.Session {
+	sec, _ := r.Context().Value(ctxSessionKey).(*models.Session)
+	return sec
+}
+
+func apiKeyFromCtx(r *http.Request) *models.APIKey {
+	k, _ := r.Context().Value(ctxAPIKeyKey).(*models.APIKey)
+	return k
+}
+
 func mapServiceErr(w http.ResponseWriter, err error) bool {
 	if err == nil {
 		return false
@@ -51,6 +125,12 @@ func mapServiceErr(w http.ResponseWriter, err error) bool {
 	case errors.Is(err, services.ErrNotFound):
 		writeErr(w, http.StatusNotFound, "not found")
 		return true
+	case errors.Is(err, services.ErrUnverified):
+		writeErr(w, http.StatusForbidden, "email not verified")
+		return true
+	case errors.Is(err, services.ErrMFASetupRequired):
+		writeErr(w, http.StatusForbidden, "two-factor authentication required")
+		return true
 	case errors.Is(err, services.ErrForbidden):
 		writeErr(w, http.StatusForbidden, "forbidden")
 		return true
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"YeahMusic/internal/oidc"
+	"YeahMusic/internal/ratelimit"
+	"YeahMusic/internal/services"
+)
+
+const oidcCookie = "oidc_state"
+
+// oidcFlow is what the login step needs to remember for the callback. It
+// lives in a signed cookie, so the server keeps no per-login state.
+type oidcFlow struct {
+	Provider string `json:"p"`
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Expires  int64  `json:"e"`
+}
+
+// oidcCookieKey comes from OIDC_COOKIE_SECRET; without it a random key is
+// used and logins in flight do not survive a restart.
+func oidcCookieKey() []byte {
+	if s := strings.TrimSpace(os.Getenv("OIDC_COOKIE_SECRET")); s != "" {
+		return []byte(s)
+	}
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return b
+}
+
+func (a *App) sealFlow(f oidcFlow) string {
+	b, _ := json.Marshal(f)
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	mac := hmac.New(sha256.New, a.oidcKey)
+	mac.Write([]byte(payload))
+	return payload + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (a *App) openFlow(v string) (*oidcFlow, error) {
+	payload, sig, ok := strings.Cut(v, ".")
+	if !ok {
+		return nil, errors.New("malformed")
+	}
+	mac := hmac.New(sha256.New, a.oidcKey)
+	mac.Write([]byte(payload))
+	want, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(want, mac.Sum(nil)) {
+		return nil, errors.New("bad signature")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, err
+	}
+	var f oidcFlow
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() > f.Expires {
+		return nil, errors.New("expired")
+	}
+	return &f, nil
+}
+
+// NewOIDCApp is an App with only what ListOIDCProviders and OIDCSubroutes
+// use, for a server that keeps the rest of its routes elsewhere.
+func NewOIDCApp(oidcs *services.OIDCService, limiter *ratelimit.Limiter) *App {
+	return &App{OIDCS: oidcs, Limiter: limiter, TrustedProxies: TrustedProxiesFromEnv(), oidcKey: oidcCookieKey()}
+}
+
+func (a *App) ListOIDCProviders(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, 200, map[string]any{"providers": a.OIDCS.Providers()})
+}
+
+// OIDCSubroutes serves /api/oidc/{provider}/login and
+// /api/oidc/{provider}/callback.
+func (a *App) OIDCSubroutes(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/oidc/"), "/"), "/")
+	if len(parts) != 2 {
+		writeErr(w, 404, "not found")
+		return
+	}
+	p, err := a.OIDCS.Provider(parts[0])
+	if err != nil {
+		writeErr(w, 404, "unknown provider")
+		return
+	}
+	switch parts[1] {
+	case "login":
+		a.oidcLogin(w, r, p)
+	case "callback":
+		a.oidcCallback(w, r, p)
+	default:
+		writeErr(w, 404, "not found")
+	}
+}
+
+func (a *App) oidcLogin(w http.ResponseWriter, r *http.Request, p *oidc.Provider) {
+	f := oidcFlow{
+		Provider: p.Name(),
+		State:    oidc.RandomString(),
+		Nonce:    oidc.RandomString(),
+		Verifier: oidc.NewVerifier(),
+		Expires:  time.Now().Add(10 * time.Minute).Unix(),
+	}
+	u, err := p.AuthCodeURL(r.Context(), f.State, f.Nonce, f.Verifier)
+	if err != nil {
+		log.Println("oidc discovery:", err)
+		writeErr(w, 502, "identity provider unavailable")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name: oidcCookie, Value: a.sealFlow(f), Path: "/api/oidc/",
+		MaxAge: 600, HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+// oidcCallback ends the login in the browser, so it answers with a redirect
+// to the app. The fragment carries the login as "oidc", JSON like the one of
+// a password login, or the reason it failed as "oidc_error"; fragments are
+// not sent to servers, so the tokens stay out of their logs.
+func (a *App) oidcCallback(w http.ResponseWriter, r *http.Request, p *oidc.Provider) {
+	c, err := r.Cookie(oidcCookie)
+	if err != nil {
+		oidcRedirect(w, r, "oidc_error", "login not started")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/api/oidc/", MaxAge: -1, HttpOnly: true})
+
+	f, err := a.openFlow(c.Value)
+	q := r.URL.Query()
+	if err != nil || f.Provider != p.Name() || q.Get("state") == "" ||
+		!hmac.Equal([]byte(q.Get("state")), []byte(f.State)) {
+		oidcRedirect(w, r, "oidc_error", "invalid state")
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		oidcRedirect(w, r, "oidc_error", "login failed: "+e)
+		return
+	}
+	if q.Get("code") == "" {
+		oidcRedirect(w, r, "oidc_error", "code required")
+		return
+	}
+
+	claims, err := p.Exchange(r.Context(), q.Get("code"), f.Verifier, f.Nonce)
+	if err != nil {
+		log.Println("oidc exchange:", err)
+		if errors.Is(err, oidc.ErrInvalidToken) || errors.Is(err, oidc.ErrExchange) {
+			oidcRedirect(w, r, "oidc_error", "login failed")
+			return
+		}
+		oidcRedirect(w, r, "oidc_error", "identity provider unavailable")
+		return
+	}
+	sec, u, err := a.OIDCS.SignIn(p.Name(), claims, a.clientInfo(r))
+	var mfa *services.MFAChallenge
+	switch {
+	case errors.As(err, &mfa):
+		b, _ := json.Marshal(map[string]any{"mfa_required": true, "mfa_token": mfa.Token, "mfa_expires_at": mfa.ExpiresAt})
+		oidcRedirect(w, r, "oidc", string(b))
+	case err == services.ErrAlreadyExists:
+		oidcRedirect(w, r, "oidc_error", "an account with this email exists; sign in with your password")
+	case err == services.ErrUnauthorized:
+		oidcRedirect(w, r, "oidc_error", "provider did not return an email")
+	case err != nil:
+		log.Println("oidc sign in:", err)
+		oidcRedirect(w, r, "oidc_error", "login failed")
+	default:
+		var login any = tokenResponse(sec, u)
+		if a.OIDCLogin != nil {
+			login = a.OIDCLogin(sec, u)
+		}
+		b, _ := json.Marshal(login)
+		oidcRedirect(w, r, "oidc", string(b))
+	}
+}
+
+func oidcRedirect(w http.ResponseWriter, r *http.Request, key, value string) {
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, "/#"+url.Values{key: {value}}.Encode(), http.StatusFound)
+}
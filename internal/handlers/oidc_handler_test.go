@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"YeahMusic/internal/oidc"
+	"YeahMusic/internal/ratelimit"
+	"YeahMusic/internal/services"
+)
+
+// TestOIDCLogin signs in through the mock identity provider the way a
+// browser does: login, the provider's authorize redirect, then the
+// callback, which has to open the app with a working session.
+func TestOIDCLogin(t *testing.T) {
+	var idp *oidc.MockProvider
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		idp.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	idp, err := oidc.NewMockProvider(srv.URL, "yeahmusic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := services.NewMemoryStore()
+	auth := services.NewAuthService(store)
+	p := oidc.NewProvider(oidc.Config{
+		Name: "mock", Issuer: srv.URL, ClientID: "yeahmusic",
+		RedirectURL: "http://app.example/api/oidc/mock/callback",
+	}, srv.Client())
+	app := NewOIDCApp(services.NewOIDCService(store, auth, p), ratelimit.NewLimiter(ratelimit.NewMemoryStore(), defaultRateLimits))
+
+	w := httptest.NewRecorder()
+	app.OIDCSubroutes(w, httptest.NewRequest("GET", "/api/oidc/mock/login", nil))
+	if w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), srv.URL+"/authorize?") {
+		t.Fatalf("login: %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := noFollow.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	back, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || back.Path != "/api/oidc/mock/callback" {
+		t.Fatalf("authorize redirected to %q", res.Header.Get("Location"))
+	}
+
+	callback := func(query string, cookies []*http.Cookie) url.Values {
+		t.Helper()
+		r := httptest.NewRequest("GET", "/api/oidc/mock/callback?"+query, nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		w := httptest.NewRecorder()
+		app.OIDCSubroutes(w, r)
+		loc, err := url.Parse(w.Header().Get("Location"))
+		if w.Code != http.StatusFound || err != nil || loc.Path != "/" {
+			t.Fatalf("callback: %d to %q", w.Code, w.Header().Get("Location"))
+		}
+		frag, _ := url.ParseQuery(loc.Fragment)
+		return frag
+	}
+
+	if frag := callback(back.RawQuery, nil); frag.Get("oidc_error") != "login not started" {
+		t.Errorf("callback without the cookie: %v", frag)
+	}
+	forged := url.Values{"code": {back.Query().Get("code")}, "state": {"forged"}}
+	if frag := callback(forged.Encode(), cookies); frag.Get("oidc_error") != "invalid state" {
+		t.Errorf("callback with another state: %v", frag)
+	}
+
+	frag := callback(back.RawQuery, cookies)
+	var login struct {
+		Token string `json:"token"`
+		User  struct {
+			Email string `json:"email"`
+		} `json:"user"`
+	}
+	if err := json.Unmarshal([]byte(frag.Get("oidc")), &login); err != nil {
+		t.Fatalf("fragment %v: %v", frag, err)
+	}
+	if login.User.Email != "mock.user@example.com" {
+		t.Errorf("signed in as %q", login.User.Email)
+	}
+	if _, u, err := auth.Authenticate(login.Token); err != nil || u.Email != "mock.user@example.com" {
+		t.Errorf("session of the callback: %v, %v", u, err)
+	}
+
+	// A code is redeemed once.
+	if frag := callback(back.RawQuery, cookies); frag.Get("oidc_error") != "login failed" {
+		t.Errorf("reusing the code: %v", frag)
+	}
+}
@@ -2,44 +2,41 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
-	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
-	"time"
 
+	"YeahMusic/internal/services"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 func (a *App) CreatePlaylist(w http.ResponseWriter, r *http.Request) {
 	u := userFromCtx(r)
-	r.ParseMultipartForm(10 << 20)
-	title := r.FormValue("title")
+	form, ok := a.readUpload(w, r, coverSpec)
+	if !ok {
+		return
+	}
+	defer form.Close()
+	title := form.Value("title")
 	if title == "" {
 		writeErr(w, 400, "title required")
 		return
 	}
 
-	coverURL := ""
-	cFile, cHeader, err := r.FormFile("cover")
-	if err == nil {
-		defer cFile.Close()
-		cExt := filepath.Ext(cHeader.Filename)
-		cFilename := fmt.Sprintf("%d_%s%s", time.Now().UnixNano(), "playlist", cExt)
-		cDst, _ := os.Create(filepath.Join("public", "uploads", cFilename))
-		io.Copy(cDst, cFile)
-		cDst.Close()
-		coverURL = "/uploads/" + cFilename
+	coverURL, err := a.saveImage(form.Files["cover"])
+	if writeUploadErr(w, err) {
+		return
 	}
 
-	writeJSON(w, 201, a.PlayS.Create(u.ID, title, coverURL))
+	p, err := a.PlayS.Create(u.ID, title, coverURL)
+	if mapServiceErr(w, err) {
+		return
+	}
+	writeJSON(w, 201, a.signPlaylist(r, p))
 }
 
 func (a *App) ListPlaylists(w http.ResponseWriter, r *http.Request) {
 	u := userFromCtx(r)
-	writeJSON(w, 200, a.PlayS.List(u.ID))
+	writeJSON(w, 200, a.signPlaylists(r, a.PlayS.List(u.ID)))
 }
 
 type addTrackReq struct {
@@ -59,6 +56,14 @@ func (a *App) PlaylistSubroutes(w http.ResponseWriter, r *http.Request) {
 		writeErr(w, 400, "invalid id")
 		return
 	}
+	if !keyAllows(w, r, services.PermManagePlaylists) {
+		return
+	}
+	// Admins may edit any playlist, so act as its owner from here on.
+	pl, err := a.Policy.Playlist(u, services.PermManagePlaylists, playlistID)
+	if mapServiceErr(w, err) {
+		return
+	}
 
 	if r.Method == http.MethodPost && len(parts) == 2 && parts[1] == "tracks" {
 		var req addTrackReq
@@ -72,8 +77,7 @@ func (a *App) PlaylistSubroutes(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := a.PlayS.AddTrack(u.ID, playlistID, trackID); err != nil {
-			writeErr(w, 500, "error")
+		if mapServiceErr(w, a.PlayS.AddTrack(pl.UserID, pl.ID, trackID)) {
 			return
 		}
 		writeJSON(w, 200, map[string]any{"status": "added"})
@@ -86,8 +90,7 @@ func (a *App) PlaylistSubroutes(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := a.PlayS.RemoveTrack(u.ID, playlistID, trackID); err != nil {
-			writeErr(w, 500, "error")
+		if mapServiceErr(w, a.PlayS.RemoveTrack(pl.UserID, pl.ID, trackID)) {
 			return
 		}
 		writeJSON(w, 200, map[string]any{"status": "removed"})
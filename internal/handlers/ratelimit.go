@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"YeahMusic/internal/ratelimit"
+)
+
+// defaultRateLimits can be changed per route with RATE_LIMITS, e.g.
+// "login=20/m,upload=off".
+var defaultRateLimits = map[string]ratelimit.Rule{
+	"login":          {Burst: 10, Per: time.Minute},
+	"login-2fa":      {Burst: 10, Per: time.Minute},
+	"register":       {Burst: 10, Per: time.Hour},
+	"token-refresh":  {Burst: 30, Per: time.Minute},
+	"password-reset": {Burst: 5, Per: 15 * time.Minute},
+	"verify-email":   {Burst: 20, Per: time.Hour},
+	"verify-resend":  {Burst: 5, Per: 15 * time.Minute},
+	"oidc":           {Burst: 30, Per: time.Minute},
+	"upload":         {Burst: 60, Per: time.Hour},
+}
+
+// RateLimit limits next per client IP and, behind Auth, per user as well.
+func (a *App) RateLimit(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys := []string{"ip:" + a.clientIP(r)}
+		if u := userFromCtx(r); u != nil {
+			keys = append(keys, "user:"+u.ID.Hex())
+		}
+		if !a.allow(w, r, route, keys...) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allow takes a token for each key and answers 429 when one is out.
+func (a *App) allow(w http.ResponseWriter, r *http.Request, route string, keys ...string) bool {
+	return !writeLimited(w, a.Limiter.Allow(r.Context(), route, keys...))
+}
+
+// writeLimited answers a *ratelimit.LimitError with 429 and Retry-After.
+func writeLimited(w http.ResponseWriter, err error) bool {
+	var le *ratelimit.LimitError
+	if !errors.As(err, &le) {
+		return false
+	}
+	w.Header().Set("Retry-After", le.RetryAfterSeconds())
+	writeErr(w, http.StatusTooManyRequests, "too many requests")
+	return true
+}
@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"os"
+
+	"YeahMusic/internal/tus"
+	"YeahMusic/internal/upload"
+)
+
+// resumableBase is where tus clients create their uploads.
+const resumableBase = "/api/uploads"
+
+// ResumableUploads takes track audio over tus, for files too large or
+// connections too flaky for UploadTrack. The metadata carries filename,
+// title, artist, lyrics, album_id and auto_album like the form does; the
+// finished upload becomes a track, whose id the final PATCH returns in
+// Upload-Result.
+func (a *App) ResumableUploads() http.Handler {
+	return &tus.Handler{
+		Store:    a.Tus,
+		Base:     resumableBase,
+		MaxSize:  a.Uploads.Resumable().MaxAudioBytes,
+		Owner:    func(r *http.Request) string { return userFromCtx(r).ID.Hex() },
+		Complete: a.completeUpload,
+	}
+}
+
+func (a *App) completeUpload(w http.ResponseWriter, r *http.Request, up *tus.Upload, data *os.File) (string, bool) {
+	m := up.Metadata
+	file, err := a.Uploads.Resumable().Stage(a.Blobs, data, m["filename"], upload.Audio)
+	if writeUploadErr(w, err) {
+		return "", false
+	}
+	defer file.Blob.Abort()
+	form := trackUpload{
+		Title: m["title"], Artist: m["artist"], Lyrics: m["lyrics"], AlbumID: m["album_id"],
+		AutoAlbum: m["auto_album"] != "false",
+	}
+	track, ok := a.addTrack(w, r, form, file.Blob, file.Result)
+	if !ok {
+		return "", false
+	}
+	return track.ID.Hex(), true
+}
@@ -2,38 +2,77 @@ package handlers
 
 import (
 	"net/http"
+
+	"YeahMusic/internal/services"
+	"YeahMusic/internal/storage"
 )
 
 func NewRouter(app *App) *http.ServeMux {
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /health", app.Health)
 
-	mux.HandleFunc("POST /api/register", app.Register)
-	mux.HandleFunc("POST /api/login", app.Login)
+	mux.Handle("POST /api/register", app.RateLimit("register", http.HandlerFunc(app.Register)))
+	mux.Handle("POST /api/login", app.RateLimit("login", http.HandlerFunc(app.Login)))
+	mux.Handle("POST /api/login/2fa", app.RateLimit("login-2fa", http.HandlerFunc(app.LoginMFA)))
+	mux.Handle("POST /api/token/refresh", app.RateLimit("token-refresh", http.HandlerFunc(app.RefreshToken)))
+	mux.HandleFunc("POST /api/password/forgot", app.ForgotPassword)
+	mux.HandleFunc("POST /api/password/reset", app.ResetPassword)
+	mux.Handle("GET /api/verify-email", app.RateLimit("verify-email", http.HandlerFunc(app.VerifyEmail)))
+	mux.Handle("POST /api/verify-email", app.RateLimit("verify-email", http.HandlerFunc(app.VerifyEmail)))
+	mux.Handle("POST /api/verify-email/resend", app.Auth(app.SessionOnly(http.HandlerFunc(app.ResendVerification))))
+	mux.HandleFunc("GET /api/oidc/providers", app.ListOIDCProviders)
+	mux.Handle("GET /api/oidc/", app.RateLimit("oidc", http.HandlerFunc(app.OIDCSubroutes)))
+	mux.Handle("POST /api/logout", app.Auth(app.SessionOnly(http.HandlerFunc(app.Logout))))
+	mux.Handle("POST /api/logout-all", app.Auth(app.SessionOnly(http.HandlerFunc(app.LogoutAll))))
+	mux.Handle("GET /api/sessions", app.Auth(app.SessionOnly(http.HandlerFunc(app.ListSessions))))
+	mux.Handle("DELETE /api/sessions/", app.Auth(app.SessionOnly(http.HandlerFunc(app.RevokeSession))))
+
+	mux.Handle("POST /api/2fa/setup", app.Auth(app.SessionOnly(http.HandlerFunc(app.SetupTOTP))))
+	mux.Handle("POST /api/2fa/enable", app.Auth(app.SessionOnly(http.HandlerFunc(app.EnableTOTP))))
+	mux.Handle("POST /api/2fa/disable", app.Auth(app.SessionOnly(http.HandlerFunc(app.DisableTOTP))))
+	mux.Handle("POST /api/2fa/recovery-codes", app.Auth(app.SessionOnly(http.HandlerFunc(app.RegenerateRecoveryCodes))))
+
+	mux.HandleFunc("GET /api/api-keys/scopes", app.ListAPIKeyScopes)
+	mux.Handle("GET /api/api-keys", app.Auth(app.SessionOnly(http.HandlerFunc(app.ListAPIKeys))))
+	mux.Handle("POST /api/api-keys", app.Auth(app.SessionOnly(http.HandlerFunc(app.CreateAPIKey))))
+	mux.Handle("DELETE /api/api-keys/", app.Auth(app.SessionOnly(http.HandlerFunc(app.RevokeAPIKey))))
 
 	mux.HandleFunc("GET /api/artists", app.ListArtists)
-	mux.HandleFunc("GET /api/albums", app.ListAlbums)
-	mux.HandleFunc("GET /api/tracks", app.ListTracks)
+	mux.Handle("GET /api/albums", app.OptionalAuth(http.HandlerFunc(app.ListAlbums)))
+	mux.Handle("GET /api/tracks", app.OptionalAuth(http.HandlerFunc(app.ListTracks)))
+	mux.Handle("GET /api/tracks/{id}/stream", app.OptionalAuth(http.HandlerFunc(app.StreamTrack)))
+	mux.HandleFunc("GET /api/tracks/{id}/waveform", app.TrackWaveform)
 
-	mux.Handle("POST /api/upload", app.Auth(http.HandlerFunc(app.UploadTrack)))
-	mux.Handle("POST /api/tracks/", app.Auth(http.HandlerFunc(app.UpdateTrackHandler)))
-	mux.Handle("DELETE /api/tracks/", app.Auth(http.HandlerFunc(app.DeleteTrackHandler)))
+	mux.Handle("POST /api/upload", app.Auth(app.RateLimit("upload", app.Require(services.PermUploadTrack, http.HandlerFunc(app.UploadTrack)))))
+	resumable := app.ResumableUploads()
+	mux.Handle("OPTIONS "+resumableBase, resumable)
+	mux.Handle("POST "+resumableBase, app.Auth(app.RateLimit("upload", app.Require(services.PermUploadTrack, resumable))))
+	// Method patterns, since GET / would conflict with a bare path; POST is
+	// for clients that send X-HTTP-Method-Override.
+	for _, m := range []string{"HEAD", "PATCH", "DELETE", "POST"} {
+		mux.Handle(m+" "+resumableBase+"/", app.Auth(app.Require(services.PermUploadTrack, resumable)))
+	}
+	mux.Handle("POST /api/tracks/", app.Auth(app.Require(services.PermEditTrack, http.HandlerFunc(app.UpdateTrackHandler))))
+	mux.Handle("DELETE /api/tracks/", app.Auth(app.Require(services.PermDeleteTrack, http.HandlerFunc(app.DeleteTrackHandler))))
 
-	mux.Handle("POST /api/albums", app.Auth(http.HandlerFunc(app.CreateAlbumHandler)))
+	mux.Handle("POST /api/albums", app.Auth(app.Require(services.PermCreateAlbum, http.HandlerFunc(app.CreateAlbumHandler))))
 
-	mux.Handle("POST /api/playlists", app.Auth(http.HandlerFunc(app.CreatePlaylist)))
-	mux.Handle("GET /api/playlists", app.Auth(http.HandlerFunc(app.ListPlaylists)))
+	mux.Handle("POST /api/playlists", app.Auth(app.Require(services.PermManagePlaylists, http.HandlerFunc(app.CreatePlaylist))))
+	mux.Handle("GET /api/playlists", app.Auth(app.Require(services.PermReadPlaylists, http.HandlerFunc(app.ListPlaylists))))
 	mux.Handle("POST /api/playlists/", app.Auth(http.HandlerFunc(app.PlaylistSubroutes)))
 	mux.Handle("DELETE /api/playlists/", app.Auth(http.HandlerFunc(app.PlaylistSubroutes)))
 
-	mux.HandleFunc("GET /api/admin/ping", app.AdminPing)
+	mux.Handle("GET /api/admin/ping", app.Auth(app.Require(services.PermAdmin, http.HandlerFunc(app.AdminPing))))
+	mux.Handle("GET /api/admin/role-requests", app.Auth(app.Require(services.PermAdmin, http.HandlerFunc(app.ListRoleRequests))))
+	mux.Handle("POST /api/admin/role-requests/", app.Auth(app.Require(services.PermAdmin, http.HandlerFunc(app.DecideRoleRequest))))
+	mux.Handle("DELETE /api/admin/users/", app.Auth(app.Require(services.PermAdmin, http.HandlerFunc(app.ResetUserTOTP))))
 
 	fs := http.FileServer(http.Dir("./public"))
 	mux.Handle("GET /", http.StripPrefix("/", fs))
 	mux.Handle("GET /css/", http.StripPrefix("/", fs))
 	mux.Handle("GET /js/", http.StripPrefix("/", fs))
-	mux.Handle("GET /audio/", http.StripPrefix("/", fs))
-	mux.Handle("GET /uploads/", http.StripPrefix("/", fs))
+	mux.Handle("GET /audio/", app.MediaFiles(http.StripPrefix("/", fs)))
+	mux.Handle("GET /uploads/", app.MediaFiles(storage.Handler(app.Blobs)))
 	mux.Handle("GET /manifest.json", http.StripPrefix("/", fs))
 
 	return mux
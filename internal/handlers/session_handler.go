@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"YeahMusic/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type refreshReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (a *App) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req refreshReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		writeErr(w, 400, "refresh_token required")
+		return
+	}
+	sec, err := a.AuthS.Refresh(req.RefreshToken, a.clientInfo(r))
+	if err != nil {
+		writeErr(w, 401, "invalid refresh token")
+		return
+	}
+	writeJSON(w, 200, tokenResponse(sec, nil))
+}
+
+func (a *App) Logout(w http.ResponseWriter, r *http.Request) {
+	if mapServiceErr(w, a.AuthS.Logout(sessionFromCtx(r))) {
+		return
+	}
+	writeJSON(w, 200, map[string]any{"status": "logged out"})
+}
+
+func (a *App) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	u := userFromCtx(r)
+	n := a.AuthS.LogoutAll(u.ID)
+	writeJSON(w, 200, map[string]any{"status": "logged out", "revoked": n})
+}
+
+type sessionView struct {
+	*models.Session
+	Current bool `json:"current"`
+}
+
+func (a *App) ListSessions(w http.ResponseWriter, r *http.Request) {
+	u := userFromCtx(r)
+	cur := sessionFromCtx(r)
+	res := []sessionView{}
+	for _, sec := range a.AuthS.ListSessions(u.ID) {
+		res = append(res, sessionView{Session: sec, Current: sec.ID == cur.ID})
+	}
+	writeJSON(w, 200, res)
+}
+
+func (a *App) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	u := userFromCtx(r)
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 {
+		writeErr(w, 400, "bad path")
+		return
+	}
+	id, err := primitive.ObjectIDFromHex(parts[2])
+	if err != nil {
+		writeErr(w, 400, "bad id")
+		return
+	}
+	if mapServiceErr(w, a.AuthS.RevokeSession(u.ID, id)) {
+		return
+	}
+	writeJSON(w, 200, map[string]any{"status": "revoked"})
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"YeahMusic/internal/stream"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// StreamTrack serves the audio of a track and counts the plays it starts.
+// An audio element cannot send the bearer token, so the signed stream_url
+// of the track is accepted instead.
+func (a *App) StreamTrack(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeErr(w, 400, "bad id")
+		return
+	}
+	listener, maxAge, ok := a.streamListener(r)
+	if !ok {
+		writeErr(w, http.StatusForbidden, "invalid or expired link")
+		return
+	}
+	t, err := a.CatalogS.GetTrack(id)
+	if mapServiceErr(w, err) {
+		return
+	}
+	f, info, err := stream.Open(a.Blobs, publicDir, t.AudioURL)
+	switch {
+	case errors.Is(err, stream.ErrNotLocal), errors.Is(err, os.ErrNotExist):
+		writeErr(w, 404, "audio not available")
+		return
+	case err != nil:
+		log.Println("stream", t.ID.Hex()+":", err)
+		writeErr(w, 500, "cannot read audio")
+		return
+	}
+	defer f.Close()
+
+	length := time.Duration(t.DurationSec) * time.Second
+	stream.Serve(w, r, f, info, maxAge, func() {
+		a.Plays.Start(stream.Event{TrackID: t.ID, Listener: listener}, length)
+	})
+}
+
+// streamListener identifies the caller of a stream request and says how
+// long the response may be cached: a signed link until it expires, the
+// bearer token for an hour.
+func (a *App) streamListener(r *http.Request) (string, time.Duration, bool) {
+	if u := userFromCtx(r); u != nil {
+		return "user:" + u.ID.Hex(), time.Hour, true
+	}
+	c, err := a.Media.Verify(r.URL.Path, r.URL.Query())
+	if err != nil || (c.User != "" || c.Session != "") && !a.mediaLive(c) {
+		return "", 0, false
+	}
+	listener := "user:" + c.User
+	if c.User == "" {
+		listener = "anon:" + a.clientIP(r) + "|" + r.UserAgent()
+	}
+	return listener, time.Until(c.Expires), true
+}
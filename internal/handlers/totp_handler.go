@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"YeahMusic/internal/services"
+)
+
+type mfaCodeReq struct {
+	Code string `json:"code"`
+}
+
+type loginMFAReq struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// LoginMFA is the second step of a login that answered mfa_required. The
+// code is a TOTP code or one of the recovery codes.
+func (a *App) LoginMFA(w http.ResponseWriter, r *http.Request) {
+	var req loginMFAReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		writeErr(w, 400, "mfa_token and code required")
+		return
+	}
+	sec, u, err := a.AuthS.VerifyMFA(req.MFAToken, req.Code, a.clientInfo(r))
+	if writeLimited(w, err) {
+		return
+	}
+	if err != nil {
+		writeErr(w, 401, "invalid code, sign in again")
+		return
+	}
+	writeJSON(w, 200, tokenResponse(sec, u))
+}
+
+func (a *App) SetupTOTP(w http.ResponseWriter, r *http.Request) {
+	secret, uri, err := a.AuthS.BeginTOTP(userFromCtx(r))
+	if err == services.ErrAlreadyExists {
+		writeErr(w, 409, "two-factor authentication is already on")
+		return
+	}
+	if mapServiceErr(w, err) {
+		return
+	}
+	writeJSON(w, 200, map[string]any{"secret": secret, "otpauth_url": uri})
+}
+
+func decodeMFACode(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req mfaCodeReq
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		writeErr(w, 400, "code required")
+		return "", false
+	}
+	return req.Code, true
+}
+
+func (a *App) EnableTOTP(w http.ResponseWriter, r *http.Request) {
+	code, ok := decodeMFACode(w, r)
+	if !ok {
+		return
+	}
+	codes, err := a.AuthS.EnableTOTP(userFromCtx(r), code)
+	switch err {
+	case services.ErrNotFound:
+		writeErr(w, 400, "call /api/2fa/setup first")
+		return
+	case services.ErrUnauthorized:
+		writeErr(w, 400, "invalid code")
+		return
+	}
+	if mapServiceErr(w, err) {
+		return
+	}
+	writeJSON(w, 200, map[string]any{"status": "enabled", "recovery_codes": codes})
+}
+
+func (a *App) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	code, ok := decodeMFACode(w, r)
+	if !ok {
+		return
+	}
+	err := a.AuthS.DisableTOTP(userFromCtx(r), code)
+	switch err {
+	case services.ErrForbidden:
+		writeErr(w, 403, "two-factor authentication is required for your role")
+		return
+	case services.ErrUnauthorized:
+		writeErr(w, 400, "invalid code")
+		return
+	}
+	if mapServiceErr(w, err) {
+		return
+	}
+	writeJSON(w, 200, map[string]any{"status": "disabled"})
+}
+
+func (a *App) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	code, ok := decodeMFACode(w, r)
+	if !ok {
+		return
+	}
+	codes, err := a.AuthS.RegenerateRecoveryCodes(userFromCtx(r), code)
+	if err == services.ErrUnauthorized {
+		writeErr(w, 400, "invalid code")
+		return
+	}
+	if mapServiceErr(w, err) {
+		return
+	}
+	writeJSON(w, 200, map[string]any{"recovery_codes": codes})
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+
+	"YeahMusic/internal/audio"
+	"YeahMusic/internal/storage"
+	"YeahMusic/internal/upload"
+)
+
+// publicDir is served at /; uploadDir is where the local blob store keeps
+// accepted files.
+var (
+	publicDir = "public"
+	uploadDir = filepath.Join(publicDir, "uploads")
+)
+
+// writeUploadErr answers for a rejected upload, or a failure to store one.
+func writeUploadErr(w http.ResponseWriter, err error) bool {
+	if err == nil {
+		return false
+	}
+	var ue *upload.Error
+	if errors.As(err, &ue) {
+		writeErr(w, ue.Status, ue.Msg)
+		return true
+	}
+	log.Println("upload:", err)
+	writeErr(w, 500, "cannot store upload")
+	return true
+}
+
+// errAnswered is returned by upload callbacks that have answered the
+// request themselves.
+var errAnswered = errors.New("answered")
+
+// readUpload reads the upload form of r into blobs; the caller closes the
+// form.
+func (a *App) readUpload(w http.ResponseWriter, r *http.Request, spec upload.Spec) (*upload.Form, bool) {
+	form, err := a.Uploads.ReadForm(w, r, a.Blobs, spec)
+	if errors.Is(err, errAnswered) || writeUploadErr(w, err) {
+		return nil, false
+	}
+	return form, true
+}
+
+// coverSpec is the spec of forms whose only file is a cover. An empty
+// title turns them away before the cover is read.
+var coverSpec = upload.Spec{
+	Files: map[string]upload.Kind{"cover": upload.Image},
+	Field: func(name, value string) error {
+		if name == "title" && value == "" {
+			return &upload.Error{Status: http.StatusBadRequest, Msg: "title required"}
+		}
+		return nil
+	},
+}
+
+// saveImage stores the image f, which may be nil, and returns its URL.
+func (a *App) saveImage(f *upload.File) (string, error) {
+	if f == nil {
+		return "", nil
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f.Blob, 0, f.Size))
+	f.Blob.Abort() // PutImage stores it without its metadata
+	if err != nil {
+		return "", err
+	}
+	name, err := storage.PutImage(a.Blobs, data, f.Format.Exts[0])
+	if err != nil {
+		return "", err
+	}
+	return "/uploads/" + name, nil
+}
+
+// saveCover stores a cover taken from the tags and returns its URL, or ""
+// when it does not pass as an upload or cannot be written.
+func (a *App) saveCover(p *audio.Picture) string {
+	f := bytes.NewReader(p.Data)
+	res, err := a.Uploads.Check(f, f.Size(), "", upload.Image)
+	if err == nil {
+		var name string
+		if name, err = storage.PutImage(a.Blobs, p.Data, res.Format.Exts[0]); err == nil {
+			return "/uploads/" + name
+		}
+	}
+	log.Println("save cover:", err)
+	return ""
+}
@@ -2,7 +2,14 @@ package handlers
 
 import (
 	"encoding/json"
+	"log"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+
+	"YeahMusic/internal/services"
 )
 
 func writeJSON(w http.ResponseWriter, code int, v any) {
@@ -14,3 +21,80 @@ func writeJSON(w http.ResponseWriter, code int, v any) {
 func writeErr(w http.ResponseWriter, code int, msg string) {
 	writeJSON(w, code, map[string]any{"error": msg})
 }
+
+func (a *App) clientIP(r *http.Request) string {
+	return ClientIP(r, a.TrustedProxies)
+}
+
+// TrustedProxiesFromEnv reads TRUSTED_PROXIES, the comma separated
+// addresses and CIDR prefixes of the reverse proxies in front of the
+// server. Entries that parse as neither are logged and skipped.
+func TrustedProxiesFromEnv() []netip.Prefix {
+	var res []netip.Prefix
+	for _, s := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if p, err := netip.ParsePrefix(s); err == nil {
+			res = append(res, p.Masked())
+			continue
+		}
+		if ip, err := netip.ParseAddr(s); err == nil {
+			res = append(res, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+			continue
+		}
+		log.Printf("TRUSTED_PROXIES: %q is not an address or prefix", s)
+	}
+	return res
+}
+
+// ClientIP is the address r came from. X-Forwarded-For and X-Real-IP are
+// only believed when the peer is one of proxies; X-Forwarded-For is then
+// read from the right, skipping the proxies, because a client can put
+// anything at its start.
+func ClientIP(r *http.Request, proxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	trusted := func(s string) bool {
+		ip, err := netip.ParseAddr(strings.TrimSpace(s))
+		if err != nil {
+			return false
+		}
+		for _, p := range proxies {
+			if p.Contains(ip.Unmap()) {
+				return true
+			}
+		}
+		return false
+	}
+	if !trusted(host) {
+		return host
+	}
+
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		if !trusted(hop) {
+			return hop
+		}
+	}
+	if ip := strings.TrimSpace(r.Header.Get("X-Real-IP")); len(hops) == 0 && ip != "" {
+		if _, err := netip.ParseAddr(ip); err == nil {
+			return ip
+		}
+	}
+	return host
+}
+
+func (a *App) clientInfo(r *http.Request) services.ClientInfo {
+	return services.ClientInfo{UserAgent: r.UserAgent(), IP: a.clientIP(r)}
+}
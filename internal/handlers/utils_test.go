@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1, nonsense, 2001:db8::/32")
+	proxies := TrustedProxiesFromEnv()
+	if len(proxies) != 3 {
+		t.Fatalf("proxies %v, want 3", proxies)
+	}
+
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		{"direct", "203.0.113.7:5000", nil, "", "203.0.113.7"},
+		{"header from a client", "203.0.113.7:5000", []string{"198.51.100.1"}, "198.51.100.2", "203.0.113.7"},
+		{"behind a proxy", "10.1.2.3:443", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"single address proxy", "192.0.2.1:443", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"spoofed start", "10.1.2.3:443", []string{"1.2.3.4, 198.51.100.1"}, "", "198.51.100.1"},
+		{"two proxies", "10.1.2.3:443", []string{"198.51.100.1, 10.9.9.9"}, "", "198.51.100.1"},
+		{"one header per hop", "10.1.2.3:443", []string{"1.2.3.4", "198.51.100.1"}, "", "198.51.100.1"},
+		{"only proxies", "10.1.2.3:443", []string{"10.4.4.4"}, "", "10.1.2.3"},
+		{"garbage", "10.1.2.3:443", []string{"not an ip"}, "", "10.1.2.3"},
+		{"real ip", "10.1.2.3:443", nil, "198.51.100.1", "198.51.100.1"},
+		{"ipv6 proxy", "[2001:db8::1]:443", []string{"198.51.100.1"}, "", "198.51.100.1"},
+		{"ipv4 mapped", "[::ffff:10.1.2.3]:443", []string{"198.51.100.1"}, "", "198.51.100.1"},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tc.remote
+		for _, v := range tc.xff {
+			r.Header.Add("X-Forwarded-For", v)
+		}
+		if tc.realIP != "" {
+			r.Header.Set("X-Real-IP", tc.realIP)
+		}
+		if got := ClientIP(r, proxies); got != tc.want {
+			t.Errorf("%s: %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
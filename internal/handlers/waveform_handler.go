@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+
+	"YeahMusic/internal/analysis"
+	"YeahMusic/internal/stream"
+	"YeahMusic/internal/waveform"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TrackWaveform serves the peaks of a track for drawing its waveform, see
+// waveform.Format and waveform.Requested. They are made when the track is
+// uploaded, or by the measure-loudness backfill for tracks older than that;
+// until then the answer is 404.
+func (a *App) TrackWaveform(w http.ResponseWriter, r *http.Request) {
+	id, err := primitive.ObjectIDFromHex(r.PathValue("id"))
+	if err != nil {
+		writeErr(w, 400, "bad id")
+		return
+	}
+	format := waveform.Format(r)
+	if format == "" {
+		writeErr(w, 400, "format is json or dat")
+		return
+	}
+	t, err := a.CatalogS.GetTrack(id)
+	if mapServiceErr(w, err) {
+		return
+	}
+	s, name, err := stream.Blob(a.Blobs, publicDir, t.AudioURL)
+	if err != nil {
+		writeErr(w, 404, "waveform not available")
+		return
+	}
+	p, err := analysis.Peaks(s, name, waveform.Requested(r))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		writeErr(w, 404, "waveform not available")
+		return
+	case err != nil:
+		log.Println("waveform", t.ID.Hex()+":", err)
+		writeErr(w, 500, "cannot read audio")
+		return
+	}
+	waveform.Serve(w, p, format)
+}
@@ -0,0 +1,198 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+)
+
+var errCorrupt = errors.New("imaging: corrupt image")
+
+// Strip removes EXIF, XMP, IPTC and text metadata from a JPEG, PNG or WebP
+// file without touching the image data. Other data is returned unchanged.
+func Strip(b []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(b, []byte{0xff, 0xd8}):
+		return stripJPEG(b)
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return stripPNG(b)
+	case len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP":
+		return stripWebP(b)
+	}
+	return b, nil
+}
+
+// stripJPEG drops the APPn segments other than JFIF (APP0), the ICC profile
+// (APP2) and Adobe's colour transform (APP14), and comments.
+func stripJPEG(b []byte) ([]byte, error) {
+	out := append([]byte(nil), b[:2]...)
+	i := 2
+	for {
+		if i+4 > len(b) || b[i] != 0xff {
+			return nil, errCorrupt
+		}
+		marker := b[i+1]
+		if marker == 0xff { // fill byte
+			i++
+			continue
+		}
+		if marker == 0xda { // start of scan: the rest is image data
+			return append(out, b[i:]...), nil
+		}
+		n := int(binary.BigEndian.Uint16(b[i+2:]))
+		if n < 2 || i+2+n > len(b) {
+			return nil, errCorrupt
+		}
+		keep := !(marker >= 0xe1 && marker <= 0xef && marker != 0xe2 && marker != 0xee || marker == 0xfe)
+		if keep {
+			out = append(out, b[i:i+2+n]...)
+		}
+		i += 2 + n
+	}
+}
+
+var pngMeta = map[string]bool{"eXIf": true, "tEXt": true, "zTXt": true, "iTXt": true, "tIME": true}
+
+func stripPNG(b []byte) ([]byte, error) {
+	out := append([]byte(nil), b[:8]...)
+	for i := 8; i < len(b); {
+		if i+12 > len(b) {
+			return nil, errCorrupt
+		}
+		n := int(binary.BigEndian.Uint32(b[i:]))
+		end := i + 12 + n
+		if n < 0 || end > len(b) {
+			return nil, errCorrupt
+		}
+		if !pngMeta[string(b[i+4:i+8])] {
+			out = append(out, b[i:end]...)
+		}
+		i = end
+	}
+	return out, nil
+}
+
+// stripWebP drops the EXIF and XMP chunks of an extended WebP and clears
+// their flags in the VP8X header.
+func stripWebP(b []byte) ([]byte, error) {
+	out := append([]byte(nil), b[:12]...)
+	for i := 12; i < len(b); {
+		if i+8 > len(b) {
+			return nil, errCorrupt
+		}
+		n := int(binary.LittleEndian.Uint32(b[i+4:]))
+		end := i + 8 + n + n&1
+		if end > len(b) {
+			return nil, errCorrupt
+		}
+		switch id := string(b[i : i+4]); id {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			start := len(out)
+			out = append(out, b[i:end]...)
+			out[start+8] &^= 0x08 | 0x04
+		default:
+			out = append(out, b[i:end]...)
+		}
+		i = end
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
+
+// orientation reads the EXIF orientation of a JPEG: 1 is upright, 0 means
+// there is no tag.
+func orientation(b []byte) int {
+	if !bytes.HasPrefix(b, []byte{0xff, 0xd8}) {
+		return 0
+	}
+	for i := 2; i+4 <= len(b) && b[i] == 0xff; {
+		marker := b[i+1]
+		if marker == 0xda {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(b[i+2:]))
+		if n < 2 || i+2+n > len(b) {
+			break
+		}
+		seg := b[i+4 : i+2+n]
+		if marker == 0xe1 && bytes.HasPrefix(seg, []byte("Exif\x00\x00")) {
+			return exifOrientation(seg[6:])
+		}
+		i += 2 + n
+	}
+	return 0
+}
+
+func exifOrientation(t []byte) int {
+	if len(t) < 8 {
+		return 0
+	}
+	var bo binary.ByteOrder
+	switch string(t[:2]) {
+	case "II":
+		bo = binary.LittleEndian
+	case "MM":
+		bo = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(bo.Uint32(t[4:]))
+	if ifd+2 > len(t) {
+		return 0
+	}
+	count := int(bo.Uint16(t[ifd:]))
+	for e := 0; e < count; e++ {
+		p := ifd + 2 + e*12
+		if p+12 > len(t) {
+			return 0
+		}
+		if bo.Uint16(t[p:]) == 0x0112 {
+			if o := int(bo.Uint16(t[p+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			return 0
+		}
+	}
+	return 0
+}
+
+// orient applies EXIF orientation o (2-8) to img.
+func orient(img image.Image, o int) image.Image {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	w, h := b.Dx(), b.Dy()
+	if o >= 5 {
+		w, h = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	sw, sh := b.Dx(), b.Dy()
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			var dx, dy int
+			switch o {
+			case 2:
+				dx, dy = sw-1-x, y
+			case 3:
+				dx, dy = sw-1-x, sh-1-y
+			case 4:
+				dx, dy = x, sh-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = sh-1-y, x
+			case 7:
+				dx, dy = sh-1-y, sw-1-x
+			case 8:
+				dx, dy = y, sw-1-x
+			default:
+				dx, dy = x, y
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(x, y):][:4])
+		}
+	}
+	return dst
+}
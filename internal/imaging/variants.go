@@ -0,0 +1,152 @@
+// Package imaging turns uploaded covers into square JPEG variants for the
+// sizes the clients show, and strips the metadata cameras and editors leave
+// in image files.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupported is returned for originals the standard library cannot
+// decode (WebP); they are kept as they are, without variants.
+var ErrUnsupported = errors.New("imaging: cannot decode image")
+
+// Sizes are the sides of the variants, in pixels. Sizes larger than the
+// original are skipped rather than upscaled.
+var Sizes = []int{64, 300, 640}
+
+const quality = 85
+
+// VariantName is the file next to name holding the variant of the given size.
+func VariantName(name string, size int) string {
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "_" + strconv.Itoa(size) + ".jpg"
+}
+
+// Clean strips the metadata of an image file. A JPEG with an EXIF
+// orientation is re-encoded upright instead, since the tag goes with the
+// metadata.
+func Clean(data []byte) ([]byte, error) {
+	if o := orientation(data); o > 1 {
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, orient(img, o), &jpeg.Options{Quality: 92}); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return Strip(data)
+}
+
+// MakeVariants encodes the variants of an image file, by size.
+func MakeVariants(data []byte) (map[int][]byte, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupported
+	}
+	sq := square(img)
+	res := map[int][]byte{}
+	for _, size := range Sizes {
+		if size > sq.Bounds().Dx() {
+			continue
+		}
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resize(sq, size), &jpeg.Options{Quality: quality}); err != nil {
+			return nil, err
+		}
+		res[size] = buf.Bytes()
+	}
+	return res, nil
+}
+
+// square crops the centre of img and flattens it onto white, JPEG having no
+// transparency.
+func square(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	off := image.Pt(b.Min.X+(b.Dx()-side)/2, b.Min.Y+(b.Dy()-side)/2)
+	dst := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, off, draw.Over)
+	return dst
+}
+
+// resize scales the square src down to size by averaging the source area
+// under every target pixel, one axis at a time.
+func resize(src *image.RGBA, size int) *image.RGBA {
+	n := src.Bounds().Dx()
+	if size >= n {
+		return src
+	}
+	weights := boxWeights(n, size)
+
+	// Horizontal pass into n rows of size pixels.
+	tmp := make([]float32, n*size*4)
+	for y := 0; y < n; y++ {
+		row := src.Pix[y*src.Stride:]
+		for x, ws := range weights {
+			var acc [4]float32
+			for _, w := range ws {
+				p := row[w.i*4:]
+				acc[0] += float32(p[0]) * w.w
+				acc[1] += float32(p[1]) * w.w
+				acc[2] += float32(p[2]) * w.w
+				acc[3] += float32(p[3]) * w.w
+			}
+			copy(tmp[(y*size+x)*4:], acc[:])
+		}
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, size, size))
+	for x := 0; x < size; x++ {
+		for y, ws := range weights {
+			var acc [4]float32
+			for _, w := range ws {
+				p := tmp[(w.i*size+x)*4:]
+				acc[0] += p[0] * w.w
+				acc[1] += p[1] * w.w
+				acc[2] += p[2] * w.w
+				acc[3] += p[3] * w.w
+			}
+			o := dst.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				dst.Pix[o+c] = uint8(min(acc[c]+0.5, 255))
+			}
+		}
+	}
+	return dst
+}
+
+type weight struct {
+	i int
+	w float32
+}
+
+// boxWeights says which of n source pixels, and how much of each, make up
+// each of size target pixels.
+func boxWeights(n, size int) [][]weight {
+	scale := float64(n) / float64(size)
+	res := make([][]weight, size)
+	for d := range res {
+		lo, hi := float64(d)*scale, float64(d+1)*scale
+		for i := int(lo); i < n && float64(i) < hi; i++ {
+			cover := min(hi, float64(i+1)) - max(lo, float64(i))
+			if cover > 0 {
+				res[d] = append(res[d], weight{i, float32(cover / scale)})
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,104 @@
+package loudness
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"YeahMusic/internal/models"
+)
+
+// Reference is the loudness ReplayGain 2.0 brings audio to, in LUFS.
+const Reference = -18
+
+// Measure is what the loudness of audio is worked out from. It is kept for
+// every track so that an album can be measured from its tracks without
+// decoding them again.
+type Measure struct {
+	// Peak is the true peak, 1 being full scale.
+	Peak float64
+	// Blocks holds the mean square of the K-weighted signal over every
+	// gating block, summed over the channels.
+	Blocks []float64
+}
+
+// Join measures audio made of all of ms, like an album of its tracks.
+func Join(ms ...*Measure) *Measure {
+	res := &Measure{}
+	for _, m := range ms {
+		res.Peak = max(res.Peak, m.Peak)
+		res.Blocks = append(res.Blocks, m.Blocks...)
+	}
+	return res
+}
+
+// Loudness gates the blocks as BS.1770 does, first at -70 LUFS and then at
+// 10 LU below the loudness of the blocks left. Silence gives nil.
+func (m *Measure) Loudness() *models.Loudness {
+	gated := func(threshold float64) (float64, bool) {
+		sum, n := 0.0, 0
+		for _, e := range m.Blocks {
+			if lufs(e) > threshold {
+				sum += e
+				n++
+			}
+		}
+		return sum / float64(n), n > 0
+	}
+	e, ok := gated(-70)
+	if !ok {
+		return nil
+	}
+	e, _ = gated(lufs(e) - 10)
+	l := lufs(e)
+	return &models.Loudness{
+		Integrated: round(l, 2),
+		TruePeak:   round(20*math.Log10(m.Peak), 2),
+		Gain:       round(Reference-l, 2),
+		Peak:       round(m.Peak, 6),
+	}
+}
+
+func lufs(e float64) float64 { return -0.691 + 10*math.Log10(e) }
+
+func round(x float64, digits int) float64 {
+	p := math.Pow(10, float64(digits))
+	return math.Round(x*p) / p
+}
+
+// Name is the blob next to the audio blob holding its Measure. It goes with
+// the audio when uploads are collected.
+func Name(audio string) string {
+	return strings.TrimSuffix(audio, filepath.Ext(audio)) + "_r128.dat"
+}
+
+// The binary form is a version, the peak as a float64 and the blocks as
+// float32s, little-endian.
+const measureVersion = 1
+
+var errFormat = errors.New("loudness: bad measure data")
+
+func (m *Measure) MarshalBinary() ([]byte, error) {
+	b := make([]byte, 12, 12+4*len(m.Blocks))
+	binary.LittleEndian.PutUint32(b[0:], measureVersion)
+	binary.LittleEndian.PutUint64(b[4:], math.Float64bits(m.Peak))
+	for _, e := range m.Blocks {
+		b = binary.LittleEndian.AppendUint32(b, math.Float32bits(float32(e)))
+	}
+	return b, nil
+}
+
+// UnmarshalBinary reads what MarshalBinary writes.
+func (m *Measure) UnmarshalBinary(b []byte) error {
+	if len(b) < 12 || len(b)%4 != 0 || binary.LittleEndian.Uint32(b) != measureVersion {
+		return errFormat
+	}
+	m.Peak = math.Float64frombits(binary.LittleEndian.Uint64(b[4:]))
+	m.Blocks = make([]float64, 0, (len(b)-12)/4)
+	for i := 12; i < len(b); i += 4 {
+		m.Blocks = append(m.Blocks, float64(math.Float32frombits(binary.LittleEndian.Uint32(b[i:]))))
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+// Package media signs the URLs of uploaded files so they can only be fetched
+// for a limited time, and optionally only while the user or session they
+// were handed to is still around.
+package media
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnsigned   = errors.New("media: url is not signed")
+	ErrBadSig     = errors.New("media: bad signature")
+	ErrExpired    = errors.New("media: url expired")
+	ErrUnknownKey = errors.New("media: unknown signing key")
+)
+
+// Prefixes are the local paths that need a signature. /api/tracks/ is there
+// for the stream endpoint, which browsers fetch without the bearer token.
+var Prefixes = []string{"/uploads/", "/audio/", "/api/tracks/"}
+
+var imageExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true}
+
+// Bind modes: what a signed URL is tied to besides its expiry.
+const (
+	BindNone    = "none"
+	BindUser    = "user"
+	BindSession = "session"
+)
+
+// Key is one signing secret. Keys are told apart by ID, which travels in
+// the URL, so old keys can keep verifying while a new one signs.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+// Claims is what a verified URL grants.
+type Claims struct {
+	Path    string
+	User    string
+	Session string
+	Expires time.Time
+}
+
+type Signer struct {
+	keys []Key // keys[0] signs, all of them verify
+	TTL  time.Duration
+	Bind string
+	// PublicImages leaves cover images unsigned.
+	PublicImages bool
+	now          func() time.Time
+}
+
+func NewSigner(ttl time.Duration, keys ...Key) *Signer {
+	return &Signer{keys: keys, TTL: ttl, Bind: BindNone, now: time.Now}
+}
+
+// SignerFromEnv reads MEDIA_SIGNING_KEYS ("id:secret,..."; the first one
+// signs, the others only verify, which is how keys are rotated),
+// MEDIA_URL_TTL (4h), MEDIA_URL_BIND (none, user or session) and
+// MEDIA_PUBLIC_COVERS. Without keys a random one is used and links die with
+// the process.
+func SignerFromEnv() *Signer {
+	var keys []Key
+	for _, item := range strings.Split(os.Getenv("MEDIA_SIGNING_KEYS"), ",") {
+		id, secret, ok := strings.Cut(strings.TrimSpace(item), ":")
+		if !ok || id == "" || secret == "" {
+			continue
+		}
+		keys = append(keys, Key{ID: id, Secret: []byte(secret)})
+	}
+	if len(keys) == 0 {
+		b := make([]byte, 32)
+		_, _ = rand.Read(b)
+		keys = append(keys, Key{ID: "tmp", Secret: b})
+	}
+	ttl := 4 * time.Hour
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv("MEDIA_URL_TTL"))); err == nil && d > 0 {
+		ttl = d
+	}
+	s := NewSigner(ttl, keys...)
+	switch b := strings.TrimSpace(os.Getenv("MEDIA_URL_BIND")); b {
+	case "", BindNone:
+	case BindUser, BindSession:
+		s.Bind = b
+	default:
+		log.Printf("MEDIA_URL_BIND: unknown mode %q, links are not bound", b)
+	}
+	s.PublicImages, _ = strconv.ParseBool(strings.TrimSpace(os.Getenv("MEDIA_PUBLIC_COVERS")))
+	return s
+}
+
+// NeedsSignature reports whether p is a local media path that is not public.
+func (s *Signer) NeedsSignature(p string) bool {
+	for _, prefix := range Prefixes {
+		if strings.HasPrefix(p, prefix) {
+			return !(s.PublicImages && imageExts[strings.ToLower(path.Ext(p))])
+		}
+	}
+	return false
+}
+
+func (s *Signer) mac(k Key, p string, exp int64, user, session string) string {
+	m := hmac.New(sha256.New, k.Secret)
+	m.Write([]byte(p + "\n" + strconv.FormatInt(exp, 10) + "\n" + user + "\n" + session))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// Sign returns raw with a signature added, or unchanged when it is empty,
+// remote or public. user and session are the caller's ids; which of them
+// end up in the URL depends on Bind, and an anonymous caller gets an
+// unbound URL.
+func (s *Signer) Sign(raw, user, session string) string {
+	if raw == "" || !s.NeedsSignature(raw) || strings.Contains(raw, "?") {
+		return raw
+	}
+	switch s.Bind {
+	case BindSession:
+		// Callers with an API key have no session and get a user-bound URL.
+	case BindUser:
+		session = ""
+	default:
+		user, session = "", ""
+	}
+	// Expiries snap to a quarter of the TTL so the same file keeps the same
+	// URL for a while and browsers can cache it.
+	step := s.TTL / 4
+	if step <= 0 {
+		step = time.Second
+	}
+	exp := s.now().Add(s.TTL + step).Truncate(step).Unix()
+
+	p, err := url.PathUnescape(raw)
+	if err != nil {
+		return raw
+	}
+	k := s.keys[0]
+	q := url.Values{}
+	q.Set("exp", strconv.FormatInt(exp, 10))
+	q.Set("kid", k.ID)
+	if user != "" {
+		q.Set("u", user)
+	}
+	if session != "" {
+		q.Set("s", session)
+	}
+	q.Set("sig", s.mac(k, p, exp, user, session))
+	return raw + "?" + q.Encode()
+}
+
+// Verify checks the signature of a request for p, the unescaped path.
+func (s *Signer) Verify(p string, q url.Values) (*Claims, error) {
+	if q.Get("sig") == "" {
+		return nil, ErrUnsigned
+	}
+	exp, err := strconv.ParseInt(q.Get("exp"), 10, 64)
+	if err != nil {
+		return nil, ErrBadSig
+	}
+	var key *Key
+	for i := range s.keys {
+		if s.keys[i].ID == q.Get("kid") {
+			key = &s.keys[i]
+			break
+		}
+	}
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+	c := &Claims{Path: p, User: q.Get("u"), Session: q.Get("s"), Expires: time.Unix(exp, 0)}
+	if !hmac.Equal([]byte(q.Get("sig")), []byte(s.mac(*key, p, exp, c.User, c.Session))) {
+		return nil, ErrBadSig
+	}
+	if !s.now().Before(c.Expires) {
+		return nil, ErrExpired
+	}
+	return c, nil
+}
+
+// Handler serves next for public paths and for valid signatures. live, if
+// set, is asked whether the user or session a URL is bound to still
+// exists, so signing out also ends the links handed to that session.
+func (s *Signer) Handler(next http.Handler, live func(*Claims) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.NeedsSignature(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c, err := s.Verify(r.URL.Path, r.URL.Query())
+		if err == nil && live != nil && (c.User != "" || c.Session != "") && !live(c) {
+			err = ErrExpired
+		}
+		if err != nil {
+			http.Error(w, "invalid or expired link", http.StatusForbidden)
+			return
+		}
+		maxAge := int(c.Expires.Sub(s.now()).Seconds())
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(maxAge))
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,452 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"YeahMusic/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Documents as written by the legacy handlers in main.go.
+type legacyUser struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Email     string             `bson:"email"`
+	Password  string             `bson:"password"`
+	Name      string             `bson:"name"`
+	Bio       string             `bson:"bio"`
+	Role      string             `bson:"role"`
+	Requested string             `bson:"requested_role,omitempty"`
+}
+
+type legacyTrack struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	Title     string             `bson:"title"`
+	Artist    string             `bson:"artist"`
+	ArtistID  primitive.ObjectID `bson:"artist_id"`
+	AlbumID   primitive.ObjectID `bson:"album_id,omitempty"`
+	CoverURL  string             `bson:"cover_url"`
+	AudioURL  string             `bson:"audio_url"`
+	Lyrics    string             `bson:"lyrics"`
+	Duration  int                `bson:"duration"`
+	IsSingle  bool               `bson:"is_single"`
+	CreatedAt time.Time          `bson:"created_at"`
+}
+
+type legacyAlbum struct {
+	ID          primitive.ObjectID `bson:"_id"`
+	Title       string             `bson:"title"`
+	Artist      string             `bson:"artist"`
+	ArtistID    primitive.ObjectID `bson:"artist_id"`
+	CoverURL    string             `bson:"cover_url"`
+	IsSingle    bool               `bson:"is_single"`
+	ReleaseDate time.Time          `bson:"release_date"`
+}
+
+type legacyPlaylist struct {
+	ID        primitive.ObjectID   `bson:"_id"`
+	Title     string               `bson:"title"`
+	Creator   string               `bson:"creator"`
+	CreatorID primitive.ObjectID   `bson:"creator_id"`
+	CoverURL  string               `bson:"cover_url"`
+	Tracks    []primitive.ObjectID `bson:"tracks"`
+}
+
+// ledgerEntry remembers what a document looked like before the legacy
+// migration first touched it, so Rollback can undo the migration exactly.
+type ledgerEntry struct {
+	ID         string             `bson:"_id"`
+	Collection string             `bson:"collection"`
+	DocID      primitive.ObjectID `bson:"doc_id"`
+	Previous   bson.Raw           `bson:"previous,omitempty"`
+	MigratedAt time.Time          `bson:"migrated_at"`
+}
+
+const ledgerCollection = "legacy_migration"
+
+type CollectionReport struct {
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+	Skipped   int `json:"skipped"`
+	Deleted   int `json:"deleted"`
+	Restored  int `json:"restored"`
+}
+
+type LegacyReport struct {
+	DryRun      bool                         `json:"dry_run"`
+	Collections map[string]*CollectionReport `json:"collections"`
+	Warnings    []string                     `json:"warnings"`
+}
+
+func newLegacyReport(dryRun bool) *LegacyReport {
+	return &LegacyReport{DryRun: dryRun, Collections: map[string]*CollectionReport{}, Warnings: []string{}}
+}
+
+func (r *LegacyReport) coll(name string) *CollectionReport {
+	c, ok := r.Collections[name]
+	if !ok {
+		c = &CollectionReport{}
+		r.Collections[name] = c
+	}
+	return c
+}
+
+func (r *LegacyReport) warn(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (r *LegacyReport) String() string {
+	var b strings.Builder
+	if r.DryRun {
+		b.WriteString("dry run, nothing was written\n")
+	}
+	names := make([]string, 0, len(r.Collections))
+	for name := range r.Collections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := r.Collections[name]
+		fmt.Fprintf(&b, "%-16s inserted=%d updated=%d unchanged=%d skipped=%d deleted=%d restored=%d\n",
+			name, c.Inserted, c.Updated, c.Unchanged, c.Skipped, c.Deleted, c.Restored)
+	}
+	for _, w := range r.Warnings {
+		b.WriteString("warning: " + w + "\n")
+	}
+	return b.String()
+}
+
+// LegacyMigrator converts the database written by the legacy handlers in
+// main.go into the schema used by internal/services. Every write is keyed by
+// the legacy _id, so running it again only applies what changed since.
+type LegacyMigrator struct {
+	src *mongo.Database
+	dst *mongo.Database
+}
+
+func NewLegacyMigrator(src, dst *mongo.Database) *LegacyMigrator {
+	return &LegacyMigrator{src: src, dst: dst}
+}
+
+func loadAll[T any](ctx context.Context, db *mongo.Database, coll string) ([]T, error) {
+	var res []T
+	cur, err := db.Collection(coll).Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	if err := cur.All(ctx, &res); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", coll, err)
+	}
+	return res, nil
+}
+
+func (m *LegacyMigrator) Run(ctx context.Context, dryRun bool) (*LegacyReport, error) {
+	rep := newLegacyReport(dryRun)
+
+	users, err := loadAll[legacyUser](ctx, m.src, "users")
+	if err != nil {
+		return nil, err
+	}
+	albums, err := loadAll[legacyAlbum](ctx, m.src, "albums")
+	if err != nil {
+		return nil, err
+	}
+	tracks, err := loadAll[legacyTrack](ctx, m.src, "tracks")
+	if err != nil {
+		return nil, err
+	}
+	playlists, err := loadAll[legacyPlaylist](ctx, m.src, "playlists")
+	if err != nil {
+		return nil, err
+	}
+
+	usersByID := map[primitive.ObjectID]legacyUser{}
+	for _, u := range users {
+		usersByID[u.ID] = u
+	}
+	albumsByID := map[primitive.ObjectID]legacyAlbum{}
+	for _, a := range albums {
+		albumsByID[a.ID] = a
+	}
+	trackIDs := map[primitive.ObjectID]bool{}
+	for _, t := range tracks {
+		trackIDs[t.ID] = true
+	}
+
+	if err := m.migrateUsers(ctx, rep, users); err != nil {
+		return nil, err
+	}
+	if err := m.migrateArtists(ctx, rep, usersByID, albums, tracks); err != nil {
+		return nil, err
+	}
+	if err := m.migrateAlbums(ctx, rep, albums); err != nil {
+		return nil, err
+	}
+	if err := m.migrateTracks(ctx, rep, albumsByID, tracks); err != nil {
+		return nil, err
+	}
+	if err := m.migratePlaylists(ctx, rep, trackIDs, playlists); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+func (m *LegacyMigrator) migrateUsers(ctx context.Context, rep *LegacyReport, users []legacyUser) error {
+	for _, u := range users {
+		email := strings.TrimSpace(strings.ToLower(u.Email))
+
+		var other models.User
+		err := m.dst.Collection("users").FindOne(ctx, bson.M{"email": email, "_id": bson.M{"$ne": u.ID}}).Decode(&other)
+		if err == nil {
+			rep.coll("users").Skipped++
+			rep.warn("user %s: email %s already belongs to %s", u.ID.Hex(), email, other.ID.Hex())
+			continue
+		}
+
+		role := strings.TrimSpace(u.Role)
+		if role == "" {
+			role = "user"
+		}
+		// The bcrypt hash is carried over unchanged rather than forcing a reset.
+		doc := &models.User{
+			ID: u.ID, Email: email, PasswordHash: u.Password, Name: u.Name, Bio: u.Bio,
+			Role: role, RequestedRole: u.Requested, EmailVerified: true, CreatedAt: u.ID.Timestamp(),
+		}
+		if err := m.put(ctx, rep, "users", u.ID, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateArtists creates an artist for every user or name that legacy tracks
+// and albums point at. The artist reuses the legacy artist_id, so references
+// carried over from tracks and albums stay valid.
+func (m *LegacyMigrator) migrateArtists(ctx context.Context, rep *LegacyReport, usersByID map[primitive.ObjectID]legacyUser, albums []legacyAlbum, tracks []legacyTrack) error {
+	names := map[primitive.ObjectID]string{}
+	var order []primitive.ObjectID
+	see := func(id primitive.ObjectID, name string) {
+		if id.IsZero() {
+			return
+		}
+		if _, ok := names[id]; !ok {
+			order = append(order, id)
+			names[id] = ""
+		}
+		if names[id] == "" {
+			names[id] = strings.TrimSpace(name)
+		}
+	}
+	for _, a := range albums {
+		see(a.ArtistID, a.Artist)
+	}
+	for _, t := range tracks {
+		see(t.ArtistID, t.Artist)
+	}
+
+	for _, id := range order {
+		doc := &models.Artist{ID: id, Name: names[id], CreatedAt: id.Timestamp()}
+		if u, ok := usersByID[id]; ok {
+			if strings.TrimSpace(u.Name) != "" {
+				doc.Name = strings.TrimSpace(u.Name)
+			}
+			doc.Bio = u.Bio
+		}
+		if doc.Name == "" {
+			rep.coll("artists").Skipped++
+			rep.warn("artist %s: no name in users, albums or tracks", id.Hex())
+			continue
+		}
+		if err := m.put(ctx, rep, "artists", id, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateAlbums copies every album. The per-track albums that the legacy
+// upload handler created for singles become standalone releases of their one
+// track, so singles keep their cover and release date.
+func (m *LegacyMigrator) migrateAlbums(ctx context.Context, rep *LegacyReport, albums []legacyAlbum) error {
+	for _, a := range albums {
+		doc := &models.Album{
+			ID: a.ID, ArtistID: a.ArtistID, Title: a.Title, CoverURL: a.CoverURL,
+			CreatedAt: a.ReleaseDate,
+		}
+		if !a.ReleaseDate.IsZero() {
+			doc.ReleaseYear = a.ReleaseDate.Year()
+		}
+		if doc.CreatedAt.IsZero() {
+			doc.CreatedAt = a.ID.Timestamp()
+		}
+		if err := m.put(ctx, rep, "albums", a.ID, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *LegacyMigrator) migrateTracks(ctx context.Context, rep *LegacyReport, albumsByID map[primitive.ObjectID]legacyAlbum, tracks []legacyTrack) error {
+	for _, t := range tracks {
+		doc := &models.Track{
+			ID: t.ID, Title: t.Title, ArtistID: t.ArtistID, ArtistName: t.Artist,
+			DurationSec: t.Duration, AudioURL: t.AudioURL, CoverURL: t.CoverURL,
+			Lyrics: t.Lyrics, CreatedAt: t.CreatedAt,
+		}
+		if doc.CreatedAt.IsZero() {
+			doc.CreatedAt = t.ID.Timestamp()
+		}
+
+		alb, ok := albumsByID[t.AlbumID]
+		switch {
+		case t.AlbumID.IsZero():
+		case !ok:
+			rep.warn("track %s: album %s does not exist, migrated without an album", t.ID.Hex(), t.AlbumID.Hex())
+		default:
+			doc.AlbumID = t.AlbumID
+			if doc.CoverURL == "" {
+				doc.CoverURL = alb.CoverURL
+			}
+		}
+
+		if err := m.put(ctx, rep, "tracks", t.ID, doc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *LegacyMigrator) migratePlaylists(ctx context.Context, rep *LegacyReport, trackIDs map[primitive.ObjectID]bool, playlists []legacyPlaylist) error {
+	for _, p := range playlists {
+		created := p.ID.Timestamp()
+		doc := &models.Playlist{
+			ID: p.ID, UserID: p.CreatorID, Title: p.Title, CoverURL: p.CoverURL, CreatedAt: created,
+		}
+		if err := m.put(ctx, rep, "playlists", p.ID, doc); err != nil {
+			return err
+		}
+
+		for i, trackID := range p.Tracks {
+			if !trackIDs[trackID] {
+				rep.coll("playlist_tracks").Skipped++
+				rep.warn("playlist %s: track %s does not exist", p.ID.Hex(), trackID.Hex())
+				continue
+			}
+
+			var existing models.PlaylistTrack
+			err := m.dst.Collection("playlist_tracks").FindOne(ctx, bson.M{"playlist_id": p.ID, "track_id": trackID}).Decode(&existing)
+			id := existing.ID
+			if err != nil {
+				id = primitive.NewObjectID()
+			}
+			// The embedded array carried no timestamps; keep its order instead.
+			pt := &models.PlaylistTrack{
+				ID: id, PlaylistID: p.ID, TrackID: trackID,
+				AddedAt: created.Add(time.Duration(i) * time.Millisecond),
+			}
+			if err := m.put(ctx, rep, "playlist_tracks", id, pt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func sameDocument(a, b bson.Raw) bool {
+	var ma, mb bson.M
+	if bson.Unmarshal(a, &ma) != nil || bson.Unmarshal(b, &mb) != nil {
+		return false
+	}
+	return reflect.DeepEqual(ma, mb)
+}
+
+func ledgerKey(coll string, id primitive.ObjectID) string {
+	return coll + ":" + id.Hex()
+}
+
+// put upserts doc by _id and records the prior state of the document in the
+// ledger the first time the migration writes it.
+func (m *LegacyMigrator) put(ctx context.Context, rep *LegacyReport, coll string, id primitive.ObjectID, doc any) error {
+	want, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	existing, err := m.dst.Collection(coll).FindOne(ctx, bson.M{"_id": id}).Raw()
+	exists := err == nil
+	if err != nil && err != mongo.ErrNoDocuments {
+		return err
+	}
+	if exists && sameDocument(existing, want) {
+		rep.coll(coll).Unchanged++
+		return nil
+	}
+	if exists {
+		rep.coll(coll).Updated++
+	} else {
+		rep.coll(coll).Inserted++
+	}
+	if rep.DryRun {
+		return nil
+	}
+
+	entry := ledgerEntry{ID: ledgerKey(coll, id), Collection: coll, DocID: id, MigratedAt: time.Now()}
+	if exists {
+		entry.Previous = existing
+	}
+	_, err = m.dst.Collection(ledgerCollection).UpdateOne(ctx,
+		bson.M{"_id": entry.ID},
+		bson.M{"$setOnInsert": entry},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.dst.Collection(coll).ReplaceOne(ctx, bson.M{"_id": id}, doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+// Rollback deletes every document the migration inserted and restores every
+// document it overwrote, then forgets the ledger.
+func (m *LegacyMigrator) Rollback(ctx context.Context, dryRun bool) (*LegacyReport, error) {
+	rep := newLegacyReport(dryRun)
+
+	entries, err := loadAll[ledgerEntry](ctx, m.dst, ledgerCollection)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Previous == nil {
+			rep.coll(e.Collection).Deleted++
+		} else {
+			rep.coll(e.Collection).Restored++
+		}
+		if dryRun {
+			continue
+		}
+
+		if e.Previous == nil {
+			_, err = m.dst.Collection(e.Collection).DeleteOne(ctx, bson.M{"_id": e.DocID})
+		} else {
+			_, err = m.dst.Collection(e.Collection).ReplaceOne(ctx, bson.M{"_id": e.DocID}, e.Previous, options.Replace().SetUpsert(true))
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, err := m.dst.Collection(ledgerCollection).DeleteOne(ctx, bson.M{"_id": e.ID}); err != nil {
+			return nil, err
+		}
+	}
+	return rep, nil
+}
@@ -0,0 +1,355 @@
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrDatabaseAhead = errors.New("database schema is newer than this binary")
+	ErrPending       = errors.New("database has pending migrations")
+	ErrLocked        = errors.New("another instance is migrating the database")
+	ErrLockLost      = errors.New("migration lock expired while migrating")
+)
+
+type Step struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, db *mongo.Database) error
+}
+
+// Steps is the schema history shared by the legacy database and the one used
+// by internal/services. Versions must be strictly increasing; never edit or
+// reorder a step that has shipped, add a new one instead.
+var Steps = []Step{
+	{1, "tracks text index", func(ctx context.Context, db *mongo.Database) error {
+		if err := dropTextIndexes(ctx, db.Collection("tracks")); err != nil {
+			return err
+		}
+		_, err := db.Collection("tracks").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "title", Value: "text"}, {Key: "artist", Value: "text"}, {Key: "artist_name", Value: "text"}},
+		})
+		return err
+	}},
+	{2, "users unique email", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		})
+		return err
+	}},
+	{3, "albums artist_id/is_single index", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("albums").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys: bson.D{{Key: "artist_id", Value: 1}, {Key: "is_single", Value: 1}},
+		})
+		return err
+	}},
+	{4, "sessions expires_at TTL", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("sessions").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		})
+		return err
+	}},
+	{5, "sessions hashed tokens", func(ctx context.Context, db *mongo.Database) error {
+		coll := db.Collection("sessions")
+		cur, err := coll.Find(ctx, bson.M{"token": bson.M{"$exists": true}})
+		if err != nil {
+			return err
+		}
+		defer cur.Close(ctx)
+		for cur.Next(ctx) {
+			var old struct {
+				ID        any       `bson:"_id"`
+				Token     string    `bson:"token"`
+				ExpiresAt time.Time `bson:"expires_at"`
+				CreatedAt time.Time `bson:"created_at"`
+			}
+			if err := cur.Decode(&old); err != nil {
+				return err
+			}
+			// Same digest as services.hashToken.
+			sum := sha256.Sum256([]byte(old.Token))
+			_, err := coll.UpdateOne(ctx, bson.M{"_id": old.ID}, bson.M{
+				"$set": bson.M{
+					"token_hash":        hex.EncodeToString(sum[:]),
+					"access_expires_at": old.ExpiresAt,
+					"last_used_at":      old.CreatedAt,
+				},
+				"$unset": bson.M{"token": ""},
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if err := cur.Err(); err != nil {
+			return err
+		}
+		_, err = coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "refresh_hash", Value: 1}}},
+			{Keys: bson.D{{Key: "prev_refresh_hashes", Value: 1}}},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "last_used_at", Value: -1}}},
+		})
+		return err
+	}},
+	{6, "users requested_role index", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "requested_role", Value: 1}},
+			Options: options.Index().SetSparse(true),
+		})
+		return err
+	}},
+	{7, "email tokens, existing users verified", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("users").UpdateMany(ctx,
+			bson.M{"email_verified": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"email_verified": true}},
+		)
+		if err != nil {
+			return err
+		}
+		_, err = db.Collection("email_tokens").Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "token_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "purpose", Value: 1}}},
+			{Keys: bson.D{{Key: "expires_at", Value: 1}}, Options: options.Index().SetExpireAfterSeconds(0)},
+		})
+		return err
+	}},
+	{8, "identities provider/subject", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("identities").Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "provider", Value: 1}, {Key: "subject", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		})
+		return err
+	}},
+	{9, "api_keys indexes", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("api_keys").Indexes().CreateMany(ctx, []mongo.IndexModel{
+			{Keys: bson.D{{Key: "key_hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+			{Keys: bson.D{{Key: "user_id", Value: 1}}},
+		})
+		return err
+	}},
+	{10, "rate_limits expires TTL", func(ctx context.Context, db *mongo.Database) error {
+		_, err := db.Collection("rate_limits").Indexes().CreateOne(ctx, mongo.IndexModel{
+			Keys:    bson.D{{Key: "expires", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		})
+		return err
+	}},
+}
+
+// MongoDB allows a single text index per collection, so an older one with
+// different fields has to go before the current one can be built.
+func dropTextIndexes(ctx context.Context, coll *mongo.Collection) error {
+	cur, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return err
+	}
+	var specs []bson.M
+	if err := cur.All(ctx, &specs); err != nil {
+		return err
+	}
+	for _, spec := range specs {
+		if _, ok := spec["textIndexVersion"]; !ok {
+			continue
+		}
+		name, _ := spec["name"].(string)
+		if _, err := coll.Indexes().DropOne(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type AppliedStep struct {
+	Version   int       `bson:"_id" json:"version"`
+	Name      string    `bson:"name" json:"name"`
+	AppliedAt time.Time `bson:"applied_at" json:"applied_at"`
+}
+
+type Runner struct {
+	db      *mongo.Database
+	steps   []Step
+	owner   string
+	lockTTL time.Duration
+}
+
+func NewRunner(db *mongo.Database) *Runner {
+	host, _ := os.Hostname()
+	return &Runner{
+		db:      db,
+		steps:   Steps,
+		owner:   fmt.Sprintf("%s/%d/%d", host, os.Getpid(), time.Now().UnixNano()),
+		lockTTL: 5 * time.Minute,
+	}
+}
+
+func (r *Runner) latest() int {
+	if len(r.steps) == 0 {
+		return 0
+	}
+	return r.steps[len(r.steps)-1].Version
+}
+
+func (r *Runner) Applied(ctx context.Context) ([]AppliedStep, error) {
+	var res []AppliedStep
+	cur, err := r.db.Collection("schema_migrations").Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	if err := cur.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (r *Runner) Pending(ctx context.Context) ([]Step, error) {
+	applied, err := r.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	done := map[int]bool{}
+	for _, a := range applied {
+		if a.Version > r.latest() {
+			return nil, fmt.Errorf("%w: version %d (%s) is unknown, binary knows up to %d",
+				ErrDatabaseAhead, a.Version, a.Name, r.latest())
+		}
+		done[a.Version] = true
+	}
+	var pending []Step
+	for _, s := range r.steps {
+		if !done[s.Version] {
+			pending = append(pending, s)
+		}
+	}
+	return pending, nil
+}
+
+// Check refuses a database that is ahead of the binary or still has pending
+// steps. Use it at startup when migrations are not run automatically.
+func (r *Runner) Check(ctx context.Context) error {
+	pending, err := r.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d step(s), next is %d (%s)", ErrPending, len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
+
+// Up applies every pending step in order while holding the migration lock.
+// The lock is renewed until Up returns, however long the steps take.
+func (r *Runner) Up(ctx context.Context) ([]Step, error) {
+	if err := r.lock(ctx); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := r.heartbeat(ctx, cancel)
+	defer func() {
+		stop()
+		cancel(nil)
+		r.unlock()
+	}()
+
+	pending, err := r.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var done []Step
+	for _, s := range pending {
+		err := s.Up(ctx, r.db)
+		if err == nil {
+			_, err = r.db.Collection("schema_migrations").InsertOne(ctx, AppliedStep{
+				Version: s.Version, Name: s.Name, AppliedAt: time.Now(),
+			})
+		}
+		if err != nil {
+			if errors.Is(context.Cause(ctx), ErrLockLost) {
+				err = ErrLockLost
+			}
+			return done, fmt.Errorf("migration %d (%s): %w", s.Version, s.Name, err)
+		}
+		done = append(done, s)
+	}
+	return done, nil
+}
+
+// lock takes the single document in schema_migrations_lock. It can only be
+// inserted while no unexpired lock exists, so a second instance gets a
+// duplicate key error and retries until ctx is done.
+func (r *Runner) lock(ctx context.Context) error {
+	coll := r.db.Collection("schema_migrations_lock")
+	for {
+		now := time.Now()
+		_, err := coll.UpdateOne(ctx,
+			bson.M{"_id": "lock", "expires_at": bson.M{"$lt": now}},
+			bson.M{"$set": bson.M{"owner": r.owner, "locked_at": now, "expires_at": now.Add(r.lockTTL)}},
+			options.Update().SetUpsert(true),
+		)
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ErrLocked
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// heartbeat moves the expiry of the lock forward every third of its TTL
+// until stop is called. Should the lock have gone to another instance in the
+// meantime, it cancels ctx with ErrLockLost.
+func (r *Runner) heartbeat(ctx context.Context, cancel context.CancelCauseFunc) (stop func()) {
+	quit := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(r.lockTTL / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-quit:
+				return
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			res, err := r.db.Collection("schema_migrations_lock").UpdateOne(ctx,
+				bson.M{"_id": "lock", "owner": r.owner},
+				bson.M{"$set": bson.M{"expires_at": time.Now().Add(r.lockTTL)}},
+			)
+			// A failed renewal is retried on the next tick, which still
+			// comes before the lock expires.
+			if err == nil && res.MatchedCount == 0 {
+				cancel(ErrLockLost)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(quit)
+		<-stopped
+	}
+}
+
+func (r *Runner) unlock() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, _ = r.db.Collection("schema_migrations_lock").DeleteOne(ctx, bson.M{"_id": "lock", "owner": r.owner})
+}
@@ -6,14 +6,31 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// User.RequestedRole is a role asked for at sign-up that an admin has not
+// approved or rejected yet. EmailVerified is set once the user followed a
+// verification or password reset link. A TOTPSecret without TOTPEnabled is
+// an enrollment that was not confirmed with a code yet; RecoveryCodes holds
+// the hashes of the unused recovery codes.
 type User struct {
-	ID           primitive.ObjectID `json:"id" bson:"_id,omitempty"`
-	Email        string             `json:"email" bson:"email"`
-	PasswordHash string             `json:"-" bson:"password_hash"`
-	Name         string             `json:"name" bson:"name"`
-	Bio          string             `json:"bio" bson:"bio"`
-	Role         string             `json:"role" bson:"role"`
-	CreatedAt    time.Time          `json:"created_at" bson:"created_at"`
+	ID            primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Email         string             `json:"email" bson:"email"`
+	PasswordHash  string             `json:"-" bson:"password_hash"`
+	Name          string             `json:"name" bson:"name"`
+	Bio           string             `json:"bio" bson:"bio"`
+	Role          string             `json:"role" bson:"role"`
+	RequestedRole string             `json:"requested_role,omitempty" bson:"requested_role,omitempty"`
+	EmailVerified bool               `json:"email_verified" bson:"email_verified"`
+	TOTPEnabled   bool               `json:"totp_enabled" bson:"totp_enabled"`
+	TOTPSecret    string             `json:"-" bson:"totp_secret,omitempty"`
+	TOTPLastStep  int64              `json:"-" bson:"totp_last_step,omitempty"`
+	RecoveryCodes []string           `json:"-" bson:"recovery_codes,omitempty"`
+	CreatedAt     time.Time          `json:"created_at" bson:"created_at"`
+}
+
+type Admin struct {
+	ID    primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	Email string             `json:"email" bson:"email"`
+	Role  string             `json:"role" bson:"role"`
 }
 
 type Artist struct {
@@ -30,6 +47,12 @@ type Album struct {
 	CoverURL    string             `json:"cover_url" bson:"cover_url"`
 	ReleaseYear int                `json:"release_year" bson:"release_year"`
 	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+	// Loudness is measured over all tracks together once they are uploaded.
+	Loudness *Loudness `json:"loudness,omitempty" bson:"loudness,omitempty"`
+
+	// Covers maps the sizes of the cover variants to their URLs; it is
+	// filled in for responses and never stored.
+	Covers map[string]string `json:"covers,omitempty" bson:"-"`
 }
 
 type Track struct {
@@ -39,10 +62,49 @@ type Track struct {
 	ArtistID    primitive.ObjectID `json:"artist_id" bson:"artist_id"`
 	ArtistName  string             `json:"artist_name" bson:"artist_name"`
 	DurationSec int                `json:"duration_sec" bson:"duration_sec"`
+	TrackNo     int                `json:"track_no,omitempty" bson:"track_no,omitempty"`
+	Genre       string             `json:"genre,omitempty" bson:"genre,omitempty"`
+	Year        int                `json:"year,omitempty" bson:"year,omitempty"`
 	AudioURL    string             `json:"audio_url" bson:"audio_url"`
 	CoverURL    string             `json:"cover_url" bson:"cover_url"`
 	Lyrics      string             `json:"lyrics" bson:"lyrics"`
+	Plays       int64              `json:"plays" bson:"plays"`
 	CreatedAt   time.Time          `json:"created_at" bson:"created_at"`
+
+	// Loudness is measured after the upload; AlbumLoudness is a copy of the
+	// album's, so players can apply either gain without another request.
+	Loudness      *Loudness `json:"loudness,omitempty" bson:"loudness,omitempty"`
+	AlbumLoudness *Loudness `json:"album_loudness,omitempty" bson:"album_loudness,omitempty"`
+	// Gapless comes from the LAME tag of uploaded MP3s.
+	Gapless *Gapless `json:"gapless,omitempty" bson:"gapless,omitempty"`
+
+	// StreamURL is the signed /api/tracks/{id}/stream link and Covers the
+	// cover variants by size, both handed to clients and never stored.
+	StreamURL string            `json:"stream_url,omitempty" bson:"-"`
+	Covers    map[string]string `json:"covers,omitempty" bson:"-"`
+}
+
+// Loudness is an EBU R128 measurement as ReplayGain 2.0 uses it. Gain
+// brings the audio to the ReplayGain reference of -18 LUFS and Peak is the
+// true peak as a factor of full scale, the gain and peak of ReplayGain tags.
+type Loudness struct {
+	Integrated float64 `json:"integrated_lufs" bson:"integrated_lufs"`
+	TruePeak   float64 `json:"true_peak_dbtp" bson:"true_peak_dbtp"`
+	Gain       float64 `json:"gain_db" bson:"gain_db"`
+	Peak       float64 `json:"peak" bson:"peak"`
+}
+
+// Gapless locates a track in its decoded MP3 so players can join tracks
+// without a gap: they drop the first PrimingSamples of the audio and keep
+// TotalSamples, per channel at SampleRate. PrimingSamples is EncoderDelay
+// plus the delay of the decoder, for decoders that skip the Xing frame as
+// most do; the rest is Padding and can go.
+type Gapless struct {
+	EncoderDelay   int   `json:"encoder_delay" bson:"encoder_delay"`
+	Padding        int   `json:"padding" bson:"padding"`
+	PrimingSamples int   `json:"priming_samples" bson:"priming_samples"`
+	TotalSamples   int64 `json:"total_samples" bson:"total_samples"`
+	SampleRate     int   `json:"sample_rate" bson:"sample_rate"`
 }
 
 type Playlist struct {
@@ -51,6 +113,8 @@ type Playlist struct {
 	Title     string             `json:"title" bson:"title"`
 	CoverURL  string             `json:"cover_url" bson:"cover_url"`
 	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+
+	Covers map[string]string `json:"covers,omitempty" bson:"-"`
 }
 
 type PlaylistTrack struct {
@@ -60,10 +124,60 @@ type PlaylistTrack struct {
 	AddedAt    time.Time          `json:"added_at" bson:"added_at"`
 }
 
+// Session is one signed-in device. Only hashes of its tokens are stored;
+// Token and RefreshToken are filled in when the tokens are issued and are
+// never persisted. ExpiresAt ends the session itself, AccessExpiresAt only
+// the current access token.
 type Session struct {
+	ID                primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID            primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Token             string             `json:"-" bson:"-"`
+	RefreshToken      string             `json:"-" bson:"-"`
+	TokenHash         string             `json:"-" bson:"token_hash"`
+	RefreshHash       string             `json:"-" bson:"refresh_hash"`
+	PrevRefreshHashes []string           `json:"-" bson:"prev_refresh_hashes,omitempty"`
+	UserAgent         string             `json:"user_agent" bson:"user_agent"`
+	IP                string             `json:"ip" bson:"ip"`
+	AccessExpiresAt   time.Time          `json:"access_expires_at" bson:"access_expires_at"`
+	ExpiresAt         time.Time          `json:"expires_at" bson:"expires_at"`
+	LastUsedAt        time.Time          `json:"last_used_at" bson:"last_used_at"`
+	CreatedAt         time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// EmailToken is a single-use token sent by mail to reset a password or to
+// verify an address. Like sessions, only its hash is stored.
+type EmailToken struct {
 	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
 	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
-	Token     string             `json:"token" bson:"token"`
+	Purpose   string             `json:"purpose" bson:"purpose"`
+	Token     string             `json:"-" bson:"-"`
+	TokenHash string             `json:"-" bson:"token_hash"`
 	ExpiresAt time.Time          `json:"expires_at" bson:"expires_at"`
 	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
 }
+
+// Identity links a user to an account at an external OpenID provider.
+type Identity struct {
+	ID        primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID    primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Provider  string             `json:"provider" bson:"provider"`
+	Subject   string             `json:"subject" bson:"subject"`
+	Email     string             `json:"email" bson:"email"`
+	CreatedAt time.Time          `json:"created_at" bson:"created_at"`
+}
+
+// APIKey is a long-lived credential for scripts and pipelines. Prefix is the
+// public part shown in listings; the full Key is only returned when the key
+// is created and only its hash is stored. A nil ExpiresAt never expires.
+type APIKey struct {
+	ID         primitive.ObjectID `json:"id" bson:"_id,omitempty"`
+	UserID     primitive.ObjectID `json:"user_id" bson:"user_id"`
+	Name       string             `json:"name" bson:"name"`
+	Prefix     string             `json:"prefix" bson:"prefix"`
+	Key        string             `json:"key,omitempty" bson:"-"`
+	KeyHash    string             `json:"-" bson:"key_hash"`
+	Scopes     []string           `json:"scopes" bson:"scopes"`
+	ExpiresAt  *time.Time         `json:"expires_at" bson:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `json:"last_used_at" bson:"last_used_at,omitempty"`
+	CreatedAt  time.Time          `json:"created_at" bson:"created_at"`
+}
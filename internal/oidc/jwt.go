@@ -0,0 +1,161 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+var b64 = base64.RawURLEncoding
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jws struct {
+	header  jwtHeader
+	payload []byte
+	signed  []byte
+	sig     []byte
+}
+
+func parseJWS(raw string) (*jws, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("oidc: malformed token")
+	}
+	hb, err := b64.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token header: %w", err)
+	}
+	var t jws
+	if err := json.Unmarshal(hb, &t.header); err != nil {
+		return nil, fmt.Errorf("oidc: token header: %w", err)
+	}
+	if t.payload, err = b64.DecodeString(parts[1]); err != nil {
+		return nil, fmt.Errorf("oidc: token payload: %w", err)
+	}
+	if t.sig, err = b64.DecodeString(parts[2]); err != nil {
+		return nil, fmt.Errorf("oidc: token signature: %w", err)
+	}
+	t.signed = []byte(parts[0] + "." + parts[1])
+	return &t, nil
+}
+
+// Only asymmetric algorithms are accepted; "none" and HMAC never verify.
+var algHash = map[string]crypto.Hash{
+	"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512,
+	"ES256": crypto.SHA256, "ES384": crypto.SHA384, "ES512": crypto.SHA512,
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	h, ok := algHash[alg]
+	if !ok {
+		return fmt.Errorf("oidc: unsupported algorithm %q", alg)
+	}
+	hh := h.New()
+	hh.Write(signed)
+	digest := hh.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("oidc: %s token with RSA key", alg)
+		}
+		return rsa.VerifyPKCS1v15(k, h, digest, sig)
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("oidc: %s token with EC key", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("oidc: bad ECDSA signature length")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("oidc: bad signature")
+		}
+		return nil
+	}
+	return errors.New("oidc: unsupported key type")
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) > 4 {
+			return nil, errors.New("oidc: RSA exponent too large")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("oidc: unsupported curve %q", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("oidc: EC point not on curve")
+		}
+		return pub, nil
+	}
+	return nil, fmt.Errorf("oidc: unsupported key type %q", k.Kty)
+}
+
+// RSAKey describes pub as a JWK, for providers that publish their own keys.
+func RSAKey(kid string, pub *rsa.PublicKey) JWK {
+	return JWK{
+		Kty: "RSA", Kid: kid, Use: "sig", Alg: "RS256",
+		N: b64.EncodeToString(pub.N.Bytes()),
+		E: b64.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+	}
+}
@@ -0,0 +1,171 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockProvider is a tiny identity provider for local development and
+// tests. It approves every authorization request without a login page; the
+// signed-in address is login_hint when given, Email otherwise. Redirect URIs
+// are not checked against a registration.
+type MockProvider struct {
+	Issuer   string
+	ClientID string
+	Email    string
+	Name     string
+
+	key *rsa.PrivateKey
+	kid string
+
+	mu    sync.Mutex
+	codes map[string]mockCode
+}
+
+type mockCode struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	email       string
+	expires     time.Time
+}
+
+func NewMockProvider(issuer, clientID string) (*MockProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &MockProvider{
+		Issuer:   strings.TrimRight(issuer, "/"),
+		ClientID: clientID,
+		Email:    "mock.user@example.com",
+		Name:     "Mock User",
+		key:      key,
+		kid:      RandomString()[:8],
+		codes:    map[string]mockCode{},
+	}, nil
+}
+
+func (m *MockProvider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/.well-known/openid-configuration":
+		writeJSON(w, 200, map[string]any{
+			"issuer":                                m.Issuer,
+			"authorization_endpoint":                m.Issuer + "/authorize",
+			"token_endpoint":                        m.Issuer + "/token",
+			"jwks_uri":                              m.Issuer + "/jwks",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+			"code_challenge_methods_supported":      []string{"S256"},
+		})
+	case "/jwks":
+		writeJSON(w, 200, JWKS{Keys: []JWK{RSAKey(m.kid, &m.key.PublicKey)}})
+	case "/authorize":
+		m.authorize(w, r)
+	case "/token":
+		m.token(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *MockProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirect.IsAbs() {
+		http.Error(w, "bad redirect_uri", 400)
+		return
+	}
+	if q.Get("response_type") != "code" || q.Get("client_id") != m.ClientID ||
+		q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "unsupported request", 400)
+		return
+	}
+	email := q.Get("login_hint")
+	if email == "" {
+		email = m.Email
+	}
+
+	code := RandomString()
+	m.mu.Lock()
+	m.codes[code] = mockCode{
+		clientID: m.ClientID, redirectURI: redirect.String(), challenge: q.Get("code_challenge"),
+		nonce: q.Get("nonce"), email: email, expires: time.Now().Add(time.Minute),
+	}
+	m.mu.Unlock()
+
+	back := redirect.Query()
+	back.Set("code", code)
+	back.Set("state", q.Get("state"))
+	redirect.RawQuery = back.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (m *MockProvider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.ParseForm() != nil {
+		writeJSON(w, 400, map[string]string{"error": "invalid_request"})
+		return
+	}
+	m.mu.Lock()
+	c, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code":
+		writeJSON(w, 400, map[string]string{"error": "unsupported_grant_type"})
+		return
+	case !ok || time.Now().After(c.expires) || r.PostForm.Get("redirect_uri") != c.redirectURI:
+		writeJSON(w, 400, map[string]string{"error": "invalid_grant"})
+		return
+	case challenge(r.PostForm.Get("code_verifier")) != c.challenge:
+		writeJSON(w, 400, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	idToken, err := m.sign(map[string]any{
+		"iss": m.Issuer, "sub": "mock|" + c.email, "aud": c.clientID,
+		"iat": now.Unix(), "exp": now.Add(5 * time.Minute).Unix(), "nonce": c.nonce,
+		"email": c.email, "email_verified": true, "name": m.Name,
+	})
+	if err != nil {
+		writeJSON(w, 500, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, 200, map[string]any{
+		"access_token": RandomString(), "token_type": "Bearer", "expires_in": 300, "id_token": idToken,
+	})
+}
+
+func (m *MockProvider) sign(claims map[string]any) (string, error) {
+	hb, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": m.kid})
+	pb, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := b64.EncodeToString(hb) + "." + b64.EncodeToString(pb)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + b64.EncodeToString(sig), nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,358 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("oidc: invalid id token")
+	ErrExchange     = errors.New("oidc: code exchange failed")
+)
+
+// Clock skew tolerated on exp, iat and nbf.
+const leeway = time.Minute
+
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+type discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+}
+
+// Claims are the ID token fields YeahMusic uses.
+type Claims struct {
+	Issuer        string
+	Subject       string
+	Audience      []string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Nonce         string
+	ExpiresAt     time.Time
+}
+
+// Provider is one relying-party registration. Discovery and keys are
+// fetched on first use and cached; an unknown key id triggers a refetch so
+// key rotation needs no restart.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+	now    func() time.Time
+
+	mu       sync.Mutex
+	meta     *discovery
+	keys     map[string]JWK
+	keysList []JWK
+	fetched  time.Time
+}
+
+func NewProvider(cfg Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	if !slices.Contains(cfg.Scopes, "openid") {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	cfg.Issuer = strings.TrimRight(cfg.Issuer, "/")
+	return &Provider{cfg: cfg, client: client, now: time.Now}
+}
+
+func (p *Provider) Name() string { return p.cfg.Name }
+
+func (p *Provider) getJSON(ctx context.Context, u string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s: %s", u, res.Status)
+	}
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	var d discovery
+	if err := p.getJSON(ctx, p.cfg.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(d.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", d.Issuer, p.cfg.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("oidc: incomplete discovery document")
+	}
+	if len(d.CodeChallengeMethods) > 0 && !slices.Contains(d.CodeChallengeMethods, "S256") {
+		return nil, errors.New("oidc: provider does not support PKCE S256")
+	}
+	p.meta = &d
+	return p.meta, nil
+}
+
+// NewVerifier returns a PKCE code verifier; RandomString is for state and
+// nonce values.
+func NewVerifier() string { return RandomString() }
+
+func RandomString() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return b64.EncodeToString(b)
+}
+
+func challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return b64.EncodeToString(sum[:])
+}
+
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the verified claims of
+// the ID token that came with it.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrExchange, err)
+	}
+	defer res.Body.Close()
+
+	var tok struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrExchange, res.Status)
+	}
+	if res.StatusCode != http.StatusOK || tok.Error != "" {
+		return nil, fmt.Errorf("%w: %s %s", ErrExchange, tok.Error, tok.ErrorDescription)
+	}
+	if tok.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id_token in response", ErrExchange)
+	}
+	return p.VerifyIDToken(ctx, tok.IDToken, nonce)
+}
+
+func (p *Provider) key(ctx context.Context, kid, alg string) (crypto.PublicKey, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	find := func() (JWK, bool) {
+		if kid != "" {
+			k, ok := p.keys[kid]
+			return k, ok
+		}
+		// Without a kid, only an unambiguous key set can be used.
+		var match []JWK
+		for _, k := range p.keysList {
+			if k.Alg == "" || k.Alg == alg {
+				match = append(match, k)
+			}
+		}
+		if len(match) == 1 {
+			return match[0], true
+		}
+		return JWK{}, false
+	}
+
+	k, ok := find()
+	if !ok && p.now().Sub(p.fetched) > 10*time.Second {
+		var set JWKS
+		if err := p.getJSON(ctx, d.JWKSURI, &set); err != nil {
+			return nil, err
+		}
+		p.keys = map[string]JWK{}
+		p.keysList = nil
+		for _, k := range set.Keys {
+			if k.Use != "" && k.Use != "sig" {
+				continue
+			}
+			p.keysList = append(p.keysList, k)
+			if k.Kid != "" {
+				p.keys[k.Kid] = k
+			}
+		}
+		p.fetched = p.now()
+		k, ok = find()
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidToken, kid)
+	}
+	if k.Alg != "" && k.Alg != alg {
+		return nil, fmt.Errorf("%w: key %q is for %s, token uses %s", ErrInvalidToken, kid, k.Alg, alg)
+	}
+	return k.PublicKey()
+}
+
+// audience is a string or an array of strings in the token.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var one string
+	if err := json.Unmarshal(b, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(b, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// flexBool accepts true and "true"; some providers send email_verified as a
+// string.
+type flexBool bool
+
+func (f *flexBool) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case bool:
+		*f = flexBool(t)
+	case string:
+		ok, _ := strconv.ParseBool(t)
+		*f = flexBool(ok)
+	}
+	return nil
+}
+
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	t, err := parseJWS(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	key, err := p.key(ctx, t.header.Kid, t.header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(t.header.Alg, key, t.signed, t.sig); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var c struct {
+		Iss           string   `json:"iss"`
+		Sub           string   `json:"sub"`
+		Aud           audience `json:"aud"`
+		Azp           string   `json:"azp"`
+		Exp           float64  `json:"exp"`
+		Iat           float64  `json:"iat"`
+		Nbf           float64  `json:"nbf"`
+		Nonce         string   `json:"nonce"`
+		Email         string   `json:"email"`
+		EmailVerified flexBool `json:"email_verified"`
+		Name          string   `json:"name"`
+	}
+	if err := json.Unmarshal(t.payload, &c); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	now := p.now()
+	unix := func(f float64) time.Time { return time.Unix(int64(f), 0) }
+	switch {
+	case strings.TrimRight(c.Iss, "/") != p.cfg.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q", ErrInvalidToken, c.Iss)
+	case c.Sub == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	case !slices.Contains(c.Aud, p.cfg.ClientID):
+		return nil, fmt.Errorf("%w: audience %v", ErrInvalidToken, []string(c.Aud))
+	case len(c.Aud) > 1 && c.Azp != p.cfg.ClientID:
+		return nil, fmt.Errorf("%w: authorized party %q", ErrInvalidToken, c.Azp)
+	case c.Exp == 0 || now.After(unix(c.Exp).Add(leeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case c.Iat != 0 && unix(c.Iat).After(now.Add(leeway)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case c.Nbf != 0 && unix(c.Nbf).After(now.Add(leeway)):
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	case nonce != "" && c.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidToken)
+	}
+	return &Claims{
+		Issuer: c.Iss, Subject: c.Sub, Audience: c.Aud, Email: c.Email,
+		EmailVerified: bool(c.EmailVerified), Name: c.Name, Nonce: c.Nonce, ExpiresAt: unix(c.Exp),
+	}, nil
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps state in the process; limits are per instance.
+type MemoryStore struct {
+	mu       sync.Mutex
+	buckets  map[string]*bucket
+	failures map[string]*failure
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	expires time.Time
+}
+
+type failure struct {
+	Failures
+	expires time.Time
+}
+
+// Entries are swept once a map grows past this size.
+const sweepAt = 10000
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: map[string]*bucket{}, failures: map[string]*failure{}}
+}
+
+func (m *MemoryStore) Take(ctx context.Context, key string, rate float64, burst int, now time.Time) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.buckets) > sweepAt {
+		for k, b := range m.buckets {
+			if now.After(b.expires) {
+				delete(m.buckets, k)
+			}
+		}
+	}
+	b := m.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: float64(burst), updated: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+	// A bucket that would be full again carries no state worth keeping.
+	b.expires = now.Add(time.Duration(float64(burst) / rate * float64(time.Second)))
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+func (m *MemoryStore) AddFailure(ctx context.Context, key string, now time.Time, ttl time.Duration) (Failures, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.failures) > sweepAt {
+		for k, f := range m.failures {
+			if now.After(f.expires) {
+				delete(m.failures, k)
+			}
+		}
+	}
+	f := m.failures[key]
+	if f == nil || now.After(f.expires) {
+		f = &failure{}
+		m.failures[key] = f
+	}
+	f.Count++
+	f.Last = now
+	f.expires = now.Add(ttl)
+	return f.Failures, nil
+}
+
+func (m *MemoryStore) GetFailures(ctx context.Context, key string) (Failures, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f := m.failures[key]
+	if f == nil || time.Now().After(f.expires) {
+		return Failures{}, nil
+	}
+	return f.Failures, nil
+}
+
+func (m *MemoryStore) ResetFailures(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.failures, key)
+	return nil
+}
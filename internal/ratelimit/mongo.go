@@ -0,0 +1,115 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore shares limits between instances through one collection. Each
+// operation is a single atomic update, so concurrent requests on different
+// servers cannot both take the last token.
+type MongoStore struct {
+	coll *mongo.Collection
+}
+
+// opTimeout bounds each call so a slow database delays a request rather
+// than hangs it.
+const opTimeout = 5 * time.Second
+
+// NewMongoStore uses coll, which should have a TTL index on "expires" so
+// stale buckets and counters go away on their own. The migrations create it
+// for "rate_limits"; EnsureIndexes is for a collection outside them.
+func NewMongoStore(coll *mongo.Collection) *MongoStore {
+	return &MongoStore{coll: coll}
+}
+
+func (m *MongoStore) EnsureIndexes(ctx context.Context) error {
+	_, err := m.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	return err
+}
+
+func (m *MongoStore) Take(ctx context.Context, key string, rate float64, burst int, now time.Time) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+	now = now.Truncate(time.Millisecond)
+	full := time.Duration(float64(burst) / rate * float64(time.Second))
+	elapsed := bson.M{"$divide": bson.A{bson.M{"$subtract": bson.A{now, bson.M{"$ifNull": bson.A{"$updated", now}}}}, 1000}}
+	refill := bson.M{"$min": bson.A{
+		float64(burst),
+		bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$tokens", float64(burst)}}, bson.M{"$multiply": bson.A{elapsed, rate}}}},
+	}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{"tokens": refill, "updated": now, "expires": now.Add(full)}}},
+		{{Key: "$set", Value: bson.M{
+			"ok":     bson.M{"$gte": bson.A{"$tokens", 1}},
+			"tokens": bson.M{"$cond": bson.A{bson.M{"$gte": bson.A{"$tokens", 1}}, bson.M{"$subtract": bson.A{"$tokens", 1}}, "$tokens"}},
+		}}},
+	}
+	var doc struct {
+		Tokens float64 `bson:"tokens"`
+		OK     bool    `bson:"ok"`
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	if err := m.coll.FindOneAndUpdate(ctx, bson.M{"_id": "bucket|" + key}, pipeline, opts).Decode(&doc); err != nil {
+		return false, 0, err
+	}
+	if !doc.OK {
+		return false, time.Duration((1 - doc.Tokens) / rate * float64(time.Second)), nil
+	}
+	return true, 0, nil
+}
+
+type failureDoc struct {
+	Count int       `bson:"count"`
+	Last  time.Time `bson:"last"`
+}
+
+func (m *MongoStore) AddFailure(ctx context.Context, key string, now time.Time, ttl time.Duration) (Failures, error) {
+	ctx, cancel := context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+	now = now.Truncate(time.Millisecond)
+	// A counter past its expiry may still be waiting for the TTL monitor;
+	// start it over rather than add to it.
+	live := bson.M{"$gt": bson.A{bson.M{"$ifNull": bson.A{"$expires", now}}, now}}
+	pipeline := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"count":   bson.M{"$add": bson.A{bson.M{"$cond": bson.A{live, bson.M{"$ifNull": bson.A{"$count", 0}}, 0}}, 1}},
+			"last":    now,
+			"expires": now.Add(ttl),
+		}}},
+	}
+	var doc failureDoc
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	if err := m.coll.FindOneAndUpdate(ctx, bson.M{"_id": "failures|" + key}, pipeline, opts).Decode(&doc); err != nil {
+		return Failures{}, err
+	}
+	return Failures{Count: doc.Count, Last: doc.Last}, nil
+}
+
+func (m *MongoStore) GetFailures(ctx context.Context, key string) (Failures, error) {
+	ctx, cancel := context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+	var doc failureDoc
+	err := m.coll.FindOne(ctx, bson.M{"_id": "failures|" + key, "expires": bson.M{"$gt": time.Now()}}).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return Failures{}, nil
+	}
+	if err != nil {
+		return Failures{}, err
+	}
+	return Failures{Count: doc.Count, Last: doc.Last}, nil
+}
+
+func (m *MongoStore) ResetFailures(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, opTimeout)
+	defer cancel()
+	_, err := m.coll.DeleteOne(ctx, bson.M{"_id": "failures|" + key})
+	return err
+}
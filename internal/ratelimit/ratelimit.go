@@ -0,0 +1,219 @@
+// Package ratelimit holds the token buckets behind per-route limits and the
+// failed-login counters behind account lockout. State lives in a Store, so
+// several instances of the server can share it.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Store keeps limiter state. Take and AddFailure must be atomic per key.
+type Store interface {
+	// Take refills the bucket at key by rate tokens per second, up to burst,
+	// and takes one token. When the bucket is empty it returns how long until
+	// the next token.
+	Take(ctx context.Context, key string, rate float64, burst int, now time.Time) (ok bool, wait time.Duration, err error)
+	// AddFailure counts a failure at key; counts are forgotten ttl after
+	// the last failure.
+	AddFailure(ctx context.Context, key string, now time.Time, ttl time.Duration) (Failures, error)
+	GetFailures(ctx context.Context, key string) (Failures, error)
+	ResetFailures(ctx context.Context, key string) error
+}
+
+type Failures struct {
+	Count int
+	Last  time.Time
+}
+
+// LimitError is returned when a limit or a lockout refuses a request.
+type LimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("rate limited, retry in %s", e.RetryAfter.Round(time.Second))
+}
+
+// RetryAfterSeconds is the value for the Retry-After header.
+func (e *LimitError) RetryAfterSeconds() string {
+	return strconv.Itoa(int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// Rule is a token bucket: Burst requests at once, refilled at Burst per
+// Per.
+type Rule struct {
+	Burst int
+	Per   time.Duration
+}
+
+func (r Rule) rate() float64 { return float64(r.Burst) / r.Per.Seconds() }
+
+// ParseRule reads "10/1m" or "10/m" (ten per minute).
+func ParseRule(s string) (Rule, error) {
+	n, per, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return Rule{}, fmt.Errorf("ratelimit: %q is not n/period", s)
+	}
+	burst, err := strconv.Atoi(n)
+	if err != nil || burst <= 0 {
+		return Rule{}, fmt.Errorf("ratelimit: bad count in %q", s)
+	}
+	switch per {
+	case "s", "m", "h":
+		per = "1" + per
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return Rule{}, fmt.Errorf("ratelimit: bad period in %q", s)
+	}
+	return Rule{Burst: burst, Per: d}, nil
+}
+
+// RulesFromEnv starts from defaults and applies RATE_LIMITS, a comma
+// separated list like "login=10/m,upload=30/h". "off" disables a route.
+func RulesFromEnv(defaults map[string]Rule) map[string]Rule {
+	rules := map[string]Rule{}
+	for k, v := range defaults {
+		rules[k] = v
+	}
+	for _, item := range strings.Split(os.Getenv("RATE_LIMITS"), ",") {
+		name, spec, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if strings.TrimSpace(spec) == "off" {
+			delete(rules, name)
+			continue
+		}
+		r, err := ParseRule(spec)
+		if err != nil {
+			log.Println("RATE_LIMITS:", err)
+			continue
+		}
+		rules[name] = r
+	}
+	return rules
+}
+
+// Limiter applies named rules. Routes without a rule are not limited, and
+// store errors let the request through rather than take the site down.
+type Limiter struct {
+	store Store
+	rules map[string]Rule
+	now   func() time.Time
+}
+
+func NewLimiter(store Store, rules map[string]Rule) *Limiter {
+	return &Limiter{store: store, rules: rules, now: time.Now}
+}
+
+// Allow takes a token for every key under the route's rule and fails on the
+// first empty bucket.
+func (l *Limiter) Allow(ctx context.Context, route string, keys ...string) error {
+	rule, ok := l.rules[route]
+	if !ok {
+		return nil
+	}
+	for _, key := range keys {
+		ok, wait, err := l.store.Take(ctx, route+"|"+key, rule.rate(), rule.Burst, l.now())
+		if err != nil {
+			log.Println("rate limit store:", err)
+			return nil
+		}
+		if !ok {
+			return &LimitError{RetryAfter: wait}
+		}
+	}
+	return nil
+}
+
+// Lockout locks an account after Threshold failed logins in a row. Each
+// further failure doubles the lock, from Base up to Max; a success resets
+// the count, and so does Window without failures.
+type Lockout struct {
+	store     Store
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+	Window    time.Duration
+	now       func() time.Time
+}
+
+// NewLockout reads LOGIN_LOCKOUT_THRESHOLD (5), LOGIN_LOCKOUT_BASE (1m),
+// LOGIN_LOCKOUT_MAX (1h) and LOGIN_LOCKOUT_WINDOW (24h).
+func NewLockout(store Store) *Lockout {
+	l := &Lockout{
+		store:     store,
+		Threshold: 5,
+		Base:      durationEnv("LOGIN_LOCKOUT_BASE", time.Minute),
+		Max:       durationEnv("LOGIN_LOCKOUT_MAX", time.Hour),
+		Window:    durationEnv("LOGIN_LOCKOUT_WINDOW", 24*time.Hour),
+		now:       time.Now,
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("LOGIN_LOCKOUT_THRESHOLD"))); err == nil && n > 0 {
+		l.Threshold = n
+	}
+	return l
+}
+
+func durationEnv(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv(key))); err == nil && d > 0 {
+		return d
+	}
+	return def
+}
+
+func (l *Lockout) lockedFor(f Failures) time.Duration {
+	if f.Count < l.Threshold {
+		return 0
+	}
+	d := l.Base
+	for i := l.Threshold; i < f.Count && d < l.Max; i++ {
+		d *= 2
+	}
+	if d > l.Max {
+		d = l.Max
+	}
+	return f.Last.Add(d).Sub(l.now())
+}
+
+// Check returns a *LimitError while the account is locked.
+func (l *Lockout) Check(ctx context.Context, account string) error {
+	f, err := l.store.GetFailures(ctx, "lockout|"+account)
+	if err != nil {
+		log.Println("lockout store:", err)
+		return nil
+	}
+	if wait := l.lockedFor(f); wait > 0 {
+		return &LimitError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// Fail records a failed attempt and returns a *LimitError if the account is
+// locked now.
+func (l *Lockout) Fail(ctx context.Context, account string) error {
+	f, err := l.store.AddFailure(ctx, "lockout|"+account, l.now(), l.Window)
+	if err != nil {
+		log.Println("lockout store:", err)
+		return nil
+	}
+	if wait := l.lockedFor(f); wait > 0 {
+		return &LimitError{RetryAfter: wait}
+	}
+	return nil
+}
+
+func (l *Lockout) Success(ctx context.Context, account string) {
+	if err := l.store.ResetFailures(ctx, "lockout|"+account); err != nil {
+		log.Println("lockout store:", err)
+	}
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"YeahMusic/internal/models"
+)
+
+const (
+	PurposeResetPassword = "reset_password"
+	PurposeVerifyEmail   = "verify_email"
+)
+
+// AccountService handles the flows that go through the user's mailbox:
+// password reset and email verification.
+type AccountService struct {
+	store     Repository
+	hasher    PasswordHasher
+	mailer    Mailer
+	baseURL   string
+	resetTTL  time.Duration
+	verifyTTL time.Duration
+}
+
+func NewAccountService(store Repository, mailer Mailer) *AccountService {
+	base := strings.TrimRight(strings.TrimSpace(os.Getenv("APP_URL")), "/")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return &AccountService{
+		store:     store,
+		hasher:    PasswordHasherFromEnv(),
+		mailer:    mailer,
+		baseURL:   base,
+		resetTTL:  durationEnv("RESET_TOKEN_TTL", time.Hour),
+		verifyTTL: durationEnv("VERIFY_TOKEN_TTL", 48*time.Hour),
+	}
+}
+
+// issue replaces any earlier token of the same purpose, so only the newest
+// mail works.
+func (s *AccountService) issue(u *models.User, purpose string, ttl time.Duration) (string, error) {
+	s.store.DeleteEmailTokens(u.ID, purpose)
+	t := &models.EmailToken{UserID: u.ID, Purpose: purpose, Token: genToken(32)}
+	t.TokenHash = hashToken(t.Token)
+	t.ExpiresAt = s.store.Now().Add(ttl)
+	if _, err := s.store.CreateEmailToken(t); err != nil {
+		return "", err
+	}
+	return t.Token, nil
+}
+
+// RequestPasswordReset mails a reset link. Unknown addresses are not an
+// error, so the endpoint cannot be used to probe for accounts.
+func (s *AccountService) RequestPasswordReset(email string) error {
+	u, err := s.store.GetUserByEmail(normalizeEmail(email))
+	if err != nil {
+		return nil
+	}
+	tok, err := s.issue(u, PurposeResetPassword, s.resetTTL)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(Mail{
+		To:      u.Email,
+		Subject: "Reset your YeahMusic password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your YeahMusic account.\n\n"+
+			"Open %s/reset-password?token=%s to choose a new one. The link works once and expires in %s.\n\n"+
+			"If it was not you, ignore this mail.\n", s.baseURL, tok, s.resetTTL),
+	})
+}
+
+// ResetPassword also signs the user out everywhere and, since the mail
+// arrived, marks the address as verified.
+func (s *AccountService) ResetPassword(token, password string) error {
+	if password == "" {
+		return ErrUnauthorized
+	}
+	t, err := s.store.ConsumeEmailToken(PurposeResetPassword, hashToken(token), s.store.Now())
+	if err != nil {
+		return ErrNotFound
+	}
+	hash, err := s.hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	if err := s.store.UpdateUserPassword(t.UserID, hash); err != nil {
+		return err
+	}
+	if err := s.store.SetEmailVerified(t.UserID, true); err != nil {
+		log.Println("reset password: verify email:", err)
+	}
+	s.store.DeleteUserSessions(t.UserID)
+	return nil
+}
+
+func (s *AccountService) SendVerification(u *models.User) error {
+	if u.EmailVerified {
+		return nil
+	}
+	tok, err := s.issue(u, PurposeVerifyEmail, s.verifyTTL)
+	if err != nil {
+		return err
+	}
+	return s.mailer.Send(Mail{
+		To:      u.Email,
+		Subject: "Confirm your YeahMusic email",
+		Body: fmt.Sprintf("Welcome to YeahMusic!\n\n"+
+			"Open %s/api/verify-email?token=%s to confirm this address. The link expires in %s.\n",
+			s.baseURL, tok, s.verifyTTL),
+	})
+}
+
+func (s *AccountService) VerifyEmail(token string) error {
+	t, err := s.store.ConsumeEmailToken(PurposeVerifyEmail, hashToken(token), s.store.Now())
+	if err != nil {
+		return ErrNotFound
+	}
+	return s.store.SetEmailVerified(t.UserID, true)
+}
@@ -1,15 +1,17 @@
 package services
 
 import (
+	"fmt"
+
 	"YeahMusic/internal/models"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type AdminService struct {
-	store *Store
+	store Repository
 }
 
-func NewAdminService(store *Store) *AdminService {
+func NewAdminService(store Repository) *AdminService {
 	return &AdminService{store: store}
 }
 
@@ -24,3 +26,69 @@ func (a *AdminService) CreateAdmin(email, role string) *models.Admin {
 func (a *AdminService) Ping() map[string]string {
 	return map[string]string{"status": "ok", "db": "mongo"}
 }
+
+func (a *AdminService) ListRoleRequests() []*models.User {
+	return a.store.ListRoleRequests()
+}
+
+// ApproveRole grants the role the user asked for; RejectRole drops the
+// request and leaves the current role alone.
+func (a *AdminService) ApproveRole(userID primitive.ObjectID) (*models.User, error) {
+	u, err := a.store.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if u.RequestedRole == "" {
+		return nil, ErrNotFound
+	}
+	if err := a.store.SetUserRole(u.ID, u.RequestedRole, ""); err != nil {
+		return nil, err
+	}
+	u.Role, u.RequestedRole = u.RequestedRole, ""
+	return u, nil
+}
+
+func (a *AdminService) RejectRole(userID primitive.ObjectID) (*models.User, error) {
+	u, err := a.store.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if u.RequestedRole == "" {
+		return nil, ErrNotFound
+	}
+	if err := a.store.SetUserRole(u.ID, u.Role, ""); err != nil {
+		return nil, err
+	}
+	u.RequestedRole = ""
+	return u, nil
+}
+
+func (a *AdminService) SetRole(email, role string) (*models.User, error) {
+	if !ValidRole(role) {
+		return nil, fmt.Errorf("unknown role %q", role)
+	}
+	u, err := a.store.GetUserByEmail(normalizeEmail(email))
+	if err != nil {
+		return nil, err
+	}
+	if err := a.store.SetUserRole(u.ID, role, ""); err != nil {
+		return nil, err
+	}
+	u.Role, u.RequestedRole = role, ""
+	return u, nil
+}
+
+// ResetTOTP turns two-factor authentication off for a user who lost the
+// device and the recovery codes. Their sessions are revoked as well.
+func (a *AdminService) ResetTOTP(userID primitive.ObjectID) (*models.User, error) {
+	u, err := a.store.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.store.SetTOTP(u.ID, "", false, nil); err != nil {
+		return nil, err
+	}
+	a.store.DeleteUserSessions(u.ID)
+	u.TOTPEnabled, u.TOTPSecret, u.RecoveryCodes = false, "", nil
+	return u, nil
+}
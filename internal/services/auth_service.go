@@ -1,26 +1,49 @@
 package services
 
 import (
+	"context"
 	"crypto/rand"
-	"crypto/sha256"
 	"encoding/hex"
+	"log"
+	"os"
 	"strings"
 	"time"
 
 	"YeahMusic/internal/models"
+	"YeahMusic/internal/ratelimit"
 )
 
 type AuthService struct {
-	store *Store
+	store      Repository
+	hasher     PasswordHasher
+	accessTTL  time.Duration
+	sessionTTL time.Duration
+	mfaRoles   []string
+	lockout    *ratelimit.Lockout
 }
 
-func NewAuthService(store *Store) *AuthService {
-	return &AuthService{store: store}
+func NewAuthService(store Repository) *AuthService {
+	return &AuthService{
+		store:      store,
+		hasher:     PasswordHasherFromEnv(),
+		accessTTL:  durationEnv("ACCESS_TOKEN_TTL", 15*time.Minute),
+		sessionTTL: durationEnv("SESSION_TTL", 30*24*time.Hour),
+		mfaRoles:   mfaRolesFromEnv(),
+		lockout:    ratelimit.NewLockout(ratelimit.NewMemoryStore()),
+	}
+}
+
+// SetLimitStore moves the failed-login counters to s, e.g. one shared by
+// all instances.
+func (a *AuthService) SetLimitStore(s ratelimit.Store) {
+	a.lockout = ratelimit.NewLockout(s)
 }
 
-func hashPassword(pw string) string {
-	sum := sha256.Sum256([]byte(pw))
-	return hex.EncodeToString(sum[:])
+func durationEnv(key string, def time.Duration) time.Duration {
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv(key))); err == nil && d > 0 {
+		return d
+	}
+	return def
 }
 
 func genToken(n int) string {
@@ -33,26 +56,62 @@ func normalizeEmail(s string) string {
 	return strings.TrimSpace(strings.ToLower(s))
 }
 
+// Register always creates a plain user. Asking for the artist role only
+// records a request that an admin has to approve.
 func (a *AuthService) Register(email, password, name, role string) (*models.User, error) {
 	email = normalizeEmail(email)
 	if email == "" || password == "" {
 		return nil, ErrUnauthorized
 	}
+	hash, err := a.hasher.Hash(password)
+	if err != nil {
+		return nil, err
+	}
 	u := &models.User{
-		Email: email, PasswordHash: hashPassword(password), Name: name, Role: role,
+		Email: email, PasswordHash: hash, Name: name, Role: RoleUser,
+	}
+	if role == RoleArtist {
+		u.RequestedRole = RoleArtist
 	}
 	return a.store.CreateUser(u)
 }
 
-func (a *AuthService) Login(email, password string) (*models.Session, *models.User, error) {
+// Login returns an *MFAChallenge error instead of a session when the account
+// has two-factor authentication on, and a *ratelimit.LimitError while the
+// address is locked out after repeated failures.
+func (a *AuthService) Login(email, password string, client ClientInfo) (*models.Session, *models.User, error) {
 	email = normalizeEmail(email)
+	ctx := context.Background()
+	if err := a.lockout.Check(ctx, email); err != nil {
+		return nil, nil, err
+	}
 	u, err := a.store.GetUserByEmail(email)
 	if err != nil {
-		return nil, nil, ErrUnauthorized
+		return nil, nil, a.loginFailed(email)
+	}
+	ok, rehash := a.hasher.Verify(u.PasswordHash, password)
+	if !ok {
+		return nil, nil, a.loginFailed(email)
+	}
+	if rehash {
+		if hash, err := a.hasher.Hash(password); err == nil {
+			if err := a.store.UpdateUserPassword(u.ID, hash); err != nil {
+				log.Println("rehash password:", err)
+			}
+		}
 	}
-	if u.PasswordHash != hashPassword(password) {
-		return nil, nil, ErrUnauthorized
+	sec, u, err := a.FinishLogin(u, client)
+	if err == nil {
+		a.lockout.Success(ctx, email)
+	}
+	return sec, u, err
+}
+
+// loginFailed counts a wrong password against the address, known or not,
+// and returns the *ratelimit.LimitError once that locks it.
+func (a *AuthService) loginFailed(email string) error {
+	if err := a.lockout.Fail(context.Background(), email); err != nil {
+		return err
 	}
-	sec := a.store.CreateSession(u.ID, genToken(16), 24*time.Hour)
-	return sec, u, nil
+	return ErrUnauthorized
 }
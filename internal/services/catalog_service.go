@@ -1,15 +1,19 @@
 package services
 
 import (
+	"strings"
+
+	"YeahMusic/internal/audio"
 	"YeahMusic/internal/models"
+	"YeahMusic/internal/stream"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type CatalogService struct {
-	store *Store
+	store Repository
 }
 
-func NewCatalogService(store *Store) *CatalogService {
+func NewCatalogService(store Repository) *CatalogService {
 	return &CatalogService{store: store}
 }
 
@@ -25,7 +29,7 @@ func (c *CatalogService) ListTracks(albumID primitive.ObjectID) []*models.Track
 	return c.store.ListTracks(albumID)
 }
 
-func (c *CatalogService) AddTrack(t *models.Track) *models.Track {
+func (c *CatalogService) AddTrack(t *models.Track) (*models.Track, error) {
 	return c.store.AddTrack(t)
 }
 
@@ -33,10 +37,56 @@ func (c *CatalogService) UpdateTrack(id primitive.ObjectID, coverURL, lyrics str
 	return c.store.UpdateTrack(id, coverURL, lyrics)
 }
 
+func (c *CatalogService) GetTrack(id primitive.ObjectID) (*models.Track, error) {
+	return c.store.GetTrack(id)
+}
+
+// Played counts a play started on the stream endpoint.
+func (c *CatalogService) Played(ev stream.Event) error {
+	return c.store.AddPlay(ev.TrackID)
+}
+
 func (c *CatalogService) DeleteTrack(id primitive.ObjectID) error {
 	return c.store.DeleteTrack(id)
 }
 
-func (c *CatalogService) CreateAlbum(a *models.Album) *models.Album {
+func (c *CatalogService) CreateAlbum(a *models.Album) (*models.Album, error) {
 	return c.store.AddAlbum(a)
 }
+
+// FillFromTags sets the duration and the gapless info of t from the audio
+// and every field the uploader left empty from its tags.
+func (c *CatalogService) FillFromTags(t *models.Track, info *audio.Info) {
+	if d := info.Seconds(); d > 0 {
+		t.DurationSec = d
+	}
+	if t.Title == "" {
+		t.Title = info.Title
+	}
+	if t.ArtistName == "" {
+		t.ArtistName = info.Artist
+	}
+	if t.Lyrics == "" {
+		t.Lyrics = info.Lyrics
+	}
+	if t.TrackNo == 0 {
+		t.TrackNo = info.Track
+	}
+	if t.Genre == "" {
+		t.Genre = info.Genre
+	}
+	if t.Year == 0 {
+		t.Year = info.Year
+	}
+	t.Gapless = GaplessInfo(info)
+}
+
+// AlbumByTitle finds an album of the artist by title, ignoring case.
+func (c *CatalogService) AlbumByTitle(artistID primitive.ObjectID, title string) (*models.Album, error) {
+	for _, a := range c.store.ListAlbums(artistID) {
+		if strings.EqualFold(a.Title, strings.TrimSpace(title)) {
+			return a, nil
+		}
+	}
+	return nil, ErrNotFound
+}
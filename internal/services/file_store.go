@@ -0,0 +1,499 @@
+package services
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"YeahMusic/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// FileStore keeps everything in a MemoryStore and snapshots it to a JSON file
+// in the storage.json layout, where every record has an integer id.
+type FileStore struct {
+	*MemoryStore
+
+	path  string
+	dirty atomic.Bool
+	next  map[string]int64
+
+	writeMu   sync.Mutex
+	stop      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type fileUser struct {
+	ID           int64     `json:"id"`
+	Email        string    `json:"email"`
+	PasswordHash string    `json:"password_hash,omitempty"`
+	Name         string    `json:"name"`
+	Bio          string    `json:"bio,omitempty"`
+	Role         string    `json:"role"`
+	Requested    string    `json:"requested_role,omitempty"`
+	Unverified   bool      `json:"unverified,omitempty"`
+	TOTPSecret   string    `json:"totp_secret,omitempty"`
+	TOTPEnabled  bool      `json:"totp_enabled,omitempty"`
+	TOTPLastStep int64     `json:"totp_last_step,omitempty"`
+	Recovery     []string  `json:"recovery_codes,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// fileSession still reads the plaintext "token" of older files; it is hashed
+// on load and only token_hash is written back.
+type fileSession struct {
+	ID                int64      `json:"id"`
+	UserID            int64      `json:"user_id"`
+	Token             string     `json:"token,omitempty"`
+	TokenHash         string     `json:"token_hash,omitempty"`
+	RefreshHash       string     `json:"refresh_hash,omitempty"`
+	PrevRefreshHashes []string   `json:"prev_refresh_hashes,omitempty"`
+	UserAgent         string     `json:"user_agent,omitempty"`
+	IP                string     `json:"ip,omitempty"`
+	AccessExpiresAt   *time.Time `json:"access_expires_at,omitempty"`
+	ExpiresAt         time.Time  `json:"expires_at"`
+	LastUsedAt        *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt         time.Time  `json:"created_at"`
+}
+
+type fileEmailToken struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Purpose   string    `json:"purpose"`
+	TokenHash string    `json:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type fileIdentity struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type fileAPIKey struct {
+	ID         int64      `json:"id"`
+	UserID     int64      `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	KeyHash    string     `json:"key_hash"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type fileArtist struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Bio       string     `json:"bio"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+}
+
+type fileAlbum struct {
+	ID          int64      `json:"id"`
+	ArtistID    int64      `json:"artist_id"`
+	Title       string     `json:"title"`
+	CoverURL    string     `json:"cover_url"`
+	ReleaseYear int        `json:"release_year"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+
+	Loudness *models.Loudness `json:"loudness,omitempty"`
+}
+
+type fileTrack struct {
+	ID          int64      `json:"id"`
+	AlbumID     int64      `json:"album_id"`
+	Title       string     `json:"title"`
+	ArtistID    int64      `json:"artist_id,omitempty"`
+	ArtistName  string     `json:"artist_name"`
+	DurationSec int        `json:"duration_sec"`
+	TrackNo     int        `json:"track_no,omitempty"`
+	Genre       string     `json:"genre,omitempty"`
+	Year        int        `json:"year,omitempty"`
+	Plays       int64      `json:"plays,omitempty"`
+	AudioURL    string     `json:"audio_url"`
+	CoverURL    string     `json:"cover_url"`
+	Lyrics      string     `json:"lyrics"`
+	CreatedAt   *time.Time `json:"created_at,omitempty"`
+
+	Loudness      *models.Loudness `json:"loudness,omitempty"`
+	AlbumLoudness *models.Loudness `json:"album_loudness,omitempty"`
+	Gapless       *models.Gapless  `json:"gapless,omitempty"`
+}
+
+type filePlaylist struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	Title     string    `json:"title"`
+	CoverURL  string    `json:"cover_url"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type filePlaylistTrack struct {
+	ID         int64     `json:"id"`
+	PlaylistID int64     `json:"playlist_id"`
+	TrackID    int64     `json:"track_id"`
+	AddedAt    time.Time `json:"added_at"`
+}
+
+type fileData struct {
+	Users          []fileUser          `json:"users"`
+	Sessions       []fileSession       `json:"sessions"`
+	Artists        []fileArtist        `json:"artists"`
+	Albums         []fileAlbum         `json:"albums"`
+	Tracks         []fileTrack         `json:"tracks"`
+	Playlists      []filePlaylist      `json:"playlists"`
+	PlaylistTracks []filePlaylistTrack `json:"playlist_tracks"`
+	EmailTokens    []fileEmailToken    `json:"email_tokens,omitempty"`
+	Identities     []fileIdentity      `json:"identities,omitempty"`
+	APIKeys        []fileAPIKey        `json:"api_keys,omitempty"`
+}
+
+// intID and oidInt map the integer ids of the file onto ObjectIDs whose
+// timestamp part is zero, so ordering by ObjectID matches ordering by id.
+func intID(n int64) primitive.ObjectID {
+	var id primitive.ObjectID
+	if n <= 0 {
+		return id
+	}
+	binary.BigEndian.PutUint64(id[4:], uint64(n))
+	return id
+}
+
+func oidInt(id primitive.ObjectID) int64 {
+	return int64(binary.BigEndian.Uint64(id[4:]))
+}
+
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func timeVal(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+func NewFileStore(path string, snapshotEvery time.Duration) (*FileStore, error) {
+	fs := &FileStore{
+		MemoryStore: NewMemoryStore(),
+		path:        path,
+		next:        map[string]int64{},
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if err := fs.load(); err != nil {
+		return nil, err
+	}
+
+	fs.MemoryStore.newID = func(coll string) primitive.ObjectID {
+		fs.next[coll]++
+		return intID(fs.next[coll])
+	}
+	fs.MemoryStore.onChange = func() { fs.dirty.Store(true) }
+
+	if snapshotEvery <= 0 {
+		snapshotEvery = 5 * time.Second
+	}
+	go fs.snapshotLoop(snapshotEvery)
+	return fs, nil
+}
+
+func (fs *FileStore) bump(coll string, id int64) {
+	if id > fs.next[coll] {
+		fs.next[coll] = id
+	}
+}
+
+func (fs *FileStore) load() error {
+	b, err := os.ReadFile(fs.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var data fileData
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	m := fs.MemoryStore
+	for _, u := range data.Users {
+		fs.bump("users", u.ID)
+		m.users[intID(u.ID)] = models.User{
+			ID: intID(u.ID), Email: u.Email, PasswordHash: u.PasswordHash, Name: u.Name,
+			Bio: u.Bio, Role: u.Role, RequestedRole: u.Requested, EmailVerified: !u.Unverified,
+			TOTPSecret: u.TOTPSecret, TOTPEnabled: u.TOTPEnabled, TOTPLastStep: u.TOTPLastStep,
+			RecoveryCodes: u.Recovery, CreatedAt: u.CreatedAt,
+		}
+	}
+	for _, s := range data.Sessions {
+		fs.bump("sessions", s.ID)
+		sec := models.Session{
+			ID: intID(s.ID), UserID: intID(s.UserID), TokenHash: s.TokenHash,
+			RefreshHash: s.RefreshHash, PrevRefreshHashes: s.PrevRefreshHashes,
+			UserAgent: s.UserAgent, IP: s.IP, AccessExpiresAt: timeVal(s.AccessExpiresAt),
+			ExpiresAt: s.ExpiresAt, LastUsedAt: timeVal(s.LastUsedAt), CreatedAt: s.CreatedAt,
+		}
+		if sec.TokenHash == "" && s.Token != "" {
+			sec.TokenHash = hashToken(s.Token)
+			fs.dirty.Store(true)
+		}
+		if sec.AccessExpiresAt.IsZero() {
+			sec.AccessExpiresAt = sec.ExpiresAt
+		}
+		if sec.LastUsedAt.IsZero() {
+			sec.LastUsedAt = sec.CreatedAt
+		}
+		m.sessions[sec.ID] = sec
+	}
+	for _, a := range data.Artists {
+		fs.bump("artists", a.ID)
+		m.artists[intID(a.ID)] = models.Artist{
+			ID: intID(a.ID), Name: a.Name, Bio: a.Bio, CreatedAt: timeVal(a.CreatedAt),
+		}
+	}
+	for _, a := range data.Albums {
+		fs.bump("albums", a.ID)
+		m.albums[intID(a.ID)] = models.Album{
+			ID: intID(a.ID), ArtistID: intID(a.ArtistID), Title: a.Title, CoverURL: a.CoverURL,
+			ReleaseYear: a.ReleaseYear, CreatedAt: timeVal(a.CreatedAt), Loudness: a.Loudness,
+		}
+	}
+	for _, t := range data.Tracks {
+		fs.bump("tracks", t.ID)
+		m.tracks[intID(t.ID)] = models.Track{
+			ID: intID(t.ID), AlbumID: intID(t.AlbumID), Title: t.Title, ArtistID: intID(t.ArtistID),
+			ArtistName: t.ArtistName, DurationSec: t.DurationSec, AudioURL: t.AudioURL,
+			TrackNo: t.TrackNo, Genre: t.Genre, Year: t.Year, Plays: t.Plays,
+			CoverURL: t.CoverURL, Lyrics: t.Lyrics, CreatedAt: timeVal(t.CreatedAt),
+			Loudness: t.Loudness, AlbumLoudness: t.AlbumLoudness, Gapless: t.Gapless,
+		}
+	}
+	for _, p := range data.Playlists {
+		fs.bump("playlists", p.ID)
+		m.playlists[intID(p.ID)] = models.Playlist{
+			ID: intID(p.ID), UserID: intID(p.UserID), Title: p.Title, CoverURL: p.CoverURL,
+			CreatedAt: p.CreatedAt,
+		}
+	}
+	for _, pt := range data.PlaylistTracks {
+		fs.bump("playlist_tracks", pt.ID)
+		m.playlistTracks[intID(pt.ID)] = models.PlaylistTrack{
+			ID: intID(pt.ID), PlaylistID: intID(pt.PlaylistID), TrackID: intID(pt.TrackID),
+			AddedAt: pt.AddedAt,
+		}
+	}
+	for _, t := range data.EmailTokens {
+		fs.bump("email_tokens", t.ID)
+		m.emailTokens[intID(t.ID)] = models.EmailToken{
+			ID: intID(t.ID), UserID: intID(t.UserID), Purpose: t.Purpose, TokenHash: t.TokenHash,
+			ExpiresAt: t.ExpiresAt, CreatedAt: t.CreatedAt,
+		}
+	}
+	for _, i := range data.Identities {
+		fs.bump("identities", i.ID)
+		m.identities[intID(i.ID)] = models.Identity{
+			ID: intID(i.ID), UserID: intID(i.UserID), Provider: i.Provider, Subject: i.Subject,
+			Email: i.Email, CreatedAt: i.CreatedAt,
+		}
+	}
+	for _, k := range data.APIKeys {
+		fs.bump("api_keys", k.ID)
+		m.apiKeys[intID(k.ID)] = models.APIKey{
+			ID: intID(k.ID), UserID: intID(k.UserID), Name: k.Name, Prefix: k.Prefix, KeyHash: k.KeyHash,
+			Scopes: k.Scopes, ExpiresAt: k.ExpiresAt, LastUsedAt: k.LastUsedAt, CreatedAt: k.CreatedAt,
+		}
+	}
+	return nil
+}
+
+func (fs *FileStore) snapshot() fileData {
+	m := fs.MemoryStore
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	data := fileData{
+		Users:          []fileUser{},
+		Sessions:       []fileSession{},
+		Artists:        []fileArtist{},
+		Albums:         []fileAlbum{},
+		Tracks:         []fileTrack{},
+		Playlists:      []filePlaylist{},
+		PlaylistTracks: []filePlaylistTrack{},
+	}
+	for _, u := range m.users {
+		data.Users = append(data.Users, fileUser{
+			ID: oidInt(u.ID), Email: u.Email, PasswordHash: u.PasswordHash, Name: u.Name,
+			Bio: u.Bio, Role: u.Role, Requested: u.RequestedRole, Unverified: !u.EmailVerified,
+			TOTPSecret: u.TOTPSecret, TOTPEnabled: u.TOTPEnabled, TOTPLastStep: u.TOTPLastStep,
+			Recovery: u.RecoveryCodes, CreatedAt: u.CreatedAt,
+		})
+	}
+	for _, s := range m.sessions {
+		data.Sessions = append(data.Sessions, fileSession{
+			ID: oidInt(s.ID), UserID: oidInt(s.UserID), TokenHash: s.TokenHash,
+			RefreshHash: s.RefreshHash, PrevRefreshHashes: s.PrevRefreshHashes,
+			UserAgent: s.UserAgent, IP: s.IP, AccessExpiresAt: timePtr(s.AccessExpiresAt),
+			ExpiresAt: s.ExpiresAt, LastUsedAt: timePtr(s.LastUsedAt), CreatedAt: s.CreatedAt,
+		})
+	}
+	for _, a := range m.artists {
+		data.Artists = append(data.Artists, fileArtist{
+			ID: oidInt(a.ID), Name: a.Name, Bio: a.Bio, CreatedAt: timePtr(a.CreatedAt),
+		})
+	}
+	for _, a := range m.albums {
+		data.Albums = append(data.Albums, fileAlbum{
+			ID: oidInt(a.ID), ArtistID: oidInt(a.ArtistID), Title: a.Title, CoverURL: a.CoverURL,
+			ReleaseYear: a.ReleaseYear, CreatedAt: timePtr(a.CreatedAt), Loudness: a.Loudness,
+		})
+	}
+	for _, t := range m.tracks {
+		data.Tracks = append(data.Tracks, fileTrack{
+			ID: oidInt(t.ID), AlbumID: oidInt(t.AlbumID), Title: t.Title, ArtistID: oidInt(t.ArtistID),
+			ArtistName: t.ArtistName, DurationSec: t.DurationSec, AudioURL: t.AudioURL,
+			TrackNo: t.TrackNo, Genre: t.Genre, Year: t.Year, Plays: t.Plays,
+			CoverURL: t.CoverURL, Lyrics: t.Lyrics, CreatedAt: timePtr(t.CreatedAt),
+			Loudness: t.Loudness, AlbumLoudness: t.AlbumLoudness, Gapless: t.Gapless,
+		})
+	}
+	for _, p := range m.playlists {
+		data.Playlists = append(data.Playlists, filePlaylist{
+			ID: oidInt(p.ID), UserID: oidInt(p.UserID), Title: p.Title, CoverURL: p.CoverURL,
+			CreatedAt: p.CreatedAt,
+		})
+	}
+	for _, pt := range m.playlistTracks {
+		data.PlaylistTracks = append(data.PlaylistTracks, filePlaylistTrack{
+			ID: oidInt(pt.ID), PlaylistID: oidInt(pt.PlaylistID), TrackID: oidInt(pt.TrackID),
+			AddedAt: pt.AddedAt,
+		})
+	}
+	for _, t := range m.emailTokens {
+		data.EmailTokens = append(data.EmailTokens, fileEmailToken{
+			ID: oidInt(t.ID), UserID: oidInt(t.UserID), Purpose: t.Purpose, TokenHash: t.TokenHash,
+			ExpiresAt: t.ExpiresAt, CreatedAt: t.CreatedAt,
+		})
+	}
+	for _, i := range m.identities {
+		data.Identities = append(data.Identities, fileIdentity{
+			ID: oidInt(i.ID), UserID: oidInt(i.UserID), Provider: i.Provider, Subject: i.Subject,
+			Email: i.Email, CreatedAt: i.CreatedAt,
+		})
+	}
+	for _, k := range m.apiKeys {
+		data.APIKeys = append(data.APIKeys, fileAPIKey{
+			ID: oidInt(k.ID), UserID: oidInt(k.UserID), Name: k.Name, Prefix: k.Prefix, KeyHash: k.KeyHash,
+			Scopes: k.Scopes, ExpiresAt: k.ExpiresAt, LastUsedAt: k.LastUsedAt, CreatedAt: k.CreatedAt,
+		})
+	}
+	sort.Slice(data.Users, func(i, j int) bool { return data.Users[i].ID < data.Users[j].ID })
+	sort.Slice(data.Sessions, func(i, j int) bool { return data.Sessions[i].ID < data.Sessions[j].ID })
+	sort.Slice(data.Artists, func(i, j int) bool { return data.Artists[i].ID < data.Artists[j].ID })
+	sort.Slice(data.Albums, func(i, j int) bool { return data.Albums[i].ID < data.Albums[j].ID })
+	sort.Slice(data.Tracks, func(i, j int) bool { return data.Tracks[i].ID < data.Tracks[j].ID })
+	sort.Slice(data.Playlists, func(i, j int) bool { return data.Playlists[i].ID < data.Playlists[j].ID })
+	sort.Slice(data.PlaylistTracks, func(i, j int) bool { return data.PlaylistTracks[i].ID < data.PlaylistTracks[j].ID })
+	sort.Slice(data.EmailTokens, func(i, j int) bool { return data.EmailTokens[i].ID < data.EmailTokens[j].ID })
+	sort.Slice(data.Identities, func(i, j int) bool { return data.Identities[i].ID < data.Identities[j].ID })
+	sort.Slice(data.APIKeys, func(i, j int) bool { return data.APIKeys[i].ID < data.APIKeys[j].ID })
+	return data
+}
+
+func (fs *FileStore) Flush() error {
+	fs.writeMu.Lock()
+	defer fs.writeMu.Unlock()
+
+	fs.dirty.Store(false)
+	b, err := json.MarshalIndent(fs.snapshot(), "", "  ")
+	if err != nil {
+		fs.dirty.Store(true)
+		return err
+	}
+	if err := writeFileAtomic(fs.path, b); err != nil {
+		fs.dirty.Store(true)
+		return err
+	}
+	return nil
+}
+
+func (fs *FileStore) snapshotLoop(every time.Duration) {
+	defer close(fs.done)
+	t := time.NewTicker(every)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			if !fs.dirty.Load() {
+				continue
+			}
+			if err := fs.Flush(); err != nil {
+				log.Println("FileStore snapshot error:", err)
+			}
+		case <-fs.stop:
+			return
+		}
+	}
+}
+
+// Close stops the snapshots and writes what changed since the last one. Only
+// the first call stops them; every call flushes.
+func (fs *FileStore) Close() error {
+	fs.closeOnce.Do(func() { close(fs.stop) })
+	<-fs.done
+	if !fs.dirty.Load() {
+		return nil
+	}
+	return fs.Flush()
+}
+
+func writeFileAtomic(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, path); err != nil {
+		return err
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"YeahMusic/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// openFileStore opens a store on path whose snapshots only happen on Flush
+// and Close.
+func openFileStore(t *testing.T, path string) *FileStore {
+	t.Helper()
+	fs, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { fs.Close() })
+	return fs
+}
+
+func TestIntID(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{1, "000000000000000000000001"},
+		{255, "0000000000000000000000ff"},
+		{1 << 40, "000000000000010000000000"},
+	}
+	for _, tc := range tests {
+		id := intID(tc.n)
+		if id.Hex() != tc.want {
+			t.Errorf("intID(%d) = %s, want %s", tc.n, id.Hex(), tc.want)
+		}
+		if id.Timestamp().Unix() != 0 {
+			t.Errorf("intID(%d) has a timestamp", tc.n)
+		}
+		if got := oidInt(id); got != tc.n {
+			t.Errorf("oidInt(intID(%d)) = %d", tc.n, got)
+		}
+	}
+	for _, n := range []int64{0, -1} {
+		if !intID(n).IsZero() {
+			t.Errorf("intID(%d) = %s, want the zero id", n, intID(n).Hex())
+		}
+	}
+	if !idLess(intID(2), intID(10)) || !idLess(intID(255), intID(256)) {
+		t.Error("ObjectIDs do not order like their integer ids")
+	}
+}
+
+// TestFileStoreLoad reads the storage.json the repository ships with.
+func TestFileStoreLoad(t *testing.T) {
+	b, err := os.ReadFile("../../storage.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "storage.json")
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fs := openFileStore(t, path)
+
+	u, err := fs.GetUserByEmail("balmagambetaat@gmail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != intID(1) || u.Role != "artist" || !u.EmailVerified {
+		t.Errorf("user %+v", u)
+	}
+	// The plaintext token of older files is hashed on load.
+	sec, err := fs.GetSession(hashToken("15d2d8c457e0430142e16e9a62f95bec"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sec.UserID != u.ID || sec.AccessExpiresAt != sec.ExpiresAt || sec.LastUsedAt != sec.CreatedAt {
+		t.Errorf("session %+v", sec)
+	}
+
+	if n := len(fs.ListArtists()); n != 2 {
+		t.Errorf("%d artists, want 2", n)
+	}
+	albums := fs.ListAlbums(primitive.NilObjectID)
+	if len(albums) != 2 || albums[0].ID != intID(1) || albums[0].ArtistID != intID(1) {
+		t.Errorf("albums %+v, want album 1 of artist 1 first", albums)
+	}
+	// Tracks are listed newest first.
+	tracks := fs.ListTracks(intID(1))
+	if len(tracks) != 2 || tracks[1].Title != "Ссора Я" || tracks[1].ID != intID(1) || tracks[1].AlbumID != intID(1) {
+		t.Errorf("tracks of album 1 %v", tracks)
+	}
+
+	if err := fs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	b, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), `"token"`) {
+		t.Error("the plaintext token was written back")
+	}
+}
+
+func TestFileStoreSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "storage.json")
+	fs := openFileStore(t, path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("a new store wrote %s before any change: %v", path, err)
+	}
+
+	if _, err := fs.CreateUser(&models.User{Email: "a@example.com", Name: "A", Role: "user"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.AddArtist(&models.Artist{Name: "B"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The snapshot is renamed into place, so only the whole file is ever
+	// there.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "storage.json" {
+		t.Errorf("files after two snapshots: %v", entries)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data fileData
+	if err := json.Unmarshal(b, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.Users) != 1 || data.Users[0].ID != 1 || len(data.Artists) != 1 || data.Artists[0].ID != 1 {
+		t.Errorf("snapshot %+v", data)
+	}
+	if data.Playlists == nil || data.PlaylistTracks == nil {
+		t.Error("empty collections are written as null, not []")
+	}
+}
+
+func TestFileStoreReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "storage.json")
+	fs, err := NewFileStore(path, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := fs.CreateUser(&models.User{Email: "a@example.com", Name: "A", Role: "artist"})
+	ar, _ := fs.AddArtist(&models.Artist{Name: "A"})
+	al, _ := fs.AddAlbum(&models.Album{ArtistID: ar.ID, Title: "First"})
+	tr, _ := fs.AddTrack(&models.Track{AlbumID: al.ID, ArtistID: u.ID, Title: "One", AudioURL: "/uploads/x.mp3"})
+	pl, _ := fs.CreatePlaylist(u.ID, "Mix", "")
+	if err := fs.AddTrackToPlaylist(u.ID, pl.ID, tr.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SetTrackLoudness(tr.ID, &models.Loudness{Integrated: -14, Gain: -4}); err != nil {
+		t.Fatal(err)
+	}
+	// Close writes what changed since the last snapshot, and may be called
+	// again.
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fs = openFileStore(t, path)
+	got, err := fs.GetTrack(tr.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != "One" || got.AlbumID != al.ID || got.ArtistID != u.ID || got.Loudness == nil || got.Loudness.Gain != -4 {
+		t.Errorf("track after reopening %+v", got)
+	}
+	if l := fs.ListPlaylists(u.ID); len(l) != 1 || l[0].ID != pl.ID {
+		t.Errorf("playlists after reopening %+v", l)
+	}
+	if err := fs.RemoveTrackFromPlaylist(u.ID, pl.ID, tr.ID); err != nil {
+		t.Errorf("playlist track after reopening: %v", err)
+	}
+
+	// New records continue after the ids of the file.
+	ar2, err := fs.AddArtist(&models.Artist{Name: "B"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if oidInt(ar2.ID) != 2 {
+		t.Errorf("next artist id %d, want 2", oidInt(ar2.ID))
+	}
+}
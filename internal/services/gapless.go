@@ -0,0 +1,69 @@
+package services
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"YeahMusic/internal/analysis"
+	"YeahMusic/internal/audio"
+	"YeahMusic/internal/models"
+	"YeahMusic/internal/storage"
+	"YeahMusic/internal/stream"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrNoGapless is reported by BackfillGapless for MP3s without a LAME tag.
+var ErrNoGapless = errors.New("no LAME tag")
+
+// GaplessInfo is the gapless info of probed audio, nil without a LAME tag.
+func GaplessInfo(info *audio.Info) *models.Gapless {
+	g := info.Gapless
+	if g == nil {
+		return nil
+	}
+	return &models.Gapless{
+		EncoderDelay:   g.Delay,
+		Padding:        g.Padding,
+		PrimingSamples: g.Delay + audio.DecoderDelay,
+		TotalSamples:   g.Samples,
+		SampleRate:     info.SampleRate,
+	}
+}
+
+// BackfillGapless probes the MP3s of the tracks without gapless
+// info again, or of all tracks, and stores what their LAME tags say. report
+// is told about every track; those that are not MP3s here come with
+// analysis.ErrUnsupported.
+func (c *CatalogService) BackfillGapless(blobs storage.BlobStore, all bool, report func(what string, err error)) {
+	for _, t := range c.store.ListTracks(primitive.NilObjectID) {
+		if !all && t.Gapless != nil {
+			continue
+		}
+		g, err := probeGapless(blobs, t)
+		if err == nil {
+			err = c.store.SetTrackGapless(t.ID, g)
+		}
+		report("track "+t.ID.Hex()+" "+t.Title, err)
+	}
+}
+
+func probeGapless(blobs storage.BlobStore, t *models.Track) (*models.Gapless, error) {
+	s, name, err := stream.Blob(blobs, publicDir, t.AudioURL)
+	if err != nil || !strings.EqualFold(filepath.Ext(name), ".mp3") {
+		return nil, analysis.ErrUnsupported
+	}
+	f, info, err := s.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	probed, err := audio.Probe(storage.ReaderAt(f), info.Size)
+	if err != nil {
+		return nil, err
+	}
+	if g := GaplessInfo(probed); g != nil {
+		return g, nil
+	}
+	return nil, ErrNoGapless
+}
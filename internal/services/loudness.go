@@ -0,0 +1,108 @@
+package services
+
+import (
+	"errors"
+	"log"
+
+	"YeahMusic/internal/analysis"
+	"YeahMusic/internal/loudness"
+	"YeahMusic/internal/models"
+	"YeahMusic/internal/storage"
+	"YeahMusic/internal/stream"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MeasureTrack stores the loudness of t, measured from its audio blob, and
+// measures the album of t again.
+func (c *CatalogService) MeasureTrack(blobs storage.BlobStore, t *models.Track) error {
+	m, err := measure(blobs, t)
+	if err != nil {
+		return err
+	}
+	if err := c.store.SetTrackLoudness(t.ID, m.Loudness()); err != nil {
+		return err
+	}
+	if t.AlbumID.IsZero() {
+		return nil
+	}
+	return c.MeasureAlbum(blobs, t.AlbumID)
+}
+
+// MeasureAlbum stores the loudness of all the tracks of the album played
+// one after another. Tracks that cannot be decoded are left out.
+func (c *CatalogService) MeasureAlbum(blobs storage.BlobStore, id primitive.ObjectID) error {
+	var ms []*loudness.Measure
+	for _, t := range c.store.ListTracks(id) {
+		m, err := measure(blobs, t)
+		if errors.Is(err, analysis.ErrUnsupported) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		ms = append(ms, m)
+	}
+	var l *models.Loudness
+	if len(ms) > 0 {
+		l = loudness.Join(ms...).Loudness()
+	}
+	return c.store.SetAlbumLoudness(id, l)
+}
+
+// publicDir holds the /audio/ tracks that come with the site, as for
+// stream.Open.
+const publicDir = "public"
+
+func measure(blobs storage.BlobStore, t *models.Track) (*loudness.Measure, error) {
+	s, name, err := stream.Blob(blobs, publicDir, t.AudioURL)
+	if err != nil {
+		return nil, analysis.ErrUnsupported
+	}
+	return analysis.Measure(s, name)
+}
+
+// Analyze runs MeasureTrack in the background for a new upload, which
+// makes its waveform on the way.
+func (c *CatalogService) Analyze(blobs storage.BlobStore, t *models.Track) {
+	background("track "+t.ID.Hex(), func() error { return c.MeasureTrack(blobs, t) })
+}
+
+// AnalyzeAlbum runs MeasureAlbum in the background, for an album that lost
+// a track.
+func (c *CatalogService) AnalyzeAlbum(blobs storage.BlobStore, id primitive.ObjectID) {
+	background("album "+id.Hex(), func() error { return c.MeasureAlbum(blobs, id) })
+}
+
+func background(what string, fn func() error) {
+	go func() {
+		if err := fn(); err != nil && !errors.Is(err, analysis.ErrUnsupported) {
+			log.Println("analysis of", what+":", err)
+		}
+	}()
+}
+
+// BackfillLoudness measures the tracks that have no loudness yet, or all
+// of them, and then their albums. report is told about every track and
+// album; tracks that cannot be decoded come with analysis.ErrUnsupported.
+func (c *CatalogService) BackfillLoudness(blobs storage.BlobStore, all bool, report func(what string, err error)) {
+	albums := map[primitive.ObjectID]bool{}
+	for _, t := range c.store.ListTracks(primitive.NilObjectID) {
+		if !t.AlbumID.IsZero() && (all || t.AlbumLoudness == nil) {
+			albums[t.AlbumID] = true
+		}
+		if !all && t.Loudness != nil {
+			continue
+		}
+		m, err := measure(blobs, t)
+		if err == nil {
+			err = c.store.SetTrackLoudness(t.ID, m.Loudness())
+		}
+		if err == nil && !t.AlbumID.IsZero() {
+			albums[t.AlbumID] = true
+		}
+		report("track "+t.ID.Hex()+" "+t.Title, err)
+	}
+	for id := range albums {
+		report("album "+id.Hex(), c.MeasureAlbum(blobs, id))
+	}
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(m Mail) error
+}
+
+// headerSafe keeps user supplied values such as the address from adding
+// headers of their own.
+var headerSafe = strings.NewReplacer("\r", "", "\n", "")
+
+func (m Mail) rfc822(from string, at time.Time) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", headerSafe.Replace(from))
+	fmt.Fprintf(&b, "To: %s\r\n", headerSafe.Replace(m.To))
+	fmt.Fprintf(&b, "Subject: %s\r\n", headerSafe.Replace(m.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", at.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// SMTPMailer relies on net/smtp, which upgrades to STARTTLS whenever the
+// server offers it.
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s *SMTPMailer) Send(m Mail) error {
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, _ := net.SplitHostPort(s.Addr)
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	sender := s.From
+	if a, err := mail.ParseAddress(s.From); err == nil {
+		sender = a.Address
+	}
+	return smtp.SendMail(s.Addr, auth, sender, []string{m.To}, m.rfc822(s.From, time.Now()))
+}
+
+// FileMailer writes every message to Dir as an .eml file, for local
+// development and tests.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func (f *FileMailer) Send(m Mail) error {
+	if err := os.MkdirAll(f.Dir, 0755); err != nil {
+		return err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%d_%s.eml", now.UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(m.To))
+	return os.WriteFile(filepath.Join(f.Dir, name), m.rfc822(f.From, now), 0600)
+}
+
+type LogMailer struct{}
+
+func (LogMailer) Send(m Mail) error {
+	log.Printf("mail to %s: %s\n%s", m.To, m.Subject, m.Body)
+	return nil
+}
+
+// MailerFromEnv picks MAIL_DRIVER: "smtp" (SMTP_HOST, SMTP_PORT, SMTP_USER,
+// SMTP_PASSWORD), "file" (MAIL_DIR) or "log", the default. MAIL_FROM is the
+// sender address.
+func MailerFromEnv() Mailer {
+	from := strings.TrimSpace(os.Getenv("MAIL_FROM"))
+	if from == "" {
+		from = "YeahMusic <no-reply@localhost>"
+	}
+	switch strings.TrimSpace(os.Getenv("MAIL_DRIVER")) {
+	case "smtp":
+		port := strings.TrimSpace(os.Getenv("SMTP_PORT"))
+		if port == "" {
+			port = "587"
+		}
+		return &SMTPMailer{
+			Addr:     net.JoinHostPort(strings.TrimSpace(os.Getenv("SMTP_HOST")), port),
+			From:     from,
+			Username: os.Getenv("SMTP_USER"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	case "file":
+		dir := strings.TrimSpace(os.Getenv("MAIL_DIR"))
+		if dir == "" {
+			dir = "mail"
+		}
+		return &FileMailer{Dir: dir, From: from}
+	default:
+		return LogMailer{}
+	}
+}
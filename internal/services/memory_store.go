@@ -0,0 +1,864 @@
+package services
+
+import (
+	"bytes"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"YeahMusic/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MemoryStore struct {
+	mu sync.RWMutex
+
+	users          map[primitive.ObjectID]models.User
+	sessions       map[primitive.ObjectID]models.Session
+	artists        map[primitive.ObjectID]models.Artist
+	albums         map[primitive.ObjectID]models.Album
+	tracks         map[primitive.ObjectID]models.Track
+	playlists      map[primitive.ObjectID]models.Playlist
+	playlistTracks map[primitive.ObjectID]models.PlaylistTrack
+	emailTokens    map[primitive.ObjectID]models.EmailToken
+	identities     map[primitive.ObjectID]models.Identity
+	apiKeys        map[primitive.ObjectID]models.APIKey
+
+	newID    func(coll string) primitive.ObjectID
+	onChange func()
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		users:          map[primitive.ObjectID]models.User{},
+		sessions:       map[primitive.ObjectID]models.Session{},
+		artists:        map[primitive.ObjectID]models.Artist{},
+		albums:         map[primitive.ObjectID]models.Album{},
+		tracks:         map[primitive.ObjectID]models.Track{},
+		playlists:      map[primitive.ObjectID]models.Playlist{},
+		playlistTracks: map[primitive.ObjectID]models.PlaylistTrack{},
+		emailTokens:    map[primitive.ObjectID]models.EmailToken{},
+		identities:     map[primitive.ObjectID]models.Identity{},
+		apiKeys:        map[primitive.ObjectID]models.APIKey{},
+		newID:          func(string) primitive.ObjectID { return primitive.NewObjectID() },
+	}
+}
+
+func (m *MemoryStore) Now() time.Time { return time.Now() }
+
+func (m *MemoryStore) Close() error { return nil }
+
+// changed must be called with mu held after every mutation.
+func (m *MemoryStore) changed() {
+	if m.onChange != nil {
+		m.onChange()
+	}
+}
+
+func idLess(a, b primitive.ObjectID) bool {
+	return bytes.Compare(a[:], b[:]) < 0
+}
+
+func (m *MemoryStore) CreateUser(u *models.User) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.users {
+		if existing.Email == u.Email {
+			return nil, ErrAlreadyExists
+		}
+	}
+
+	u.ID = m.newID("users")
+	u.CreatedAt = m.Now()
+	m.users[u.ID] = *u
+	m.changed()
+	return u, nil
+}
+
+func (m *MemoryStore) GetUserByEmail(email string) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, u := range m.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) GetUserByID(id primitive.ObjectID) (*models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &u, nil
+}
+
+func (m *MemoryStore) UpdateUserPassword(id primitive.ObjectID, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.PasswordHash = hash
+	m.users[id] = u
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) SetUserRole(id primitive.ObjectID, role, requestedRole string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.Role = role
+	u.RequestedRole = requestedRole
+	m.users[id] = u
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) ListRoleRequests() []*models.User {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make([]*models.User, 0)
+	for _, u := range m.users {
+		if u.RequestedRole == "" {
+			continue
+		}
+		u := u
+		res = append(res, &u)
+	}
+	sort.Slice(res, func(i, j int) bool { return idLess(res[i].ID, res[j].ID) })
+	return res
+}
+
+func (m *MemoryStore) SetEmailVerified(id primitive.ObjectID, verified bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.EmailVerified = verified
+	m.users[id] = u
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) SetTOTP(id primitive.ObjectID, secret string, enabled bool, recoveryHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	u.TOTPSecret = secret
+	u.TOTPEnabled = enabled && secret != ""
+	u.RecoveryCodes = slices.Clone(recoveryHashes)
+	if secret == "" {
+		u.RecoveryCodes, u.TOTPLastStep = nil, 0
+	}
+	m.users[id] = u
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) UseTOTPStep(id primitive.ObjectID, step int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if step <= u.TOTPLastStep {
+		return ErrAlreadyExists
+	}
+	u.TOTPLastStep = step
+	m.users[id] = u
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) UseRecoveryCode(id primitive.ObjectID, hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok || !slices.Contains(u.RecoveryCodes, hash) {
+		return ErrNotFound
+	}
+	// A new slice, since copies handed out earlier share the old one.
+	left := make([]string, 0, len(u.RecoveryCodes)-1)
+	for _, h := range u.RecoveryCodes {
+		if h != hash {
+			left = append(left, h)
+		}
+	}
+	u.RecoveryCodes = left
+	m.users[id] = u
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) CreateEmailToken(t *models.EmailToken) (*models.EmailToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[t.UserID]; !ok {
+		return nil, ErrNotFound
+	}
+	t.ID = m.newID("email_tokens")
+	t.CreatedAt = m.Now()
+	m.emailTokens[t.ID] = *t
+	m.changed()
+	return t, nil
+}
+
+func (m *MemoryStore) ConsumeEmailToken(purpose, tokenHash string, now time.Time) (*models.EmailToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, t := range m.emailTokens {
+		if t.Purpose != purpose || t.TokenHash != tokenHash {
+			continue
+		}
+		delete(m.emailTokens, id)
+		m.changed()
+		if !t.ExpiresAt.After(now) {
+			return nil, ErrNotFound
+		}
+		return &t, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) DeleteEmailTokens(userID primitive.ObjectID, purpose string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for id, t := range m.emailTokens {
+		if t.UserID == userID && t.Purpose == purpose {
+			delete(m.emailTokens, id)
+			n++
+		}
+	}
+	if n > 0 {
+		m.changed()
+	}
+	return n
+}
+
+func (m *MemoryStore) CreateIdentity(i *models.Identity) (*models.Identity, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[i.UserID]; !ok {
+		return nil, ErrNotFound
+	}
+	for _, existing := range m.identities {
+		if existing.Provider == i.Provider && existing.Subject == i.Subject {
+			return nil, ErrAlreadyExists
+		}
+	}
+	i.ID = m.newID("identities")
+	i.CreatedAt = m.Now()
+	m.identities[i.ID] = *i
+	m.changed()
+	return i, nil
+}
+
+func (m *MemoryStore) GetIdentity(provider, subject string) (*models.Identity, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, i := range m.identities {
+		if i.Provider == provider && i.Subject == subject {
+			return &i, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) ListIdentities(userID primitive.ObjectID) []*models.Identity {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make([]*models.Identity, 0)
+	for _, i := range m.identities {
+		if i.UserID != userID {
+			continue
+		}
+		i := i
+		res = append(res, &i)
+	}
+	sort.Slice(res, func(a, b int) bool { return idLess(res[a].ID, res[b].ID) })
+	return res
+}
+
+// copyAPIKey keeps callers from sharing the scopes slice and time pointers
+// with the stored key.
+func copyAPIKey(k models.APIKey) *models.APIKey {
+	k.Scopes = slices.Clone(k.Scopes)
+	if k.ExpiresAt != nil {
+		t := *k.ExpiresAt
+		k.ExpiresAt = &t
+	}
+	if k.LastUsedAt != nil {
+		t := *k.LastUsedAt
+		k.LastUsedAt = &t
+	}
+	return &k
+}
+
+func (m *MemoryStore) CreateAPIKey(k *models.APIKey) (*models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[k.UserID]; !ok {
+		return nil, ErrNotFound
+	}
+	k.ID = m.newID("api_keys")
+	k.CreatedAt = m.Now()
+	stored := copyAPIKey(*k)
+	stored.Key = ""
+	m.apiKeys[k.ID] = *stored
+	m.changed()
+	return k, nil
+}
+
+func (m *MemoryStore) GetAPIKey(keyHash string) (*models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, k := range m.apiKeys {
+		if k.KeyHash == keyHash {
+			return copyAPIKey(k), nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) ListAPIKeys(userID primitive.ObjectID) []*models.APIKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make([]*models.APIKey, 0)
+	for _, k := range m.apiKeys {
+		if k.UserID == userID {
+			res = append(res, copyAPIKey(k))
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return idLess(res[i].ID, res[j].ID) })
+	return res
+}
+
+func (m *MemoryStore) TouchAPIKey(id primitive.ObjectID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.apiKeys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	k.LastUsedAt = &at
+	m.apiKeys[id] = k
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) DeleteAPIKey(userID, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	k, ok := m.apiKeys[id]
+	if !ok || k.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.apiKeys, id)
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) CreateSession(sec *models.Session) (*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sec.ID = m.newID("sessions")
+	sec.CreatedAt = m.Now()
+	if sec.LastUsedAt.IsZero() {
+		sec.LastUsedAt = sec.CreatedAt
+	}
+	stored := *sec
+	stored.Token, stored.RefreshToken = "", ""
+	m.sessions[sec.ID] = stored
+	m.changed()
+	return sec, nil
+}
+
+func (m *MemoryStore) GetSession(tokenHash string) (*models.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, sec := range m.sessions {
+		if sec.TokenHash == tokenHash {
+			return &sec, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) FindSessionByRefresh(refreshHash string) (*models.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, sec := range m.sessions {
+		if sec.RefreshHash == refreshHash || slices.Contains(sec.PrevRefreshHashes, refreshHash) {
+			sec.PrevRefreshHashes = slices.Clone(sec.PrevRefreshHashes)
+			return &sec, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *MemoryStore) RotateSession(id primitive.ObjectID, oldRefreshHash string, next *models.Session) (*models.Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sec, ok := m.sessions[id]
+	if !ok || sec.RefreshHash != oldRefreshHash {
+		return nil, ErrNotFound
+	}
+	prev := append(slices.Clone(sec.PrevRefreshHashes), oldRefreshHash)
+	if len(prev) > maxPrevRefreshHashes {
+		prev = prev[len(prev)-maxPrevRefreshHashes:]
+	}
+	sec.PrevRefreshHashes = prev
+	sec.TokenHash = next.TokenHash
+	sec.RefreshHash = next.RefreshHash
+	sec.AccessExpiresAt = next.AccessExpiresAt
+	sec.ExpiresAt = next.ExpiresAt
+	sec.LastUsedAt = next.LastUsedAt
+	sec.UserAgent = next.UserAgent
+	sec.IP = next.IP
+	m.sessions[id] = sec
+	m.changed()
+
+	sec.PrevRefreshHashes = slices.Clone(prev)
+	return &sec, nil
+}
+
+func (m *MemoryStore) TouchSession(id primitive.ObjectID, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sec, ok := m.sessions[id]
+	if !ok {
+		return ErrNotFound
+	}
+	sec.LastUsedAt = at
+	m.sessions[id] = sec
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) ListSessions(userID primitive.ObjectID) []*models.Session {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := m.Now()
+	res := make([]*models.Session, 0)
+	for _, sec := range m.sessions {
+		if sec.UserID != userID || !sec.ExpiresAt.After(now) {
+			continue
+		}
+		sec := sec
+		sec.PrevRefreshHashes = nil
+		res = append(res, &sec)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].LastUsedAt.After(res[j].LastUsedAt) })
+	return res
+}
+
+func (m *MemoryStore) DeleteSession(userID, id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sec, ok := m.sessions[id]
+	if !ok || sec.UserID != userID {
+		return ErrNotFound
+	}
+	delete(m.sessions, id)
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) DeleteUserSessions(userID primitive.ObjectID) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for id, sec := range m.sessions {
+		if sec.UserID == userID {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+	if n > 0 {
+		m.changed()
+	}
+	return n
+}
+
+func (m *MemoryStore) CleanupExpiredSessions(now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+	for id, sec := range m.sessions {
+		if !sec.ExpiresAt.After(now) {
+			delete(m.sessions, id)
+			n++
+		}
+	}
+	if n > 0 {
+		m.changed()
+	}
+	return n
+}
+
+func (m *MemoryStore) AddArtist(a *models.Artist) (*models.Artist, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.artists {
+		if existing.Name == a.Name {
+			return &existing, nil
+		}
+	}
+
+	a.ID = m.newID("artists")
+	m.artists[a.ID] = *a
+	m.changed()
+	return a, nil
+}
+
+func (m *MemoryStore) ListArtists() []*models.Artist {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make([]*models.Artist, 0, len(m.artists))
+	for _, a := range m.artists {
+		a := a
+		res = append(res, &a)
+	}
+	sort.Slice(res, func(i, j int) bool { return idLess(res[i].ID, res[j].ID) })
+	return res
+}
+
+func (m *MemoryStore) AddAlbum(a *models.Album) (*models.Album, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a.ID = m.newID("albums")
+	m.albums[a.ID] = *a
+	m.changed()
+	return a, nil
+}
+
+func (m *MemoryStore) GetAlbum(id primitive.ObjectID) (*models.Album, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	a, ok := m.albums[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &a, nil
+}
+
+func (m *MemoryStore) ListAlbums(artistID primitive.ObjectID) []*models.Album {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make([]*models.Album, 0)
+	for _, a := range m.albums {
+		if !artistID.IsZero() && a.ArtistID != artistID {
+			continue
+		}
+		a := a
+		res = append(res, &a)
+	}
+	sort.Slice(res, func(i, j int) bool { return idLess(res[i].ID, res[j].ID) })
+	return res
+}
+
+func (m *MemoryStore) AddTrack(t *models.Track) (*models.Track, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t.ID = m.newID("tracks")
+	m.tracks[t.ID] = *t
+	m.changed()
+	return t, nil
+}
+
+func (m *MemoryStore) GetTrack(id primitive.ObjectID) (*models.Track, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.tracks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &t, nil
+}
+
+func (m *MemoryStore) UpdateTrack(id primitive.ObjectID, coverURL, lyrics string) (*models.Track, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tracks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if coverURL != "" {
+		t.CoverURL = coverURL
+	}
+	if lyrics != "" {
+		t.Lyrics = lyrics
+	}
+	m.tracks[id] = t
+	m.changed()
+	return &t, nil
+}
+
+func (m *MemoryStore) AddPlay(id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tracks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	t.Plays++
+	m.tracks[id] = t
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) SetTrackLoudness(id primitive.ObjectID, l *models.Loudness) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tracks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	t.Loudness = l
+	m.tracks[id] = t
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) SetAlbumLoudness(id primitive.ObjectID, l *models.Loudness) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a, ok := m.albums[id]
+	if !ok {
+		return ErrNotFound
+	}
+	a.Loudness = l
+	m.albums[id] = a
+	for tid, t := range m.tracks {
+		if t.AlbumID == id {
+			t.AlbumLoudness = l
+			m.tracks[tid] = t
+		}
+	}
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) SetTrackGapless(id primitive.ObjectID, g *models.Gapless) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tracks[id]
+	if !ok {
+		return ErrNotFound
+	}
+	t.Gapless = g
+	m.tracks[id] = t
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) DeleteTrack(id primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.tracks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.tracks, id)
+
+	for ptID, pt := range m.playlistTracks {
+		if pt.TrackID == id {
+			delete(m.playlistTracks, ptID)
+		}
+	}
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) ListTracks(albumID primitive.ObjectID) []*models.Track {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make([]*models.Track, 0)
+	for _, t := range m.tracks {
+		if !albumID.IsZero() && t.AlbumID != albumID {
+			continue
+		}
+		t := t
+		res = append(res, &t)
+	}
+	sort.Slice(res, func(i, j int) bool { return idLess(res[j].ID, res[i].ID) })
+	return res
+}
+
+func (m *MemoryStore) CreatePlaylist(userID primitive.ObjectID, title, coverURL string) (*models.Playlist, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := models.Playlist{
+		ID:        m.newID("playlists"),
+		UserID:    userID,
+		Title:     title,
+		CoverURL:  coverURL,
+		CreatedAt: m.Now(),
+	}
+	m.playlists[p.ID] = p
+	m.changed()
+	return &p, nil
+}
+
+func (m *MemoryStore) GetPlaylist(id primitive.ObjectID) (*models.Playlist, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, ok := m.playlists[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &p, nil
+}
+
+func (m *MemoryStore) MediaRefs() (map[string]int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	refs := map[string]int{}
+	add := func(urls ...string) {
+		for _, u := range urls {
+			if u != "" {
+				refs[u]++
+			}
+		}
+	}
+	for _, t := range m.tracks {
+		add(t.AudioURL, t.CoverURL)
+	}
+	for _, a := range m.albums {
+		add(a.CoverURL)
+	}
+	for _, p := range m.playlists {
+		add(p.CoverURL)
+	}
+	return refs, nil
+}
+
+func (m *MemoryStore) ListPlaylists(userID primitive.ObjectID) []*models.Playlist {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res := make([]*models.Playlist, 0)
+	for _, p := range m.playlists {
+		if p.UserID != userID {
+			continue
+		}
+		p := p
+		res = append(res, &p)
+	}
+	sort.Slice(res, func(i, j int) bool { return idLess(res[i].ID, res[j].ID) })
+	return res
+}
+
+func (m *MemoryStore) ownedPlaylist(userID, playlistID primitive.ObjectID) error {
+	p, ok := m.playlists[playlistID]
+	if !ok {
+		return ErrNotFound
+	}
+	if p.UserID != userID {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func (m *MemoryStore) AddTrackToPlaylist(userID, playlistID, trackID primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.ownedPlaylist(userID, playlistID); err != nil {
+		return err
+	}
+	if _, ok := m.tracks[trackID]; !ok {
+		return ErrNotFound
+	}
+
+	pt := models.PlaylistTrack{
+		ID:         m.newID("playlist_tracks"),
+		PlaylistID: playlistID,
+		TrackID:    trackID,
+		AddedAt:    m.Now(),
+	}
+	m.playlistTracks[pt.ID] = pt
+	m.changed()
+	return nil
+}
+
+func (m *MemoryStore) RemoveTrackFromPlaylist(userID, playlistID, trackID primitive.ObjectID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.ownedPlaylist(userID, playlistID); err != nil {
+		return err
+	}
+
+	for id, pt := range m.playlistTracks {
+		if pt.PlaylistID == playlistID && pt.TrackID == trackID {
+			delete(m.playlistTracks, id)
+			m.changed()
+			return nil
+		}
+	}
+	return ErrNotFound
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"YeahMusic/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSeedCatalog(t *testing.T) {
+	store := NewMemoryStore()
+	for range 2 {
+		if err := Seed(store); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := NewCatalogService(store)
+	if n := len(c.ListArtists()); n != 2 {
+		t.Errorf("%d artists after seeding twice, want 2", n)
+	}
+	tracks := c.ListTracks(primitive.NilObjectID)
+	if len(tracks) != 3 {
+		t.Fatalf("%d tracks after seeding twice, want 3", len(tracks))
+	}
+
+	a := c.ListArtists()[0]
+	al, err := c.AlbumByTitle(a.ID, "  "+c.ListAlbums(a.ID)[0].Title+" ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.AlbumByTitle(a.ID, "No such album"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown album: %v, want ErrNotFound", err)
+	}
+	if _, err := c.AlbumByTitle(primitive.NewObjectID(), al.Title); !errors.Is(err, ErrNotFound) {
+		t.Errorf("album of another artist: %v, want ErrNotFound", err)
+	}
+
+	tr := tracks[0]
+	if _, err := c.UpdateTrack(tr.ID, "/uploads/cover.jpg", "new lyrics"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.GetTrack(tr.ID)
+	if err != nil || got.CoverURL != "/uploads/cover.jpg" || got.Lyrics != "new lyrics" {
+		t.Errorf("updated track %+v, %v", got, err)
+	}
+	refs, err := store.MediaRefs()
+	if err != nil || refs["/uploads/cover.jpg"] != 1 || refs["/audio/1.mp3"] != 1 {
+		t.Errorf("media refs %v, %v", refs, err)
+	}
+
+	if err := c.DeleteTrack(tr.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetTrack(tr.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted track: %v, want ErrNotFound", err)
+	}
+	if err := c.DeleteTrack(tr.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleting twice: %v, want ErrNotFound", err)
+	}
+}
+
+func TestPlaylists(t *testing.T) {
+	store := NewMemoryStore()
+	if err := Seed(store); err != nil {
+		t.Fatal(err)
+	}
+	tracks := store.ListTracks(primitive.NilObjectID)
+	p := NewPlaylistService(store)
+	alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+
+	pl, err := p.Create(alice, "Mix", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l := p.List(alice); len(l) != 1 || l[0].ID != pl.ID {
+		t.Errorf("playlists of the owner %v", l)
+	}
+	if l := p.List(bob); len(l) != 0 {
+		t.Errorf("playlists of someone else %v", l)
+	}
+
+	tests := []struct {
+		name     string
+		fn       func(user, playlist, track primitive.ObjectID) error
+		user     primitive.ObjectID
+		playlist primitive.ObjectID
+		track    *models.Track
+		want     error
+	}{
+		{"add", p.AddTrack, alice, pl.ID, tracks[0], nil},
+		{"add another", p.AddTrack, alice, pl.ID, tracks[1], nil},
+		{"add to someone else's", p.AddTrack, bob, pl.ID, tracks[2], ErrForbidden},
+		{"add to none", p.AddTrack, alice, primitive.NewObjectID(), tracks[2], ErrNotFound},
+		{"add a missing track", p.AddTrack, alice, pl.ID, &models.Track{ID: primitive.NewObjectID()}, ErrNotFound},
+		{"remove from someone else's", p.RemoveTrack, bob, pl.ID, tracks[0], ErrForbidden},
+		{"remove", p.RemoveTrack, alice, pl.ID, tracks[0], nil},
+		{"remove again", p.RemoveTrack, alice, pl.ID, tracks[0], ErrNotFound},
+	}
+	for _, tc := range tests {
+		if err := tc.fn(tc.user, tc.playlist, tc.track.ID); !errors.Is(err, tc.want) {
+			t.Errorf("%s: %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	// Deleting a track takes it out of the playlists it is on.
+	if err := store.DeleteTrack(tracks[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RemoveTrack(alice, pl.ID, tracks[1].ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("removing a deleted track: %v, want ErrNotFound", err)
+	}
+}
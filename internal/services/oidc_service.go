@@ -0,0 +1,136 @@
+package services
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"YeahMusic/internal/models"
+	"YeahMusic/internal/oidc"
+)
+
+// OIDCProvidersFromEnv reads OIDC_PROVIDERS=google,gitlab and, for each
+// name, OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET, _REDIRECT_URL and
+// _SCOPES (space separated). The redirect URL defaults to
+// APP_URL/api/oidc/<name>/callback. Entries without an issuer or client id
+// are skipped.
+func OIDCProvidersFromEnv() []*oidc.Provider {
+	base := strings.TrimRight(strings.TrimSpace(os.Getenv("APP_URL")), "/")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	var res []*oidc.Provider
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		env := func(k string) string {
+			return strings.TrimSpace(os.Getenv("OIDC_" + strings.ToUpper(name) + "_" + k))
+		}
+		cfg := oidc.Config{
+			Name:         name,
+			Issuer:       env("ISSUER"),
+			ClientID:     env("CLIENT_ID"),
+			ClientSecret: env("CLIENT_SECRET"),
+			RedirectURL:  env("REDIRECT_URL"),
+			Scopes:       strings.Fields(env("SCOPES")),
+		}
+		if cfg.Issuer == "" || cfg.ClientID == "" {
+			continue
+		}
+		if cfg.RedirectURL == "" {
+			cfg.RedirectURL = base + "/api/oidc/" + name + "/callback"
+		}
+		res = append(res, oidc.NewProvider(cfg, nil))
+	}
+	return res
+}
+
+// OIDCService turns a verified ID token into a YeahMusic session. A user is
+// found by the linked (provider, subject) pair; failing that, an existing
+// account with the same address is linked only when the provider vouches
+// for the address, and otherwise a new password-less user is created.
+type OIDCService struct {
+	store     Repository
+	auth      *AuthService
+	providers map[string]*oidc.Provider
+}
+
+func NewOIDCService(store Repository, auth *AuthService, providers ...*oidc.Provider) *OIDCService {
+	s := &OIDCService{store: store, auth: auth, providers: map[string]*oidc.Provider{}}
+	for _, p := range providers {
+		s.providers[p.Name()] = p
+	}
+	return s
+}
+
+func (s *OIDCService) Provider(name string) (*oidc.Provider, error) {
+	p, ok := s.providers[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return p, nil
+}
+
+func (s *OIDCService) Providers() []string {
+	res := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// SignIn returns ErrAlreadyExists when the address belongs to an account
+// that the provider cannot prove ownership of; the user has to sign in
+// with the password instead. Accounts with two-factor authentication get an
+// *MFAChallenge like Login.
+func (s *OIDCService) SignIn(provider string, c *oidc.Claims, client ClientInfo) (*models.Session, *models.User, error) {
+	if c == nil || c.Subject == "" {
+		return nil, nil, ErrUnauthorized
+	}
+	email := normalizeEmail(c.Email)
+
+	var u *models.User
+	if id, err := s.store.GetIdentity(provider, c.Subject); err == nil {
+		if u, err = s.store.GetUserByID(id.UserID); err != nil {
+			return nil, nil, ErrUnauthorized
+		}
+	} else {
+		if email == "" {
+			return nil, nil, ErrUnauthorized
+		}
+		u, err = s.store.GetUserByEmail(email)
+		switch {
+		case err == nil && !c.EmailVerified:
+			return nil, nil, ErrAlreadyExists
+		case err == nil:
+			if !u.EmailVerified {
+				if err := s.store.SetEmailVerified(u.ID, true); err != nil {
+					return nil, nil, err
+				}
+				u.EmailVerified = true
+			}
+		default:
+			name := strings.TrimSpace(c.Name)
+			if name == "" {
+				name = strings.SplitN(email, "@", 2)[0]
+			}
+			u, err = s.store.CreateUser(&models.User{
+				Email: email, Name: name, Role: RoleUser, EmailVerified: c.EmailVerified,
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		_, err = s.store.CreateIdentity(&models.Identity{
+			UserID: u.ID, Provider: provider, Subject: c.Subject, Email: email,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return s.auth.FinishLogin(u, client)
+}
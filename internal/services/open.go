@@ -0,0 +1,101 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"YeahMusic/internal/ratelimit"
+)
+
+type StoreConfig struct {
+	Backend       string
+	MongoURI      string
+	FilePath      string
+	SnapshotEvery time.Duration
+	SQLitePath    string
+	AutoMigrate   bool
+}
+
+// StoreConfigFromEnv reads STORE_BACKEND (mongo, memory, file or sqlite)
+// and the settings of each backend.
+func StoreConfigFromEnv() StoreConfig {
+	cfg := StoreConfig{
+		Backend:     strings.TrimSpace(os.Getenv("STORE_BACKEND")),
+		MongoURI:    strings.TrimSpace(os.Getenv("MONGODB_URI")),
+		FilePath:    strings.TrimSpace(os.Getenv("STORE_FILE")),
+		SQLitePath:  strings.TrimSpace(os.Getenv("STORE_SQLITE_PATH")),
+		AutoMigrate: strings.TrimSpace(os.Getenv("MIGRATE_ON_START")) != "false",
+	}
+	if cfg.Backend == "" {
+		cfg.Backend = "mongo"
+	}
+	if cfg.MongoURI == "" {
+		cfg.MongoURI = "mongodb://localhost:27017"
+	}
+	if cfg.FilePath == "" {
+		cfg.FilePath = "storage.json"
+	}
+	if cfg.SQLitePath == "" {
+		cfg.SQLitePath = "yeahmusic.db"
+	}
+	if d, err := time.ParseDuration(os.Getenv("STORE_SNAPSHOT_EVERY")); err == nil {
+		cfg.SnapshotEvery = d
+	}
+	return cfg
+}
+
+func OpenRepository(cfg StoreConfig) (Repository, error) {
+	switch cfg.Backend {
+	case "", "mongo":
+		s, err := NewStore(cfg.MongoURI)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.PrepareSchema(cfg.AutoMigrate); err != nil {
+			s.Close()
+			return nil, err
+		}
+		return s, nil
+	case "memory":
+		return NewMemoryStore(), nil
+	case "file":
+		return NewFileStore(cfg.FilePath, cfg.SnapshotEvery)
+	case "sqlite":
+		return NewSQLiteStore(cfg.SQLitePath)
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", cfg.Backend)
+	}
+}
+
+// RateLimitStoreFromEnv picks where rate limits and login lockouts are kept:
+// RATE_LIMIT_STORE=memory (the default, per instance) or mongo, which shares
+// them between instances. With mongo the "rate_limits" collection of the
+// app's database is used, or of MONGODB_URI when the app runs on another
+// backend.
+func RateLimitStoreFromEnv(repo Repository) (ratelimit.Store, error) {
+	switch strings.TrimSpace(os.Getenv("RATE_LIMIT_STORE")) {
+	case "", "memory":
+		return ratelimit.NewMemoryStore(), nil
+	case "mongo":
+		if s, ok := repo.(*Store); ok {
+			return ratelimit.NewMongoStore(s.db.Collection("rate_limits")), nil
+		}
+		s, err := NewStore(StoreConfigFromEnv().MongoURI)
+		if err != nil {
+			return nil, err
+		}
+		ms := ratelimit.NewMongoStore(s.db.Collection("rate_limits"))
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := ms.EnsureIndexes(ctx); err != nil {
+			s.Close()
+			return nil, err
+		}
+		return ms, nil
+	default:
+		return nil, fmt.Errorf("unknown rate limit store %q", os.Getenv("RATE_LIMIT_STORE"))
+	}
+}
@@ -0,0 +1,148 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher produces self-describing hashes: the stored string carries
+// the algorithm and its parameters, so the configuration can change without
+// breaking existing accounts.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches hash and whether the hash
+	// should be replaced because it uses an outdated algorithm or parameters.
+	Verify(hash, password string) (ok, rehash bool)
+}
+
+type Argon2Params struct {
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+var DefaultArgon2Params = Argon2Params{Memory: 64 * 1024, Time: 3, Threads: 2, SaltLen: 16, KeyLen: 32}
+
+type passwordHasher struct {
+	algo       string
+	argon      Argon2Params
+	bcryptCost int
+}
+
+func NewArgon2idHasher(p Argon2Params) PasswordHasher {
+	return &passwordHasher{algo: "argon2id", argon: p, bcryptCost: bcrypt.DefaultCost}
+}
+
+func NewBcryptHasher(cost int) PasswordHasher {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		cost = bcrypt.DefaultCost
+	}
+	return &passwordHasher{algo: "bcrypt", argon: DefaultArgon2Params, bcryptCost: cost}
+}
+
+// PasswordHasherFromEnv reads PASSWORD_HASH ("argon2id" or "bcrypt"),
+// BCRYPT_COST and ARGON2_MEMORY_KB / ARGON2_TIME / ARGON2_THREADS.
+func PasswordHasherFromEnv() PasswordHasher {
+	if strings.TrimSpace(os.Getenv("PASSWORD_HASH")) == "bcrypt" {
+		cost, _ := strconv.Atoi(os.Getenv("BCRYPT_COST"))
+		return NewBcryptHasher(cost)
+	}
+	p := DefaultArgon2Params
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_MEMORY_KB"), 10, 32); err == nil && v > 0 {
+		p.Memory = uint32(v)
+	}
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_TIME"), 10, 32); err == nil && v > 0 {
+		p.Time = uint32(v)
+	}
+	if v, err := strconv.ParseUint(os.Getenv("ARGON2_THREADS"), 10, 8); err == nil && v > 0 {
+		p.Threads = uint8(v)
+	}
+	return NewArgon2idHasher(p)
+}
+
+func (h *passwordHasher) Hash(password string) (string, error) {
+	if h.algo == "bcrypt" {
+		b, err := bcrypt.GenerateFromPassword([]byte(password), h.bcryptCost)
+		return string(b), err
+	}
+
+	p := h.argon
+	salt := make([]byte, p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	b64 := base64.RawStdEncoding
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Time, p.Threads, b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+func (h *passwordHasher) Verify(hash, password string) (bool, bool) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		p, salt, key, err := parseArgon2id(hash)
+		if err != nil {
+			return false, false
+		}
+		got := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, uint32(len(key)))
+		if subtle.ConstantTimeCompare(got, key) != 1 {
+			return false, false
+		}
+		return true, h.algo != "argon2id" || p.Memory != h.argon.Memory || p.Time != h.argon.Time || p.Threads != h.argon.Threads
+
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return false, false
+		}
+		cost, _ := bcrypt.Cost([]byte(hash))
+		return true, h.algo != "bcrypt" || cost != h.bcryptCost
+
+	case len(hash) == sha256.Size*2:
+		// Unsalted sha256 hex written by the first version of AuthService.
+		sum := sha256.Sum256([]byte(password))
+		want, err := hex.DecodeString(hash)
+		if err != nil || subtle.ConstantTimeCompare(sum[:], want) != 1 {
+			return false, false
+		}
+		return true, true
+	}
+	return false, false
+}
+
+func parseArgon2id(hash string) (Argon2Params, []byte, []byte, error) {
+	var p Argon2Params
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, errors.New("malformed argon2id hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return p, nil, nil, errors.New("unsupported argon2 version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, err
+	}
+	b64 := base64.RawStdEncoding
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, err
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return p, nil, nil, errors.New("malformed argon2id key")
+	}
+	return p, salt, key, nil
+}
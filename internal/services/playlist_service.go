@@ -6,14 +6,14 @@ import (
 )
 
 type PlaylistService struct {
-	store *Store
+	store Repository
 }
 
-func NewPlaylistService(store *Store) *PlaylistService {
+func NewPlaylistService(store Repository) *PlaylistService {
 	return &PlaylistService{store: store}
 }
 
-func (p *PlaylistService) Create(userID primitive.ObjectID, title, coverURL string) *models.Playlist {
+func (p *PlaylistService) Create(userID primitive.ObjectID, title, coverURL string) (*models.Playlist, error) {
 	return p.store.CreatePlaylist(userID, title, coverURL)
 }
 
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"slices"
+
+	"YeahMusic/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	RoleUser   = "user"
+	RoleArtist = "artist"
+	RoleAdmin  = "admin"
+)
+
+type Permission string
+
+const (
+	PermReadPlaylists   Permission = "playlists:read"
+	PermManagePlaylists Permission = "playlists:manage"
+	PermUploadTrack     Permission = "tracks:upload"
+	PermEditTrack       Permission = "tracks:edit"
+	PermDeleteTrack     Permission = "tracks:delete"
+	PermCreateAlbum     Permission = "albums:create"
+	PermEditAlbum       Permission = "albums:edit"
+	PermAdmin           Permission = "admin"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleUser: {PermReadPlaylists, PermManagePlaylists},
+	RoleArtist: {PermReadPlaylists, PermManagePlaylists, PermUploadTrack, PermEditTrack, PermDeleteTrack,
+		PermCreateAlbum, PermEditAlbum},
+	RoleAdmin: {PermReadPlaylists, PermManagePlaylists, PermUploadTrack, PermEditTrack, PermDeleteTrack,
+		PermCreateAlbum, PermEditAlbum, PermAdmin},
+}
+
+// Publishing needs a confirmed address on top of the role.
+var verifiedOnly = []Permission{PermUploadTrack, PermCreateAlbum}
+
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+func Can(u *models.User, perm Permission) bool {
+	return u != nil && slices.Contains(rolePermissions[u.Role], perm)
+}
+
+// Policy adds ownership on top of role permissions: acting on a single
+// track, album or playlist also requires owning it, unless the caller is an
+// admin. Errors are ErrUnauthorized, ErrForbidden, ErrUnverified,
+// ErrMFASetupRequired or ErrNotFound.
+type Policy struct {
+	store    Repository
+	mfaRoles []string
+}
+
+// NewPolicy reads MFA_REQUIRED_ROLES (default "admin"): users with these
+// roles keep only the permissions of a plain user until they turn on
+// two-factor authentication. Set it to "" to require it from nobody.
+func NewPolicy(store Repository) *Policy {
+	return &Policy{store: store, mfaRoles: mfaRolesFromEnv()}
+}
+
+func (p *Policy) MFARequired(u *models.User) bool {
+	return u != nil && slices.Contains(p.mfaRoles, u.Role)
+}
+
+func (p *Policy) Require(u *models.User, perm Permission) error {
+	if u == nil {
+		return ErrUnauthorized
+	}
+	if !Can(u, perm) {
+		return ErrForbidden
+	}
+	if !u.EmailVerified && slices.Contains(verifiedOnly, perm) {
+		return ErrUnverified
+	}
+	if !u.TOTPEnabled && p.MFARequired(u) && !slices.Contains(rolePermissions[RoleUser], perm) {
+		return ErrMFASetupRequired
+	}
+	return nil
+}
+
+func (p *Policy) owns(u *models.User, owner primitive.ObjectID) error {
+	if u.Role == RoleAdmin || (!owner.IsZero() && owner == u.ID) {
+		return nil
+	}
+	return ErrForbidden
+}
+
+func (p *Policy) Track(u *models.User, perm Permission, id primitive.ObjectID) (*models.Track, error) {
+	if err := p.Require(u, perm); err != nil {
+		return nil, err
+	}
+	t, err := p.store.GetTrack(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.owns(u, t.ArtistID); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (p *Policy) Album(u *models.User, perm Permission, id primitive.ObjectID) (*models.Album, error) {
+	if err := p.Require(u, perm); err != nil {
+		return nil, err
+	}
+	a, err := p.store.GetAlbum(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.owns(u, a.ArtistID); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (p *Policy) Playlist(u *models.User, perm Permission, id primitive.ObjectID) (*models.Playlist, error) {
+	if err := p.Require(u, perm); err != nil {
+		return nil, err
+	}
+	pl, err := p.store.GetPlaylist(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.owns(u, pl.UserID); err != nil {
+		return nil, err
+	}
+	return pl, nil
+}
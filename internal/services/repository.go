@@ -0,0 +1,102 @@
+package services
+
+import (
+	"time"
+
+	"YeahMusic/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Repository interface {
+	Now() time.Time
+	Close() error
+
+	CreateUser(u *models.User) (*models.User, error)
+	GetUserByEmail(email string) (*models.User, error)
+	GetUserByID(id primitive.ObjectID) (*models.User, error)
+	UpdateUserPassword(id primitive.ObjectID, hash string) error
+	// SetUserRole sets both the role and the pending requested role.
+	SetUserRole(id primitive.ObjectID, role, requestedRole string) error
+	ListRoleRequests() []*models.User
+	SetEmailVerified(id primitive.ObjectID, verified bool) error
+
+	// SetTOTP stores a pending or an active secret with its recovery code
+	// hashes; an empty secret turns two-factor authentication off.
+	SetTOTP(id primitive.ObjectID, secret string, enabled bool, recoveryHashes []string) error
+	// UseTOTPStep records the time step of an accepted code. It returns
+	// ErrAlreadyExists when that step or a later one was used before, so a
+	// code cannot be replayed.
+	UseTOTPStep(id primitive.ObjectID, step int64) error
+	// UseRecoveryCode removes a recovery code hash, or returns ErrNotFound.
+	UseRecoveryCode(id primitive.ObjectID, hash string) error
+
+	CreateEmailToken(t *models.EmailToken) (*models.EmailToken, error)
+	// ConsumeEmailToken deletes the token and returns it. Unknown and expired
+	// tokens give ErrNotFound, so a token works at most once.
+	ConsumeEmailToken(purpose, tokenHash string, now time.Time) (*models.EmailToken, error)
+	DeleteEmailTokens(userID primitive.ObjectID, purpose string) int
+
+	// CreateIdentity returns ErrAlreadyExists when provider and subject are
+	// already linked.
+	CreateIdentity(i *models.Identity) (*models.Identity, error)
+	GetIdentity(provider, subject string) (*models.Identity, error)
+	ListIdentities(userID primitive.ObjectID) []*models.Identity
+
+	CreateAPIKey(k *models.APIKey) (*models.APIKey, error)
+	GetAPIKey(keyHash string) (*models.APIKey, error)
+	ListAPIKeys(userID primitive.ObjectID) []*models.APIKey
+	TouchAPIKey(id primitive.ObjectID, at time.Time) error
+	DeleteAPIKey(userID, id primitive.ObjectID) error
+
+	CreateSession(sec *models.Session) (*models.Session, error)
+	GetSession(tokenHash string) (*models.Session, error)
+	// FindSessionByRefresh matches the current refresh hash as well as the
+	// ones already rotated away, so callers can detect refresh token reuse.
+	FindSessionByRefresh(refreshHash string) (*models.Session, error)
+	// RotateSession replaces the tokens of a session only if its refresh hash
+	// is still oldRefreshHash, and returns ErrNotFound otherwise.
+	RotateSession(id primitive.ObjectID, oldRefreshHash string, next *models.Session) (*models.Session, error)
+	TouchSession(id primitive.ObjectID, at time.Time) error
+	ListSessions(userID primitive.ObjectID) []*models.Session
+	DeleteSession(userID, id primitive.ObjectID) error
+	DeleteUserSessions(userID primitive.ObjectID) int
+	CleanupExpiredSessions(now time.Time) int
+
+	AddArtist(a *models.Artist) (*models.Artist, error)
+	ListArtists() []*models.Artist
+
+	AddAlbum(a *models.Album) (*models.Album, error)
+	GetAlbum(id primitive.ObjectID) (*models.Album, error)
+	ListAlbums(artistID primitive.ObjectID) []*models.Album
+
+	AddTrack(t *models.Track) (*models.Track, error)
+	GetTrack(id primitive.ObjectID) (*models.Track, error)
+	UpdateTrack(id primitive.ObjectID, coverURL, lyrics string) (*models.Track, error)
+	DeleteTrack(id primitive.ObjectID) error
+	// AddPlay counts one more play of the track.
+	AddPlay(id primitive.ObjectID) error
+	ListTracks(albumID primitive.ObjectID) []*models.Track
+	// SetTrackLoudness stores the measured loudness of a track, nil for
+	// none.
+	SetTrackLoudness(id primitive.ObjectID, l *models.Loudness) error
+	// SetAlbumLoudness stores the loudness of an album and copies it to the
+	// tracks of the album as their AlbumLoudness.
+	SetAlbumLoudness(id primitive.ObjectID, l *models.Loudness) error
+	// SetTrackGapless stores the gapless info of a track, nil for none.
+	SetTrackGapless(id primitive.ObjectID, g *models.Gapless) error
+
+	CreatePlaylist(userID primitive.ObjectID, title, coverURL string) (*models.Playlist, error)
+	GetPlaylist(id primitive.ObjectID) (*models.Playlist, error)
+	ListPlaylists(userID primitive.ObjectID) []*models.Playlist
+	AddTrackToPlaylist(userID, playlistID, trackID primitive.ObjectID) error
+	RemoveTrackFromPlaylist(userID, playlistID, trackID primitive.ObjectID) error
+
+	// MediaRefs counts how often every audio and cover URL is used by
+	// tracks, albums and playlists.
+	MediaRefs() (map[string]int, error)
+}
+
+var _ Repository = (*Store)(nil)
+var _ Repository = (*MemoryStore)(nil)
+var _ Repository = (*FileStore)(nil)
+var _ Repository = (*SQLiteStore)(nil)
@@ -6,23 +6,42 @@ import (
 	"time"
 )
 
-func Seed(store *Store) {
+func Seed(store Repository) error {
 	if len(store.ListTracks(primitive.NilObjectID)) > 0 {
-		return
+		return nil
 	}
 
-	a1 := store.AddArtist(&models.Artist{Name: "OG Buda", Bio: "Demo artist"})
-	a2 := store.AddArtist(&models.Artist{Name: "Ernar Beats", Bio: "Demo artist"})
+	a1, err := store.AddArtist(&models.Artist{Name: "OG Buda", Bio: "Demo artist"})
+	if err != nil {
+		return err
+	}
+	a2, err := store.AddArtist(&models.Artist{Name: "Ernar Beats", Bio: "Demo artist"})
+	if err != nil {
+		return err
+	}
 
-	al1 := store.AddAlbum(&models.Album{ArtistID: a1.ID, Title: "Скучаю но Работаю", CoverURL: "https://via.placeholder.com/300", ReleaseYear: 2023})
-	al2 := store.AddAlbum(&models.Album{ArtistID: a2.ID, Title: "Скучаю но Ещё Работаю", CoverURL: "https://via.placeholder.com/300", ReleaseYear: 2025})
+	al1, err := store.AddAlbum(&models.Album{ArtistID: a1.ID, Title: "Скучаю но Работаю", CoverURL: "https://via.placeholder.com/300", ReleaseYear: 2023})
+	if err != nil {
+		return err
+	}
+	al2, err := store.AddAlbum(&models.Album{ArtistID: a2.ID, Title: "Скучаю но Ещё Работаю", CoverURL: "https://via.placeholder.com/300", ReleaseYear: 2025})
+	if err != nil {
+		return err
+	}
 
-	store.AddTrack(&models.Track{AlbumID: al1.ID, Title: "Ссора Я", ArtistName: "OG Buda", DurationSec: 180, AudioURL: "/audio/1.mp3", Lyrics: "Lyrics...", CoverURL: "https://via.placeholder.com/300"})
-	store.AddTrack(&models.Track{AlbumID: al1.ID, Title: "Вода", ArtistName: "OG Buda", DurationSec: 210, AudioURL: "/audio/2.mp3", Lyrics: "Lyrics...", CoverURL: "https://via.placeholder.com/300"})
-	store.AddTrack(&models.Track{AlbumID: al2.ID, Title: "Всё Норм", ArtistName: "Ernar Beats", DurationSec: 200, AudioURL: "/audio/3.mp3", Lyrics: "", CoverURL: "https://via.placeholder.com/300"})
+	for _, t := range []*models.Track{
+		{AlbumID: al1.ID, Title: "Ссора Я", ArtistName: "OG Buda", DurationSec: 180, AudioURL: "/audio/1.mp3", Lyrics: "Lyrics...", CoverURL: "https://via.placeholder.com/300"},
+		{AlbumID: al1.ID, Title: "Вода", ArtistName: "OG Buda", DurationSec: 210, AudioURL: "/audio/2.mp3", Lyrics: "Lyrics...", CoverURL: "https://via.placeholder.com/300"},
+		{AlbumID: al2.ID, Title: "Всё Норм", ArtistName: "Ernar Beats", DurationSec: 200, AudioURL: "/audio/3.mp3", Lyrics: "", CoverURL: "https://via.placeholder.com/300"},
+	} {
+		if _, err := store.AddTrack(t); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func SessionCleanupWorker(store *Store, every time.Duration, stop <-chan struct{}) {
+func SessionCleanupWorker(store Repository, every time.Duration, stop <-chan struct{}) {
 	t := time.NewTicker(every)
 	defer t.Stop()
 	for {
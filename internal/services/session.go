@@ -0,0 +1,134 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"time"
+
+	"YeahMusic/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// How many rotated refresh hashes a session remembers for reuse detection.
+const maxPrevRefreshHashes = 20
+
+// Sessions are not touched on every request; last-used is only informational.
+const touchEvery = time.Minute
+
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// hashToken is what the stores keep instead of bearer and refresh tokens.
+// The tokens are 256-bit random values, so a plain digest is enough.
+func hashToken(tok string) string {
+	sum := sha256.Sum256([]byte(tok))
+	return hex.EncodeToString(sum[:])
+}
+
+func (a *AuthService) fillTokens(sec *models.Session, client ClientInfo) {
+	now := a.store.Now()
+	sec.Token = genToken(32)
+	sec.RefreshToken = genToken(32)
+	sec.TokenHash = hashToken(sec.Token)
+	sec.RefreshHash = hashToken(sec.RefreshToken)
+	sec.AccessExpiresAt = now.Add(a.accessTTL)
+	sec.ExpiresAt = now.Add(a.sessionTTL)
+	sec.LastUsedAt = now
+	sec.UserAgent = client.UserAgent
+	sec.IP = client.IP
+}
+
+// StartSession signs in a user whose credentials were already checked.
+func (a *AuthService) StartSession(userID primitive.ObjectID, client ClientInfo) (*models.Session, error) {
+	sec := &models.Session{UserID: userID}
+	a.fillTokens(sec, client)
+	return a.store.CreateSession(sec)
+}
+
+// Authenticate resolves a bearer access token to its session and user.
+func (a *AuthService) Authenticate(token string) (*models.Session, *models.User, error) {
+	sec, err := a.store.GetSession(hashToken(token))
+	if err != nil {
+		return nil, nil, ErrUnauthorized
+	}
+	now := a.store.Now()
+	if !sec.AccessExpiresAt.After(now) || !sec.ExpiresAt.After(now) {
+		return nil, nil, ErrUnauthorized
+	}
+	u, err := a.store.GetUserByID(sec.UserID)
+	if err != nil {
+		return nil, nil, ErrUnauthorized
+	}
+	if now.Sub(sec.LastUsedAt) > touchEvery {
+		if err := a.store.TouchSession(sec.ID, now); err == nil {
+			sec.LastUsedAt = now
+		}
+	}
+	return sec, u, nil
+}
+
+// Refresh exchanges a refresh token for a new access/refresh pair. Presenting
+// a refresh token that was already rotated away means it leaked, so the whole
+// session is revoked.
+func (a *AuthService) Refresh(refreshToken string, client ClientInfo) (*models.Session, error) {
+	h := hashToken(refreshToken)
+	sec, err := a.store.FindSessionByRefresh(h)
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	if sec.RefreshHash != h {
+		log.Printf("refresh token reuse on session %s of user %s, revoking", sec.ID.Hex(), sec.UserID.Hex())
+		_ = a.store.DeleteSession(sec.UserID, sec.ID)
+		return nil, ErrUnauthorized
+	}
+	if !sec.ExpiresAt.After(a.store.Now()) {
+		return nil, ErrUnauthorized
+	}
+
+	next := &models.Session{}
+	a.fillTokens(next, client)
+	rotated, err := a.store.RotateSession(sec.ID, h, next)
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	rotated.Token = next.Token
+	rotated.RefreshToken = next.RefreshToken
+	return rotated, nil
+}
+
+func (a *AuthService) Logout(sec *models.Session) error {
+	return a.store.DeleteSession(sec.UserID, sec.ID)
+}
+
+func (a *AuthService) LogoutAll(userID primitive.ObjectID) int {
+	return a.store.DeleteUserSessions(userID)
+}
+
+func (a *AuthService) ListSessions(userID primitive.ObjectID) []*models.Session {
+	return a.store.ListSessions(userID)
+}
+
+func (a *AuthService) RevokeSession(userID, sessionID primitive.ObjectID) error {
+	return a.store.DeleteSession(userID, sessionID)
+}
+
+// Active reports whether the user still exists and, unless sessionID is
+// zero, whether that session of theirs is still valid.
+func (a *AuthService) Active(userID, sessionID primitive.ObjectID) bool {
+	if _, err := a.store.GetUserByID(userID); err != nil {
+		return false
+	}
+	if sessionID.IsZero() {
+		return true
+	}
+	now := a.store.Now()
+	for _, sec := range a.store.ListSessions(userID) {
+		if sec.ID == sessionID && now.Before(sec.ExpiresAt) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,1283 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"YeahMusic/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	_ "modernc.org/sqlite"
+)
+
+// sqliteSchema is applied in order; PRAGMA user_version records how many
+// steps a database file has already seen. Only ever append to it.
+var sqliteSchema = []string{
+	`CREATE TABLE users (
+		id            TEXT PRIMARY KEY,
+		email         TEXT NOT NULL UNIQUE,
+		password_hash TEXT NOT NULL DEFAULT '',
+		name          TEXT NOT NULL DEFAULT '',
+		bio           TEXT NOT NULL DEFAULT '',
+		role          TEXT NOT NULL DEFAULT 'user',
+		created_at    TEXT NOT NULL
+	)`,
+	`CREATE TABLE sessions (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		token      TEXT NOT NULL UNIQUE,
+		expires_at TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	)`,
+	`CREATE INDEX sessions_expires_at ON sessions(expires_at)`,
+	`CREATE TABLE artists (
+		id         TEXT PRIMARY KEY,
+		name       TEXT NOT NULL,
+		bio        TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE TABLE albums (
+		id           TEXT PRIMARY KEY,
+		artist_id    TEXT,
+		title        TEXT NOT NULL,
+		cover_url    TEXT NOT NULL DEFAULT '',
+		release_year INTEGER NOT NULL DEFAULT 0,
+		created_at   TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX albums_artist_id ON albums(artist_id)`,
+	`CREATE TABLE tracks (
+		id           TEXT PRIMARY KEY,
+		album_id     TEXT REFERENCES albums(id) ON DELETE SET NULL,
+		title        TEXT NOT NULL,
+		artist_id    TEXT,
+		artist_name  TEXT NOT NULL DEFAULT '',
+		duration_sec INTEGER NOT NULL DEFAULT 0,
+		audio_url    TEXT NOT NULL DEFAULT '',
+		cover_url    TEXT NOT NULL DEFAULT '',
+		lyrics       TEXT NOT NULL DEFAULT '',
+		created_at   TEXT NOT NULL DEFAULT ''
+	)`,
+	`CREATE INDEX tracks_album_id ON tracks(album_id)`,
+	`CREATE TABLE playlists (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		title      TEXT NOT NULL,
+		cover_url  TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL
+	)`,
+	`CREATE INDEX playlists_user_id ON playlists(user_id)`,
+	`CREATE TABLE playlist_tracks (
+		id          TEXT PRIMARY KEY,
+		playlist_id TEXT NOT NULL REFERENCES playlists(id) ON DELETE CASCADE,
+		track_id    TEXT NOT NULL REFERENCES tracks(id) ON DELETE CASCADE,
+		added_at    TEXT NOT NULL
+	)`,
+	`CREATE INDEX playlist_tracks_playlist_id ON playlist_tracks(playlist_id, track_id)`,
+	`CREATE INDEX playlist_tracks_track_id ON playlist_tracks(track_id)`,
+	// Sessions switched to hashed access and refresh tokens; the old
+	// plaintext rows cannot be converted into refreshable sessions.
+	`DROP TABLE sessions`,
+	`CREATE TABLE sessions (
+		id                TEXT PRIMARY KEY,
+		user_id           TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		token_hash        TEXT NOT NULL UNIQUE,
+		refresh_hash      TEXT NOT NULL DEFAULT '',
+		user_agent        TEXT NOT NULL DEFAULT '',
+		ip                TEXT NOT NULL DEFAULT '',
+		access_expires_at TEXT NOT NULL,
+		expires_at        TEXT NOT NULL,
+		last_used_at      TEXT NOT NULL,
+		created_at        TEXT NOT NULL
+	)`,
+	`CREATE INDEX sessions_user_id ON sessions(user_id)`,
+	`CREATE INDEX sessions_expires_at ON sessions(expires_at)`,
+	`CREATE INDEX sessions_refresh_hash ON sessions(refresh_hash)`,
+	`CREATE TABLE session_prev_refresh (
+		session_id   TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+		refresh_hash TEXT NOT NULL,
+		rotated_at   TEXT NOT NULL
+	)`,
+	`CREATE INDEX session_prev_refresh_hash ON session_prev_refresh(refresh_hash)`,
+	`CREATE INDEX session_prev_refresh_session ON session_prev_refresh(session_id)`,
+	`ALTER TABLE users ADD COLUMN requested_role TEXT NOT NULL DEFAULT ''`,
+	// Accounts created before email verification existed count as verified.
+	`ALTER TABLE users ADD COLUMN email_verified INTEGER NOT NULL DEFAULT 0`,
+	`UPDATE users SET email_verified = 1`,
+	`CREATE TABLE email_tokens (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		purpose    TEXT NOT NULL,
+		token_hash TEXT NOT NULL UNIQUE,
+		expires_at TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	)`,
+	`CREATE INDEX email_tokens_user ON email_tokens(user_id, purpose)`,
+	`CREATE TABLE identities (
+		id         TEXT PRIMARY KEY,
+		user_id    TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		provider   TEXT NOT NULL,
+		subject    TEXT NOT NULL,
+		email      TEXT NOT NULL DEFAULT '',
+		created_at TEXT NOT NULL,
+		UNIQUE (provider, subject)
+	)`,
+	`CREATE INDEX identities_user_id ON identities(user_id)`,
+	`CREATE TABLE api_keys (
+		id           TEXT PRIMARY KEY,
+		user_id      TEXT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name         TEXT NOT NULL,
+		prefix       TEXT NOT NULL,
+		key_hash     TEXT NOT NULL UNIQUE,
+		scopes       TEXT NOT NULL,
+		expires_at   TEXT NOT NULL DEFAULT '',
+		last_used_at TEXT NOT NULL DEFAULT '',
+		created_at   TEXT NOT NULL
+	)`,
+	`CREATE INDEX api_keys_user_id ON api_keys(user_id)`,
+	`ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE users ADD COLUMN totp_enabled INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE users ADD COLUMN recovery_codes TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE tracks ADD COLUMN track_no INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE tracks ADD COLUMN genre TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE tracks ADD COLUMN year INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE tracks ADD COLUMN plays INTEGER NOT NULL DEFAULT 0`,
+	`ALTER TABLE albums ADD COLUMN loudness TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE tracks ADD COLUMN loudness TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE tracks ADD COLUMN album_loudness TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE tracks ADD COLUMN gapless TEXT NOT NULL DEFAULT ''`,
+	// albums and tracks gain a foreign key on artist_id, which needs the
+	// tables rebuilt. Uploads store their user as the artist, so those users
+	// get an artists row; ids that match nothing are cleared.
+	`INSERT INTO artists (id, name, bio, created_at)
+		SELECT id, name, bio, created_at FROM users
+		WHERE id IN (SELECT artist_id FROM albums UNION SELECT artist_id FROM tracks)
+		AND id NOT IN (SELECT id FROM artists)`,
+	`CREATE TABLE albums_new (
+		id           TEXT PRIMARY KEY,
+		artist_id    TEXT REFERENCES artists(id) ON DELETE SET NULL,
+		title        TEXT NOT NULL,
+		cover_url    TEXT NOT NULL DEFAULT '',
+		release_year INTEGER NOT NULL DEFAULT 0,
+		created_at   TEXT NOT NULL DEFAULT '',
+		loudness     TEXT NOT NULL DEFAULT ''
+	)`,
+	`INSERT INTO albums_new
+		SELECT id, CASE WHEN artist_id IN (SELECT id FROM artists) THEN artist_id END,
+			title, cover_url, release_year, created_at, loudness
+		FROM albums`,
+	`DROP TABLE albums`,
+	`ALTER TABLE albums_new RENAME TO albums`,
+	`CREATE INDEX albums_artist_id ON albums(artist_id)`,
+	`CREATE TABLE tracks_new (
+		id             TEXT PRIMARY KEY,
+		album_id       TEXT REFERENCES albums(id) ON DELETE SET NULL,
+		title          TEXT NOT NULL,
+		artist_id      TEXT REFERENCES artists(id) ON DELETE SET NULL,
+		artist_name    TEXT NOT NULL DEFAULT '',
+		duration_sec   INTEGER NOT NULL DEFAULT 0,
+		audio_url      TEXT NOT NULL DEFAULT '',
+		cover_url      TEXT NOT NULL DEFAULT '',
+		lyrics         TEXT NOT NULL DEFAULT '',
+		created_at     TEXT NOT NULL DEFAULT '',
+		track_no       INTEGER NOT NULL DEFAULT 0,
+		genre          TEXT NOT NULL DEFAULT '',
+		year           INTEGER NOT NULL DEFAULT 0,
+		plays          INTEGER NOT NULL DEFAULT 0,
+		loudness       TEXT NOT NULL DEFAULT '',
+		album_loudness TEXT NOT NULL DEFAULT '',
+		gapless        TEXT NOT NULL DEFAULT ''
+	)`,
+	`INSERT INTO tracks_new
+		SELECT id, album_id, title, CASE WHEN artist_id IN (SELECT id FROM artists) THEN artist_id END,
+			artist_name, duration_sec, audio_url, cover_url, lyrics, created_at,
+			track_no, genre, year, plays, loudness, album_loudness, gapless
+		FROM tracks`,
+	`DROP TABLE tracks`,
+	`ALTER TABLE tracks_new RENAME TO tracks`,
+	`CREATE INDEX tracks_album_id ON tracks(album_id)`,
+	`CREATE INDEX tracks_artist_id ON tracks(artist_id)`,
+}
+
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	dsn := "file:" + path + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// One connection keeps writes serialized and makes ":memory:" usable.
+	db.SetMaxOpenConns(1)
+
+	s := &SQLiteStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *SQLiteStore) migrate() error {
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(sqliteSchema) {
+		return fmt.Errorf("sqlite schema version %d is newer than this binary (%d)", version, len(sqliteSchema))
+	}
+	if version == len(sqliteSchema) {
+		return nil
+	}
+
+	// Steps that rebuild a table must not fire the foreign keys of the rows
+	// they move, so all pending steps run in one transaction with the checks
+	// off, and the keys are verified before it commits. The pragma has no
+	// effect inside a transaction.
+	if _, err := s.db.Exec(`PRAGMA foreign_keys = OFF`); err != nil {
+		return err
+	}
+	defer s.db.Exec(`PRAGMA foreign_keys = ON`)
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for i := version; i < len(sqliteSchema); i++ {
+		if _, err := tx.Exec(sqliteSchema[i]); err != nil {
+			return fmt.Errorf("sqlite schema step %d: %w", i+1, err)
+		}
+	}
+	if err := foreignKeyCheck(tx); err != nil {
+		return fmt.Errorf("sqlite schema version %d: %w", len(sqliteSchema), err)
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, len(sqliteSchema))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func foreignKeyCheck(tx *sql.Tx) error {
+	var table, parent string
+	var rowid sql.NullInt64
+	var fk int
+	err := tx.QueryRow(`PRAGMA foreign_key_check`).Scan(&table, &rowid, &parent, &fk)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("row %d of %s has no %s", rowid.Int64, table, parent)
+}
+
+func (s *SQLiteStore) Now() time.Time { return time.Now() }
+
+func (s *SQLiteStore) Close() error { return s.db.Close() }
+
+// Fixed-width UTC timestamps so that string comparison in SQL orders them.
+const sqlTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+func sqlTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(sqlTimeLayout)
+}
+
+func parseSQLTime(v string) time.Time {
+	t, _ := time.Parse(sqlTimeLayout, v)
+	return t
+}
+
+func sqlID(id primitive.ObjectID) any {
+	if id.IsZero() {
+		return nil
+	}
+	return id.Hex()
+}
+
+func parseSQLID(v sql.NullString) primitive.ObjectID {
+	if !v.Valid {
+		return primitive.NilObjectID
+	}
+	id, _ := primitive.ObjectIDFromHex(v.String)
+	return id
+}
+
+// Measurements like models.Loudness are stored as JSON, nil as the empty
+// string.
+func sqlJSON[T any](v *T) string {
+	if v == nil {
+		return ""
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func parseSQLJSON[T any](s string) *T {
+	var v T
+	if json.Unmarshal([]byte(s), &v) != nil {
+		return nil
+	}
+	return &v
+}
+
+func isConstraintErr(err error, kind string) bool {
+	return err != nil && strings.Contains(err.Error(), kind+" constraint failed")
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+const userCols = `id, email, password_hash, name, bio, role, requested_role, email_verified,
+	totp_secret, totp_enabled, totp_last_step, recovery_codes, created_at`
+
+func scanUser(r rowScanner) (*models.User, error) {
+	var u models.User
+	var id sql.NullString
+	var recovery, created string
+	err := r.Scan(&id, &u.Email, &u.PasswordHash, &u.Name, &u.Bio, &u.Role, &u.RequestedRole, &u.EmailVerified,
+		&u.TOTPSecret, &u.TOTPEnabled, &u.TOTPLastStep, &recovery, &created)
+	if err != nil {
+		return nil, err
+	}
+	u.ID = parseSQLID(id)
+	u.RecoveryCodes = strings.Fields(recovery)
+	u.CreatedAt = parseSQLTime(created)
+	return &u, nil
+}
+
+func (s *SQLiteStore) CreateUser(u *models.User) (*models.User, error) {
+	u.ID = primitive.NewObjectID()
+	u.CreatedAt = s.Now()
+
+	_, err := s.db.Exec(`INSERT INTO users (`+userCols+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		u.ID.Hex(), u.Email, u.PasswordHash, u.Name, u.Bio, u.Role, u.RequestedRole, u.EmailVerified,
+		u.TOTPSecret, u.TOTPEnabled, u.TOTPLastStep, strings.Join(u.RecoveryCodes, " "), sqlTime(u.CreatedAt))
+	if isConstraintErr(err, "UNIQUE") {
+		return nil, ErrAlreadyExists
+	}
+	if err != nil {
+		log.Println("CreateUser error:", err)
+		return nil, err
+	}
+	return u, nil
+}
+
+func (s *SQLiteStore) GetUserByEmail(email string) (*models.User, error) {
+	u, err := scanUser(s.db.QueryRow(`SELECT `+userCols+` FROM users WHERE email = ?`, email))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return u, nil
+}
+
+func (s *SQLiteStore) GetUserByID(id primitive.ObjectID) (*models.User, error) {
+	u, err := scanUser(s.db.QueryRow(`SELECT `+userCols+` FROM users WHERE id = ?`, id.Hex()))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return u, nil
+}
+
+func (s *SQLiteStore) UpdateUserPassword(id primitive.ObjectID, hash string) error {
+	res, err := s.db.Exec(`UPDATE users SET password_hash = ? WHERE id = ?`, hash, id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetUserRole(id primitive.ObjectID, role, requestedRole string) error {
+	res, err := s.db.Exec(`UPDATE users SET role = ?, requested_role = ? WHERE id = ?`, role, requestedRole, id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListRoleRequests() []*models.User {
+	res := []*models.User{}
+	rows, err := s.db.Query(`SELECT ` + userCols + ` FROM users WHERE requested_role != '' ORDER BY id`)
+	if err != nil {
+		return res
+	}
+	defer rows.Close()
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			continue
+		}
+		res = append(res, u)
+	}
+	return res
+}
+
+func (s *SQLiteStore) SetEmailVerified(id primitive.ObjectID, verified bool) error {
+	res, err := s.db.Exec(`UPDATE users SET email_verified = ? WHERE id = ?`, verified, id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetTOTP(id primitive.ObjectID, secret string, enabled bool, recoveryHashes []string) error {
+	var res sql.Result
+	var err error
+	if secret == "" {
+		res, err = s.db.Exec(`UPDATE users SET totp_secret = '', totp_enabled = 0, totp_last_step = 0,
+			recovery_codes = '' WHERE id = ?`, id.Hex())
+	} else {
+		res, err = s.db.Exec(`UPDATE users SET totp_secret = ?, totp_enabled = ?, recovery_codes = ? WHERE id = ?`,
+			secret, enabled, strings.Join(recoveryHashes, " "), id.Hex())
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UseTOTPStep(id primitive.ObjectID, step int64) error {
+	res, err := s.db.Exec(`UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?`, step, id.Hex(), step)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+func (s *SQLiteStore) UseRecoveryCode(id primitive.ObjectID, hash string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var recovery string
+	if err := tx.QueryRow(`SELECT recovery_codes FROM users WHERE id = ?`, id.Hex()).Scan(&recovery); err != nil {
+		return ErrNotFound
+	}
+	hashes := strings.Fields(recovery)
+	i := slices.Index(hashes, hash)
+	if i < 0 {
+		return ErrNotFound
+	}
+	hashes = slices.Delete(hashes, i, i+1)
+	if _, err := tx.Exec(`UPDATE users SET recovery_codes = ? WHERE id = ?`, strings.Join(hashes, " "), id.Hex()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) CreateEmailToken(t *models.EmailToken) (*models.EmailToken, error) {
+	t.ID = primitive.NewObjectID()
+	t.CreatedAt = s.Now()
+	_, err := s.db.Exec(`INSERT INTO email_tokens (id, user_id, purpose, token_hash, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		t.ID.Hex(), t.UserID.Hex(), t.Purpose, t.TokenHash, sqlTime(t.ExpiresAt), sqlTime(t.CreatedAt))
+	if isConstraintErr(err, "FOREIGN KEY") {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *SQLiteStore) ConsumeEmailToken(purpose, tokenHash string, now time.Time) (*models.EmailToken, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var t models.EmailToken
+	var id, userID sql.NullString
+	var expires, created string
+	err = tx.QueryRow(`SELECT id, user_id, purpose, token_hash, expires_at, created_at FROM email_tokens
+		WHERE purpose = ? AND token_hash = ?`, purpose, tokenHash).
+		Scan(&id, &userID, &t.Purpose, &t.TokenHash, &expires, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(`DELETE FROM email_tokens WHERE id = ?`, id.String); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	t.ID = parseSQLID(id)
+	t.UserID = parseSQLID(userID)
+	t.ExpiresAt = parseSQLTime(expires)
+	t.CreatedAt = parseSQLTime(created)
+	if !t.ExpiresAt.After(now) {
+		return nil, ErrNotFound
+	}
+	return &t, nil
+}
+
+func (s *SQLiteStore) DeleteEmailTokens(userID primitive.ObjectID, purpose string) int {
+	res, err := s.db.Exec(`DELETE FROM email_tokens WHERE user_id = ? AND purpose = ?`, userID.Hex(), purpose)
+	if err != nil {
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return int(n)
+}
+
+const identityCols = `id, user_id, provider, subject, email, created_at`
+
+func scanIdentity(r rowScanner) (*models.Identity, error) {
+	var i models.Identity
+	var id, userID sql.NullString
+	var created string
+	if err := r.Scan(&id, &userID, &i.Provider, &i.Subject, &i.Email, &created); err != nil {
+		return nil, err
+	}
+	i.ID = parseSQLID(id)
+	i.UserID = parseSQLID(userID)
+	i.CreatedAt = parseSQLTime(created)
+	return &i, nil
+}
+
+func (s *SQLiteStore) CreateIdentity(i *models.Identity) (*models.Identity, error) {
+	i.ID = primitive.NewObjectID()
+	i.CreatedAt = s.Now()
+	_, err := s.db.Exec(`INSERT INTO identities (`+identityCols+`) VALUES (?, ?, ?, ?, ?, ?)`,
+		i.ID.Hex(), i.UserID.Hex(), i.Provider, i.Subject, i.Email, sqlTime(i.CreatedAt))
+	if isConstraintErr(err, "UNIQUE") {
+		return nil, ErrAlreadyExists
+	}
+	if isConstraintErr(err, "FOREIGN KEY") {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (s *SQLiteStore) GetIdentity(provider, subject string) (*models.Identity, error) {
+	i, err := scanIdentity(s.db.QueryRow(`SELECT `+identityCols+` FROM identities WHERE provider = ? AND subject = ?`, provider, subject))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return i, nil
+}
+
+func (s *SQLiteStore) ListIdentities(userID primitive.ObjectID) []*models.Identity {
+	res := []*models.Identity{}
+	rows, err := s.db.Query(`SELECT `+identityCols+` FROM identities WHERE user_id = ? ORDER BY id`, userID.Hex())
+	if err != nil {
+		return res
+	}
+	defer rows.Close()
+	for rows.Next() {
+		i, err := scanIdentity(rows)
+		if err != nil {
+			continue
+		}
+		res = append(res, i)
+	}
+	return res
+}
+
+const apiKeyCols = `id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, created_at`
+
+func sqlTimePtr(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return sqlTime(*t)
+}
+
+func parseSQLTimePtr(v string) *time.Time {
+	if v == "" {
+		return nil
+	}
+	t := parseSQLTime(v)
+	return &t
+}
+
+func scanAPIKey(r rowScanner) (*models.APIKey, error) {
+	var k models.APIKey
+	var id, userID sql.NullString
+	var scopes, expires, lastUsed, created string
+	err := r.Scan(&id, &userID, &k.Name, &k.Prefix, &k.KeyHash, &scopes, &expires, &lastUsed, &created)
+	if err != nil {
+		return nil, err
+	}
+	k.ID = parseSQLID(id)
+	k.UserID = parseSQLID(userID)
+	k.Scopes = strings.Fields(scopes)
+	k.ExpiresAt = parseSQLTimePtr(expires)
+	k.LastUsedAt = parseSQLTimePtr(lastUsed)
+	k.CreatedAt = parseSQLTime(created)
+	return &k, nil
+}
+
+func (s *SQLiteStore) CreateAPIKey(k *models.APIKey) (*models.APIKey, error) {
+	k.ID = primitive.NewObjectID()
+	k.CreatedAt = s.Now()
+	_, err := s.db.Exec(`INSERT INTO api_keys (`+apiKeyCols+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		k.ID.Hex(), k.UserID.Hex(), k.Name, k.Prefix, k.KeyHash, strings.Join(k.Scopes, " "),
+		sqlTimePtr(k.ExpiresAt), sqlTimePtr(k.LastUsedAt), sqlTime(k.CreatedAt))
+	if isConstraintErr(err, "FOREIGN KEY") {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (s *SQLiteStore) GetAPIKey(keyHash string) (*models.APIKey, error) {
+	k, err := scanAPIKey(s.db.QueryRow(`SELECT `+apiKeyCols+` FROM api_keys WHERE key_hash = ?`, keyHash))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return k, nil
+}
+
+func (s *SQLiteStore) ListAPIKeys(userID primitive.ObjectID) []*models.APIKey {
+	res := []*models.APIKey{}
+	rows, err := s.db.Query(`SELECT `+apiKeyCols+` FROM api_keys WHERE user_id = ? ORDER BY id`, userID.Hex())
+	if err != nil {
+		return res
+	}
+	defer rows.Close()
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			continue
+		}
+		res = append(res, k)
+	}
+	return res
+}
+
+func (s *SQLiteStore) TouchAPIKey(id primitive.ObjectID, at time.Time) error {
+	_, err := s.db.Exec(`UPDATE api_keys SET last_used_at = ? WHERE id = ?`, sqlTime(at), id.Hex())
+	return err
+}
+
+func (s *SQLiteStore) DeleteAPIKey(userID, id primitive.ObjectID) error {
+	res, err := s.db.Exec(`DELETE FROM api_keys WHERE id = ? AND user_id = ?`, id.Hex(), userID.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const sessionCols = `id, user_id, token_hash, refresh_hash, user_agent, ip, access_expires_at, expires_at, last_used_at, created_at`
+
+func scanSession(r rowScanner) (*models.Session, error) {
+	var sec models.Session
+	var id, userID sql.NullString
+	var access, expires, lastUsed, created string
+	err := r.Scan(&id, &userID, &sec.TokenHash, &sec.RefreshHash, &sec.UserAgent, &sec.IP,
+		&access, &expires, &lastUsed, &created)
+	if err != nil {
+		return nil, err
+	}
+	sec.ID = parseSQLID(id)
+	sec.UserID = parseSQLID(userID)
+	sec.AccessExpiresAt = parseSQLTime(access)
+	sec.ExpiresAt = parseSQLTime(expires)
+	sec.LastUsedAt = parseSQLTime(lastUsed)
+	sec.CreatedAt = parseSQLTime(created)
+	return &sec, nil
+}
+
+func (s *SQLiteStore) CreateSession(sec *models.Session) (*models.Session, error) {
+	sec.ID = primitive.NewObjectID()
+	sec.CreatedAt = s.Now()
+	if sec.LastUsedAt.IsZero() {
+		sec.LastUsedAt = sec.CreatedAt
+	}
+	_, err := s.db.Exec(`INSERT INTO sessions (`+sessionCols+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		sec.ID.Hex(), sec.UserID.Hex(), sec.TokenHash, sec.RefreshHash, sec.UserAgent, sec.IP,
+		sqlTime(sec.AccessExpiresAt), sqlTime(sec.ExpiresAt), sqlTime(sec.LastUsedAt), sqlTime(sec.CreatedAt))
+	if err != nil {
+		return nil, err
+	}
+	return sec, nil
+}
+
+func (s *SQLiteStore) GetSession(tokenHash string) (*models.Session, error) {
+	sec, err := scanSession(s.db.QueryRow(`SELECT `+sessionCols+` FROM sessions WHERE token_hash = ?`, tokenHash))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return sec, nil
+}
+
+func (s *SQLiteStore) FindSessionByRefresh(refreshHash string) (*models.Session, error) {
+	sec, err := scanSession(s.db.QueryRow(`SELECT `+sessionCols+` FROM sessions
+		WHERE refresh_hash = ?
+		   OR id IN (SELECT session_id FROM session_prev_refresh WHERE refresh_hash = ?)
+		LIMIT 1`, refreshHash, refreshHash))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return sec, nil
+}
+
+func (s *SQLiteStore) RotateSession(id primitive.ObjectID, oldRefreshHash string, next *models.Session) (*models.Session, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE sessions SET token_hash = ?, refresh_hash = ?, access_expires_at = ?, expires_at = ?,
+		last_used_at = ?, user_agent = ?, ip = ? WHERE id = ? AND refresh_hash = ?`,
+		next.TokenHash, next.RefreshHash, sqlTime(next.AccessExpiresAt), sqlTime(next.ExpiresAt),
+		sqlTime(next.LastUsedAt), next.UserAgent, next.IP, id.Hex(), oldRefreshHash)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrNotFound
+	}
+	_, err = tx.Exec(`INSERT INTO session_prev_refresh (session_id, refresh_hash, rotated_at) VALUES (?, ?, ?)`,
+		id.Hex(), oldRefreshHash, sqlTime(s.Now()))
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`DELETE FROM session_prev_refresh WHERE session_id = ? AND rowid NOT IN (
+		SELECT rowid FROM session_prev_refresh WHERE session_id = ? ORDER BY rowid DESC LIMIT ?)`,
+		id.Hex(), id.Hex(), maxPrevRefreshHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	sec, err := scanSession(tx.QueryRow(`SELECT `+sessionCols+` FROM sessions WHERE id = ?`, id.Hex()))
+	if err != nil {
+		return nil, err
+	}
+	return sec, tx.Commit()
+}
+
+func (s *SQLiteStore) TouchSession(id primitive.ObjectID, at time.Time) error {
+	_, err := s.db.Exec(`UPDATE sessions SET last_used_at = ? WHERE id = ?`, sqlTime(at), id.Hex())
+	return err
+}
+
+func (s *SQLiteStore) ListSessions(userID primitive.ObjectID) []*models.Session {
+	res := []*models.Session{}
+	rows, err := s.db.Query(`SELECT `+sessionCols+` FROM sessions WHERE user_id = ? AND expires_at > ? ORDER BY last_used_at DESC`,
+		userID.Hex(), sqlTime(s.Now()))
+	if err != nil {
+		return res
+	}
+	defer rows.Close()
+	for rows.Next() {
+		sec, err := scanSession(rows)
+		if err != nil {
+			continue
+		}
+		res = append(res, sec)
+	}
+	return res
+}
+
+func (s *SQLiteStore) DeleteSession(userID, id primitive.ObjectID) error {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE id = ? AND user_id = ?`, id.Hex(), userID.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) DeleteUserSessions(userID primitive.ObjectID) int {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE user_id = ?`, userID.Hex())
+	if err != nil {
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return int(n)
+}
+
+func (s *SQLiteStore) CleanupExpiredSessions(now time.Time) int {
+	res, err := s.db.Exec(`DELETE FROM sessions WHERE expires_at <= ?`, sqlTime(now))
+	if err != nil {
+		return 0
+	}
+	n, _ := res.RowsAffected()
+	return int(n)
+}
+
+func (s *SQLiteStore) AddArtist(a *models.Artist) (*models.Artist, error) {
+	var existing models.Artist
+	var id sql.NullString
+	var created string
+	err := s.db.QueryRow(`SELECT id, name, bio, created_at FROM artists WHERE name = ? ORDER BY id LIMIT 1`, a.Name).
+		Scan(&id, &existing.Name, &existing.Bio, &created)
+	if err == nil {
+		existing.ID = parseSQLID(id)
+		existing.CreatedAt = parseSQLTime(created)
+		return &existing, nil
+	}
+
+	a.ID = primitive.NewObjectID()
+	_, err = s.db.Exec(`INSERT INTO artists (id, name, bio, created_at) VALUES (?, ?, ?, ?)`,
+		a.ID.Hex(), a.Name, a.Bio, sqlTime(a.CreatedAt))
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (s *SQLiteStore) ListArtists() []*models.Artist {
+	res := []*models.Artist{}
+	rows, err := s.db.Query(`SELECT id, name, bio, created_at FROM artists ORDER BY id`)
+	if err != nil {
+		return res
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a models.Artist
+		var id sql.NullString
+		var created string
+		if err := rows.Scan(&id, &a.Name, &a.Bio, &created); err != nil {
+			continue
+		}
+		a.ID = parseSQLID(id)
+		a.CreatedAt = parseSQLTime(created)
+		res = append(res, &a)
+	}
+	return res
+}
+
+// userArtist gives the user an artists row under their own id when id is a
+// user without one, as uploads store their user as the artist.
+func userArtist(tx *sql.Tx, id primitive.ObjectID) error {
+	if id.IsZero() {
+		return nil
+	}
+	_, err := tx.Exec(`INSERT INTO artists (id, name, bio, created_at)
+		SELECT id, name, bio, created_at FROM users
+		WHERE id = ? AND id NOT IN (SELECT id FROM artists)`, id.Hex())
+	return err
+}
+
+func (s *SQLiteStore) AddAlbum(a *models.Album) (*models.Album, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := userArtist(tx, a.ArtistID); err != nil {
+		return nil, err
+	}
+	a.ID = primitive.NewObjectID()
+	_, err = tx.Exec(`INSERT INTO albums (`+albumCols+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		a.ID.Hex(), sqlID(a.ArtistID), a.Title, a.CoverURL, a.ReleaseYear, sqlTime(a.CreatedAt), sqlJSON(a.Loudness))
+	if isConstraintErr(err, "FOREIGN KEY") {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a, tx.Commit()
+}
+
+const albumCols = `id, artist_id, title, cover_url, release_year, created_at, loudness`
+
+func scanAlbum(r rowScanner) (*models.Album, error) {
+	var a models.Album
+	var id, artist sql.NullString
+	var created, loudness string
+	if err := r.Scan(&id, &artist, &a.Title, &a.CoverURL, &a.ReleaseYear, &created, &loudness); err != nil {
+		return nil, err
+	}
+	a.ID = parseSQLID(id)
+	a.ArtistID = parseSQLID(artist)
+	a.CreatedAt = parseSQLTime(created)
+	a.Loudness = parseSQLJSON[models.Loudness](loudness)
+	return &a, nil
+}
+
+func (s *SQLiteStore) GetAlbum(id primitive.ObjectID) (*models.Album, error) {
+	a, err := scanAlbum(s.db.QueryRow(`SELECT `+albumCols+` FROM albums WHERE id = ?`, id.Hex()))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return a, nil
+}
+
+func (s *SQLiteStore) ListAlbums(artistID primitive.ObjectID) []*models.Album {
+	res := []*models.Album{}
+	q := `SELECT ` + albumCols + ` FROM albums`
+	var args []any
+	if !artistID.IsZero() {
+		q += ` WHERE artist_id = ?`
+		args = append(args, artistID.Hex())
+	}
+	rows, err := s.db.Query(q+` ORDER BY id`, args...)
+	if err != nil {
+		return res
+	}
+	defer rows.Close()
+	for rows.Next() {
+		a, err := scanAlbum(rows)
+		if err != nil {
+			continue
+		}
+		res = append(res, a)
+	}
+	return res
+}
+
+const trackCols = `id, album_id, title, artist_id, artist_name, duration_sec, audio_url, cover_url, lyrics, created_at,
+	track_no, genre, year, plays, loudness, album_loudness, gapless`
+
+func scanTrack(r rowScanner) (*models.Track, error) {
+	var t models.Track
+	var id, album, artist sql.NullString
+	var created, loudness, albumLoudness, gapless string
+	err := r.Scan(&id, &album, &t.Title, &artist, &t.ArtistName, &t.DurationSec,
+		&t.AudioURL, &t.CoverURL, &t.Lyrics, &created, &t.TrackNo, &t.Genre, &t.Year, &t.Plays,
+		&loudness, &albumLoudness, &gapless)
+	if err != nil {
+		return nil, err
+	}
+	t.Loudness = parseSQLJSON[models.Loudness](loudness)
+	t.AlbumLoudness = parseSQLJSON[models.Loudness](albumLoudness)
+	t.Gapless = parseSQLJSON[models.Gapless](gapless)
+	t.ID = parseSQLID(id)
+	t.AlbumID = parseSQLID(album)
+	t.ArtistID = parseSQLID(artist)
+	t.CreatedAt = parseSQLTime(created)
+	return &t, nil
+}
+
+func (s *SQLiteStore) AddTrack(t *models.Track) (*models.Track, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := userArtist(tx, t.ArtistID); err != nil {
+		return nil, err
+	}
+	t.ID = primitive.NewObjectID()
+	_, err = tx.Exec(`INSERT INTO tracks (`+trackCols+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		t.ID.Hex(), sqlID(t.AlbumID), t.Title, sqlID(t.ArtistID), t.ArtistName, t.DurationSec,
+		t.AudioURL, t.CoverURL, t.Lyrics, sqlTime(t.CreatedAt), t.TrackNo, t.Genre, t.Year, t.Plays,
+		sqlJSON(t.Loudness), sqlJSON(t.AlbumLoudness), sqlJSON(t.Gapless))
+	if isConstraintErr(err, "FOREIGN KEY") {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, tx.Commit()
+}
+
+func (s *SQLiteStore) getTrack(q interface {
+	QueryRow(query string, args ...any) *sql.Row
+}, id primitive.ObjectID) (*models.Track, error) {
+	t, err := scanTrack(q.QueryRow(`SELECT `+trackCols+` FROM tracks WHERE id = ?`, id.Hex()))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return t, nil
+}
+
+func (s *SQLiteStore) GetTrack(id primitive.ObjectID) (*models.Track, error) {
+	return s.getTrack(s.db, id)
+}
+
+func (s *SQLiteStore) UpdateTrack(id primitive.ObjectID, coverURL, lyrics string) (*models.Track, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if coverURL != "" {
+		if _, err := tx.Exec(`UPDATE tracks SET cover_url = ? WHERE id = ?`, coverURL, id.Hex()); err != nil {
+			return nil, err
+		}
+	}
+	if lyrics != "" {
+		if _, err := tx.Exec(`UPDATE tracks SET lyrics = ? WHERE id = ?`, lyrics, id.Hex()); err != nil {
+			return nil, err
+		}
+	}
+	t, err := s.getTrack(tx, id)
+	if err != nil {
+		return nil, err
+	}
+	return t, tx.Commit()
+}
+
+func (s *SQLiteStore) AddPlay(id primitive.ObjectID) error {
+	res, err := s.db.Exec(`UPDATE tracks SET plays = plays + 1 WHERE id = ?`, id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetTrackLoudness(id primitive.ObjectID, l *models.Loudness) error {
+	res, err := s.db.Exec(`UPDATE tracks SET loudness = ? WHERE id = ?`, sqlJSON(l), id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetTrackGapless(id primitive.ObjectID, g *models.Gapless) error {
+	res, err := s.db.Exec(`UPDATE tracks SET gapless = ? WHERE id = ?`, sqlJSON(g), id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SetAlbumLoudness(id primitive.ObjectID, l *models.Loudness) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE albums SET loudness = ? WHERE id = ?`, sqlJSON(l), id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	if _, err := tx.Exec(`UPDATE tracks SET album_loudness = ? WHERE album_id = ?`, sqlJSON(l), id.Hex()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) MediaRefs() (map[string]int, error) {
+	rows, err := s.db.Query(`
+		SELECT audio_url FROM tracks
+		UNION ALL SELECT cover_url FROM tracks
+		UNION ALL SELECT cover_url FROM albums
+		UNION ALL SELECT cover_url FROM playlists`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	refs := map[string]int{}
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, err
+		}
+		if u != "" {
+			refs[u]++
+		}
+	}
+	return refs, rows.Err()
+}
+
+// DeleteTrack relies on ON DELETE CASCADE to drop playlist_tracks rows in the
+// same transaction as the track itself.
+func (s *SQLiteStore) DeleteTrack(id primitive.ObjectID) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM tracks WHERE id = ?`, id.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ListTracks(albumID primitive.ObjectID) []*models.Track {
+	res := []*models.Track{}
+	q := `SELECT ` + trackCols + ` FROM tracks`
+	var args []any
+	if !albumID.IsZero() {
+		q += ` WHERE album_id = ?`
+		args = append(args, albumID.Hex())
+	}
+	rows, err := s.db.Query(q+` ORDER BY id DESC`, args...)
+	if err != nil {
+		return res
+	}
+	defer rows.Close()
+	for rows.Next() {
+		t, err := scanTrack(rows)
+		if err != nil {
+			continue
+		}
+		res = append(res, t)
+	}
+	return res
+}
+
+func (s *SQLiteStore) CreatePlaylist(userID primitive.ObjectID, title, coverURL string) (*models.Playlist, error) {
+	p := &models.Playlist{
+		ID:        primitive.NewObjectID(),
+		UserID:    userID,
+		Title:     title,
+		CoverURL:  coverURL,
+		CreatedAt: s.Now(),
+	}
+	_, err := s.db.Exec(`INSERT INTO playlists (`+playlistCols+`) VALUES (?, ?, ?, ?, ?)`,
+		p.ID.Hex(), userID.Hex(), title, coverURL, sqlTime(p.CreatedAt))
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+const playlistCols = `id, user_id, title, cover_url, created_at`
+
+func scanPlaylist(r rowScanner) (*models.Playlist, error) {
+	var p models.Playlist
+	var id, user sql.NullString
+	var created string
+	if err := r.Scan(&id, &user, &p.Title, &p.CoverURL, &created); err != nil {
+		return nil, err
+	}
+	p.ID = parseSQLID(id)
+	p.UserID = parseSQLID(user)
+	p.CreatedAt = parseSQLTime(created)
+	return &p, nil
+}
+
+func (s *SQLiteStore) GetPlaylist(id primitive.ObjectID) (*models.Playlist, error) {
+	p, err := scanPlaylist(s.db.QueryRow(`SELECT `+playlistCols+` FROM playlists WHERE id = ?`, id.Hex()))
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return p, nil
+}
+
+func (s *SQLiteStore) ListPlaylists(userID primitive.ObjectID) []*models.Playlist {
+	res := []*models.Playlist{}
+	rows, err := s.db.Query(`SELECT `+playlistCols+` FROM playlists WHERE user_id = ? ORDER BY id`, userID.Hex())
+	if err != nil {
+		return res
+	}
+	defer rows.Close()
+	for rows.Next() {
+		p, err := scanPlaylist(rows)
+		if err != nil {
+			continue
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+func (s *SQLiteStore) ownedPlaylist(tx *sql.Tx, userID, playlistID primitive.ObjectID) error {
+	var owner sql.NullString
+	err := tx.QueryRow(`SELECT user_id FROM playlists WHERE id = ?`, playlistID.Hex()).Scan(&owner)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if parseSQLID(owner) != userID {
+		return ErrForbidden
+	}
+	return nil
+}
+
+func (s *SQLiteStore) AddTrackToPlaylist(userID, playlistID, trackID primitive.ObjectID) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.ownedPlaylist(tx, userID, playlistID); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO playlist_tracks (id, playlist_id, track_id, added_at) VALUES (?, ?, ?, ?)`,
+		primitive.NewObjectID().Hex(), playlistID.Hex(), trackID.Hex(), sqlTime(s.Now()))
+	if isConstraintErr(err, "FOREIGN KEY") {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) RemoveTrackFromPlaylist(userID, playlistID, trackID primitive.ObjectID) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := s.ownedPlaylist(tx, userID, playlistID); err != nil {
+		return err
+	}
+	res, err := tx.Exec(`DELETE FROM playlist_tracks WHERE id = (
+		SELECT id FROM playlist_tracks WHERE playlist_id = ? AND track_id = ? ORDER BY id LIMIT 1)`,
+		playlistID.Hex(), trackID.Hex())
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"YeahMusic/internal/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func openSQLiteStore(t *testing.T, path string) *SQLiteStore {
+	t.Helper()
+	s, err := NewSQLiteStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func count(t *testing.T, s *SQLiteStore, table string) int {
+	t.Helper()
+	var n int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSQLiteCascades(t *testing.T) {
+	s := openSQLiteStore(t, filepath.Join(t.TempDir(), "yeahmusic.db"))
+	u, err := s.CreateUser(&models.User{Email: "a@example.com", Name: "A", Role: "artist"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, err := s.CreateSession(&models.Session{UserID: u.ID, TokenHash: "t", RefreshHash: "r",
+		AccessExpiresAt: now.Add(time.Hour), ExpiresAt: now.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Uploads store their user as the artist, which gives the user an
+	// artists row.
+	al, err := s.AddAlbum(&models.Album{ArtistID: u.ID, Title: "First"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := s.ListArtists(); len(a) != 1 || a[0].ID != u.ID || a[0].Name != "A" {
+		t.Errorf("artists %+v, want the user", a)
+	}
+	var tracks []*models.Track
+	for _, title := range []string{"One", "Two"} {
+		tr, err := s.AddTrack(&models.Track{AlbumID: al.ID, ArtistID: u.ID, Title: title})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tracks = append(tracks, tr)
+	}
+	if _, err := s.AddTrack(&models.Track{ArtistID: primitive.NewObjectID(), Title: "Nobody's"}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("track of an unknown artist: %v, want ErrNotFound", err)
+	}
+
+	pl, err := s.CreatePlaylist(u.ID, "Mix", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tr := range tracks {
+		if err := s.AddTrackToPlaylist(u.ID, pl.ID, tr.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.AddTrackToPlaylist(u.ID, pl.ID, primitive.NewObjectID()); !errors.Is(err, ErrNotFound) {
+		t.Errorf("adding a missing track: %v, want ErrNotFound", err)
+	}
+
+	// Deleting a track takes it out of the playlists it is on.
+	if err := s.DeleteTrack(tracks[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, s, "playlist_tracks"); n != 1 {
+		t.Errorf("%d playlist tracks after deleting a track, want 1", n)
+	}
+
+	// Deleting an album or an artist keeps the tracks.
+	if _, err := s.db.Exec(`DELETE FROM albums WHERE id = ?`, al.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.db.Exec(`DELETE FROM artists WHERE id = ?`, u.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetTrack(tracks[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.AlbumID.IsZero() || !got.ArtistID.IsZero() {
+		t.Errorf("track of a deleted album and artist %+v", got)
+	}
+
+	// Deleting a user takes their sessions, playlists and playlist tracks.
+	if _, err := s.db.Exec(`DELETE FROM users WHERE id = ?`, u.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"sessions", "playlists", "playlist_tracks"} {
+		if n := count(t, s, table); n != 0 {
+			t.Errorf("%d %s left of a deleted user", n, table)
+		}
+	}
+}
+
+// TestSQLiteUpgrade opens a file made before artist_id had a foreign key.
+func TestSQLiteUpgrade(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "yeahmusic.db")
+	old := slices.IndexFunc(sqliteSchema, func(q string) bool {
+		return strings.Contains(q, "CREATE TABLE albums_new")
+	}) - 1
+
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, artist, dangling := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	album, track, orphan := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	playlist := primitive.NewObjectID()
+	steps := append(slices.Clone(sqliteSchema[:old]),
+		fmt.Sprintf(`PRAGMA user_version = %d`, old),
+		fmt.Sprintf(`INSERT INTO users (id, email, name, created_at) VALUES ('%s', 'a@example.com', 'A', '')`, user.Hex()),
+		fmt.Sprintf(`INSERT INTO artists (id, name) VALUES ('%s', 'Seeded')`, artist.Hex()),
+		fmt.Sprintf(`INSERT INTO albums (id, artist_id, title) VALUES ('%s', '%s', 'First')`, album.Hex(), user.Hex()),
+		fmt.Sprintf(`INSERT INTO tracks (id, album_id, artist_id, title, plays) VALUES ('%s', '%s', '%s', 'One', 7)`,
+			track.Hex(), album.Hex(), user.Hex()),
+		fmt.Sprintf(`INSERT INTO tracks (id, artist_id, title) VALUES ('%s', '%s', 'Orphan')`, orphan.Hex(), dangling.Hex()),
+		fmt.Sprintf(`INSERT INTO playlists (id, user_id, title, created_at) VALUES ('%s', '%s', 'Mix', '')`,
+			playlist.Hex(), user.Hex()),
+		fmt.Sprintf(`INSERT INTO playlist_tracks (id, playlist_id, track_id, added_at) VALUES ('%s', '%s', '%s', '')`,
+			primitive.NewObjectID().Hex(), playlist.Hex(), track.Hex()),
+	)
+	for _, q := range steps {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	db.Close()
+
+	s := openSQLiteStore(t, path)
+	var version int
+	if err := s.db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil || version != len(sqliteSchema) {
+		t.Fatalf("user_version %d, %v, want %d", version, err, len(sqliteSchema))
+	}
+	if a := s.ListArtists(); len(a) != 2 {
+		t.Errorf("artists %+v, want the seeded one and the user", a)
+	}
+	got, err := s.GetTrack(track)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AlbumID != album || got.ArtistID != user || got.Plays != 7 {
+		t.Errorf("track after the upgrade %+v", got)
+	}
+	if got, err := s.GetTrack(orphan); err != nil || !got.ArtistID.IsZero() {
+		t.Errorf("track of an unknown artist after the upgrade %+v, %v", got, err)
+	}
+	// Rebuilding tracks must not empty the playlists.
+	if n := count(t, s, "playlist_tracks"); n != 1 {
+		t.Errorf("%d playlist tracks after the upgrade, want 1", n)
+	}
+	var fks bool
+	if err := s.db.QueryRow(`PRAGMA foreign_keys`).Scan(&fks); err != nil || !fks {
+		t.Errorf("foreign keys %v after the upgrade, %v", fks, err)
+	}
+	if _, err := s.db.Exec(`DELETE FROM artists WHERE id = ?`, user.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetTrack(track); !got.ArtistID.IsZero() {
+		t.Errorf("artist_id %s survived deleting its artist", got.ArtistID.Hex())
+	}
+	s.Close()
+
+	// A file from a newer binary is left alone.
+	db, err = sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, len(sqliteSchema)+1)); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if _, err := NewSQLiteStore(path); err == nil || !strings.Contains(err.Error(), "newer than this binary") {
+		t.Errorf("opening a newer file: %v", err)
+	}
+}
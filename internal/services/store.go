@@ -6,6 +6,7 @@ import (
 	"log"
 	"time"
 
+	"YeahMusic/internal/migrate"
 	"YeahMusic/internal/models"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -19,6 +20,9 @@ var (
 	ErrUnauthorized  = errors.New("unauthorized")
 	ErrForbidden     = errors.New("forbidden")
 	ErrAlreadyExists = errors.New("already exists")
+	ErrUnverified    = errors.New("email not verified")
+
+	ErrMFASetupRequired = errors.New("two-factor authentication required")
 )
 
 type Store struct {
@@ -42,8 +46,42 @@ func NewStore(uri string) (*Store, error) {
 	return &Store{client: client, db: client.Database("YeahMusic")}, nil
 }
 
+// NewStoreWithDatabase reuses an existing connection, e.g. the database of
+// the legacy server in main.go.
+func NewStoreWithDatabase(db *mongo.Database) *Store {
+	return &Store{client: db.Client(), db: db}
+}
+
+// PrepareSchema applies pending migrations when auto is set and otherwise
+// only checks them. A database ahead of the binary is always an error.
+func (s *Store) PrepareSchema(auto bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	r := migrate.NewRunner(s.db)
+	if !auto {
+		err := r.Check(ctx)
+		if errors.Is(err, migrate.ErrPending) {
+			log.Println("schema:", err)
+			return nil
+		}
+		return err
+	}
+	applied, err := r.Up(ctx)
+	for _, st := range applied {
+		log.Printf("schema: applied migration %d (%s)", st.Version, st.Name)
+	}
+	return err
+}
+
 func (s *Store) Now() time.Time { return time.Now() }
 
+func (s *Store) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.client.Disconnect(ctx)
+}
+
 func (s *Store) CreateUser(u *models.User) (*models.User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -88,33 +126,392 @@ func (s *Store) GetUserByID(id primitive.ObjectID) (*models.User, error) {
 	return &u, nil
 }
 
-func (s *Store) CreateSession(userID primitive.ObjectID, token string, ttl time.Duration) *models.Session {
+func (s *Store) UpdateUserPassword(id primitive.ObjectID, hash string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	sec := &models.Session{
-		ID:        primitive.NewObjectID(),
-		UserID:    userID,
-		Token:     token,
-		CreatedAt: s.Now(),
-		ExpiresAt: s.Now().Add(ttl),
+	res, err := s.db.Collection("users").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"password_hash": hash}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) SetUserRole(id primitive.ObjectID, role, requestedRole string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"role": role}}
+	if requestedRole != "" {
+		update["$set"].(bson.M)["requested_role"] = requestedRole
+	} else {
+		update["$unset"] = bson.M{"requested_role": ""}
+	}
+	res, err := s.db.Collection("users").UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) ListRoleRequests() []*models.User {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var res []*models.User
+	filter := bson.M{"requested_role": bson.M{"$exists": true, "$ne": ""}}
+	cur, err := s.db.Collection("users").Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return []*models.User{}
+	}
+	defer cur.Close(ctx)
+	cur.All(ctx, &res)
+	if res == nil {
+		return []*models.User{}
+	}
+	return res
+}
+
+func (s *Store) SetEmailVerified(id primitive.ObjectID, verified bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := s.db.Collection("users").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"email_verified": verified}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) SetTOTP(id primitive.ObjectID, secret string, enabled bool, recoveryHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{
+		"totp_secret": secret, "totp_enabled": enabled, "recovery_codes": recoveryHashes,
+	}}
+	if secret == "" {
+		update = bson.M{
+			"$set":   bson.M{"totp_enabled": false},
+			"$unset": bson.M{"totp_secret": "", "recovery_codes": "", "totp_last_step": ""},
+		}
+	}
+	res, err := s.db.Collection("users").UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) UseTOTPStep(id primitive.ObjectID, step int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := s.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": id, "$or": bson.A{
+			bson.M{"totp_last_step": bson.M{"$lt": step}},
+			bson.M{"totp_last_step": bson.M{"$exists": false}},
+		}},
+		bson.M{"$set": bson.M{"totp_last_step": step}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+func (s *Store) UseRecoveryCode(id primitive.ObjectID, hash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := s.db.Collection("users").UpdateOne(ctx,
+		bson.M{"_id": id, "recovery_codes": hash},
+		bson.M{"$pull": bson.M{"recovery_codes": hash}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) CreateEmailToken(t *models.EmailToken) (*models.EmailToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	t.ID = primitive.NewObjectID()
+	t.CreatedAt = s.Now()
+	if _, err := s.db.Collection("email_tokens").InsertOne(ctx, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *Store) ConsumeEmailToken(purpose, tokenHash string, now time.Time) (*models.EmailToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var t models.EmailToken
+	err := s.db.Collection("email_tokens").FindOneAndDelete(ctx, bson.M{"purpose": purpose, "token_hash": tokenHash}).Decode(&t)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	if !t.ExpiresAt.After(now) {
+		return nil, ErrNotFound
+	}
+	return &t, nil
+}
+
+func (s *Store) DeleteEmailTokens(userID primitive.ObjectID, purpose string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := s.db.Collection("email_tokens").DeleteMany(ctx, bson.M{"user_id": userID, "purpose": purpose})
+	if err != nil {
+		return 0
+	}
+	return int(res.DeletedCount)
+}
+
+func (s *Store) CreateIdentity(i *models.Identity) (*models.Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	i.ID = primitive.NewObjectID()
+	i.CreatedAt = s.Now()
+	_, err := s.db.Collection("identities").InsertOne(ctx, i)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrAlreadyExists
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+func (s *Store) GetIdentity(provider, subject string) (*models.Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var i models.Identity
+	err := s.db.Collection("identities").FindOne(ctx, bson.M{"provider": provider, "subject": subject}).Decode(&i)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	return &i, nil
+}
+
+func (s *Store) ListIdentities(userID primitive.ObjectID) []*models.Identity {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var res []*models.Identity
+	cur, err := s.db.Collection("identities").Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return []*models.Identity{}
+	}
+	defer cur.Close(ctx)
+	cur.All(ctx, &res)
+	if res == nil {
+		return []*models.Identity{}
+	}
+	return res
+}
+
+func (s *Store) CreateAPIKey(k *models.APIKey) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	k.ID = primitive.NewObjectID()
+	k.CreatedAt = s.Now()
+	stored := *k
+	stored.Key = ""
+	if _, err := s.db.Collection("api_keys").InsertOne(ctx, stored); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (s *Store) GetAPIKey(keyHash string) (*models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var k models.APIKey
+	if err := s.db.Collection("api_keys").FindOne(ctx, bson.M{"key_hash": keyHash}).Decode(&k); err != nil {
+		return nil, ErrNotFound
+	}
+	return &k, nil
+}
+
+func (s *Store) ListAPIKeys(userID primitive.ObjectID) []*models.APIKey {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var res []*models.APIKey
+	cur, err := s.db.Collection("api_keys").Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.M{"_id": 1}))
+	if err != nil {
+		return []*models.APIKey{}
+	}
+	defer cur.Close(ctx)
+	cur.All(ctx, &res)
+	if res == nil {
+		return []*models.APIKey{}
+	}
+	return res
+}
+
+func (s *Store) TouchAPIKey(id primitive.ObjectID, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.Collection("api_keys").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
+
+func (s *Store) DeleteAPIKey(userID, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := s.db.Collection("api_keys").DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
 	}
-	s.db.Collection("sessions").InsertOne(ctx, sec)
-	return sec
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
-func (s *Store) GetSession(token string) (*models.Session, error) {
+func (s *Store) CreateSession(sec *models.Session) (*models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sec.ID = primitive.NewObjectID()
+	sec.CreatedAt = s.Now()
+	if sec.LastUsedAt.IsZero() {
+		sec.LastUsedAt = sec.CreatedAt
+	}
+	if _, err := s.db.Collection("sessions").InsertOne(ctx, sec); err != nil {
+		return nil, err
+	}
+	return sec, nil
+}
+
+func (s *Store) findSession(filter bson.M) (*models.Session, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	var sec models.Session
-	err := s.db.Collection("sessions").FindOne(ctx, bson.M{"token": token}).Decode(&sec)
+	err := s.db.Collection("sessions").FindOne(ctx, filter).Decode(&sec)
 	if err != nil {
 		return nil, ErrNotFound
 	}
 	return &sec, nil
 }
 
+func (s *Store) GetSession(tokenHash string) (*models.Session, error) {
+	return s.findSession(bson.M{"token_hash": tokenHash})
+}
+
+func (s *Store) FindSessionByRefresh(refreshHash string) (*models.Session, error) {
+	return s.findSession(bson.M{"$or": bson.A{
+		bson.M{"refresh_hash": refreshHash},
+		bson.M{"prev_refresh_hashes": refreshHash},
+	}})
+}
+
+func (s *Store) RotateSession(id primitive.ObjectID, oldRefreshHash string, next *models.Session) (*models.Session, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res := s.db.Collection("sessions").FindOneAndUpdate(
+		ctx,
+		bson.M{"_id": id, "refresh_hash": oldRefreshHash},
+		bson.M{
+			"$set": bson.M{
+				"token_hash":        next.TokenHash,
+				"refresh_hash":      next.RefreshHash,
+				"access_expires_at": next.AccessExpiresAt,
+				"expires_at":        next.ExpiresAt,
+				"last_used_at":      next.LastUsedAt,
+				"user_agent":        next.UserAgent,
+				"ip":                next.IP,
+			},
+			"$push": bson.M{"prev_refresh_hashes": bson.M{"$each": bson.A{oldRefreshHash}, "$slice": -maxPrevRefreshHashes}},
+		},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	)
+
+	var sec models.Session
+	if err := res.Decode(&sec); err != nil {
+		return nil, ErrNotFound
+	}
+	return &sec, nil
+}
+
+func (s *Store) TouchSession(id primitive.ObjectID, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.Collection("sessions").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"last_used_at": at}})
+	return err
+}
+
+func (s *Store) ListSessions(userID primitive.ObjectID) []*models.Session {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var res []*models.Session
+	opts := options.Find().SetSort(bson.M{"last_used_at": -1})
+	cur, _ := s.db.Collection("sessions").Find(ctx, bson.M{"user_id": userID, "expires_at": bson.M{"$gt": s.Now()}}, opts)
+	defer cur.Close(ctx)
+	cur.All(ctx, &res)
+	if res == nil {
+		return []*models.Session{}
+	}
+	return res
+}
+
+func (s *Store) DeleteSession(userID, id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := s.db.Collection("sessions").DeleteOne(ctx, bson.M{"_id": id, "user_id": userID})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) DeleteUserSessions(userID primitive.ObjectID) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := s.db.Collection("sessions").DeleteMany(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return 0
+	}
+	return int(res.DeletedCount)
+}
+
 func (s *Store) CleanupExpiredSessions(now time.Time) int {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -122,37 +519,65 @@ func (s *Store) CleanupExpiredSessions(now time.Time) int {
 	return int(res.DeletedCount)
 }
 
-func (s *Store) AddArtist(a *models.Artist) *models.Artist {
+func (s *Store) AddArtist(a *models.Artist) (*models.Artist, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	var existing models.Artist
 	err := s.db.Collection("artists").FindOne(ctx, bson.M{"name": a.Name}).Decode(&existing)
 	if err == nil {
-		return &existing
+		return &existing, nil
 	}
 
 	a.ID = primitive.NewObjectID()
-	s.db.Collection("artists").InsertOne(ctx, a)
-	return a
+	if _, err := s.db.Collection("artists").InsertOne(ctx, a); err != nil {
+		return nil, err
+	}
+	return a, nil
 }
 
-func (s *Store) AddAlbum(a *models.Album) *models.Album {
+func (s *Store) AddAlbum(a *models.Album) (*models.Album, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	a.ID = primitive.NewObjectID()
-	s.db.Collection("albums").InsertOne(ctx, a)
-	return a
+	if _, err := s.db.Collection("albums").InsertOne(ctx, a); err != nil {
+		return nil, err
+	}
+	return a, nil
 }
 
-func (s *Store) AddTrack(t *models.Track) *models.Track {
+func (s *Store) GetAlbum(id primitive.ObjectID) (*models.Album, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var a models.Album
+	if err := s.db.Collection("albums").FindOne(ctx, bson.M{"_id": id}).Decode(&a); err != nil {
+		return nil, ErrNotFound
+	}
+	return &a, nil
+}
+
+func (s *Store) AddTrack(t *models.Track) (*models.Track, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	t.ID = primitive.NewObjectID()
-	s.db.Collection("tracks").InsertOne(ctx, t)
-	return t
+	if _, err := s.db.Collection("tracks").InsertOne(ctx, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (s *Store) GetTrack(id primitive.ObjectID) (*models.Track, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var t models.Track
+	if err := s.db.Collection("tracks").FindOne(ctx, bson.M{"_id": id}).Decode(&t); err != nil {
+		return nil, ErrNotFound
+	}
+	return &t, nil
 }
 
 func (s *Store) UpdateTrack(id primitive.ObjectID, coverURL, lyrics string) (*models.Track, error) {
@@ -169,7 +594,9 @@ func (s *Store) UpdateTrack(id primitive.ObjectID, coverURL, lyrics string) (*mo
 
 	if len(update) == 0 {
 		var t models.Track
-		s.db.Collection("tracks").FindOne(ctx, bson.M{"_id": id}).Decode(&t)
+		if err := s.db.Collection("tracks").FindOne(ctx, bson.M{"_id": id}).Decode(&t); err != nil {
+			return nil, ErrNotFound
+		}
 		return &t, nil
 	}
 
@@ -187,6 +614,71 @@ func (s *Store) UpdateTrack(id primitive.ObjectID, coverURL, lyrics string) (*mo
 	return &t, nil
 }
 
+func (s *Store) AddPlay(id primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := s.db.Collection("tracks").UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"plays": 1}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) SetTrackLoudness(id primitive.ObjectID, l *models.Loudness) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := s.db.Collection("tracks").UpdateOne(ctx, bson.M{"_id": id}, setOrUnset("loudness", l))
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *Store) SetAlbumLoudness(id primitive.ObjectID, l *models.Loudness) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := s.db.Collection("albums").UpdateOne(ctx, bson.M{"_id": id}, setOrUnset("loudness", l))
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	_, err = s.db.Collection("tracks").UpdateMany(ctx, bson.M{"album_id": id}, setOrUnset("album_loudness", l))
+	return err
+}
+
+func (s *Store) SetTrackGapless(id primitive.ObjectID, g *models.Gapless) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := s.db.Collection("tracks").UpdateOne(ctx, bson.M{"_id": id}, setOrUnset("gapless", g))
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// setOrUnset is the update setting field to v, or removing it for nil.
+func setOrUnset[T any](field string, v *T) bson.M {
+	if v == nil {
+		return bson.M{"$unset": bson.M{field: ""}}
+	}
+	return bson.M{"$set": bson.M{field: v}}
+}
+
 func (s *Store) DeleteTrack(id primitive.ObjectID) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -256,7 +748,46 @@ func (s *Store) ListTracks(albumID primitive.ObjectID) []*models.Track {
 	return res
 }
 
-func (s *Store) CreatePlaylist(userID primitive.ObjectID, title, coverURL string) *models.Playlist {
+func (s *Store) MediaRefs() (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	refs := map[string]int{}
+	for coll, fields := range map[string][]string{
+		"tracks":    {"audio_url", "cover_url"},
+		"albums":    {"cover_url"},
+		"playlists": {"cover_url"},
+	} {
+		proj := bson.M{}
+		for _, f := range fields {
+			proj[f] = 1
+		}
+		cur, err := s.db.Collection(coll).Find(ctx, bson.M{}, options.Find().SetProjection(proj))
+		if err != nil {
+			return nil, err
+		}
+		for cur.Next(ctx) {
+			var doc bson.M
+			if err := cur.Decode(&doc); err != nil {
+				cur.Close(ctx)
+				return nil, err
+			}
+			for _, f := range fields {
+				if u, _ := doc[f].(string); u != "" {
+					refs[u]++
+				}
+			}
+		}
+		err = cur.Err()
+		cur.Close(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
+
+func (s *Store) CreatePlaylist(userID primitive.ObjectID, title, coverURL string) (*models.Playlist, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -267,8 +798,21 @@ func (s *Store) CreatePlaylist(userID primitive.ObjectID, title, coverURL string
 		CoverURL:  coverURL,
 		CreatedAt: s.Now(),
 	}
-	s.db.Collection("playlists").InsertOne(ctx, p)
-	return p
+	if _, err := s.db.Collection("playlists").InsertOne(ctx, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (s *Store) GetPlaylist(id primitive.ObjectID) (*models.Playlist, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var p models.Playlist
+	if err := s.db.Collection("playlists").FindOne(ctx, bson.M{"_id": id}).Decode(&p); err != nil {
+		return nil, ErrNotFound
+	}
+	return &p, nil
 }
 
 func (s *Store) ListPlaylists(userID primitive.ObjectID) []*models.Playlist {
@@ -297,6 +841,13 @@ func (s *Store) AddTrackToPlaylist(userID, playlistID, trackID primitive.ObjectI
 	if p.UserID != userID {
 		return ErrForbidden
 	}
+	n, err := s.db.Collection("tracks").CountDocuments(ctx, bson.M{"_id": trackID})
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
 
 	pt := &models.PlaylistTrack{
 		ID:         primitive.NewObjectID(),
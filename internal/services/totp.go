@@ -0,0 +1,265 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"YeahMusic/internal/models"
+)
+
+// RFC 6238 with the parameters every authenticator app understands:
+// HMAC-SHA1, six digits, 30 second steps. One step of drift either way is
+// accepted.
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	totpSkew   = 1
+
+	recoveryCodeCount = 10
+	mfaChallengeTTL   = 5 * time.Minute
+)
+
+const PurposeMFALogin = "mfa_login"
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFAChallenge is the error Login returns when the password was right but
+// the account has two-factor authentication on. The login is finished with
+// VerifyMFA and the challenge token.
+type MFAChallenge struct {
+	Token     string
+	ExpiresAt time.Time
+}
+
+func (c *MFAChallenge) Error() string { return "two-factor code required" }
+
+func newTOTPSecret() string {
+	b := make([]byte, 20)
+	_, _ = rand.Read(b)
+	return b32.EncodeToString(b)
+}
+
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	n := binary.BigEndian.Uint32(sum[off:off+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%1000000)
+}
+
+// matchTOTP returns the time step the code belongs to.
+func matchTOTP(secret, code string, now time.Time) (int64, bool) {
+	key, err := b32.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	cur := now.Unix() / totpPeriod
+	for step := cur - totpSkew; step <= cur+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func totpURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	q := url.Values{
+		"secret":    {secret},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(totpDigits)},
+		"period":    {fmt.Sprint(totpPeriod)},
+	}
+	return "otpauth://totp/" + label + "?" + q.Encode()
+}
+
+// Recovery codes look like "3f9a-c01b" and are compared without the dash
+// and case-insensitively.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+func newRecoveryCodes() (codes, hashes []string) {
+	for i := 0; i < recoveryCodeCount; i++ {
+		c := genToken(4)
+		codes = append(codes, c[:4]+"-"+c[4:])
+		hashes = append(hashes, hashToken(c))
+	}
+	return codes, hashes
+}
+
+// FinishLogin runs after the first factor was accepted, by password or by
+// an identity provider. It returns an *MFAChallenge instead of a session
+// when u has two-factor authentication on.
+func (a *AuthService) FinishLogin(u *models.User, client ClientInfo) (*models.Session, *models.User, error) {
+	if u.TOTPEnabled {
+		t := &models.EmailToken{UserID: u.ID, Purpose: PurposeMFALogin, Token: genToken(32)}
+		t.TokenHash = hashToken(t.Token)
+		t.ExpiresAt = a.store.Now().Add(mfaChallengeTTL)
+		if _, err := a.store.CreateEmailToken(t); err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, &MFAChallenge{Token: t.Token, ExpiresAt: t.ExpiresAt}
+	}
+	sec, err := a.StartSession(u.ID, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sec, u, nil
+}
+
+// checkSecondFactor accepts a current TOTP code or an unused recovery code.
+// Both are single use.
+func (a *AuthService) checkSecondFactor(u *models.User, code string) error {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if u.TOTPSecret == "" || code == "" {
+		return ErrUnauthorized
+	}
+	if len(code) == totpDigits {
+		step, ok := matchTOTP(u.TOTPSecret, code, a.store.Now())
+		if !ok || a.store.UseTOTPStep(u.ID, step) != nil {
+			return ErrUnauthorized
+		}
+		return nil
+	}
+	if a.store.UseRecoveryCode(u.ID, hashToken(normalizeRecoveryCode(code))) != nil {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+// VerifyMFA finishes a login that got an MFAChallenge. A challenge is
+// consumed by the first attempt, right or wrong, so guessing codes costs a
+// password check each time, and a wrong code counts towards the lockout
+// like a wrong password.
+func (a *AuthService) VerifyMFA(token, code string, client ClientInfo) (*models.Session, *models.User, error) {
+	t, err := a.store.ConsumeEmailToken(PurposeMFALogin, hashToken(token), a.store.Now())
+	if err != nil {
+		return nil, nil, ErrUnauthorized
+	}
+	u, err := a.store.GetUserByID(t.UserID)
+	if err != nil {
+		return nil, nil, ErrUnauthorized
+	}
+	if err := a.lockout.Check(context.Background(), u.Email); err != nil {
+		return nil, nil, err
+	}
+	if u.TOTPEnabled {
+		if err := a.checkSecondFactor(u, code); err != nil {
+			return nil, nil, a.loginFailed(u.Email)
+		}
+	}
+	sec, err := a.StartSession(u.ID, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	a.lockout.Success(context.Background(), u.Email)
+	return sec, u, nil
+}
+
+// BeginTOTP stores a new pending secret and returns it with the otpauth://
+// URI for the QR code. Nothing changes for logins until EnableTOTP.
+func (a *AuthService) BeginTOTP(u *models.User) (secret, uri string, err error) {
+	if u.TOTPEnabled {
+		return "", "", ErrAlreadyExists
+	}
+	secret = newTOTPSecret()
+	if err := a.store.SetTOTP(u.ID, secret, false, nil); err != nil {
+		return "", "", err
+	}
+	issuer := strings.TrimSpace(os.Getenv("TOTP_ISSUER"))
+	if issuer == "" {
+		issuer = "YeahMusic"
+	}
+	return secret, totpURI(issuer, u.Email, secret), nil
+}
+
+// EnableTOTP confirms the pending secret with a code from the app and
+// returns the recovery codes; they are not shown again.
+func (a *AuthService) EnableTOTP(u *models.User, code string) ([]string, error) {
+	u, err := a.store.GetUserByID(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if u.TOTPEnabled {
+		return nil, ErrAlreadyExists
+	}
+	if u.TOTPSecret == "" {
+		return nil, ErrNotFound
+	}
+	step, ok := matchTOTP(u.TOTPSecret, strings.TrimSpace(code), a.store.Now())
+	if !ok || a.store.UseTOTPStep(u.ID, step) != nil {
+		return nil, ErrUnauthorized
+	}
+	codes, hashes := newRecoveryCodes()
+	if err := a.store.SetTOTP(u.ID, u.TOTPSecret, true, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP needs a second factor as well and is refused for roles that
+// must use one.
+func (a *AuthService) DisableTOTP(u *models.User, code string) error {
+	u, err := a.store.GetUserByID(u.ID)
+	if err != nil {
+		return err
+	}
+	if !u.TOTPEnabled {
+		return ErrNotFound
+	}
+	if slices.Contains(a.mfaRoles, u.Role) {
+		return ErrForbidden
+	}
+	if err := a.checkSecondFactor(u, code); err != nil {
+		return err
+	}
+	return a.store.SetTOTP(u.ID, "", false, nil)
+}
+
+func (a *AuthService) RegenerateRecoveryCodes(u *models.User, code string) ([]string, error) {
+	u, err := a.store.GetUserByID(u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !u.TOTPEnabled {
+		return nil, ErrNotFound
+	}
+	if err := a.checkSecondFactor(u, code); err != nil {
+		return nil, err
+	}
+	codes, hashes := newRecoveryCodes()
+	if err := a.store.SetTOTP(u.ID, u.TOTPSecret, true, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func mfaRolesFromEnv() []string {
+	v, ok := os.LookupEnv("MFA_REQUIRED_ROLES")
+	if !ok {
+		return []string{RoleAdmin}
+	}
+	var roles []string
+	for _, r := range strings.Split(v, ",") {
+		if r = strings.TrimSpace(r); ValidRole(r) && !slices.Contains(roles, r) {
+			roles = append(roles, r)
+		}
+	}
+	return roles
+}
@@ -0,0 +1,45 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA-1 vectors of RFC 6238 appendix B, cut to the six digits used here.
+var rfc6238 = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCode(t *testing.T) {
+	key := []byte("12345678901234567890")
+	for _, tc := range rfc6238 {
+		if got := totpCode(key, tc.unix/totpPeriod); got != tc.code {
+			t.Errorf("T=%d: code %s, want %s", tc.unix, got, tc.code)
+		}
+	}
+}
+
+func TestMatchTOTP(t *testing.T) {
+	secret := b32.EncodeToString([]byte("12345678901234567890"))
+	for _, tc := range rfc6238 {
+		step := tc.unix / totpPeriod
+		for _, drift := range []int64{-totpPeriod, 0, totpPeriod} {
+			now := time.Unix(tc.unix+drift, 0)
+			got, ok := matchTOTP(secret, tc.code, now)
+			if !ok || got != step {
+				t.Errorf("T=%d%+d: step %d, %v, want %d", tc.unix, drift, got, ok, step)
+			}
+		}
+		if _, ok := matchTOTP(secret, tc.code, time.Unix(tc.unix+3*totpPeriod, 0)); ok {
+			t.Errorf("T=%d: code accepted three steps late", tc.unix)
+		}
+	}
+}
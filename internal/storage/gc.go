@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Orphan is a blob no stored URL refers to.
+type Orphan = Info
+
+type Report struct {
+	DryRun  bool
+	Scanned int
+	// Referenced counts the files in use, with the files derived from them.
+	Referenced int
+	// Young are orphans still within their grace period.
+	Young []Orphan
+	// Removed are the orphans deleted, or the ones that would be on a dry
+	// run.
+	Removed []Orphan
+	Freed   int64
+}
+
+// Collect removes the blobs of s that none of refs (stored URLs and how
+// often each is used) points at and that have not been written for grace.
+// The grace period covers uploads whose rows are not saved yet. Files
+// derived from a blob, like cover variants and waveforms, are named
+// <stem>_<what> after it and go with it. On a dry run nothing is removed.
+func Collect(s BlobStore, refs map[string]int, grace time.Duration, dryRun bool) (*Report, error) {
+	used := map[string]bool{}
+	for url, n := range refs {
+		name, ok := Name(url)
+		if !ok || n <= 0 {
+			continue
+		}
+		used[name] = true
+		used[strings.TrimSuffix(name, filepath.Ext(name))+"_"] = true
+	}
+
+	rep := &Report{DryRun: dryRun}
+	cutoff := time.Now().Add(-grace)
+	err := s.List(func(o Orphan) error {
+		rep.Scanned++
+		if used[o.Name] || derivedFromUsed(o.Name, used) {
+			rep.Referenced++
+			return nil
+		}
+		if o.ModTime.After(cutoff) {
+			rep.Young = append(rep.Young, o)
+			return nil
+		}
+		if !dryRun {
+			if err := s.Delete(o.Name); err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+		}
+		rep.Removed = append(rep.Removed, o)
+		rep.Freed += o.Size
+		return nil
+	})
+	sort.Slice(rep.Removed, func(i, j int) bool { return rep.Removed[i].Name < rep.Removed[j].Name })
+	if err != nil && rep.Scanned == 0 {
+		return nil, err
+	}
+	return rep, err
+}
+
+// derivedFromUsed reports whether name is <stem>_<what> for a stem in used.
+// Stems may have underscores of their own, so every one is tried.
+func derivedFromUsed(name string, used map[string]bool) bool {
+	for i := range len(name) {
+		if name[i] == '_' && used[name[:i+1]] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCollect(t *testing.T) {
+	dir := t.TempDir()
+	s := NewLocal(dir)
+	names := []string{
+		"abc_audio.mp3", "abc_audio_waveform.json", "abc_audio_256.jpg",
+		"cover.png", "cover_64.jpg",
+		"abc.mp3", "abc_128.jpg", "gone.jpg", "gone_64.jpg",
+	}
+	old := time.Now().Add(-time.Hour)
+	for _, name := range names {
+		if err := s.WriteFile(name, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.WriteFile("fresh.mp3", []byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	refs := map[string]int{
+		"/uploads/abc_audio.mp3": 1,
+		"/uploads/cover.png":     2,
+		"/uploads/gone.jpg":      0,
+	}
+
+	rep, err := Collect(s, refs, time.Minute, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var removed []string
+	for _, o := range rep.Removed {
+		removed = append(removed, o.Name)
+	}
+	// abc_ is not a stem in use, only abc_audio_ is.
+	want := []string{"abc.mp3", "abc_128.jpg", "gone.jpg", "gone_64.jpg"}
+	if !slices.Equal(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	if rep.Referenced != 5 || len(rep.Young) != 1 || rep.Scanned != 10 {
+		t.Errorf("report %+v", rep)
+	}
+	if _, err := s.Stat("abc.mp3"); err != nil {
+		t.Errorf("a dry run removed a file: %v", err)
+	}
+
+	if _, err := Collect(s, refs, time.Minute, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		_, err := s.Stat(name)
+		if kept := !slices.Contains(want, name); kept != (err == nil) {
+			t.Errorf("%s kept %v, stat %v", name, kept, err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+// Package storage keeps uploads content-addressed: a file is named by the
+// SHA-256 of its bytes, so the same upload is stored once, and a file that
+// no track, album or playlist refers to any more can be found and removed.
+// Blobs live on the local disk or in an S3-compatible bucket.
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Local stores blobs as files in Dir, which is served under /uploads/.
+type Local struct {
+	Dir string
+	now func() time.Time
+}
+
+func NewLocal(dir string) *Local {
+	return &Local{Dir: dir, now: time.Now}
+}
+
+// Create writes to a temporary file in Dir, which Commit renames. When the
+// blob is there already the copy is dropped and the existing file's time is
+// renewed.
+func (l *Local) Create() (Writer, error) {
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return newSpool(l.Dir, tmpPrefix+"*", func(f *os.File, size int64, sum, name string) error {
+		if err := f.Close(); err != nil {
+			return err
+		}
+		dst := l.Path(name)
+		if _, err := os.Stat(dst); err == nil {
+			now := l.now()
+			return os.Chtimes(dst, now, now)
+		}
+		if err := os.Chmod(f.Name(), 0644); err != nil {
+			return err
+		}
+		return os.Rename(f.Name(), dst)
+	})
+}
+
+// WriteFile writes through a temporary file so nothing is served half
+// written.
+func (l *Local) WriteFile(name string, data []byte) error {
+	if err := os.MkdirAll(l.Dir, 0755); err != nil {
+		return err
+	}
+	tmp := l.Path(tmpPrefix + name)
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, l.Path(name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (l *Local) Get(name string) (io.ReadSeekCloser, Info, error) {
+	f, err := os.Open(l.Path(name))
+	if err != nil {
+		return nil, Info{}, err
+	}
+	fi, err := f.Stat()
+	if err == nil && !fi.Mode().IsRegular() {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		f.Close()
+		return nil, Info{}, err
+	}
+	return f, fileInfo(fi), nil
+}
+
+func (l *Local) Stat(name string) (Info, error) {
+	fi, err := os.Stat(l.Path(name))
+	if err != nil {
+		return Info{}, err
+	}
+	if !fi.Mode().IsRegular() {
+		return Info{}, os.ErrNotExist
+	}
+	return fileInfo(fi), nil
+}
+
+func (l *Local) Delete(name string) error {
+	return os.Remove(l.Path(name))
+}
+
+// List skips dot files other than unfinished Puts.
+func (l *Local) List(fn func(Info) error) error {
+	entries, err := os.ReadDir(l.Dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Type().IsRegular() || strings.HasPrefix(e.Name(), ".") && !strings.HasPrefix(e.Name(), tmpPrefix) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue // gone meanwhile
+		}
+		if err := fn(fileInfo(fi)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Presign returns the path the server serves the blob at. The link is
+// signed like every other local media URL, by the media signer.
+func (l *Local) Presign(name string, ttl time.Duration) (string, error) {
+	return "/uploads/" + name, nil
+}
+
+// Path is the file of the blob called name.
+func (l *Local) Path(name string) string {
+	return filepath.Join(l.Dir, name)
+}
+
+func fileInfo(fi os.FileInfo) Info {
+	return Info{Name: fi.Name(), Size: fi.Size(), ModTime: fi.ModTime()}
+}
+
+// tmpPrefix marks uploads still being written; Collect removes the ones a
+// crash left behind.
+const tmpPrefix = ".put-"
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MockS3 is a small S3 stand-in for local development and tests: one
+// bucket in memory, path-style addressing, and just the calls the S3 driver
+// makes. Requests have to be signed with its keys.
+type MockS3 struct {
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Region    string
+
+	mu      sync.Mutex
+	objects map[string]*mockObject
+	now     func() time.Time
+}
+
+type mockObject struct {
+	data        []byte
+	contentType string
+	modTime     time.Time
+}
+
+func NewMockS3(bucket, accessKey, secretKey string) *MockS3 {
+	return &MockS3{
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Region:    "us-east-1",
+		objects:   map[string]*mockObject{},
+		now:       time.Now,
+	}
+}
+
+func (m *MockS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	if bucket != m.Bucket {
+		mockErr(w, http.StatusNotFound, "NoSuchBucket", "the bucket does not exist")
+		return
+	}
+	body, ok := m.authorize(w, r)
+	if !ok {
+		return
+	}
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		m.list(w, r)
+	case key == "":
+		mockErr(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "not supported on the bucket")
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		m.copy(w, r, key)
+	case r.Method == http.MethodPut:
+		m.mu.Lock()
+		m.objects[key] = &mockObject{data: body, contentType: r.Header.Get("Content-Type"), modTime: m.now()}
+		m.mu.Unlock()
+		w.Header().Set("ETag", etag(body))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		m.mu.Lock()
+		o := m.objects[key]
+		m.mu.Unlock()
+		if o == nil {
+			mockErr(w, http.StatusNotFound, "NoSuchKey", "the key does not exist")
+			return
+		}
+		if o.contentType != "" {
+			w.Header().Set("Content-Type", o.contentType)
+		}
+		w.Header().Set("ETag", etag(o.data))
+		http.ServeContent(w, r, "", o.modTime, bytes.NewReader(o.data))
+	case r.Method == http.MethodDelete:
+		m.mu.Lock()
+		delete(m.objects, key)
+		m.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		mockErr(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "not supported")
+	}
+}
+
+// authorize checks the signature in the Authorization header or, for
+// presigned URLs, the query, and returns the body checked against its
+// declared hash.
+func (m *MockS3) authorize(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	creds := credentials{AccessKey: m.AccessKey, SecretKey: m.SecretKey, Region: m.Region}
+	q := r.URL.Query()
+	var cred, date, signed, sig, payloadHash string
+	if auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), sigAlgorithm+" "); ok {
+		for _, part := range strings.Split(auth, ",") {
+			k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch k {
+			case "Credential":
+				cred = v
+			case "SignedHeaders":
+				signed = v
+			case "Signature":
+				sig = v
+			}
+		}
+		date, payloadHash = r.Header.Get("X-Amz-Date"), r.Header.Get("X-Amz-Content-Sha256")
+	} else if q.Get("X-Amz-Algorithm") == sigAlgorithm {
+		cred, date, signed, sig = q.Get("X-Amz-Credential"), q.Get("X-Amz-Date"), q.Get("X-Amz-SignedHeaders"), q.Get("X-Amz-Signature")
+		payloadHash = unsignedPayload
+	} else {
+		mockErr(w, http.StatusForbidden, "AccessDenied", "request is not signed")
+		return nil, false
+	}
+
+	t, err := time.Parse(amzDateFormat, date)
+	if err != nil || cred != m.AccessKey+"/"+creds.scope(t) {
+		mockErr(w, http.StatusForbidden, "InvalidAccessKeyId", "unknown key or scope")
+		return nil, false
+	}
+	if q.Has("X-Amz-Expires") {
+		secs, _ := strconv.Atoi(q.Get("X-Amz-Expires"))
+		if !m.now().Before(t.Add(time.Duration(secs) * time.Second)) {
+			mockErr(w, http.StatusForbidden, "AccessDenied", "request has expired")
+			return nil, false
+		}
+	}
+	canon := canonicalRequest(r.Method, r.URL.EscapedPath(), q, r.Host, r.Header, strings.Split(signed, ";"), payloadHash)
+	if !hmac.Equal([]byte(sig), []byte(creds.signature(t, canon))) {
+		mockErr(w, http.StatusForbidden, "SignatureDoesNotMatch", "signature does not match")
+		return nil, false
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		mockErr(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return nil, false
+	}
+	if sum := sha256.Sum256(body); payloadHash != unsignedPayload && payloadHash != hex.EncodeToString(sum[:]) {
+		mockErr(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "body does not match its hash")
+		return nil, false
+	}
+	return body, true
+}
+
+func (m *MockS3) copy(w http.ResponseWriter, r *http.Request, key string) {
+	src, _ := url.PathUnescape(r.Header.Get("X-Amz-Copy-Source"))
+	srcBucket, srcKey, _ := strings.Cut(strings.TrimPrefix(src, "/"), "/")
+	m.mu.Lock()
+	o := m.objects[srcKey]
+	if srcBucket != m.Bucket || o == nil {
+		m.mu.Unlock()
+		mockErr(w, http.StatusNotFound, "NoSuchKey", "the copy source does not exist")
+		return
+	}
+	if srcKey == key && r.Header.Get("X-Amz-Metadata-Directive") != "REPLACE" {
+		m.mu.Unlock()
+		mockErr(w, http.StatusBadRequest, "InvalidRequest", "copying an object onto itself needs new metadata")
+		return
+	}
+	c := &mockObject{data: o.data, contentType: o.contentType, modTime: m.now()}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		c.contentType = ct
+	}
+	m.objects[key] = c
+	m.mu.Unlock()
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string
+		LastModified string
+	}{ETag: etag(c.data), LastModified: c.modTime.UTC().Format(time.RFC3339)})
+}
+
+// list answers ListObjectsV2; the continuation token is the last key sent.
+func (m *MockS3) list(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("list-type") != "2" {
+		mockErr(w, http.StatusNotImplemented, "NotImplemented", "only ListObjectsV2 is supported")
+		return
+	}
+	max, err := strconv.Atoi(q.Get("max-keys"))
+	if err != nil || max <= 0 || max > 1000 {
+		max = 1000
+	}
+	prefix, after := q.Get("prefix"), q.Get("continuation-token")
+
+	type content struct {
+		Key          string
+		LastModified string
+		Size         int
+	}
+	res := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		KeyCount              int
+		MaxKeys               int
+		IsTruncated           bool
+		Contents              []content
+		NextContinuationToken string `xml:",omitempty"`
+	}{Name: m.Bucket, Prefix: prefix, MaxKeys: max}
+
+	m.mu.Lock()
+	keys := make([]string, 0, len(m.objects))
+	for k := range m.objects {
+		if strings.HasPrefix(k, prefix) && k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	if len(keys) > max {
+		keys, res.IsTruncated = keys[:max], true
+		res.NextContinuationToken = keys[max-1]
+	}
+	for _, k := range keys {
+		o := m.objects[k]
+		res.Contents = append(res.Contents, content{k, o.modTime.UTC().Format(time.RFC3339), len(o.data)})
+	}
+	m.mu.Unlock()
+	res.KeyCount = len(res.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(res)
+}
+
+func etag(b []byte) string {
+	sum := md5.Sum(b)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func mockErr(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}{Code: code, Message: msg})
+}
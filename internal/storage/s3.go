@@ -0,0 +1,371 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type S3Config struct {
+	// Endpoint is the base URL of the service, e.g. https://s3.eu-west-1.amazonaws.com
+	// or http://localhost:9000.
+	Endpoint string
+	// PublicEndpoint is used in presigned URLs when clients reach the
+	// service under another address than the server does.
+	PublicEndpoint string
+	Region         string
+	Bucket         string
+	// Prefix is put in front of every key.
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	// VirtualHost puts the bucket in the host name instead of the path.
+	VirtualHost bool
+}
+
+// S3ConfigFromEnv reads S3_ENDPOINT, S3_PUBLIC_ENDPOINT, S3_REGION
+// (us-east-1), S3_BUCKET, S3_PREFIX, S3_VIRTUAL_HOST and the keys from
+// S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY, or AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY.
+func S3ConfigFromEnv() S3Config {
+	env := func(keys ...string) string {
+		for _, k := range keys {
+			if v := strings.TrimSpace(os.Getenv(k)); v != "" {
+				return v
+			}
+		}
+		return ""
+	}
+	cfg := S3Config{
+		Endpoint:       env("S3_ENDPOINT"),
+		PublicEndpoint: env("S3_PUBLIC_ENDPOINT"),
+		Region:         env("S3_REGION", "AWS_REGION"),
+		Bucket:         env("S3_BUCKET"),
+		Prefix:         env("S3_PREFIX"),
+		AccessKey:      env("S3_ACCESS_KEY_ID", "AWS_ACCESS_KEY_ID"),
+		SecretKey:      env("S3_SECRET_ACCESS_KEY", "AWS_SECRET_ACCESS_KEY"),
+	}
+	cfg.VirtualHost, _ = strconv.ParseBool(env("S3_VIRTUAL_HOST"))
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	return cfg
+}
+
+// S3 stores blobs as objects of a bucket on S3 or a service speaking its
+// API. Clients fetch them through presigned URLs.
+type S3 struct {
+	cfg      S3Config
+	base     *url.URL
+	public   *url.URL
+	creds    credentials
+	client   *http.Client
+	now      func() time.Time
+	pageSize int
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Bucket == "" || cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, errors.New("s3: bucket and keys are required")
+	}
+	base, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || base.Host == "" {
+		return nil, fmt.Errorf("s3: bad endpoint %q", cfg.Endpoint)
+	}
+	public := base
+	if cfg.PublicEndpoint != "" {
+		if public, err = url.Parse(strings.TrimRight(cfg.PublicEndpoint, "/")); err != nil || public.Host == "" {
+			return nil, fmt.Errorf("s3: bad public endpoint %q", cfg.PublicEndpoint)
+		}
+	}
+	if cfg.Prefix != "" {
+		cfg.Prefix = strings.Trim(cfg.Prefix, "/") + "/"
+	}
+	return &S3{
+		cfg:      cfg,
+		base:     base,
+		public:   public,
+		creds:    credentials{AccessKey: cfg.AccessKey, SecretKey: cfg.SecretKey, Region: cfg.Region},
+		client:   &http.Client{Timeout: 5 * time.Minute},
+		now:      time.Now,
+		pageSize: 1000,
+	}, nil
+}
+
+// objectURL is where the object of a blob lives; "" is the bucket.
+func (s *S3) objectURL(base *url.URL, name string) *url.URL {
+	u := *base
+	key := ""
+	if name != "" {
+		key = s.cfg.Prefix + name
+	}
+	if s.cfg.VirtualHost {
+		u.Host = s.cfg.Bucket + "." + u.Host
+		u.Path += "/" + key
+	} else {
+		u.Path += "/" + s.cfg.Bucket + "/" + key
+	}
+	return &u
+}
+
+// S3Error is an error answer of the service.
+type S3Error struct {
+	Status  int
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *S3Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+// do signs and sends a request. body has the SHA-256 payloadHash; a 404 is
+// os.ErrNotExist, and other failures an *S3Error.
+func (s *S3) do(method string, u *url.URL, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.creds.sign(req, payloadHash, s.now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+	e := &S3Error{Status: resp.StatusCode}
+	_ = xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(e)
+	return nil, e
+}
+
+// Create spools to a temporary file to learn the hash and length, which the
+// upload needs up front. A blob that exists is copied onto itself, which
+// renews its modification time.
+func (s *S3) Create() (Writer, error) {
+	return newSpool("", "blob-*", func(f *os.File, size int64, sum, name string) error {
+		if _, err := s.Stat(name); err == nil {
+			return s.touch(name)
+		}
+		return s.put(name, io.NewSectionReader(f, 0, size), size, sum)
+	})
+}
+
+func (s *S3) WriteFile(name string, data []byte) error {
+	sum := sha256.Sum256(data)
+	return s.put(name, bytes.NewReader(data), int64(len(data)), hex.EncodeToString(sum[:]))
+}
+
+func (s *S3) put(name string, body io.Reader, size int64, sum string) error {
+	h := http.Header{}
+	if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	resp, err := s.do(http.MethodPut, s.objectURL(s.base, name), h, body, size, sum)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// touch copies an object onto itself; S3 only allows that when the
+// metadata is replaced.
+func (s *S3) touch(name string) error {
+	h := http.Header{}
+	src := url.URL{Path: "/" + s.cfg.Bucket + "/" + s.cfg.Prefix + name}
+	h.Set("X-Amz-Copy-Source", src.EscapedPath())
+	h.Set("X-Amz-Metadata-Directive", "REPLACE")
+	if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	resp, err := s.do(http.MethodPut, s.objectURL(s.base, name), h, nil, 0, emptyHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// A failed copy can come back as a 200 with an error document.
+	e := &S3Error{Status: resp.StatusCode}
+	if xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(e) == nil && e.Code != "" {
+		return e
+	}
+	return nil
+}
+
+func (s *S3) Stat(name string) (Info, error) {
+	resp, err := s.do(http.MethodHead, s.objectURL(s.base, name), nil, nil, 0, emptyHash)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	mod, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Info{Name: name, Size: resp.ContentLength, ModTime: mod}, nil
+}
+
+// Get returns a reader that fetches the object with range requests from
+// wherever it is seeked to, so serving a range reads only that range.
+func (s *S3) Get(name string) (io.ReadSeekCloser, Info, error) {
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, Info{}, err
+	}
+	return &s3Reader{s: s, name: name, size: info.Size}, info, nil
+}
+
+type s3Reader struct {
+	s    *S3
+	name string
+	size int64
+	off  int64
+	body io.ReadCloser
+}
+
+func (r *s3Reader) Read(p []byte) (int, error) {
+	if r.off >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		h := http.Header{}
+		h.Set("Range", "bytes="+strconv.FormatInt(r.off, 10)+"-")
+		resp, err := r.s.do(http.MethodGet, r.s.objectURL(r.s.base, r.name), h, nil, 0, emptyHash)
+		if err != nil {
+			return 0, err
+		}
+		r.body = resp.Body
+	}
+	n, err := r.body.Read(p)
+	r.off += int64(n)
+	if err == io.EOF && r.off < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.off
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+	if offset != r.off {
+		r.Close()
+		r.off = offset
+	}
+	return offset, nil
+}
+
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+func (s *S3) Delete(name string) error {
+	if _, err := s.Stat(name); err != nil {
+		return err // S3 deletes what is not there without complaint
+	}
+	resp, err := s.do(http.MethodDelete, s.objectURL(s.base, name), nil, nil, 0, emptyHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List walks the objects under the prefix. Keys with a slash after it are
+// not blobs and are skipped.
+func (s *S3) List(fn func(Info) error) error {
+	token := ""
+	for {
+		u := s.objectURL(s.base, "")
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("max-keys", strconv.Itoa(s.pageSize))
+		if s.cfg.Prefix != "" {
+			q.Set("prefix", s.cfg.Prefix)
+		}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		u.RawQuery = canonicalQuery(q)
+		resp, err := s.do(http.MethodGet, u, nil, nil, 0, emptyHash)
+		if err != nil {
+			return err
+		}
+		var res listResult
+		err = xml.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		for _, c := range res.Contents {
+			name := strings.TrimPrefix(c.Key, s.cfg.Prefix)
+			if name == "" || strings.Contains(name, "/") {
+				continue
+			}
+			if err := fn(Info{Name: name, Size: c.Size, ModTime: c.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return nil
+		}
+		token = res.NextContinuationToken
+	}
+}
+
+// Presign signs a GET of the object. The signing time snaps to a quarter of
+// ttl, as media links do, so a client sees the same URL for a while and
+// can cache what it fetched.
+func (s *S3) Presign(name string, ttl time.Duration) (string, error) {
+	step := ttl / 4
+	if step < time.Second {
+		step = time.Second
+	}
+	// S3 takes at most a week.
+	exp := min(ttl+step, 7*24*time.Hour)
+	t := s.now().Truncate(step)
+	return s.creds.presign(http.MethodGet, s.objectURL(s.public, name), t, exp), nil
+}
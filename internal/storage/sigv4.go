@@ -0,0 +1,157 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4, as far as S3 needs it: signed headers for the
+// calls the driver makes, signed queries for presigned URLs.
+
+const (
+	sigAlgorithm    = "AWS4-HMAC-SHA256"
+	amzDateFormat   = "20060102T150405Z"
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// emptyHash is the SHA-256 of an empty body.
+var emptyHash = hex.EncodeToString(sha256.New().Sum(nil))
+
+type credentials struct {
+	AccessKey string
+	SecretKey string
+	Region    string
+}
+
+func (c credentials) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + c.Region + "/s3/aws4_request"
+}
+
+func (c credentials) signature(t time.Time, canonical string) string {
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := sigAlgorithm + "\n" + t.Format(amzDateFormat) + "\n" + c.scope(t) + "\n" + hex.EncodeToString(sum[:])
+	k := hmacSHA256([]byte("AWS4"+c.SecretKey), t.Format("20060102"))
+	k = hmacSHA256(k, c.Region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(k, toSign))
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(s))
+	return m.Sum(nil)
+}
+
+// sign adds the Authorization header to req, whose body has the SHA-256
+// payloadHash. Host and the x-amz-* headers are signed.
+func (c credentials) sign(req *http.Request, payloadHash string, now time.Time) {
+	t := now.UTC()
+	req.Header.Set("X-Amz-Date", t.Format(amzDateFormat))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signed := signedHeaders(req.Header)
+	canon := canonicalRequest(req.Method, req.URL.EscapedPath(), req.URL.Query(), req.URL.Host, req.Header, signed, payloadHash)
+	req.Header.Set("Authorization", sigAlgorithm+" Credential="+c.AccessKey+"/"+c.scope(t)+
+		", SignedHeaders="+strings.Join(signed, ";")+", Signature="+c.signature(t, canon))
+}
+
+// presign returns u with a signed query valid for ttl from t.
+func (c credentials) presign(method string, u *url.URL, t time.Time, ttl time.Duration) string {
+	t = t.UTC()
+	q := u.Query()
+	q.Set("X-Amz-Algorithm", sigAlgorithm)
+	q.Set("X-Amz-Credential", c.AccessKey+"/"+c.scope(t))
+	q.Set("X-Amz-Date", t.Format(amzDateFormat))
+	q.Set("X-Amz-Expires", strconv.Itoa(int(ttl.Seconds())))
+	q.Set("X-Amz-SignedHeaders", "host")
+	canon := canonicalRequest(method, u.EscapedPath(), q, u.Host, nil, []string{"host"}, unsignedPayload)
+	res := *u
+	res.RawQuery = canonicalQuery(q) + "&X-Amz-Signature=" + c.signature(t, canon)
+	return res.String()
+}
+
+// signedHeaders are the lower-cased x-amz-* headers and host, sorted.
+func signedHeaders(h http.Header) []string {
+	res := []string{"host"}
+	for k := range h {
+		if k := strings.ToLower(k); strings.HasPrefix(k, "x-amz-") {
+			res = append(res, k)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+func canonicalRequest(method, path string, q url.Values, host string, h http.Header, signed []string, payloadHash string) string {
+	var b strings.Builder
+	b.WriteString(method + "\n")
+	b.WriteString(canonicalPath(path) + "\n")
+	b.WriteString(canonicalQuery(q) + "\n")
+	for _, k := range signed {
+		v := host
+		if k != "host" {
+			v = strings.Join(h.Values(k), ",")
+		}
+		b.WriteString(k + ":" + strings.TrimSpace(v) + "\n")
+	}
+	b.WriteString("\n" + strings.Join(signed, ";") + "\n" + payloadHash)
+	return b.String()
+}
+
+// canonicalPath encodes every segment of the escaped path once, the way
+// S3 wants it.
+func canonicalPath(escaped string) string {
+	if escaped == "" {
+		return "/"
+	}
+	segs := strings.Split(escaped, "/")
+	for i, s := range segs {
+		if u, err := url.PathUnescape(s); err == nil {
+			s = u
+		}
+		segs[i] = uriEncode(s)
+	}
+	return strings.Join(segs, "/")
+}
+
+// canonicalQuery sorts and encodes q, leaving out the signature of a
+// presigned URL.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		if k != "X-Amz-Signature" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vs := append([]string(nil), q[k]...)
+		sort.Strings(vs)
+		for _, v := range vs {
+			parts = append(parts, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode percent-encodes everything but the unreserved characters.
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+		}
+	}
+	return b.String()
+}
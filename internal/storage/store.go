@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"YeahMusic/internal/imaging"
+)
+
+// BlobStore keeps uploads under flat names. Stored rows refer to a blob as
+// /uploads/<name> whichever driver holds it; URL turns that into something
+// a client can fetch. A missing blob is reported as os.ErrNotExist.
+type BlobStore interface {
+	// Create starts a blob, which Put does in one go.
+	Create() (Writer, error)
+	// WriteFile stores data under name, for files derived from a blob.
+	WriteFile(name string, data []byte) error
+	// Get opens a blob; the caller closes it.
+	Get(name string) (io.ReadSeekCloser, Info, error)
+	Stat(name string) (Info, error)
+	Delete(name string) error
+	// List calls fn for every blob, including unfinished Puts.
+	List(fn func(Info) error) error
+	// Presign returns a URL the blob can be fetched from for ttl.
+	Presign(name string, ttl time.Duration) (string, error)
+}
+
+type Info struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// FromEnv picks the driver: BLOB_STORE=local (the default) keeps files in
+// dir, s3 in a bucket, see S3ConfigFromEnv.
+func FromEnv(dir string) (BlobStore, error) {
+	switch d := strings.TrimSpace(os.Getenv("BLOB_STORE")); d {
+	case "", "local":
+		return NewLocal(dir), nil
+	case "s3":
+		return NewS3(S3ConfigFromEnv())
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", d)
+	}
+}
+
+// Name returns the blob a stored URL (/uploads/<name>) points at.
+func Name(url string) (string, bool) {
+	name, ok := strings.CutPrefix(url, "/uploads/")
+	name, _, _ = strings.Cut(name, "?")
+	if !ok || name == "" || strings.ContainsAny(name, `/\`) {
+		return "", false
+	}
+	return name, true
+}
+
+// ReaderAt reads a blob at offsets by seeking, which costs nothing for the
+// sequential reads of audio.Probe. It is not safe for concurrent use.
+func ReaderAt(r io.ReadSeeker) io.ReaderAt { return readerAt{r} }
+
+type readerAt struct{ io.ReadSeeker }
+
+func (r readerAt) ReadAt(p []byte, off int64) (int, error) {
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(r, p)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// URL is where a client fetches the stored URL raw: the driver's URL for a
+// blob, raw itself for anything else.
+func URL(s BlobStore, raw string, ttl time.Duration) string {
+	name, ok := Name(raw)
+	if !ok {
+		return raw
+	}
+	u, err := s.Presign(name, ttl)
+	if err != nil {
+		log.Println("presign", name+":", err)
+		return raw
+	}
+	return u
+}
+
+// PutImage stores an image without its metadata and makes its variants.
+// Variants that cannot be made are logged; the image is stored regardless.
+func PutImage(s BlobStore, data []byte, ext string) (string, error) {
+	if clean, err := imaging.Clean(data); err == nil {
+		data = clean
+	}
+	name, err := Put(s, bytes.NewReader(data), ext)
+	if err != nil {
+		return "", err
+	}
+	if err := writeVariants(s, name, data); err != nil && !errors.Is(err, imaging.ErrUnsupported) {
+		log.Println("cover variants", name+":", err)
+	}
+	return name, nil
+}
+
+// writeVariants stores the variants of the image name holds, leaving the
+// ones already there alone.
+func writeVariants(s BlobStore, name string, data []byte) error {
+	missing := false
+	for _, size := range imaging.Sizes {
+		if _, err := s.Stat(imaging.VariantName(name, size)); err != nil {
+			missing = true
+		}
+	}
+	if !missing {
+		return nil
+	}
+	vs, err := imaging.MakeVariants(data)
+	if err != nil {
+		return err
+	}
+	for size, b := range vs {
+		if err := s.WriteFile(imaging.VariantName(name, size), b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Variants maps the size of every stored variant of the cover at raw
+// (/uploads/<name>) to its stored URL. What is found is remembered.
+func Variants(s BlobStore, raw string) map[string]string {
+	name, ok := Name(raw)
+	if !ok || strings.Contains(raw, "?") {
+		return nil
+	}
+	if v, ok := known.Load(raw); ok {
+		return v.(map[string]string)
+	}
+	res := map[string]string{}
+	for _, size := range imaging.Sizes {
+		v := imaging.VariantName(name, size)
+		if _, err := s.Stat(v); err == nil {
+			res[strconv.Itoa(size)] = "/uploads/" + v
+		}
+	}
+	if len(res) == 0 {
+		return nil
+	}
+	known.Store(raw, res)
+	return res
+}
+
+var known sync.Map // cover URL -> map[string]string
+
+// Handler serves /uploads/<name> from s, with range support. Signatures are
+// checked in front of it.
+func Handler(s BlobStore) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := Name(r.URL.Path)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		f, info, err := s.Get(name)
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Println("uploads", name+":", err)
+			http.Error(w, "cannot read file", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+		if ct := mime.TypeByExtension(filepath.Ext(name)); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		http.ServeContent(w, r, name, info.ModTime, f)
+	})
+}
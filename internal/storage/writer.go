@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"strings"
+)
+
+// Writer is a blob being written. It is hashed as it goes and kept in a
+// temporary file, where it can be read back and checked; nothing is stored
+// until Commit.
+type Writer interface {
+	io.Writer
+	// ReadAt reads what has been written so far.
+	io.ReaderAt
+	Size() int64
+	// Sum is the SHA-256 of what has been written, in hex.
+	Sum() string
+	// Commit stores the blob as <sum><ext> and returns that name. A blob
+	// that exists is kept and its grace period for Collect restarts.
+	Commit(ext string) (string, error)
+	// Abort drops what was written; after Commit it does nothing. It is
+	// safe to defer.
+	Abort()
+}
+
+// Put stores what r holds as <sha256><ext> and returns that name.
+func Put(s BlobStore, r io.Reader, ext string) (string, error) {
+	w, err := s.Create()
+	if err != nil {
+		return "", err
+	}
+	defer w.Abort()
+	if _, err := io.Copy(w, r); err != nil {
+		return "", err
+	}
+	return w.Commit(ext)
+}
+
+// spool is the Writer of both drivers; store moves the finished file to
+// the blob called name.
+type spool struct {
+	f     *os.File
+	h     hash.Hash
+	n     int64
+	store func(f *os.File, size int64, sum, name string) error
+	done  bool
+}
+
+func newSpool(dir, pattern string, store func(f *os.File, size int64, sum, name string) error) (*spool, error) {
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return &spool{f: f, h: sha256.New(), store: store}, nil
+}
+
+func (s *spool) Write(p []byte) (int, error) {
+	n, err := s.f.Write(p)
+	s.h.Write(p[:n])
+	s.n += int64(n)
+	return n, err
+}
+
+func (s *spool) ReadAt(p []byte, off int64) (int, error) { return s.f.ReadAt(p, off) }
+func (s *spool) Size() int64                             { return s.n }
+func (s *spool) Sum() string                             { return hex.EncodeToString(s.h.Sum(nil)) }
+
+var errDone = errors.New("storage: blob already committed or aborted")
+
+func (s *spool) Commit(ext string) (string, error) {
+	if s.done {
+		return "", errDone
+	}
+	defer s.Abort()
+	sum := s.Sum()
+	name := sum + strings.ToLower(ext)
+	return name, s.store(s.f, s.n, sum, name)
+}
+
+func (s *spool) Abort() {
+	if s.done {
+		return
+	}
+	s.done = true
+	s.f.Close()
+	os.Remove(s.f.Name()) // fails harmlessly once renamed
+}
@@ -0,0 +1,78 @@
+package stream
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Event is the start of a playback.
+type Event struct {
+	TrackID primitive.ObjectID
+	// Listener tells listeners apart: a user id, or the address and user
+	// agent of an anonymous client.
+	Listener string
+	At       time.Time
+}
+
+// Sink is told about every counted play.
+type Sink interface {
+	Played(ev Event) error
+}
+
+// MinReplay is how long a listener has to wait before starting a short track
+// again counts as another play.
+const MinReplay = 30 * time.Second
+
+// Accounting turns playback starts into plays. Players ask for the first
+// byte again when the user seeks back to the start or the audio element is
+// reset, so a start only counts once the listener's previous one is a track
+// length ago. What it remembers is per process.
+type Accounting struct {
+	sink Sink
+	now  func() time.Time
+
+	mu      sync.Mutex
+	until   map[string]time.Time
+	inserts int
+}
+
+func NewAccounting(sink Sink) *Accounting {
+	return &Accounting{sink: sink, now: time.Now, until: map[string]time.Time{}}
+}
+
+// Start reports whether ev counted as a play of a track of the given length,
+// which may be unknown (zero).
+func (a *Accounting) Start(ev Event, length time.Duration) bool {
+	now := a.now()
+	if ev.At.IsZero() {
+		ev.At = now
+	}
+	if length < MinReplay {
+		length = MinReplay
+	}
+	key := ev.TrackID.Hex() + "|" + ev.Listener
+
+	a.mu.Lock()
+	if until, ok := a.until[key]; ok && now.Before(until) {
+		a.mu.Unlock()
+		return false
+	}
+	a.until[key] = now.Add(length)
+	if a.inserts++; a.inserts >= 1024 {
+		a.inserts = 0
+		for k, until := range a.until {
+			if !now.Before(until) {
+				delete(a.until, k)
+			}
+		}
+	}
+	a.mu.Unlock()
+
+	if err := a.sink.Played(ev); err != nil {
+		log.Println("count play:", err)
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+// Package stream serves track audio with HTTP range support and counts the
+// playbacks it starts.
+package stream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"YeahMusic/internal/storage"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrNotLocal = errors.New("stream: audio is not stored here")
+
+// Path is the stream endpoint of a track.
+func Path(id primitive.ObjectID) string {
+	return "/api/tracks/" + id.Hex() + "/stream"
+}
+
+// Local maps a stored audio URL, /uploads/... or /audio/..., to its file
+// under root.
+func Local(root, audioURL string) (string, error) {
+	raw, _, _ := strings.Cut(audioURL, "?")
+	p, err := url.PathUnescape(raw)
+	if err != nil {
+		return "", ErrNotLocal
+	}
+	p = path.Clean(p)
+	if !strings.HasPrefix(p, "/uploads/") && !strings.HasPrefix(p, "/audio/") {
+		return "", ErrNotLocal
+	}
+	return filepath.Join(root, filepath.FromSlash(p)), nil
+}
+
+// Open opens the audio at a stored URL: uploads from blobs, /audio/ files
+// from under root. The caller closes it.
+func Open(blobs storage.BlobStore, root, audioURL string) (io.ReadSeekCloser, storage.Info, error) {
+	if name, ok := storage.Name(audioURL); ok {
+		return blobs.Get(name)
+	}
+	name, err := Local(root, audioURL)
+	if err != nil {
+		return nil, storage.Info{}, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, storage.Info{}, err
+	}
+	st, err := f.Stat()
+	if err == nil && st.IsDir() {
+		err = os.ErrNotExist
+	}
+	if err != nil {
+		f.Close()
+		return nil, storage.Info{}, err
+	}
+	return f, storage.Info{Name: st.Name(), Size: st.Size(), ModTime: st.ModTime()}, nil
+}
+
+// Blob returns the audio at a stored URL as a blob, for what is worked out
+// from it: an upload in blobs, an /audio/ file under root in a local store
+// of its directory, so that what is made from it is kept next to it.
+func Blob(blobs storage.BlobStore, root, audioURL string) (storage.BlobStore, string, error) {
+	if name, ok := storage.Name(audioURL); ok {
+		return blobs, name, nil
+	}
+	name, err := Local(root, audioURL)
+	if err != nil {
+		return nil, "", err
+	}
+	return storage.NewLocal(filepath.Dir(name)), filepath.Base(name), nil
+}
+
+// Serve answers r with content, described by info; http.ServeContent takes
+// care of Range, If-Range and the other conditional headers against an
+// ETag made from the size and modification time. started is called before
+// the body of a GET that begins a playback: the whole file, or a range from
+// its first byte. Seeks and probes further into the file are not playbacks.
+func Serve(w http.ResponseWriter, r *http.Request, content io.ReadSeeker, info storage.Info, maxAge time.Duration, started func()) {
+	h := w.Header()
+	h.Set("ETag", fmt.Sprintf(`"%x-%x"`, info.Size, info.ModTime.UnixNano()))
+	h.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(maxAge.Seconds())))
+	if ct := mime.TypeByExtension(path.Ext(info.Name)); ct != "" {
+		h.Set("Content-Type", ct)
+	}
+	if r.Method == http.MethodGet && started != nil {
+		w = &startWriter{ResponseWriter: w, fromStart: fromStart(r.Header.Get("Range")), started: started}
+	}
+	http.ServeContent(w, r, info.Name, info.ModTime, content)
+}
+
+// fromStart reports whether a Range header asks for the first byte. Without
+// one the whole file is sent.
+func fromStart(rng string) bool {
+	if rng == "" {
+		return true
+	}
+	spec, ok := strings.CutPrefix(rng, "bytes=")
+	if !ok {
+		return true // ignored by ServeContent, so the whole file goes out
+	}
+	first, _, _ := strings.Cut(spec, ",")
+	start, _, _ := strings.Cut(strings.TrimSpace(first), "-")
+	return strings.TrimLeft(start, "0") == "" && start != ""
+}
+
+// startWriter calls started when the response status says the playback
+// begins: 200, or 206 for a range from the start.
+type startWriter struct {
+	http.ResponseWriter
+	fromStart bool
+	started   func()
+	wrote     bool
+}
+
+func (s *startWriter) WriteHeader(code int) {
+	if !s.wrote {
+		s.wrote = true
+		if code == http.StatusOK || code == http.StatusPartialContent && s.fromStart {
+			s.started()
+		}
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *startWriter) Write(b []byte) (int, error) {
+	if !s.wrote {
+		s.WriteHeader(http.StatusOK)
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+func (s *startWriter) Unwrap() http.ResponseWriter { return s.ResponseWriter }
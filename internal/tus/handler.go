@@ -0,0 +1,326 @@
+package tus
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	Version    = "1.0.0"
+	Extensions = "creation,creation-with-upload,termination,expiration"
+
+	offsetType = "application/offset+octet-stream"
+)
+
+// Handler serves the upload collection at Base (POST creates) and the
+// uploads under Base+"/" (HEAD, PATCH, DELETE).
+type Handler struct {
+	Store *Store
+	Base  string
+	// MaxSize bounds Upload-Length.
+	MaxSize int64
+	// Owner names the caller of r, "" for anonymous ones.
+	Owner func(r *http.Request) string
+	// Complete hands a finished upload over and returns what it became,
+	// which is sent in the Upload-Result header from then on. On failure it
+	// answers r itself; the upload is dropped unless that answer is a 5xx,
+	// in which case an empty PATCH at the final offset tries again.
+	Complete func(w http.ResponseWriter, r *http.Request, u *Upload, data *os.File) (string, bool)
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if m := r.Header.Get("X-HTTP-Method-Override"); m != "" && r.Method == http.MethodPost {
+		r.Method = strings.ToUpper(m)
+	}
+	w.Header().Set("Tus-Resumable", Version)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", Version)
+		w.Header().Set("Tus-Extension", Extensions)
+		if h.MaxSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.MaxSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != Version {
+		w.Header().Set("Tus-Version", Version)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id, ok := strings.CutPrefix(r.URL.Path, h.Base+"/")
+	switch {
+	case r.URL.Path == h.Base && r.Method == http.MethodPost:
+		h.create(w, r)
+	case !ok || id == "" || strings.Contains(id, "/"):
+		http.NotFound(w, r)
+	case r.Method == http.MethodHead:
+		h.head(w, r, id)
+	case r.Method == http.MethodPatch:
+		h.patch(w, r, id)
+	case r.Method == http.MethodDelete:
+		h.terminate(w, r, id)
+	default:
+		w.Header().Set("Allow", "HEAD, PATCH, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *Handler) owner(r *http.Request) string {
+	if h.Owner == nil {
+		return ""
+	}
+	return h.Owner(r)
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "Upload-Defer-Length is not supported", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Upload-Length required", http.StatusBadRequest)
+		return
+	}
+	if h.MaxSize > 0 && length > h.MaxSize {
+		http.Error(w, fmt.Sprintf("upload is larger than %d MB", h.MaxSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+	meta, err := ParseMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		http.Error(w, "bad Upload-Metadata", http.StatusBadRequest)
+		return
+	}
+
+	u, err := h.Store.Create(length, meta, h.owner(r))
+	if err != nil {
+		log.Println("tus create:", err)
+		http.Error(w, "cannot create upload", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", h.Base+"/"+u.ID)
+	w.Header().Set("Upload-Expires", u.Expires.UTC().Format(http.TimeFormat))
+	if r.Header.Get("Content-Type") != offsetType {
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	// creation-with-upload: the body is the first chunk.
+	unlock, _ := h.Store.Lock(u.ID)
+	defer unlock()
+	off, done := h.write(w, r, u, 0)
+	if done {
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(off, 10))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// load finds the upload id of the caller, answering r when there is none.
+func (h *Handler) load(w http.ResponseWriter, r *http.Request, id string) (*Upload, int64, bool) {
+	u, off, err := h.Store.Get(id)
+	if err == nil && u.Owner != h.owner(r) {
+		err = ErrNotFound
+	}
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.NotFound(w, r)
+	case errors.Is(err, ErrExpired):
+		http.Error(w, "upload expired", http.StatusGone)
+	case err != nil:
+		log.Println("tus", id+":", err)
+		http.Error(w, "cannot read upload", http.StatusInternalServerError)
+	default:
+		return u, off, true
+	}
+	return nil, 0, false
+}
+
+func (h *Handler) head(w http.ResponseWriter, r *http.Request, id string) {
+	u, off, ok := h.load(w, r, id)
+	if !ok {
+		return
+	}
+	hd := w.Header()
+	hd.Set("Cache-Control", "no-store")
+	hd.Set("Upload-Offset", strconv.FormatInt(off, 10))
+	hd.Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	hd.Set("Upload-Expires", u.Expires.UTC().Format(http.TimeFormat))
+	if len(u.Metadata) > 0 {
+		hd.Set("Upload-Metadata", FormatMetadata(u.Metadata))
+	}
+	if u.Result != "" {
+		hd.Set("Upload-Result", u.Result)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) patch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != offsetType {
+		http.Error(w, "Content-Type must be "+offsetType, http.StatusUnsupportedMediaType)
+		return
+	}
+	at, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || at < 0 {
+		http.Error(w, "Upload-Offset required", http.StatusBadRequest)
+		return
+	}
+	unlock, err := h.Store.Lock(id)
+	if err != nil {
+		http.Error(w, "upload is being written by another request", http.StatusLocked)
+		return
+	}
+	defer unlock()
+	u, off, ok := h.load(w, r, id)
+	if !ok {
+		return
+	}
+	if at != off {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(off, 10))
+		http.Error(w, "Upload-Offset does not match", http.StatusConflict)
+		return
+	}
+	if u.Result != "" {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(off, 10))
+		w.Header().Set("Upload-Result", u.Result)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	off, done := h.write(w, r, u, off)
+	if done {
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(off, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// write appends the body of r to u, which holds off bytes, and completes u
+// once it is whole. done says r has been answered.
+func (h *Handler) write(w http.ResponseWriter, r *http.Request, u *Upload, off int64) (int64, bool) {
+	f, err := os.OpenFile(h.Store.DataPath(u.ID), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Println("tus", u.ID+":", err)
+		http.Error(w, "cannot write upload", http.StatusInternalServerError)
+		return 0, true
+	}
+	// What arrives before a broken connection is kept; the client resumes
+	// from there.
+	n, err := io.Copy(f, io.LimitReader(r.Body, u.Length-off))
+	off += n
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if terr := h.Store.Touch(u); err == nil {
+		err = terr
+	}
+	if err != nil {
+		log.Println("tus", u.ID+":", err)
+		http.Error(w, "upload interrupted", http.StatusInternalServerError)
+		return off, true
+	}
+	w.Header().Set("Upload-Expires", u.Expires.UTC().Format(http.TimeFormat))
+	if off < u.Length {
+		return off, false
+	}
+	return off, h.complete(w, r, u)
+}
+
+// complete hands u over and answers r on failure.
+func (h *Handler) complete(w http.ResponseWriter, r *http.Request, u *Upload) bool {
+	f, err := os.Open(h.Store.DataPath(u.ID))
+	if err != nil {
+		log.Println("tus", u.ID+":", err)
+		http.Error(w, "cannot read upload", http.StatusInternalServerError)
+		return true
+	}
+	rec := &statusWriter{ResponseWriter: w}
+	result, ok := h.Complete(rec, r, u, f)
+	f.Close()
+	if !ok {
+		if rec.status < 500 {
+			h.Store.Remove(u.ID)
+		}
+		return true
+	}
+	if err := h.Store.Finish(u, result); err != nil {
+		log.Println("tus", u.ID+":", err)
+	}
+	w.Header().Set("Upload-Result", result)
+	return false
+}
+
+func (h *Handler) terminate(w http.ResponseWriter, r *http.Request, id string) {
+	unlock, err := h.Store.Lock(id)
+	if err != nil {
+		http.Error(w, "upload is being written by another request", http.StatusLocked)
+		return
+	}
+	defer unlock()
+	if _, _, ok := h.load(w, r, id); !ok {
+		return
+	}
+	if err := h.Store.Remove(id); err != nil && !errors.Is(err, ErrNotFound) {
+		log.Println("tus", id+":", err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusWriter) WriteHeader(code int) {
+	if s.status == 0 {
+		s.status = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusWriter) Write(b []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// ParseMetadata reads an Upload-Metadata header: comma separated keys,
+// each with an optional base64 value.
+func ParseMetadata(s string) (map[string]string, error) {
+	res := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(pair, " ")
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+		if err != nil || k == "" {
+			return nil, errors.New("tus: bad metadata")
+		}
+		res[k] = string(b)
+	}
+	return res, nil
+}
+
+func FormatMetadata(m map[string]string) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + " " + base64.StdEncoding.EncodeToString([]byte(m[k]))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,174 @@
+package tus
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRoundTrip creates an upload, sends it in two chunks with a HEAD in
+// between and a few wrong requests on the way, and checks every answer.
+func TestRoundTrip(t *testing.T) {
+	var got string
+	h := &Handler{
+		Store:   NewStore(t.TempDir(), time.Hour),
+		Base:    "/uploads",
+		MaxSize: 100,
+		Owner:   func(r *http.Request) string { return r.Header.Get("X-User") },
+		Complete: func(w http.ResponseWriter, r *http.Request, u *Upload, data *os.File) (string, bool) {
+			b, _ := io.ReadAll(data)
+			got = string(b)
+			return "track-" + u.Metadata["filename"], true
+		},
+	}
+
+	var location string
+	tests := []struct {
+		name   string
+		method string
+		// path is the upload created by the first step when empty.
+		path   string
+		header map[string]string
+		body   string
+		status int
+		want   map[string]string
+	}{
+		{
+			name: "create", method: "POST", path: "/uploads",
+			header: map[string]string{"Upload-Length": "11", "Upload-Metadata": "filename c29uZw=="},
+			status: http.StatusCreated,
+		},
+		{
+			name: "too large", method: "POST", path: "/uploads",
+			header: map[string]string{"Upload-Length": "101"},
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "empty head", method: "HEAD",
+			status: http.StatusOK,
+			want:   map[string]string{"Upload-Offset": "0", "Upload-Length": "11", "Upload-Metadata": "filename c29uZw=="},
+		},
+		{
+			name: "first chunk", method: "PATCH",
+			header: map[string]string{"Upload-Offset": "0", "Content-Type": offsetType},
+			body:   "hello ",
+			status: http.StatusNoContent,
+			want:   map[string]string{"Upload-Offset": "6"},
+		},
+		{
+			name: "head after a chunk", method: "HEAD",
+			status: http.StatusOK,
+			want:   map[string]string{"Upload-Offset": "6", "Upload-Length": "11"},
+		},
+		{
+			name: "stale offset", method: "PATCH",
+			header: map[string]string{"Upload-Offset": "0", "Content-Type": offsetType},
+			body:   "hello ",
+			status: http.StatusConflict,
+			want:   map[string]string{"Upload-Offset": "6"},
+		},
+		{
+			name: "wrong content type", method: "PATCH",
+			header: map[string]string{"Upload-Offset": "6"},
+			body:   "world",
+			status: http.StatusUnsupportedMediaType,
+		},
+		{
+			name: "someone else's upload", method: "HEAD",
+			header: map[string]string{"X-User": "mallory"},
+			status: http.StatusNotFound,
+		},
+		{
+			name: "last chunk", method: "PATCH",
+			header: map[string]string{"Upload-Offset": "6", "Content-Type": offsetType},
+			body:   "world",
+			status: http.StatusNoContent,
+			want:   map[string]string{"Upload-Offset": "11", "Upload-Result": "track-song"},
+		},
+		{
+			name: "head when finished", method: "HEAD",
+			status: http.StatusOK,
+			want:   map[string]string{"Upload-Offset": "11", "Upload-Result": "track-song"},
+		},
+	}
+	for _, tc := range tests {
+		path := tc.path
+		if path == "" {
+			path = location
+		}
+		r := httptest.NewRequest(tc.method, path, strings.NewReader(tc.body))
+		r.Header.Set("Tus-Resumable", Version)
+		r.Header.Set("X-User", "alice")
+		for k, v := range tc.header {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Fatalf("%s: status %d, want %d: %s", tc.name, w.Code, tc.status, w.Body)
+		}
+		for k, v := range tc.want {
+			if w.Header().Get(k) != v {
+				t.Errorf("%s: %s %q, want %q", tc.name, k, w.Header().Get(k), v)
+			}
+		}
+		if tc.name == "create" {
+			location = w.Header().Get("Location")
+			if !strings.HasPrefix(location, "/uploads/") {
+				t.Fatalf("Location %q", location)
+			}
+		}
+	}
+	if got != "hello world" {
+		t.Errorf("completed with %q, want %q", got, "hello world")
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	h := &Handler{Store: NewStore(t.TempDir(), time.Hour), Base: "/uploads"}
+	r := httptest.NewRequest("POST", "/uploads", nil)
+	r.Header.Set("Tus-Resumable", "0.2.2")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusPreconditionFailed || w.Header().Get("Tus-Version") != Version {
+		t.Errorf("status %d, Tus-Version %q", w.Code, w.Header().Get("Tus-Version"))
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	tests := []struct {
+		header string
+		want   map[string]string
+		bad    bool
+	}{
+		{"", map[string]string{}, false},
+		{"filename d29ybGRfZG9taW5hdGlvbl9wbGFuLnBkZg==,is_confidential", map[string]string{"filename": "world_domination_plan.pdf", "is_confidential": ""}, false},
+		{" a YQ== , b Yg== ", map[string]string{"a": "a", "b": "b"}, false},
+		{"a !!!", nil, true},
+	}
+	for _, tc := range tests {
+		got, err := ParseMetadata(tc.header)
+		if (err != nil) != tc.bad {
+			t.Errorf("%q: error %v", tc.header, err)
+			continue
+		}
+		if tc.bad {
+			continue
+		}
+		if len(got) != len(tc.want) {
+			t.Errorf("%q: %v, want %v", tc.header, got, tc.want)
+		}
+		for k, v := range tc.want {
+			if got[k] != v {
+				t.Errorf("%q: %s=%q, want %q", tc.header, k, got[k], v)
+			}
+		}
+		if back, _ := ParseMetadata(FormatMetadata(got)); len(back) != len(got) {
+			t.Errorf("%q: %v does not survive formatting", tc.header, got)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+// Package tus implements the server side of the tus 1.0 resumable upload
+// protocol (core, creation, creation-with-upload, termination and
+// expiration). Partial uploads are kept on disk, so they survive restarts;
+// a finished one is handed to the application.
+package tus
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("tus: no such upload")
+	ErrExpired  = errors.New("tus: upload expired")
+	ErrLocked   = errors.New("tus: upload is being written")
+)
+
+// Upload is the state of one upload; the bytes received so far are in the
+// data file, whose size is the offset.
+type Upload struct {
+	ID       string            `json:"id"`
+	Length   int64             `json:"length"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Owner is who created the upload; nobody else sees it.
+	Owner   string    `json:"owner,omitempty"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	// Result is what the finished upload became, e.g. a track id. The data
+	// is gone by then.
+	Result string `json:"result,omitempty"`
+}
+
+type Store struct {
+	Dir string
+	// TTL is how long an upload lives after its last write.
+	TTL time.Duration
+
+	mu       sync.Mutex
+	busy     map[string]bool
+	lastReap time.Time
+	now      func() time.Time
+}
+
+func NewStore(dir string, ttl time.Duration) *Store {
+	return &Store{Dir: dir, TTL: ttl, busy: map[string]bool{}, now: time.Now}
+}
+
+// StoreFromEnv reads TUS_DIR (uploads-partial) and TUS_EXPIRY (24h).
+func StoreFromEnv() *Store {
+	dir := strings.TrimSpace(os.Getenv("TUS_DIR"))
+	if dir == "" {
+		dir = "uploads-partial"
+	}
+	ttl := 24 * time.Hour
+	if d, err := time.ParseDuration(strings.TrimSpace(os.Getenv("TUS_EXPIRY"))); err == nil && d > 0 {
+		ttl = d
+	}
+	return NewStore(dir, ttl)
+}
+
+func (s *Store) infoPath(id string) string { return filepath.Join(s.Dir, id+".json") }
+
+// DataPath is the file holding the bytes of upload id.
+func (s *Store) DataPath(id string) string { return filepath.Join(s.Dir, id+".part") }
+
+// validID keeps ids from naming files outside Dir.
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// Create starts an empty upload.
+func (s *Store) Create(length int64, meta map[string]string, owner string) (*Upload, error) {
+	s.reapSometimes()
+	if err := os.MkdirAll(s.Dir, 0700); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	now := s.now()
+	u := &Upload{
+		ID: hex.EncodeToString(b), Length: length, Metadata: meta, Owner: owner,
+		Created: now, Expires: now.Add(s.TTL),
+	}
+	f, err := os.OpenFile(s.DataPath(u.ID), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	if err := s.save(u); err != nil {
+		os.Remove(s.DataPath(u.ID))
+		return nil, err
+	}
+	return u, nil
+}
+
+// Get returns an upload and its offset. Expired uploads are removed.
+func (s *Store) Get(id string) (*Upload, int64, error) {
+	if !validID(id) {
+		return nil, 0, ErrNotFound
+	}
+	b, err := os.ReadFile(s.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, ErrNotFound
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	u := &Upload{}
+	if err := json.Unmarshal(b, u); err != nil {
+		return nil, 0, err
+	}
+	if !s.now().Before(u.Expires) {
+		s.Remove(id)
+		return nil, 0, ErrExpired
+	}
+	if u.Result != "" {
+		return u, u.Length, nil
+	}
+	st, err := os.Stat(s.DataPath(id))
+	if err != nil {
+		return nil, 0, err
+	}
+	return u, st.Size(), nil
+}
+
+// Touch pushes the expiry of u back to a full TTL.
+func (s *Store) Touch(u *Upload) error {
+	u.Expires = s.now().Add(s.TTL)
+	return s.save(u)
+}
+
+// Finish records what u became and drops its data.
+func (s *Store) Finish(u *Upload, result string) error {
+	u.Result = result
+	if err := s.Touch(u); err != nil {
+		return err
+	}
+	os.Remove(s.DataPath(u.ID))
+	return nil
+}
+
+func (s *Store) Remove(id string) error {
+	os.Remove(s.DataPath(id))
+	err := os.Remove(s.infoPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Lock keeps two requests from writing one upload at once.
+func (s *Store) Lock(id string) (unlock func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.busy[id] {
+		return nil, ErrLocked
+	}
+	s.busy[id] = true
+	return func() {
+		s.mu.Lock()
+		delete(s.busy, id)
+		s.mu.Unlock()
+	}, nil
+}
+
+func (s *Store) save(u *Upload) error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	tmp := s.infoPath(u.ID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.infoPath(u.ID))
+}
+
+// Reap removes the expired uploads and returns how many there were.
+func (s *Store) Reap() (int, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	n := 0
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !validID(id) {
+			continue
+		}
+		if _, _, err := s.Get(id); errors.Is(err, ErrExpired) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// reapSometimes runs Reap at most once per tenth of the TTL, so expired
+// uploads go away without a job of their own.
+func (s *Store) reapSometimes() {
+	s.mu.Lock()
+	due := s.now().Sub(s.lastReap) >= s.TTL/10
+	if due {
+		s.lastReap = s.now()
+	}
+	s.mu.Unlock()
+	if due {
+		s.Reap()
+	}
+}
@@ -0,0 +1,211 @@
+package upload
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"YeahMusic/internal/storage"
+)
+
+// A form is read part by part and never held whole: files go straight into
+// blobs while they are checked, the other fields are kept in memory up to
+// maxValueBytes together.
+const (
+	maxParts      = 64
+	maxValueBytes = 1 << 20
+)
+
+// Spec says what ReadForm expects of a form.
+type Spec struct {
+	// Files maps the file fields to what they hold. Every other field is
+	// read as a value.
+	Files map[string]Kind
+	// Field, if set, sees each value as it arrives. An error ends the
+	// upload there, before the rest of the body is read, and is what
+	// ReadForm returns.
+	Field func(name, value string) error
+}
+
+// Form is a read upload. Its files are checked but not stored: Commit the
+// blobs to keep, and Close the form in any case.
+type Form struct {
+	Values map[string]string
+	Files  map[string]*File
+}
+
+// File is an accepted file, waiting in its blob.
+type File struct {
+	Filename string
+	*Result
+	Blob storage.Writer
+}
+
+// Value is the first value of the field name, like Request.FormValue.
+func (f *Form) Value(name string) string { return f.Values[name] }
+
+// Close drops the blobs that have not been committed.
+func (f *Form) Close() {
+	for _, file := range f.Files {
+		file.Blob.Abort()
+	}
+}
+
+func (f *Form) set(spec Spec, name, value string) error {
+	if _, ok := f.Values[name]; ok {
+		return nil
+	}
+	f.Values[name] = value
+	if spec.Field != nil {
+		return spec.Field(name, value)
+	}
+	return nil
+}
+
+// ReadForm reads the upload in r, of at most MaxRequest bytes, into blobs
+// of store. A file is turned away as soon as its first bytes or its size
+// give it away, without reading the rest of the body. Forms that are not
+// multipart have no files.
+func (v *Validator) ReadForm(w http.ResponseWriter, r *http.Request, store storage.BlobStore, spec Spec) (*Form, error) {
+	if r.ContentLength > v.MaxRequest() {
+		return nil, v.bodyErr(&http.MaxBytesError{Limit: v.MaxRequest()})
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, v.MaxRequest())
+	form := &Form{Values: map[string]string{}, Files: map[string]*File{}}
+
+	mr, err := r.MultipartReader()
+	if errors.Is(err, http.ErrNotMultipart) {
+		if err := r.ParseForm(); err != nil {
+			return nil, v.bodyErr(err)
+		}
+		for name := range r.PostForm {
+			if err := form.set(spec, name, r.PostForm.Get(name)); err != nil {
+				return nil, err
+			}
+		}
+		return form, nil
+	}
+	if err != nil {
+		return nil, v.bodyErr(err)
+	}
+	if err := v.readParts(mr, store, spec, form); err != nil {
+		form.Close()
+		return nil, err
+	}
+	return form, nil
+}
+
+func (v *Validator) readParts(mr *multipart.Reader, store storage.BlobStore, spec Spec, form *Form) error {
+	left := int64(maxValueBytes)
+	for n := 0; ; n++ {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return v.bodyErr(err)
+		}
+		if n == maxParts {
+			return reject(http.StatusBadRequest, "form has more than %d parts", maxParts)
+		}
+
+		name := p.FormName()
+		kind, isFile := spec.Files[name]
+		switch {
+		case name == "":
+		case isFile:
+			if form.Files[name] != nil {
+				return reject(http.StatusBadRequest, "more than one %s file", name)
+			}
+			f, err := v.readFile(p, store, kind)
+			if err != nil {
+				return err
+			}
+			if f != nil {
+				form.Files[name] = f
+			}
+		default:
+			b, err := io.ReadAll(io.LimitReader(p, left+1))
+			if err != nil {
+				return v.bodyErr(err)
+			}
+			if left -= int64(len(b)); left < 0 {
+				return reject(http.StatusRequestEntityTooLarge, "form fields are larger than %d KB", maxValueBytes>>10)
+			}
+			if err := form.set(spec, name, string(b)); err != nil {
+				return err
+			}
+		}
+		p.Close()
+	}
+}
+
+// readFile streams a file part into a blob once its first bytes pass. An
+// empty part is a file input left empty and gives no file.
+func (v *Validator) readFile(p *multipart.Part, store storage.BlobStore, kind Kind) (*File, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(p, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, v.bodyErr(err)
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	if _, err := checkHead(head[:n], p.FileName(), kind); err != nil {
+		return nil, err
+	}
+	return v.Stage(store, io.MultiReader(bytes.NewReader(head[:n]), p), p.FileName(), kind)
+}
+
+// Stage copies r into a new blob of store and checks it as a file of kind
+// named filename, giving up as soon as r outgrows the limit of kind. The
+// caller commits or aborts the blob.
+func (v *Validator) Stage(store storage.BlobStore, r io.Reader, filename string, kind Kind) (*File, error) {
+	blob, err := store.Create()
+	if err != nil {
+		return nil, err
+	}
+	body := &bodyReader{r: r}
+	_, err = io.Copy(blob, io.LimitReader(body, v.max(kind)+1))
+	if body.err != nil {
+		err = v.bodyErr(body.err)
+	}
+	if err == nil && blob.Size() > v.max(kind) {
+		err = v.tooLarge(kind)
+	}
+	var res *Result
+	if err == nil {
+		res, err = v.Check(blob, blob.Size(), filename, kind)
+	}
+	if err != nil {
+		blob.Abort()
+		return nil, err
+	}
+	return &File{Filename: filename, Result: res, Blob: blob}, nil
+}
+
+// bodyReader keeps the error of reading the request apart from those of
+// writing the blob.
+type bodyReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// bodyErr is the answer to a request body that cannot be read.
+func (v *Validator) bodyErr(err error) error {
+	var tooBig *http.MaxBytesError
+	if errors.As(err, &tooBig) {
+		return reject(http.StatusRequestEntityTooLarge, "upload is larger than %d MB", v.MaxRequest()>>20)
+	}
+	return reject(http.StatusBadRequest, "bad multipart form")
+}
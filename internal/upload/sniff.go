@@ -0,0 +1,237 @@
+package upload
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"YeahMusic/internal/audio"
+)
+
+type Format struct {
+	Name string
+	Kind Kind
+	MIME string
+	// Exts are the accepted file name extensions; the first is used when
+	// the file is saved.
+	Exts []string
+}
+
+func (f *Format) hasExt(ext string) bool { return slices.Contains(f.Exts, ext) }
+
+var (
+	fmtMP3  = &Format{"MP3", Audio, "audio/mpeg", []string{".mp3"}}
+	fmtFLAC = &Format{"FLAC", Audio, "audio/flac", []string{".flac"}}
+	fmtOgg  = &Format{"Ogg", Audio, "audio/ogg", []string{".ogg", ".oga", ".opus"}}
+	fmtWAV  = &Format{"WAV", Audio, "audio/wav", []string{".wav", ".wave"}}
+	fmtM4A  = &Format{"M4A", Audio, "audio/mp4", []string{".m4a", ".mp4", ".aac"}}
+	fmtPNG  = &Format{"PNG", Image, "image/png", []string{".png"}}
+	fmtJPEG = &Format{"JPEG", Image, "image/jpeg", []string{".jpg", ".jpeg", ".jfif"}}
+	fmtWebP = &Format{"WebP", Image, "image/webp", []string{".webp"}}
+
+	formats = []*Format{fmtMP3, fmtFLAC, fmtOgg, fmtWAV, fmtM4A, fmtPNG, fmtJPEG, fmtWebP}
+)
+
+func names(kind Kind) string {
+	var res []string
+	for _, f := range formats {
+		if f.Kind == kind {
+			res = append(res, f.Name)
+		}
+	}
+	return strings.Join(res, ", ")
+}
+
+// m4aBrands are the ftyp brands of audio-only MP4 files.
+var m4aBrands = []string{"M4A ", "M4B ", "mp42", "mp41", "isom", "iso2", "dash"}
+
+func sniff(b []byte) *Format {
+	switch {
+	case bytes.HasPrefix(b, []byte("ID3")):
+		return fmtMP3
+	case len(b) >= 2 && b[0] == 0xff && b[1]&0xe0 == 0xe0 && b[1]&0x06 != 0:
+		return fmtMP3
+	case bytes.HasPrefix(b, []byte("fLaC")):
+		return fmtFLAC
+	case bytes.HasPrefix(b, []byte("OggS")):
+		return fmtOgg
+	case len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WAVE":
+		return fmtWAV
+	case len(b) >= 12 && string(b[4:8]) == "ftyp" && slices.Contains(m4aBrands, string(b[8:12])):
+		return fmtM4A
+	case bytes.HasPrefix(b, []byte("\x89PNG\r\n\x1a\n")):
+		return fmtPNG
+	case bytes.HasPrefix(b, []byte{0xff, 0xd8, 0xff}):
+		return fmtJPEG
+	case len(b) >= 12 && string(b[:4]) == "RIFF" && string(b[8:12]) == "WEBP":
+		return fmtWebP
+	}
+	return nil
+}
+
+// Markup has no business inside media files; it is what makes a file that
+// a browser also renders as a page.
+var markupMarkers = [][]byte{
+	[]byte("<script"), []byte("<html"), []byte("<!doctype"), []byte("<?php"), []byte("<svg"),
+	[]byte("<iframe"), []byte("<body"), []byte("javascript:"),
+}
+
+// zipEnd is the end of a ZIP central directory, which unzip looks for near
+// the end of a file.
+var zipEnd = []byte("PK\x05\x06")
+
+// polyglotWindow is how much of each end of a file is searched. The middle
+// is compressed media data, where short markers turn up by chance.
+const polyglotWindow = 64 << 10
+
+func checkPolyglot(f io.ReaderAt, size int64) error {
+	head, tail := int64(polyglotWindow), int64(polyglotWindow)
+	if size < head {
+		head = size
+	}
+	if size < tail {
+		tail = size
+	}
+	for _, s := range [][2]int64{{0, head}, {size - tail, size}} {
+		b := make([]byte, s[1]-s[0])
+		if _, err := f.ReadAt(b, s[0]); err != nil && err != io.EOF {
+			return err
+		}
+		if s[0] > 0 || size <= polyglotWindow {
+			if bytes.Contains(b, zipEnd) {
+				return reject(http.StatusUnprocessableEntity, "file has a ZIP archive attached")
+			}
+		}
+		b = bytes.ToLower(b)
+		for _, m := range markupMarkers {
+			if bytes.Contains(b, m) {
+				return reject(http.StatusUnprocessableEntity, "file contains embedded %q markup", string(m))
+			}
+		}
+	}
+	return nil
+}
+
+var errTrailing = reject(http.StatusUnprocessableEntity, "image has data after its end")
+
+// checkImage decodes the dimensions and makes sure the file ends where the
+// image does.
+func checkImage(f io.ReaderAt, size int64, format *Format) (w, h int, err error) {
+	r := io.NewSectionReader(f, 0, size)
+	switch format {
+	case fmtWebP:
+		w, h, err = webpSize(r, size)
+	default:
+		var cfg image.Config
+		if cfg, _, err = image.DecodeConfig(r); err == nil {
+			w, h = cfg.Width, cfg.Height
+		}
+	}
+	if err != nil || w <= 0 || h <= 0 {
+		return 0, 0, reject(http.StatusUnprocessableEntity, "%s image is corrupt", format.Name)
+	}
+
+	switch format {
+	case fmtPNG:
+		err = pngEnd(r, size)
+	case fmtJPEG:
+		err = jpegEnd(r, size)
+	}
+	return w, h, err
+}
+
+// pngEnd walks the chunks; IEND has to be the last thing in the file.
+func pngEnd(r io.ReaderAt, size int64) error {
+	off := int64(8)
+	hdr := make([]byte, 8)
+	for off+12 <= size {
+		if _, err := r.ReadAt(hdr, off); err != nil {
+			return reject(http.StatusUnprocessableEntity, "PNG image is corrupt")
+		}
+		off += 12 + int64(binary.BigEndian.Uint32(hdr))
+		if string(hdr[4:]) == "IEND" {
+			if off != size {
+				return errTrailing
+			}
+			return nil
+		}
+	}
+	return reject(http.StatusUnprocessableEntity, "PNG image is truncated")
+}
+
+// jpegEnd wants the end-of-image marker at the end of the file, allowing for
+// the zero padding some cameras write.
+func jpegEnd(r io.ReaderAt, size int64) error {
+	n := int64(4096)
+	if n > size {
+		n = size
+	}
+	tail := make([]byte, n)
+	if _, err := r.ReadAt(tail, size-n); err != nil && err != io.EOF {
+		return err
+	}
+	tail = bytes.TrimRight(tail, "\x00")
+	if !bytes.HasSuffix(tail, []byte{0xff, 0xd9}) {
+		return errTrailing
+	}
+	return nil
+}
+
+// webpSize reads the first chunk of a WebP and checks the RIFF length
+// against the file size.
+func webpSize(r io.ReaderAt, size int64) (int, int, error) {
+	b := make([]byte, 30)
+	if _, err := r.ReadAt(b, 0); err != nil {
+		return 0, 0, err
+	}
+	if riff := int64(binary.LittleEndian.Uint32(b[4:8])) + 8; riff != size && riff+1 != size {
+		return 0, 0, errTrailing
+	}
+	switch string(b[12:16]) {
+	case "VP8 ":
+		if b[23] != 0x9d || b[24] != 0x01 || b[25] != 0x2a {
+			return 0, 0, errors.New("bad VP8 frame")
+		}
+		return int(binary.LittleEndian.Uint16(b[26:]) & 0x3fff), int(binary.LittleEndian.Uint16(b[28:]) & 0x3fff), nil
+	case "VP8L":
+		if b[20] != 0x2f {
+			return 0, 0, errors.New("bad VP8L frame")
+		}
+		v := binary.LittleEndian.Uint32(b[21:])
+		return int(v&0x3fff) + 1, int(v>>14&0x3fff) + 1, nil
+	case "VP8X":
+		w := int(b[24]) | int(b[25])<<8 | int(b[26])<<16
+		h := int(b[27]) | int(b[28])<<8 | int(b[29])<<16
+		return w + 1, h + 1, nil
+	}
+	return 0, 0, errors.New("unknown WebP chunk")
+}
+
+// checkAudio makes sure MP3s have frames and WAVs are as long as they say.
+// The other formats are only sniffed.
+func checkAudio(f io.ReaderAt, size int64, format *Format, res *Result) error {
+	switch format {
+	case fmtMP3:
+		info, err := audio.Probe(f, size)
+		if err != nil {
+			return reject(http.StatusUnprocessableEntity, "MP3 file has no audio frames")
+		}
+		res.Audio = info
+	case fmtWAV:
+		b := make([]byte, 8)
+		if _, err := f.ReadAt(b, 0); err != nil {
+			return err
+		}
+		if riff := int64(binary.LittleEndian.Uint32(b[4:8])) + 8; riff < size-1 {
+			return reject(http.StatusUnprocessableEntity, "WAV file has data after its end")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+// Package upload checks user files before they are stored: the format is
+// taken from the bytes, not the name, and has to agree with the name, fit
+// the size and dimension limits and carry nothing else along.
+package upload
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"YeahMusic/internal/audio"
+)
+
+type Kind string
+
+const (
+	Audio Kind = "audio"
+	Image Kind = "image"
+)
+
+// Error is a rejected upload; Status is the HTTP status to answer with.
+type Error struct {
+	Status int
+	Msg    string
+}
+
+func (e *Error) Error() string { return e.Msg }
+
+func reject(status int, format string, args ...any) *Error {
+	return &Error{Status: status, Msg: fmt.Sprintf(format, args...)}
+}
+
+type Limits struct {
+	MaxAudioBytes int64
+	MaxImageBytes int64
+	// MaxImageSide bounds width and height.
+	MaxImageSide int
+	// MaxResumableBytes bounds audio sent in resumable uploads, which are
+	// not held in a form and can take masters.
+	MaxResumableBytes int64
+}
+
+// LimitsFromEnv reads UPLOAD_MAX_AUDIO_MB (50), UPLOAD_MAX_IMAGE_MB (10),
+// UPLOAD_MAX_IMAGE_SIDE (4096 pixels) and UPLOAD_MAX_RESUMABLE_MB (2048).
+func LimitsFromEnv() Limits {
+	env := func(key string, def int) int {
+		if n, err := strconv.Atoi(strings.TrimSpace(os.Getenv(key))); err == nil && n > 0 {
+			return n
+		}
+		return def
+	}
+	return Limits{
+		MaxAudioBytes: int64(env("UPLOAD_MAX_AUDIO_MB", 50)) << 20,
+		MaxImageBytes: int64(env("UPLOAD_MAX_IMAGE_MB", 10)) << 20,
+		MaxImageSide:  env("UPLOAD_MAX_IMAGE_SIDE", 4096),
+
+		MaxResumableBytes: int64(env("UPLOAD_MAX_RESUMABLE_MB", 2048)) << 20,
+	}
+}
+
+// MaxRequest is a bound for a whole upload form: one track and two images.
+func (l Limits) MaxRequest() int64 {
+	return l.MaxAudioBytes + 2*l.MaxImageBytes + 1<<20
+}
+
+// Result describes an accepted file.
+type Result struct {
+	Format *Format
+	Size   int64
+	Width  int
+	Height int
+	// Audio is set for MP3s.
+	Audio *audio.Info
+}
+
+type Validator struct {
+	Limits
+}
+
+func NewValidator(l Limits) *Validator {
+	return &Validator{Limits: l}
+}
+
+// Resumable is v with the audio limit of resumable uploads.
+func (v *Validator) Resumable() *Validator {
+	c := *v
+	c.MaxAudioBytes = max(v.MaxResumableBytes, v.MaxAudioBytes)
+	return &c
+}
+
+// Check validates the size bytes of f, named filename by the client, as a
+// file of kind.
+func (v *Validator) Check(f io.ReaderAt, size int64, filename string, kind Kind) (*Result, error) {
+	if size == 0 {
+		return nil, reject(http.StatusBadRequest, "%s file is empty", kind)
+	}
+	if size > v.max(kind) {
+		return nil, v.tooLarge(kind)
+	}
+
+	head := make([]byte, 512)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	format, err := checkHead(head[:n], filename, kind)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPolyglot(f, size); err != nil {
+		return nil, err
+	}
+
+	res := &Result{Format: format, Size: size}
+	if kind == Image {
+		if res.Width, res.Height, err = checkImage(f, size, format); err != nil {
+			return nil, err
+		}
+		if res.Width > v.MaxImageSide || res.Height > v.MaxImageSide {
+			return nil, reject(http.StatusUnprocessableEntity, "image is %dx%d, at most %dx%d is allowed",
+				res.Width, res.Height, v.MaxImageSide, v.MaxImageSide)
+		}
+		return res, nil
+	}
+	if err := checkAudio(f, size, format, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// checkHead tells the format from the first bytes of a file, which is all
+// a streamed upload needs to be turned away.
+func checkHead(head []byte, filename string, kind Kind) (*Format, error) {
+	format := sniff(head)
+	if format == nil || format.Kind != kind {
+		return nil, reject(http.StatusUnsupportedMediaType, "unsupported %s format; use %s", kind, names(kind))
+	}
+	if ext := strings.ToLower(filepath.Ext(filename)); ext != "" && !format.hasExt(ext) {
+		return nil, reject(http.StatusUnprocessableEntity, "file name ends in %s but the file is %s", ext, format.Name)
+	}
+	return format, nil
+}
+
+func (v *Validator) max(kind Kind) int64 {
+	if kind == Image {
+		return v.MaxImageBytes
+	}
+	return v.MaxAudioBytes
+}
+
+func (v *Validator) tooLarge(kind Kind) error {
+	return reject(http.StatusRequestEntityTooLarge, "%s file is larger than %d MB", kind, v.max(kind)>>20)
+}
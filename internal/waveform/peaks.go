@@ -0,0 +1,144 @@
+// Package waveform computes the peaks players draw a track's waveform
+// from: the lowest and highest sample of every run of samples, at a few
+// zoom levels. They are kept and served in the formats of BBC
+// audiowaveform, which peaks.js and waveform-data.js read.
+package waveform
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+// Levels are the zoom levels in samples per pixel, finest first. Each is a
+// multiple of the first.
+var Levels = []int{512, 1024, 2048, 4096}
+
+// Peaks holds one channel, the mix of all, in 8 bits.
+type Peaks struct {
+	SampleRate      int
+	SamplesPerPixel int
+	// Data is the minimum and the maximum of every pixel.
+	Data []int8
+}
+
+// Length is the number of pixels.
+func (p *Peaks) Length() int { return len(p.Data) / 2 }
+
+// Builder works out the peaks of the samples written to it.
+type Builder struct {
+	base   *Peaks
+	lo, hi int16
+	n      int
+}
+
+func NewBuilder(sampleRate int) *Builder {
+	return &Builder{base: &Peaks{SampleRate: sampleRate, SamplesPerPixel: Levels[0]}}
+}
+
+// Write takes samples interleaved as left and right.
+func (b *Builder) Write(samples []int16) {
+	for i := 0; i+1 < len(samples); i += 2 {
+		s := int16((int32(samples[i]) + int32(samples[i+1])) / 2)
+		if b.n == 0 || s < b.lo {
+			b.lo = s
+		}
+		if b.n == 0 || s > b.hi {
+			b.hi = s
+		}
+		if b.n++; b.n == b.base.SamplesPerPixel {
+			b.flush()
+		}
+	}
+}
+
+func (b *Builder) flush() {
+	b.base.Data = append(b.base.Data, int8(b.lo>>8), int8(b.hi>>8))
+	b.n = 0
+}
+
+// Peaks ends the audio and returns its peaks at every level.
+func (b *Builder) Peaks() ([]*Peaks, error) {
+	if b.n > 0 {
+		b.flush()
+	}
+	if b.base.Length() == 0 {
+		return nil, errors.New("waveform: no audio")
+	}
+	res := []*Peaks{b.base}
+	for _, spp := range Levels[1:] {
+		res = append(res, b.base.merge(spp/b.base.SamplesPerPixel))
+	}
+	return res, nil
+}
+
+// merge joins every k pixels of p into one.
+func (p *Peaks) merge(k int) *Peaks {
+	res := &Peaks{SampleRate: p.SampleRate, SamplesPerPixel: p.SamplesPerPixel * k}
+	for i := 0; i < p.Length(); i += k {
+		lo, hi := p.Data[2*i], p.Data[2*i+1]
+		for j := i + 1; j < i+k && j < p.Length(); j++ {
+			lo, hi = min(lo, p.Data[2*j]), max(hi, p.Data[2*j+1])
+		}
+		res.Data = append(res.Data, lo, hi)
+	}
+	return res
+}
+
+// The binary format is version 2 of audiowaveform's .dat: a header of six
+// little-endian 32-bit fields and the pixels.
+const (
+	datVersion    = 2
+	datHeaderSize = 24
+	flag8Bit      = 1
+)
+
+var errFormat = errors.New("waveform: bad peaks data")
+
+func (p *Peaks) MarshalBinary() ([]byte, error) {
+	b := make([]byte, datHeaderSize, datHeaderSize+len(p.Data))
+	binary.LittleEndian.PutUint32(b[0:], datVersion)
+	binary.LittleEndian.PutUint32(b[4:], flag8Bit)
+	binary.LittleEndian.PutUint32(b[8:], uint32(p.SampleRate))
+	binary.LittleEndian.PutUint32(b[12:], uint32(p.SamplesPerPixel))
+	binary.LittleEndian.PutUint32(b[16:], uint32(p.Length()))
+	binary.LittleEndian.PutUint32(b[20:], 1) // channels
+	for _, v := range p.Data {
+		b = append(b, byte(v))
+	}
+	return b, nil
+}
+
+// UnmarshalBinary reads what MarshalBinary writes.
+func (p *Peaks) UnmarshalBinary(b []byte) error {
+	if len(b) < datHeaderSize ||
+		binary.LittleEndian.Uint32(b[0:]) != datVersion ||
+		binary.LittleEndian.Uint32(b[4:]) != flag8Bit ||
+		binary.LittleEndian.Uint32(b[20:]) != 1 {
+		return errFormat
+	}
+	n := int(binary.LittleEndian.Uint32(b[16:]))
+	if len(b) != datHeaderSize+2*n {
+		return errFormat
+	}
+	p.SampleRate = int(binary.LittleEndian.Uint32(b[8:]))
+	p.SamplesPerPixel = int(binary.LittleEndian.Uint32(b[12:]))
+	p.Data = make([]int8, 2*n)
+	for i, v := range b[datHeaderSize:] {
+		p.Data[i] = int8(v)
+	}
+	return nil
+}
+
+// MarshalJSON writes audiowaveform's JSON.
+func (p *Peaks) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Version         int    `json:"version"`
+		Channels        int    `json:"channels"`
+		SampleRate      int    `json:"sample_rate"`
+		SamplesPerPixel int    `json:"samples_per_pixel"`
+		Bits            int    `json:"bits"`
+		Length          int    `json:"length"`
+		Data            []int8 `json:"data"`
+	}{datVersion, 1, p.SampleRate, p.SamplesPerPixel, 8, p.Length(), p.Data})
+}
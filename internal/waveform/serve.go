@@ -0,0 +1,46 @@
+package waveform
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Requested is the samples_per_pixel query parameter of r, 0 when absent.
+func Requested(r *http.Request) int {
+	n, _ := strconv.Atoi(r.URL.Query().Get("samples_per_pixel"))
+	return n
+}
+
+// Format is what r asks for: audiowaveform's "json" unless format=dat or
+// the request accepts application/octet-stream, which get its binary
+// "dat". Other formats give "".
+func Format(r *http.Request) string {
+	switch f := r.URL.Query().Get("format"); f {
+	case "json", "dat":
+		return f
+	case "":
+		if strings.Contains(r.Header.Get("Accept"), "application/octet-stream") {
+			return "dat"
+		}
+		return "json"
+	}
+	return ""
+}
+
+// Serve answers with p in format. The peaks of an upload never change, so
+// they may be cached for long.
+func Serve(w http.ResponseWriter, p *Peaks, format string) {
+	var b []byte
+	if format == "dat" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		b, _ = p.MarshalBinary()
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		b, _ = json.Marshal(p)
+	}
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.Header().Set("Vary", "Accept")
+	w.Write(b)
+}
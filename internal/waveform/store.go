@@ -0,0 +1,25 @@
+package waveform
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Name is the blob next to the audio blob holding its peaks at the level
+// spp. It goes with the audio when uploads are collected.
+func Name(audio string, spp int) string {
+	return strings.TrimSuffix(audio, filepath.Ext(audio)) + "_peaks" + strconv.Itoa(spp) + ".dat"
+}
+
+// Level is the coarsest level at most spp, which a renderer can scale to
+// spp, or the finest one.
+func Level(spp int) int {
+	res := Levels[0]
+	for _, l := range Levels {
+		if l <= spp {
+			res = l
+		}
+	}
+	return res
+}
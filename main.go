@@ -4,32 +4,109 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/netip"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"YeahMusic/internal/analysis"
+	"YeahMusic/internal/audio"
+	"YeahMusic/internal/handlers"
+	"YeahMusic/internal/media"
+	"YeahMusic/internal/migrate"
+	"YeahMusic/internal/models"
+	"YeahMusic/internal/ratelimit"
+	"YeahMusic/internal/services"
+	"YeahMusic/internal/storage"
+	"YeahMusic/internal/stream"
+	"YeahMusic/internal/tus"
+	"YeahMusic/internal/upload"
+	"YeahMusic/internal/waveform"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var client *mongo.Client
 var db *mongo.Database
+var passwords services.PasswordHasher
+
+// sessions issues and checks bearer tokens for the legacy handlers, using the
+// "sessions" collection of db.
+var sessions *services.AuthService
+
+// apiKeys checks the keys made at /api/api-keys, which the handlers behind
+// authorize take in place of a session for what their scopes allow.
+var apiKeys *services.APIKeyService
+
+// accounts mails password reset and verification links; policy decides
+// what the caller of a legacy handler may do, like in internal/handlers.
+var accounts *services.AccountService
+var policy *services.Policy
+
+// limits and lockout guard the legacy login and the Gemini proxy; see
+// legacyRateLimits.
+var limits *ratelimit.Limiter
+var lockout *ratelimit.Lockout
+
+// trustedProxies are the TRUSTED_PROXIES whose X-Forwarded-For legacyClient
+// reads the client address from; anyone else is keyed by their own.
+var trustedProxies []netip.Prefix
+
+// mediaSigner signs the /uploads/ and /audio/ URLs the API hands out; the
+// servers of those paths refuse them without a valid signature.
+var mediaSigner *media.Signer
+
+// uploads checks every file as readUpload streams it into blobs, before
+// saveFile, saveImage or addTrack keep it.
+var uploads *upload.Validator
+
+// blobs stores uploads by content, in getUploadDir unless BLOB_STORE says
+// otherwise; see gc-uploads.
+var blobs storage.BlobStore
+
+// plays counts the playbacks started on /api/tracks/{id}/stream.
+var plays *stream.Accounting
+
+// catalog analyses uploads and stores the results in the "tracks" and
+// "albums" collections of db, whose documents it shares the fields of.
+var catalog *services.CatalogService
+
+// legacyRateLimits can be changed with RATE_LIMITS like the /api routes of
+// internal/handlers. generate-lyrics-total is one bucket for all clients so
+// the Gemini quota holds however many addresses ask.
+var legacyRateLimits = map[string]ratelimit.Rule{
+	"login":                 {Burst: 10, Per: time.Minute},
+	"login-2fa":             {Burst: 10, Per: time.Minute},
+	"register":              {Burst: 10, Per: time.Hour},
+	"generate-lyrics":       {Burst: 20, Per: time.Hour},
+	"generate-lyrics-total": {Burst: 300, Per: time.Hour},
+	"password-reset":        {Burst: 5, Per: 15 * time.Minute},
+	"verify-email":          {Burst: 20, Per: time.Hour},
+	"verify-resend":         {Burst: 5, Per: 15 * time.Minute},
+	"oidc":                  {Burst: 30, Per: time.Minute},
+	"upload":                {Burst: 60, Per: time.Hour},
+}
 
 type User struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Email    string             `bson:"email" json:"email"`
-	Password string             `bson:"password" json:"password"`
-	Name     string             `bson:"name" json:"name"`
-	Bio      string             `bson:"bio" json:"bio"`
-	Role     string             `bson:"role" json:"role"`
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Email     string             `bson:"email" json:"email"`
+	Password  string             `bson:"password" json:"-"`
+	Name      string             `bson:"name" json:"name"`
+	Bio       string             `bson:"bio" json:"bio"`
+	Role      string             `bson:"role" json:"role"`
+	Requested string             `bson:"requested_role,omitempty" json:"requested_role,omitempty"`
+	Verified  bool               `bson:"email_verified" json:"email_verified"`
+	TOTP      bool               `bson:"totp_enabled" json:"totp_enabled"`
 }
 
 type Track struct {
@@ -42,8 +119,19 @@ type Track struct {
 	AudioURL  string             `bson:"audio_url" json:"audio_url"`
 	Lyrics    string             `bson:"lyrics" json:"lyrics"`
 	Duration  int                `bson:"duration" json:"duration"`
+	TrackNo   int                `bson:"track_no,omitempty" json:"track_no,omitempty"`
+	Genre     string             `bson:"genre,omitempty" json:"genre,omitempty"`
+	Year      int                `bson:"year,omitempty" json:"year,omitempty"`
 	IsSingle  bool               `bson:"is_single" json:"is_single"`
+	Plays     int64              `bson:"plays" json:"plays"`
 	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+
+	Loudness      *models.Loudness `bson:"loudness,omitempty" json:"loudness,omitempty"`
+	AlbumLoudness *models.Loudness `bson:"album_loudness,omitempty" json:"album_loudness,omitempty"`
+	Gapless       *models.Gapless  `bson:"gapless,omitempty" json:"gapless,omitempty"`
+
+	StreamURL string            `bson:"-" json:"stream_url,omitempty"`
+	Covers    map[string]string `bson:"-" json:"covers,omitempty"`
 }
 
 type Album struct {
@@ -54,6 +142,8 @@ type Album struct {
 	CoverURL    string             `bson:"cover_url" json:"cover_url"`
 	IsSingle    bool               `bson:"is_single" json:"is_single"`
 	ReleaseDate time.Time          `bson:"release_date" json:"release_date"`
+	Loudness    *models.Loudness   `bson:"loudness,omitempty" json:"loudness,omitempty"`
+	Covers      map[string]string  `bson:"-" json:"covers,omitempty"`
 }
 
 type Playlist struct {
@@ -63,6 +153,7 @@ type Playlist struct {
 	CreatorID primitive.ObjectID   `bson:"creator_id" json:"creator_id"`
 	CoverURL  string               `bson:"cover_url" json:"cover_url"`
 	Tracks    []primitive.ObjectID `bson:"tracks" json:"tracks"`
+	Covers    map[string]string    `bson:"-" json:"covers,omitempty"`
 }
 
 type generateLyricsReq struct {
@@ -92,6 +183,11 @@ type geminiResp struct {
 
 func main() {
 	loadDotEnv(".env")
+	passwords = services.PasswordHasherFromEnv()
+
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
 
 	uploadDir := strings.TrimSpace(os.Getenv("UPLOAD_DIR"))
 	if uploadDir == "" {
@@ -99,6 +195,15 @@ func main() {
 	}
 	_ = os.MkdirAll(uploadDir, 0755)
 
+	port := strings.TrimSpace(os.Getenv("PORT"))
+	if port == "" {
+		port = "8080"
+	}
+	if cfg := services.StoreConfigFromEnv(); cfg.Backend != "mongo" {
+		serveRepository(cfg, port)
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Second)
 	defer cancel()
 
@@ -108,20 +213,87 @@ func main() {
 		log.Fatal(err)
 	}
 	db = client.Database(getMongoDBName())
+	store := services.NewStoreWithDatabase(db)
+	sessions = services.NewAuthService(store)
+	apiKeys = services.NewAPIKeyService(store)
+	accounts = services.NewAccountService(store, services.MailerFromEnv())
+	policy = services.NewPolicy(legacyStore{store})
 
-	initIndexes()
+	initSchema()
+
+	limitStore, err := services.RateLimitStoreFromEnv(store)
+	if err != nil {
+		log.Fatal(err)
+	}
+	limits = ratelimit.NewLimiter(limitStore, ratelimit.RulesFromEnv(legacyRateLimits))
+	trustedProxies = handlers.TrustedProxiesFromEnv()
+	lockout = ratelimit.NewLockout(limitStore)
+	sessions.SetLimitStore(limitStore)
+	mediaSigner = media.SignerFromEnv()
+	uploads = upload.NewValidator(upload.LimitsFromEnv())
+	plays = stream.NewAccounting(trackPlays{})
+	catalog = services.NewCatalogService(store)
+	if blobs, err = storage.FromEnv(getUploadDir()); err != nil {
+		log.Fatal(err)
+	}
 
-	http.HandleFunc("/api/register", registerHandler)
-	http.HandleFunc("/api/login", loginHandler)
+	http.HandleFunc("/api/register", limited("register", registerHandler))
+	http.HandleFunc("/api/login", limited("login", loginHandler))
+	http.HandleFunc("/api/login/2fa", limited("login-2fa", loginMFAHandler))
+	http.HandleFunc("/api/2fa/setup", setupTOTPHandler)
+	http.HandleFunc("/api/2fa/enable", enableTOTPHandler)
+	http.HandleFunc("/api/2fa/disable", disableTOTPHandler)
+	http.HandleFunc("/api/2fa/recovery-codes", recoveryCodesHandler)
+	http.HandleFunc("/api/token/refresh", refreshTokenHandler)
+	http.HandleFunc("/api/logout", logoutHandler)
+	http.HandleFunc("/api/logout-all", logoutAllHandler)
+	http.HandleFunc("/api/sessions", listSessionsHandler)
+	http.HandleFunc("/api/sessions/", revokeSessionHandler)
 	http.HandleFunc("/api/update-profile", updateProfileHandler)
+	http.HandleFunc("/api/password/forgot", limited("password-reset", forgotPasswordHandler))
+	http.HandleFunc("/api/password/reset", resetPasswordHandler)
+	http.HandleFunc("/api/verify-email", limited("verify-email", verifyEmailHandler))
+	http.HandleFunc("/api/verify-email/resend", limited("verify-resend", resendVerificationHandler))
+
+	// Sign-in with OIDC_PROVIDERS runs the handlers of internal/handlers on
+	// the sessions of this server; the callback opens the app with the login
+	// of loginResponse.
+	oidcApp := handlers.NewOIDCApp(services.NewOIDCService(store, sessions, services.OIDCProvidersFromEnv()...), limits)
+	oidcApp.OIDCLogin = func(sec *models.Session, u *models.User) any { return loginResponse(u, sec) }
+	http.HandleFunc("GET /api/oidc/providers", oidcApp.ListOIDCProviders)
+	http.Handle("GET /api/oidc/", oidcApp.RateLimit("oidc", http.HandlerFunc(oidcApp.OIDCSubroutes)))
+
+	// So do the API keys, which only a session may list, create or revoke.
+	keyApp := &handlers.App{AuthS: sessions, KeyS: apiKeys}
+	http.HandleFunc("GET /api/api-keys/scopes", keyApp.ListAPIKeyScopes)
+	http.Handle("GET /api/api-keys", keyApp.Auth(keyApp.SessionOnly(http.HandlerFunc(keyApp.ListAPIKeys))))
+	http.Handle("POST /api/api-keys", keyApp.Auth(keyApp.SessionOnly(http.HandlerFunc(keyApp.CreateAPIKey))))
+	http.Handle("DELETE /api/api-keys/", keyApp.Auth(keyApp.SessionOnly(http.HandlerFunc(keyApp.RevokeAPIKey))))
+	// The reset link of the mail opens the app, which posts the new password.
+	http.HandleFunc("/reset-password", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, "public/index.html")
+	})
 
-	http.HandleFunc("/api/upload-track", uploadTrackHandler)
+	http.HandleFunc("/api/upload-track", limited("upload", uploadTrackHandler))
+	resumable := resumableUploads()
+	// Creating an upload takes an uploader, like /api/upload-track; the
+	// uploads themselves are only found by their owner.
+	http.HandleFunc("/api/uploads", limited("upload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			if _, ok := authorize(w, r, services.PermUploadTrack); !ok {
+				return
+			}
+		}
+		resumable.ServeHTTP(w, r)
+	}))
+	http.Handle("/api/uploads/", resumable)
 	http.HandleFunc("/api/delete-track", deleteTrackHandler)
 	http.HandleFunc("/api/create-album", createAlbumHandler)
 	http.HandleFunc("/api/create-playlist", createPlaylistHandler)
 	http.HandleFunc("/api/add-to-playlist", addToPlaylistHandler)
 
 	http.HandleFunc("/api/update-lyrics", updateLyricsHandler)
+	http.HandleFunc("/api/tracks/", trackRoutes)
 
 	http.HandleFunc("/api/content", contentHandler)
 	http.HandleFunc("/api/search", searchHandler)
@@ -131,20 +303,48 @@ func main() {
 	http.HandleFunc("/api/user-playlists", getUserPlaylistsHandler)
 	http.HandleFunc("/api/artist-albums", getArtistAlbumsHandler)
 
-	http.HandleFunc("/api/generate-lyrics", generateLyricsHandler)
+	http.HandleFunc("/api/generate-lyrics", limited("generate-lyrics", generateLyricsHandler))
 
 	fs := http.FileServer(http.Dir("./public"))
-	http.Handle("/", fs)
-
-	port := strings.TrimSpace(os.Getenv("PORT"))
-	if port == "" {
-		port = "8080"
-	}
+	http.Handle("/", mediaSigner.Handler(fs, mediaLive))
+	http.Handle("/uploads/", mediaSigner.Handler(storage.Handler(blobs), mediaLive))
 
 	fmt.Println("Server running on :" + port)
 	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
 
+// serveRepository runs the server of internal/handlers on the memory, file
+// or sqlite backend of cfg; the handlers of this file keep their data in
+// MongoDB. It returns on SIGINT or SIGTERM once the store is closed, so the
+// file backend writes its last snapshot.
+func serveRepository(cfg services.StoreConfig, port string) {
+	repo, err := services.OpenRepository(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stop := make(chan struct{})
+	go services.SessionCleanupWorker(repo, time.Hour, stop)
+
+	srv := &http.Server{Addr: ":" + port, Handler: handlers.NewRouter(handlers.NewApp(repo))}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdown)
+	}()
+
+	fmt.Printf("Server running on :%s with the %s store\n", port, cfg.Backend)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Println(err)
+	}
+	close(stop)
+	if err := repo.Close(); err != nil {
+		log.Fatal("closing the store: ", err)
+	}
+}
+
 func getMongoURI() string {
 	if v := strings.TrimSpace(os.Getenv("MONGODB_URI")); v != "" {
 		return v
@@ -197,21 +397,27 @@ func loadDotEnv(path string) {
 	}
 }
 
-func initIndexes() {
-	ctx := context.Background()
-
-	_, _ = db.Collection("tracks").Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "title", Value: "text"}, {Key: "artist", Value: "text"}},
-	})
-
-	_, _ = db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys:    bson.D{{Key: "email", Value: 1}},
-		Options: options.Index().SetUnique(true),
-	})
+func initSchema() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
 
-	_, _ = db.Collection("albums").Indexes().CreateOne(ctx, mongo.IndexModel{
-		Keys: bson.D{{Key: "artist_id", Value: 1}, {Key: "is_single", Value: 1}},
-	})
+	r := migrate.NewRunner(db)
+	if strings.TrimSpace(os.Getenv("MIGRATE_ON_START")) == "false" {
+		if err := r.Check(ctx); err != nil {
+			if errors.Is(err, migrate.ErrDatabaseAhead) {
+				log.Fatal(err)
+			}
+			log.Println("schema:", err)
+		}
+		return
+	}
+	applied, err := r.Up(ctx)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, st := range applied {
+		log.Printf("schema: applied migration %d (%s)", st.Version, st.Name)
+	}
 }
 
 func jsonOut(w http.ResponseWriter, code int, v any) {
@@ -222,7 +428,7 @@ func jsonOut(w http.ResponseWriter, code int, v any) {
 
 func allow(w http.ResponseWriter) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
 }
 
@@ -251,19 +457,20 @@ func updateLyricsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	u, ok := authorize(w, r, services.PermEditTrack)
+	if !ok {
+		return
+	}
+
 	var body map[string]any
 	_ = json.NewDecoder(r.Body).Decode(&body)
 
 	trackID := strings.TrimSpace(r.URL.Query().Get("track_id"))
-	userID := strings.TrimSpace(r.URL.Query().Get("user_id"))
 	lyrics := ""
 
 	if trackID == "" {
 		trackID = getAnyString(body, "track_id", "trackId", "id", "track")
 	}
-	if userID == "" {
-		userID = getAnyString(body, "user_id", "userId", "artist_id", "artistId", "user")
-	}
 
 	if v, ok := body["lyrics"]; ok {
 		if s, ok2 := v.(string); ok2 {
@@ -289,24 +496,13 @@ func updateLyricsHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
-	defer cancel()
-
-	var t Track
-	if err := db.Collection("tracks").FindOne(ctx, bson.M{"_id": tid}).Decode(&t); err != nil {
-		http.Error(w, "track not found", 404)
+	if _, err := policy.Track(u, services.PermEditTrack, tid); err != nil {
+		denied(w, err)
 		return
 	}
 
-	if userID != "" {
-		uid, err := primitive.ObjectIDFromHex(userID)
-		if err == nil {
-			if t.ArtistID != uid {
-				http.Error(w, "forbidden", 403)
-				return
-			}
-		}
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
 
 	_, err = db.Collection("tracks").UpdateOne(
 		ctx,
@@ -596,30 +792,43 @@ func splitSections(text string) []section {
 	return sections
 }
 
+// credentials is the body of /api/register and /api/login, which only
+// register reads more than the email and password of.
+type credentials struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Name     string `json:"name"`
+	Bio      string `json:"bio"`
+	Role     string `json:"role"`
+}
+
 func registerHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		return
 	}
-	var u User
-	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+	var req credentials
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid input", 400)
 		return
 	}
+	u := User{
+		Email: strings.TrimSpace(req.Email), Name: strings.TrimSpace(req.Name),
+		Bio: req.Bio, Role: strings.TrimSpace(req.Role),
+	}
 
-	u.Email = strings.TrimSpace(u.Email)
-	u.Name = strings.TrimSpace(u.Name)
-	u.Role = strings.TrimSpace(u.Role)
-
-	if u.Email == "" || u.Password == "" {
+	if u.Email == "" || req.Password == "" {
 		http.Error(w, "Email and password required", 400)
 		return
 	}
 	if u.Name == "" {
 		u.Name = "User"
 	}
-	if u.Role == "" {
-		u.Role = "user"
+	// Artist sign-ups wait for `set-role -legacy`; admins are only made there.
+	u.Requested = ""
+	if u.Role == services.RoleArtist {
+		u.Requested = services.RoleArtist
 	}
+	u.Role = services.RoleUser
 
 	count, _ := db.Collection("users").CountDocuments(context.Background(), bson.M{"email": u.Email})
 	if count > 0 {
@@ -627,21 +836,38 @@ func registerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	hash, _ := bcrypt.GenerateFromPassword([]byte(u.Password), 14)
-	u.Password = string(hash)
+	hash, err := passwords.Hash(req.Password)
+	if err != nil {
+		http.Error(w, "Server error", 500)
+		return
+	}
+	u.Password = hash
 	u.ID = primitive.NewObjectID()
 
-	_, err := db.Collection("users").InsertOne(context.Background(), u)
+	_, err = db.Collection("users").InsertOne(context.Background(), u)
+	if err != nil {
+		http.Error(w, "Server error", 500)
+		return
+	}
+	if err := accounts.SendVerification(&models.User{ID: u.ID, Email: u.Email}); err != nil {
+		log.Println("verification mail:", err)
+	}
+	sec, err := sessions.StartSession(u.ID, legacyClient(r))
 	if err != nil {
 		http.Error(w, "Server error", 500)
 		return
 	}
 	jsonOut(w, 200, map[string]any{
-		"id":    u.ID.Hex(),
-		"email": u.Email,
-		"name":  u.Name,
-		"bio":   u.Bio,
-		"role":  u.Role,
+		"id":             u.ID.Hex(),
+		"email":          u.Email,
+		"name":           u.Name,
+		"bio":            u.Bio,
+		"role":           u.Role,
+		"requested_role": u.Requested,
+		"email_verified": false,
+		"token":          sec.Token,
+		"refresh_token":  sec.RefreshToken,
+		"expires_at":     sec.AccessExpiresAt,
 	})
 
 }
@@ -650,195 +876,1057 @@ func loginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		return
 	}
-	var req User
+	var req credentials
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid input", 400)
 		return
 	}
 
 	req.Email = strings.TrimSpace(req.Email)
+	account := strings.ToLower(req.Email)
+	if tooMany(w, lockout.Check(r.Context(), account)) {
+		return
+	}
 
 	var u User
 	err := db.Collection("users").FindOne(context.Background(), bson.M{"email": req.Email}).Decode(&u)
 	if err != nil {
-		http.Error(w, "User not found", 404)
+		if !tooMany(w, lockout.Fail(r.Context(), account)) {
+			http.Error(w, "User not found", 404)
+		}
 		return
 	}
 
-	if err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(req.Password)); err != nil {
-		http.Error(w, "Invalid password", 401)
+	ok, rehash := passwords.Verify(u.Password, req.Password)
+	if !ok {
+		if !tooMany(w, lockout.Fail(r.Context(), account)) {
+			http.Error(w, "Invalid password", 401)
+		}
 		return
 	}
-	jsonOut(w, 200, map[string]any{
-		"id":    u.ID.Hex(),
-		"email": u.Email,
-		"name":  u.Name,
-		"bio":   u.Bio,
-		"role":  u.Role,
-	})
+	if rehash {
+		if hash, err := passwords.Hash(req.Password); err == nil {
+			_, _ = db.Collection("users").UpdateOne(context.Background(), bson.M{"_id": u.ID}, bson.M{"$set": bson.M{"password": hash}})
+		}
+	}
+	mu := &models.User{
+		ID: u.ID, Email: u.Email, Name: u.Name, Bio: u.Bio, Role: u.Role,
+		EmailVerified: u.Verified, TOTPEnabled: u.TOTP,
+	}
+	sec, _, err := sessions.FinishLogin(mu, legacyClient(r))
+	var c *services.MFAChallenge
+	if errors.As(err, &c) {
+		// Finished at /api/login/2fa, which counts wrong codes against the
+		// lockout.
+		jsonOut(w, 200, map[string]any{"mfa_required": true, "mfa_token": c.Token, "mfa_expires_at": c.ExpiresAt})
+		return
+	}
+	if err != nil {
+		http.Error(w, "Server error", 500)
+		return
+	}
+	lockout.Success(r.Context(), account)
+	jsonOut(w, 200, loginResponse(mu, sec))
+}
 
+// loginResponse is what public/js/app.js keeps of a login: the user and the
+// tokens, and whether the role of the user needs two-factor authentication
+// set up before it gives more than a listener may do.
+func loginResponse(u *models.User, sec *models.Session) map[string]any {
+	return map[string]any{
+		"id":                 u.ID.Hex(),
+		"email":              u.Email,
+		"name":               u.Name,
+		"bio":                u.Bio,
+		"role":               u.Role,
+		"email_verified":     u.EmailVerified,
+		"totp_enabled":       u.TOTPEnabled,
+		"mfa_setup_required": !u.TOTPEnabled && policy.MFARequired(u),
+		"token":              sec.Token,
+		"refresh_token":      sec.RefreshToken,
+		"expires_at":         sec.AccessExpiresAt,
+	}
 }
 
-func updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+// loginMFAHandler is the second step of a login that answered mfa_required.
+// The code is a TOTP code or one of the recovery codes.
+func loginMFAHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		return
 	}
 	var req struct {
-		ID   string `json:"id"`
-		Name string `json:"name"`
-		Bio  string `json:"bio"`
+		MFAToken string `json:"mfa_token"`
+		Code     string `json:"code"`
 	}
-	_ = json.NewDecoder(r.Body).Decode(&req)
-	oid, _ := primitive.ObjectIDFromHex(req.ID)
-
-	update := bson.M{
-		"name": strings.TrimSpace(req.Name),
-		"bio":  strings.TrimSpace(req.Bio),
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		http.Error(w, "mfa_token and code required", 400)
+		return
 	}
-	_, _ = db.Collection("users").UpdateOne(context.Background(), bson.M{"_id": oid}, bson.M{"$set": update})
-
-	var u User
-	_ = db.Collection("users").FindOne(context.Background(), bson.M{"_id": oid}).Decode(&u)
-	jsonOut(w, 200, u)
+	sec, u, err := sessions.VerifyMFA(req.MFAToken, req.Code, legacyClient(r))
+	if tooMany(w, err) {
+		return
+	}
+	if err != nil {
+		http.Error(w, "invalid code, sign in again", 401)
+		return
+	}
+	jsonOut(w, 200, loginResponse(u, sec))
 }
 
-func createAlbumHandler(w http.ResponseWriter, r *http.Request) {
-	_ = r.ParseMultipartForm(10 << 20)
-	title := strings.TrimSpace(r.FormValue("title"))
-	artistName := strings.TrimSpace(r.FormValue("artist_name"))
-	artistID, _ := primitive.ObjectIDFromHex(r.FormValue("artist_id"))
-	coverURL := saveFile(r, "cover")
-
-	if title == "" {
-		http.Error(w, "title required", 400)
+func setupTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
 		return
 	}
-
-	album := Album{
-		ID: primitive.NewObjectID(), Title: title, Artist: artistName, ArtistID: artistID,
-		CoverURL: coverURL, IsSingle: false, ReleaseDate: time.Now(),
+	u, err := authUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", 401)
+		return
 	}
-	_, _ = db.Collection("albums").InsertOne(context.Background(), album)
-	jsonOut(w, 200, album)
+	secret, uri, err := sessions.BeginTOTP(u)
+	switch {
+	case errors.Is(err, services.ErrAlreadyExists):
+		http.Error(w, "two-factor authentication is already on", 409)
+		return
+	case err != nil:
+		http.Error(w, "Server error", 500)
+		return
+	}
+	jsonOut(w, 200, map[string]string{"secret": secret, "otpauth_url": uri})
 }
 
-func uploadTrackHandler(w http.ResponseWriter, r *http.Request) {
-	_ = r.ParseMultipartForm(50 << 20)
-	title := strings.TrimSpace(r.FormValue("title"))
-	artistName := strings.TrimSpace(r.FormValue("artist_name"))
-	artistID, _ := primitive.ObjectIDFromHex(r.FormValue("artist_id"))
-	albumIDStr := strings.TrimSpace(r.FormValue("album_id"))
-	lyrics := r.FormValue("lyrics")
+// mfaCode reads the code of the /api/2fa/ endpoints that need one, with
+// the caller.
+func mfaCode(w http.ResponseWriter, r *http.Request) (*models.User, string, bool) {
+	if r.Method != "POST" {
+		return nil, "", false
+	}
+	u, err := authUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", 401)
+		return nil, "", false
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" {
+		http.Error(w, "code required", 400)
+		return nil, "", false
+	}
+	return u, req.Code, true
+}
 
-	if title == "" {
-		http.Error(w, "title required", 400)
+func enableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	u, code, ok := mfaCode(w, r)
+	if !ok {
 		return
 	}
-
-	var albumID primitive.ObjectID
-	var coverURL string
-	var isSingle bool
-
-	if albumIDStr != "" && albumIDStr != "single" {
-		albumID, _ = primitive.ObjectIDFromHex(albumIDStr)
-		var alb Album
-		_ = db.Collection("albums").FindOne(context.Background(), bson.M{"_id": albumID}).Decode(&alb)
-		coverURL = alb.CoverURL
-		isSingle = false
-	} else {
-		albumID = primitive.NewObjectID()
-		coverURL = saveFile(r, "cover")
-		isSingle = true
-
-		album := Album{
-			ID: albumID, Title: title, Artist: artistName, ArtistID: artistID,
-			CoverURL: coverURL, IsSingle: true, ReleaseDate: time.Now(),
-		}
-		_, _ = db.Collection("albums").InsertOne(context.Background(), album)
+	codes, err := sessions.EnableTOTP(u, code)
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		http.Error(w, "call /api/2fa/setup first", 400)
+		return
+	case errors.Is(err, services.ErrUnauthorized):
+		http.Error(w, "invalid code", 400)
+		return
+	case errors.Is(err, services.ErrAlreadyExists):
+		http.Error(w, "two-factor authentication is already on", 409)
+		return
+	case err != nil:
+		http.Error(w, "Server error", 500)
+		return
 	}
+	jsonOut(w, 200, map[string]any{"status": "enabled", "recovery_codes": codes})
+}
 
-	file, header, err := r.FormFile("audio")
-	if err != nil {
-		http.Error(w, "audio required", 400)
+func disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	u, code, ok := mfaCode(w, r)
+	if !ok {
 		return
 	}
-	defer file.Close()
-
-	ext := filepath.Ext(header.Filename)
-	name := fmt.Sprintf("%d%s", time.Now().UnixNano(), ext)
-
-	uploadDir := getUploadDir()
-	_ = os.MkdirAll(uploadDir, 0755)
-
-	dst, err := os.Create(filepath.Join(uploadDir, name))
-	if err != nil {
-		http.Error(w, "cannot save file", 500)
+	switch err := sessions.DisableTOTP(u, code); {
+	case errors.Is(err, services.ErrForbidden):
+		http.Error(w, "two-factor authentication is required for your role", 403)
+		return
+	case errors.Is(err, services.ErrUnauthorized):
+		http.Error(w, "invalid code", 400)
+		return
+	case errors.Is(err, services.ErrNotFound):
+		http.Error(w, "two-factor authentication is off", 404)
+		return
+	case err != nil:
+		http.Error(w, "Server error", 500)
 		return
 	}
-	_, _ = io.Copy(dst, file)
-	_ = dst.Close()
+	jsonOut(w, 200, map[string]string{"status": "disabled"})
+}
 
-	track := Track{
-		ID: primitive.NewObjectID(), Title: title, Artist: artistName, ArtistID: artistID,
-		AlbumID: albumID, CoverURL: coverURL, AudioURL: "/uploads/" + name,
-		Lyrics: lyrics, IsSingle: isSingle, CreatedAt: time.Now(),
+func recoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	u, code, ok := mfaCode(w, r)
+	if !ok {
+		return
 	}
-	_, _ = db.Collection("tracks").InsertOne(context.Background(), track)
-
-	jsonOut(w, 200, map[string]string{"status": "ok"})
+	codes, err := sessions.RegenerateRecoveryCodes(u, code)
+	switch {
+	case errors.Is(err, services.ErrUnauthorized):
+		http.Error(w, "invalid code", 400)
+		return
+	case errors.Is(err, services.ErrNotFound):
+		http.Error(w, "two-factor authentication is off", 404)
+		return
+	case err != nil:
+		http.Error(w, "Server error", 500)
+		return
+	}
+	jsonOut(w, 200, map[string]any{"recovery_codes": codes})
 }
 
-func deleteTrackHandler(w http.ResponseWriter, r *http.Request) {
+// forgotPasswordHandler answers the same way whether or not the address
+// exists.
+func forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		return
 	}
 	var req struct {
-		ID string `json:"id"`
+		Email string `json:"email"`
 	}
-	_ = json.NewDecoder(r.Body).Decode(&req)
-
-	oid, _ := primitive.ObjectIDFromHex(req.ID)
-
-	_, _ = db.Collection("tracks").DeleteOne(context.Background(), bson.M{"_id": oid})
-	_, _ = db.Collection("playlists").UpdateMany(context.Background(), bson.M{}, bson.M{"$pull": bson.M{"tracks": oid}})
-
-	jsonOut(w, 200, map[string]string{"status": "deleted"})
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		http.Error(w, "email required", 400)
+		return
+	}
+	if err := accounts.RequestPasswordReset(req.Email); err != nil {
+		log.Println("password reset:", err)
+	}
+	jsonOut(w, 202, map[string]string{"status": "if the account exists, a reset link was sent"})
 }
 
-func createPlaylistHandler(w http.ResponseWriter, r *http.Request) {
-	_ = r.ParseMultipartForm(10 << 20)
-	title := strings.TrimSpace(r.FormValue("title"))
-	creator := strings.TrimSpace(r.FormValue("creator"))
-	creatorID, _ := primitive.ObjectIDFromHex(r.FormValue("creator_id"))
-	coverURL := saveFile(r, "cover")
+func resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		return
+	}
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" || req.Password == "" {
+		http.Error(w, "token and password required", 400)
+		return
+	}
+	switch err := accounts.ResetPassword(req.Token, req.Password); {
+	case errors.Is(err, services.ErrNotFound):
+		http.Error(w, "invalid or expired token", 400)
+		return
+	case err != nil:
+		http.Error(w, "Server error", 500)
+		return
+	}
+	jsonOut(w, 200, map[string]string{"status": "password changed"})
+}
 
-	if title == "" {
-		http.Error(w, "title required", 400)
+// verifyEmailHandler takes the token from the mailed link (GET) or a JSON
+// body.
+func verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" && r.Method == "POST" {
+		var req struct {
+			Token string `json:"token"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		token = req.Token
+	}
+	if token == "" {
+		http.Error(w, "token required", 400)
+		return
+	}
+	switch err := accounts.VerifyEmail(token); {
+	case errors.Is(err, services.ErrNotFound):
+		http.Error(w, "invalid or expired token", 400)
+		return
+	case err != nil:
+		http.Error(w, "Server error", 500)
 		return
 	}
+	jsonOut(w, 200, map[string]string{"status": "email verified"})
+}
 
-	p := Playlist{
-		ID: primitive.NewObjectID(), Title: title, Creator: creator, CreatorID: creatorID,
-		CoverURL: coverURL, Tracks: []primitive.ObjectID{},
+func resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		return
 	}
-	_, _ = db.Collection("playlists").InsertOne(context.Background(), p)
-	jsonOut(w, 200, p)
+	u, err := authUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", 401)
+		return
+	}
+	if u.EmailVerified {
+		jsonOut(w, 200, map[string]string{"status": "already verified"})
+		return
+	}
+	if err := accounts.SendVerification(u); err != nil {
+		http.Error(w, "Server error", 500)
+		return
+	}
+	jsonOut(w, 202, map[string]string{"status": "sent"})
 }
 
-func addToPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+func refreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		return
+	}
 	var req struct {
-		PlaylistID string `json:"playlist_id"`
-		TrackID    string `json:"track_id"`
+		RefreshToken string `json:"refresh_token"`
 	}
 	_ = json.NewDecoder(r.Body).Decode(&req)
 
-	pID, _ := primitive.ObjectIDFromHex(req.PlaylistID)
-	tID, _ := primitive.ObjectIDFromHex(req.TrackID)
+	sec, err := sessions.Refresh(req.RefreshToken, legacyClient(r))
+	if err != nil {
+		http.Error(w, "Invalid refresh token", 401)
+		return
+	}
+	jsonOut(w, 200, map[string]any{
+		"token":         sec.Token,
+		"refresh_token": sec.RefreshToken,
+		"expires_at":    sec.AccessExpiresAt,
+	})
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		return
+	}
+	sec, _, ok := sessionOnly(w, r)
+	if !ok {
+		return
+	}
+	if err := sessions.Logout(sec); err != nil {
+		http.Error(w, "Server error", 500)
+		return
+	}
+	jsonOut(w, 200, map[string]string{"status": "logged out"})
+}
+
+func logoutAllHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		return
+	}
+	u, err := authUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", 401)
+		return
+	}
+	jsonOut(w, 200, map[string]any{"status": "logged out", "revoked": sessions.LogoutAll(u.ID)})
+}
+
+// listSessionsHandler serves GET /api/sessions, the sessions of the caller
+// with the one making the request marked current.
+func listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.NotFound(w, r)
+		return
+	}
+	cur, u, ok := sessionOnly(w, r)
+	if !ok {
+		return
+	}
+	type session struct {
+		*models.Session
+		Current bool `json:"current"`
+	}
+	res := []session{}
+	for _, sec := range sessions.ListSessions(u.ID) {
+		res = append(res, session{Session: sec, Current: sec.ID == cur.ID})
+	}
+	jsonOut(w, 200, res)
+}
+
+// revokeSessionHandler serves DELETE /api/sessions/{id}, which signs out one
+// session of the caller.
+func revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		http.NotFound(w, r)
+		return
+	}
+	u, err := authUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", 401)
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(strings.TrimPrefix(r.URL.Path, "/api/sessions/"))
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	switch err := sessions.RevokeSession(u.ID, oid); {
+	case errors.Is(err, services.ErrNotFound):
+		http.Error(w, "session not found", 404)
+		return
+	case err != nil:
+		http.Error(w, "Server error", 500)
+		return
+	}
+	jsonOut(w, 200, map[string]string{"status": "revoked"})
+}
+
+func legacyClient(r *http.Request) services.ClientInfo {
+	return services.ClientInfo{UserAgent: r.UserAgent(), IP: handlers.ClientIP(r, trustedProxies)}
+}
+
+// limited takes a token per client address, and per user when the request
+// carries a valid bearer token, plus one from the route's "-total" bucket if
+// it has a rule.
+func limited(route string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			h(w, r)
+			return
+		}
+		keys := []string{"ip:" + legacyClient(r).IP}
+		if r.Header.Get("Authorization") != "" {
+			if _, u, _, err := authSession(r); err == nil {
+				keys = append(keys, "user:"+u.ID.Hex())
+			}
+		}
+		err := limits.Allow(r.Context(), route, keys...)
+		if err == nil {
+			err = limits.Allow(r.Context(), route+"-total", "all")
+		}
+		if tooMany(w, err) {
+			return
+		}
+		h(w, r)
+	}
+}
+
+// tooMany answers a *ratelimit.LimitError with 429 and Retry-After.
+func tooMany(w http.ResponseWriter, err error) bool {
+	var le *ratelimit.LimitError
+	if !errors.As(err, &le) {
+		return false
+	}
+	w.Header().Set("Retry-After", le.RetryAfterSeconds())
+	http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	return true
+}
+
+// mediaURLs returns what turns stored media URLs into the ones the caller
+// of r fetches: the blob store's URL for uploads, signed when it is served
+// here. The session is only looked up when links are bound to one.
+func mediaURLs(r *http.Request) func(string) string {
+	var user, session string
+	tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if ok && tok != "" && mediaSigner.Bind != media.BindNone {
+		if sec, u, err := sessions.Authenticate(tok); err == nil {
+			user, session = u.ID.Hex(), sec.ID.Hex()
+		}
+	}
+	return func(raw string) string {
+		return mediaSigner.Sign(storage.URL(blobs, raw, mediaSigner.TTL), user, session)
+	}
+}
+
+func signTracks(sign func(string) string, tracks []Track) {
+	for i := range tracks {
+		tracks[i].AudioURL = sign(tracks[i].AudioURL)
+		tracks[i].Covers = signCovers(sign, tracks[i].CoverURL)
+		tracks[i].CoverURL = sign(tracks[i].CoverURL)
+		tracks[i].StreamURL = sign(stream.Path(tracks[i].ID))
+	}
+}
+
+func signAlbums(sign func(string) string, albums []Album) {
+	for i := range albums {
+		albums[i].Covers = signCovers(sign, albums[i].CoverURL)
+		albums[i].CoverURL = sign(albums[i].CoverURL)
+	}
+}
+
+func signPlaylists(sign func(string) string, playlists []Playlist) {
+	for i := range playlists {
+		playlists[i].Covers = signCovers(sign, playlists[i].CoverURL)
+		playlists[i].CoverURL = sign(playlists[i].CoverURL)
+	}
+}
+
+// signCovers returns the URLs of the variants of a stored cover.
+func signCovers(sign func(string) string, cover string) map[string]string {
+	vs := storage.Variants(blobs, cover)
+	if vs == nil {
+		return nil
+	}
+	res := make(map[string]string, len(vs))
+	for size, u := range vs {
+		res[size] = sign(u)
+	}
+	return res
+}
+
+// mediaLive ends the links of signed-out sessions and deleted users.
+func mediaLive(c *media.Claims) bool {
+	userID, err := primitive.ObjectIDFromHex(c.User)
+	if err != nil {
+		return false
+	}
+	var sessionID primitive.ObjectID
+	if c.Session != "" {
+		if sessionID, err = primitive.ObjectIDFromHex(c.Session); err != nil {
+			return false
+		}
+	}
+	return sessions.Active(userID, sessionID)
+}
+
+// sessionOnly is authSession for signing out and the list of sessions,
+// which API keys do not get to. It answers w itself when there is no
+// session.
+func sessionOnly(w http.ResponseWriter, r *http.Request) (*models.Session, *models.User, bool) {
+	sec, u, _, err := authSession(r)
+	switch {
+	case err != nil:
+		http.Error(w, "unauthorized", 401)
+	case sec == nil:
+		http.Error(w, "not available with an api key", 403)
+	default:
+		return sec, u, true
+	}
+	return nil, nil, false
+}
+
+// authUser resolves the bearer token sent by public/js/app.js. API keys
+// are refused; they only do what authorize checks their scopes for.
+func authUser(r *http.Request) (*models.User, error) {
+	sec, u, _, err := authSession(r)
+	if err == nil && sec == nil {
+		return nil, services.ErrUnauthorized
+	}
+	return u, err
+}
+
+// authorize answers r with 401 or 403 unless policy lets the caller do
+// perm, and the scopes of the API key allow it when the caller sent one. It
+// returns the user otherwise.
+func authorize(w http.ResponseWriter, r *http.Request, perm services.Permission) (*models.User, bool) {
+	_, u, key, err := authSession(r)
+	if err == nil {
+		err = policy.Require(u, perm)
+	}
+	if err != nil {
+		denied(w, err)
+		return nil, false
+	}
+	if key != nil && !services.ScopesAllow(key.Scopes, perm) {
+		http.Error(w, "api key scope does not allow this", 403)
+		return nil, false
+	}
+	return u, true
+}
+
+// denied answers w with the status of a policy error.
+func denied(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, services.ErrNotFound):
+		http.Error(w, "not found", 404)
+	case errors.Is(err, services.ErrUnauthorized):
+		http.Error(w, "unauthorized", 401)
+	case errors.Is(err, services.ErrUnverified):
+		http.Error(w, "email not verified", 403)
+	case errors.Is(err, services.ErrMFASetupRequired):
+		http.Error(w, "two-factor authentication required", 403)
+	default:
+		http.Error(w, "forbidden", 403)
+	}
+}
+
+// legacyStore is the store of policy. The playlists of the legacy handlers
+// name their owner creator_id rather than user_id.
+type legacyStore struct {
+	*services.Store
+}
+
+func (legacyStore) GetPlaylist(id primitive.ObjectID) (*models.Playlist, error) {
+	var p Playlist
+	if err := db.Collection("playlists").FindOne(context.Background(), bson.M{"_id": id}).Decode(&p); err != nil {
+		return nil, services.ErrNotFound
+	}
+	return &models.Playlist{ID: p.ID, UserID: p.CreatorID, Title: p.Title, CoverURL: p.CoverURL}, nil
+}
+
+// authSession is authUser for the handlers that need the session as well.
+// An API key gives its user and the key, with a nil session.
+func authSession(r *http.Request) (*models.Session, *models.User, *models.APIKey, error) {
+	tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || tok == "" {
+		return nil, nil, nil, services.ErrUnauthorized
+	}
+	if strings.HasPrefix(tok, services.APIKeyPrefix) {
+		key, u, err := apiKeys.Authenticate(tok)
+		return nil, u, key, err
+	}
+	sec, u, err := sessions.Authenticate(tok)
+	return sec, u, nil, err
+}
+
+// updateProfileHandler changes the name and bio of the caller.
+func updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		return
+	}
+	me, err := authUser(r)
+	if err != nil {
+		http.Error(w, "unauthorized", 401)
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+		Bio  string `json:"bio"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	update := bson.M{
+		"name": strings.TrimSpace(req.Name),
+		"bio":  strings.TrimSpace(req.Bio),
+	}
+	if _, err := db.Collection("users").UpdateOne(context.Background(), bson.M{"_id": me.ID}, bson.M{"$set": update}); err != nil {
+		http.Error(w, "Server error", 500)
+		return
+	}
+
+	var u User
+	if err := db.Collection("users").FindOne(context.Background(), bson.M{"_id": me.ID}).Decode(&u); err != nil {
+		http.Error(w, "Server error", 500)
+		return
+	}
+	jsonOut(w, 200, u)
+}
+
+// createAlbumHandler creates an album of the calling artist.
+func createAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := authorize(w, r, services.PermCreateAlbum)
+	if !ok {
+		return
+	}
+	form, ok := readUpload(w, r, coverSpec)
+	if !ok {
+		return
+	}
+	defer form.Close()
+	title := strings.TrimSpace(form.Value("title"))
+
+	if title == "" {
+		http.Error(w, "title required", 400)
+		return
+	}
+	coverURL, err := saveFile(form.Files["cover"])
+	if err != nil {
+		uploadErr(w, err)
+		return
+	}
+
+	album := Album{
+		ID: primitive.NewObjectID(), Title: title, Artist: u.Name, ArtistID: u.ID,
+		CoverURL: coverURL, IsSingle: false, ReleaseDate: time.Now(),
+	}
+	if _, err := db.Collection("albums").InsertOne(context.Background(), album); err != nil {
+		http.Error(w, "Server error", 500)
+		return
+	}
+	sign := mediaURLs(r)
+	album.Covers = signCovers(sign, album.CoverURL)
+	album.CoverURL = sign(album.CoverURL)
+	jsonOut(w, 200, album)
+}
+
+// uploadTrackHandler adds a track by the calling artist. What the form
+// leaves out comes from the ID3 tags of the file: title, lyrics, cover and,
+// when album_id is missing rather than "single", the album named in the
+// tags.
+func uploadTrackHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := authorize(w, r, services.PermUploadTrack)
+	if !ok {
+		return
+	}
+	form, ok := readUpload(w, r, upload.Spec{
+		Files: map[string]upload.Kind{"audio": upload.Audio, "cover": upload.Image},
+	})
+	if !ok {
+		return
+	}
+	defer form.Close()
+	file := form.Files["audio"]
+	if file == nil {
+		http.Error(w, "audio required", 400)
+		return
+	}
+
+	tf := trackForm{
+		Title: form.Value("title"), AlbumID: form.Value("album_id"), Lyrics: form.Value("lyrics"),
+		cover: form.Files["cover"],
+	}
+	if _, ok := addTrack(w, u, tf, file.Blob, file.Result); ok {
+		jsonOut(w, 200, map[string]string{"status": "ok"})
+	}
+}
+
+// trackForm is what an upload says about a track besides the audio: the
+// form of /api/upload-track or the metadata of a resumable upload.
+type trackForm struct {
+	Title, AlbumID, Lyrics string
+	// cover is the cover file of a form.
+	cover *upload.File
+}
+
+// addTrack commits the checked audio in blob and inserts the track of the
+// artist u, and the album it goes on when there is none yet. An album named
+// by id has to be theirs. It answers w itself on failure.
+func addTrack(w http.ResponseWriter, u *models.User, form trackForm, blob storage.Writer, res *upload.Result) (*Track, bool) {
+	title := strings.TrimSpace(form.Title)
+	artistName, artistID := u.Name, u.ID
+	albumIDStr := strings.TrimSpace(form.AlbumID)
+	lyrics := form.Lyrics
+	cover := func() (string, error) { return saveFile(form.cover) }
+
+	// Only MP3s carry tags we read.
+	info := res.Audio
+	if info == nil {
+		info = &audio.Info{}
+	}
+	if title == "" {
+		title = info.Title
+	}
+	if lyrics == "" {
+		lyrics = info.Lyrics
+	}
+	if title == "" {
+		http.Error(w, "title required", 400)
+		return nil, false
+	}
+
+	var err error
+	var albumID primitive.ObjectID
+	var coverURL string
+	var isSingle bool
+
+	if albumIDStr != "" && albumIDStr != "single" {
+		albumID, _ = primitive.ObjectIDFromHex(albumIDStr)
+		alb, err := policy.Album(u, services.PermEditAlbum, albumID)
+		if err != nil {
+			denied(w, err)
+			return nil, false
+		}
+		coverURL = alb.CoverURL
+		isSingle = false
+	} else if albumIDStr == "" && info.Album != "" {
+		if coverURL, err = cover(); err != nil {
+			uploadErr(w, err)
+			return nil, false
+		}
+		var alb Album
+		filter := bson.M{"artist_id": artistID, "title": info.Album, "is_single": false}
+		if err := db.Collection("albums").FindOne(context.Background(), filter).Decode(&alb); err != nil {
+			if coverURL == "" && info.Picture != nil {
+				coverURL = saveImage(info.Picture.Data)
+			}
+			alb = Album{
+				ID: primitive.NewObjectID(), Title: info.Album, Artist: artistName, ArtistID: artistID,
+				CoverURL: coverURL, ReleaseDate: time.Now(),
+			}
+			_, _ = db.Collection("albums").InsertOne(context.Background(), alb)
+		} else if coverURL == "" {
+			coverURL = alb.CoverURL
+		}
+		albumID = alb.ID
+	} else {
+		if coverURL, err = cover(); err != nil {
+			uploadErr(w, err)
+			return nil, false
+		}
+		if coverURL == "" && info.Picture != nil {
+			coverURL = saveImage(info.Picture.Data)
+		}
+		albumID = primitive.NewObjectID()
+		isSingle = true
+
+		album := Album{
+			ID: albumID, Title: title, Artist: artistName, ArtistID: artistID,
+			CoverURL: coverURL, IsSingle: true, ReleaseDate: time.Now(),
+		}
+		_, _ = db.Collection("albums").InsertOne(context.Background(), album)
+	}
+
+	name, err := blob.Commit(res.Format.Exts[0])
+	if err != nil {
+		http.Error(w, "cannot save file", 500)
+		return nil, false
+	}
+
+	track := Track{
+		ID: primitive.NewObjectID(), Title: title, Artist: artistName, ArtistID: artistID,
+		AlbumID: albumID, CoverURL: coverURL, AudioURL: "/uploads/" + name,
+		Lyrics: lyrics, Duration: info.Seconds(), TrackNo: info.Track, Genre: info.Genre, Year: info.Year,
+		Gapless: services.GaplessInfo(info), IsSingle: isSingle, CreatedAt: time.Now(),
+	}
+	_, _ = db.Collection("tracks").InsertOne(context.Background(), track)
+	catalog.Analyze(blobs, &models.Track{ID: track.ID, AlbumID: track.AlbumID, AudioURL: track.AudioURL})
+	return &track, true
+}
 
-	_, _ = db.Collection("playlists").UpdateOne(context.Background(),
+// resumableUploads takes track audio over tus at /api/uploads; the metadata
+// carries filename and the fields of /api/upload-track but the cover. The
+// finished upload becomes a track, whose id the final PATCH returns in
+// Upload-Result.
+func resumableUploads() http.Handler {
+	return &tus.Handler{
+		Store:   tus.StoreFromEnv(),
+		Base:    "/api/uploads",
+		MaxSize: uploads.Resumable().MaxAudioBytes,
+		Owner: func(r *http.Request) string {
+			if _, u, _, err := authSession(r); err == nil {
+				return u.ID.Hex()
+			}
+			return ""
+		},
+		Complete: func(w http.ResponseWriter, r *http.Request, up *tus.Upload, data *os.File) (string, bool) {
+			u, ok := authorize(w, r, services.PermUploadTrack)
+			if !ok {
+				return "", false
+			}
+			m := up.Metadata
+			file, err := uploads.Resumable().Stage(blobs, data, m["filename"], upload.Audio)
+			if err != nil {
+				uploadErr(w, err)
+				return "", false
+			}
+			defer file.Blob.Abort()
+			form := trackForm{Title: m["title"], AlbumID: m["album_id"], Lyrics: m["lyrics"]}
+			t, ok := addTrack(w, u, form, file.Blob, file.Result)
+			if !ok {
+				return "", false
+			}
+			return t.ID.Hex(), true
+		},
+	}
+}
+
+// trackRoutes serves /api/tracks/{id}/stream and /api/tracks/{id}/waveform.
+func trackRoutes(w http.ResponseWriter, r *http.Request) {
+	if strings.HasSuffix(r.URL.Path, "/waveform") {
+		trackWaveformHandler(w, r)
+		return
+	}
+	streamTrackHandler(w, r)
+}
+
+// trackWaveformHandler serves GET /api/tracks/{id}/waveform, the peaks of
+// the track for drawing its waveform; see waveform.Format. The peaks are
+// made by catalog.Analyze after the upload, or by measure-loudness for older
+// tracks; until then the answer is 404.
+func trackWaveformHandler(w http.ResponseWriter, r *http.Request) {
+	hex := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/tracks/"), "/waveform")
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.NotFound(w, r)
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+	format := waveform.Format(r)
+	if format == "" {
+		http.Error(w, "format is json or dat", 400)
+		return
+	}
+
+	var t Track
+	if err := db.Collection("tracks").FindOne(context.Background(), bson.M{"_id": oid}).Decode(&t); err != nil {
+		http.Error(w, "track not found", 404)
+		return
+	}
+	s, name, err := stream.Blob(blobs, "public", t.AudioURL)
+	if err != nil {
+		http.Error(w, "waveform not available", 404)
+		return
+	}
+	p, err := analysis.Peaks(s, name, waveform.Requested(r))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		http.Error(w, "waveform not available", 404)
+		return
+	case err != nil:
+		log.Println("waveform", hex+":", err)
+		http.Error(w, "cannot read audio", 500)
+		return
+	}
+	waveform.Serve(w, p, format)
+}
+
+// streamTrackHandler serves GET /api/tracks/{id}/stream to the bearer of a
+// token or of the signed stream_url, with range support, and counts the
+// plays it starts.
+func streamTrackHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/api/tracks/")
+	hex, ok := strings.CutSuffix(rest, "/stream")
+	if !ok || (r.Method != "GET" && r.Method != "HEAD") {
+		http.NotFound(w, r)
+		return
+	}
+	oid, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		http.Error(w, "bad id", 400)
+		return
+	}
+
+	var listener string
+	maxAge := time.Hour
+	if u, err := authUser(r); err == nil {
+		listener = "user:" + u.ID.Hex()
+	} else {
+		c, err := mediaSigner.Verify(r.URL.Path, r.URL.Query())
+		if err != nil || (c.User != "" || c.Session != "") && !mediaLive(c) {
+			http.Error(w, "invalid or expired link", 403)
+			return
+		}
+		listener = "user:" + c.User
+		if c.User == "" {
+			c := legacyClient(r)
+			listener = "anon:" + c.IP + "|" + c.UserAgent
+		}
+		maxAge = time.Until(c.Expires)
+	}
+
+	var t Track
+	if err := db.Collection("tracks").FindOne(context.Background(), bson.M{"_id": oid}).Decode(&t); err != nil {
+		http.Error(w, "track not found", 404)
+		return
+	}
+	f, info, err := stream.Open(blobs, "public", t.AudioURL)
+	switch {
+	case errors.Is(err, stream.ErrNotLocal), errors.Is(err, os.ErrNotExist):
+		http.Error(w, "audio not available", 404)
+		return
+	case err != nil:
+		log.Println("stream", hex+":", err)
+		http.Error(w, "cannot read audio", 500)
+		return
+	}
+	defer f.Close()
+
+	length := time.Duration(t.Duration) * time.Second
+	stream.Serve(w, r, f, info, maxAge, func() {
+		plays.Start(stream.Event{TrackID: t.ID, Listener: listener}, length)
+	})
+}
+
+// trackPlays adds the plays counted by plays to the tracks collection.
+type trackPlays struct{}
+
+func (trackPlays) Played(ev stream.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := db.Collection("tracks").UpdateOne(ctx, bson.M{"_id": ev.TrackID}, bson.M{"$inc": bson.M{"plays": 1}})
+	return err
+}
+
+func deleteTrackHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		return
+	}
+	u, ok := authorize(w, r, services.PermDeleteTrack)
+	if !ok {
+		return
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	oid, _ := primitive.ObjectIDFromHex(req.ID)
+
+	t, err := policy.Track(u, services.PermDeleteTrack, oid)
+	if err != nil {
+		denied(w, err)
+		return
+	}
+
+	_, _ = db.Collection("tracks").DeleteOne(context.Background(), bson.M{"_id": oid})
+	_, _ = db.Collection("playlists").UpdateMany(context.Background(), bson.M{}, bson.M{"$pull": bson.M{"tracks": oid}})
+	if !t.AlbumID.IsZero() {
+		catalog.AnalyzeAlbum(blobs, t.AlbumID)
+	}
+
+	jsonOut(w, 200, map[string]string{"status": "deleted"})
+}
+
+// createPlaylistHandler creates a playlist of the caller.
+func createPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := authorize(w, r, services.PermManagePlaylists)
+	if !ok {
+		return
+	}
+	form, ok := readUpload(w, r, coverSpec)
+	if !ok {
+		return
+	}
+	defer form.Close()
+	title := strings.TrimSpace(form.Value("title"))
+
+	if title == "" {
+		http.Error(w, "title required", 400)
+		return
+	}
+	coverURL, err := saveFile(form.Files["cover"])
+	if err != nil {
+		uploadErr(w, err)
+		return
+	}
+
+	p := Playlist{
+		ID: primitive.NewObjectID(), Title: title, Creator: u.Name, CreatorID: u.ID,
+		CoverURL: coverURL, Tracks: []primitive.ObjectID{},
+	}
+	if _, err := db.Collection("playlists").InsertOne(context.Background(), p); err != nil {
+		http.Error(w, "Server error", 500)
+		return
+	}
+	sign := mediaURLs(r)
+	p.Covers = signCovers(sign, p.CoverURL)
+	p.CoverURL = sign(p.CoverURL)
+	jsonOut(w, 200, p)
+}
+
+// addToPlaylistHandler adds a track to a playlist of the caller.
+func addToPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		return
+	}
+	u, ok := authorize(w, r, services.PermManagePlaylists)
+	if !ok {
+		return
+	}
+	var req struct {
+		PlaylistID string `json:"playlist_id"`
+		TrackID    string `json:"track_id"`
+	}
+	_ = json.NewDecoder(r.Body).Decode(&req)
+
+	pID, _ := primitive.ObjectIDFromHex(req.PlaylistID)
+	tID, _ := primitive.ObjectIDFromHex(req.TrackID)
+
+	if _, err := policy.Playlist(u, services.PermManagePlaylists, pID); err != nil {
+		denied(w, err)
+		return
+	}
+	if n, _ := db.Collection("tracks").CountDocuments(context.Background(), bson.M{"_id": tID}); n == 0 {
+		http.Error(w, "track not found", 404)
+		return
+	}
+
+	_, err := db.Collection("playlists").UpdateOne(context.Background(),
 		bson.M{"_id": pID},
 		bson.M{"$addToSet": bson.M{"tracks": tID}},
 	)
+	if err != nil {
+		http.Error(w, "Server error", 500)
+		return
+	}
 	jsonOut(w, 200, map[string]string{"status": "added"})
 }
 
@@ -856,6 +1944,11 @@ func contentHandler(w http.ResponseWriter, r *http.Request) {
 	cur3, _ := db.Collection("playlists").Find(context.Background(), bson.M{})
 	_ = cur3.All(context.Background(), &playlists)
 
+	sign := mediaURLs(r)
+	signAlbums(sign, albums)
+	signAlbums(sign, singles)
+	signPlaylists(sign, playlists)
+
 	resp := map[string]any{
 		"albums": func() []Album {
 			if albums == nil {
@@ -898,6 +1991,7 @@ func searchHandler(w http.ResponseWriter, r *http.Request) {
 	if tracks == nil {
 		tracks = []Track{}
 	}
+	signTracks(mediaURLs(r), tracks)
 	jsonOut(w, 200, tracks)
 }
 
@@ -934,6 +2028,9 @@ func getAlbumHandler(w http.ResponseWriter, r *http.Request) {
 	if tracks == nil {
 		tracks = []Track{}
 	}
+	sign := mediaURLs(r)
+	album.CoverURL = sign(album.CoverURL)
+	signTracks(sign, tracks)
 	jsonOut(w, 200, map[string]any{"info": album, "tracks": tracks})
 }
 
@@ -950,6 +2047,9 @@ func getPlaylistHandler(w http.ResponseWriter, r *http.Request) {
 	if tracks == nil {
 		tracks = []Track{}
 	}
+	sign := mediaURLs(r)
+	pl.CoverURL = sign(pl.CoverURL)
+	signTracks(sign, tracks)
 	jsonOut(w, 200, map[string]any{"info": pl, "tracks": tracks})
 }
 
@@ -961,6 +2061,7 @@ func getUserPlaylistsHandler(w http.ResponseWriter, r *http.Request) {
 	if playlists == nil {
 		playlists = []Playlist{}
 	}
+	signPlaylists(mediaURLs(r), playlists)
 	jsonOut(w, 200, playlists)
 }
 
@@ -972,28 +2073,73 @@ func getArtistAlbumsHandler(w http.ResponseWriter, r *http.Request) {
 	if albums == nil {
 		albums = []Album{}
 	}
+	signAlbums(mediaURLs(r), albums)
 	jsonOut(w, 200, albums)
 }
 
-func saveFile(r *http.Request, key string) string {
-	file, header, err := r.FormFile(key)
+// readUpload reads the upload form of r into blobs; the caller closes the
+// form.
+func readUpload(w http.ResponseWriter, r *http.Request, spec upload.Spec) (*upload.Form, bool) {
+	form, err := uploads.ReadForm(w, r, blobs, spec)
 	if err != nil {
-		return ""
+		uploadErr(w, err)
+		return nil, false
 	}
-	defer file.Close()
+	return form, true
+}
+
+// coverSpec is the spec of forms whose only file is a cover. A blank title
+// turns them away before the cover is read.
+var coverSpec = upload.Spec{
+	Files: map[string]upload.Kind{"cover": upload.Image},
+	Field: func(name, value string) error {
+		if name == "title" && strings.TrimSpace(value) == "" {
+			return &upload.Error{Status: 400, Msg: "title required"}
+		}
+		return nil
+	},
+}
 
-	ext := filepath.Ext(header.Filename)
-	name := fmt.Sprintf("%d_img%s", time.Now().UnixNano(), ext)
+func uploadErr(w http.ResponseWriter, err error) {
+	var ue *upload.Error
+	if errors.As(err, &ue) {
+		http.Error(w, ue.Msg, ue.Status)
+		return
+	}
+	log.Println("upload:", err)
+	http.Error(w, "cannot save file", 500)
+}
 
-	uploadDir := getUploadDir()
-	_ = os.MkdirAll(uploadDir, 0755)
+// saveFile stores the image file, which may be nil, and returns its URL.
+func saveFile(file *upload.File) (string, error) {
+	if file == nil {
+		return "", nil
+	}
+	data, err := io.ReadAll(io.NewSectionReader(file.Blob, 0, file.Size))
+	file.Blob.Abort() // PutImage stores it without its metadata
+	if err != nil {
+		return "", err
+	}
+	name, err := storage.PutImage(blobs, data, file.Format.Exts[0])
+	if err != nil {
+		return "", err
+	}
+	return "/uploads/" + name, nil
+}
 
-	dst, err := os.Create(filepath.Join(uploadDir, name))
+// saveImage stores a cover taken from the tags of an upload, if it passes
+// the same checks as an uploaded one.
+func saveImage(data []byte) string {
+	f := bytes.NewReader(data)
+	res, err := uploads.Check(f, f.Size(), "", upload.Image)
 	if err != nil {
+		log.Println("tag cover:", err)
+		return ""
+	}
+	name, err := storage.PutImage(blobs, data, res.Format.Exts[0])
+	if err != nil {
+		log.Println("tag cover:", err)
 		return ""
 	}
-	_, _ = io.Copy(dst, file)
-	_ = dst.Close()
-
 	return "/uploads/" + name
 }
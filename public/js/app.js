@@ -29,9 +29,17 @@ document.addEventListener('DOMContentLoaded', () => {
 });
 
 function init() {
+    if (finishOIDCLogin()) return;
     setupUI();
     setupAudio();
+    loadOIDCProviders();
     fetchContent().then(() => {
+        // The mailed reset link opens /reset-password?token=...; the token
+        // moves into the history state so it leaves the address bar.
+        const token = new URLSearchParams(location.search).get('token');
+        if (location.pathname === '/reset-password' && token) {
+            history.replaceState({ view: 'reset-password', token }, '', '/#reset-password');
+        }
         if (!history.state) history.replaceState({ view: 'home' }, '', '#home');
         routeFromState(history.state);
     });
@@ -144,6 +152,7 @@ function routeFromState(s) {
     else if (s.view === 'upload-track') renderUploadTrack();
     else if (s.view === 'page') openPage(s.type, s.id, true);
     else if (s.view === 'edit-lyrics') renderEditLyrics(s);
+    else if (s.view === 'reset-password') renderResetPassword(s.token);
     else renderHome();
 }
 
@@ -177,10 +186,19 @@ function renderHome() {
     lucide.createIcons();
 }
 
+// coverSrc picks the smallest cover variant of at least px pixels, or the
+// original when there is none.
+function coverSrc(item, px) {
+    const covers = item.covers || {};
+    const sizes = Object.keys(covers).map(Number).sort((a, b) => a - b);
+    const size = sizes.find(s => s >= px);
+    return (size && covers[size]) || item.cover_url || '';
+}
+
 function card(item, type) {
     return `
         <div class="card" onclick="navigateOpenPage('${type}', '${item.id}')">
-            <img src="${item.cover_url || ''}" class="c-img">
+            <img src="${coverSrc(item, 300)}" class="c-img">
             <div class="c-title">${escapeHtml(item.title || 'Untitled')}</div>
             <div class="c-sub">${escapeHtml(item.artist || item.creator || '')}</div>
         </div>
@@ -500,6 +518,57 @@ function renderEditProfile() {
     closeAll();
 }
 
+function renderResetPassword(token) {
+    const main = document.getElementById('main-view');
+    main.innerHTML = `
+        <span class="section-h" style="margin-top:8px;">Choose a New Password</span>
+        <div class="upload-container">
+            <form onsubmit="handleResetPassword(event, '${escapeAttr(token || '')}')" class="modal-form">
+                <input type="password" name="password" placeholder="New password" required class="upload-field">
+                <button type="submit" class="submit-btn">Save</button>
+            </form>
+            <div id="reset-msg" class="hidden auth-error"></div>
+        </div>
+    `;
+    lucide.createIcons();
+    closeAll();
+}
+
+async function handleResetPassword(e, token) {
+    e.preventDefault();
+    const fd = new FormData(e.target);
+    const res = await fetch(`${API_URL}/password/reset`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token, password: fd.get('password') })
+    });
+    if (!res.ok) {
+        const box = document.getElementById('reset-msg');
+        box.innerText = ((await res.text()) || 'Could not change the password').trim();
+        box.classList.remove('hidden');
+        return;
+    }
+    // A reset signs out every session, this one included.
+    localStorage.removeItem('user');
+    state.user = null;
+    history.replaceState({ view: 'home' }, '', '#home');
+    renderHome();
+    openModal('auth-modal');
+}
+
+// publishFailed tells the user why an upload or a new album was refused,
+// offering a new verification mail when that is the reason.
+async function publishFailed(res) {
+    const msg = ((await res.text()) || 'Upload failed').trim();
+    if (msg !== 'email not verified') {
+        alert(msg);
+        return;
+    }
+    if (confirm('Confirm your email first. Send the link again?')) {
+        await authFetch(`${API_URL}/verify-email/resend`, { method: 'POST' });
+    }
+}
+
 function fileCheck(input, id) {
     if (input.files && input.files[0]) {
         const el = document.getElementById(id);
@@ -510,9 +579,8 @@ function fileCheck(input, id) {
 async function handleCreateAlbum(e) {
     e.preventDefault();
     const fd = new FormData(e.target);
-    fd.append('artist_id', state.user.id);
-    fd.append('artist_name', state.user.name);
-    await fetch(`${API_URL}/create-album`, { method: 'POST', body: fd });
+    const res = await authFetch(`${API_URL}/create-album`, { method: 'POST', body: fd });
+    if (!res.ok) return publishFailed(res);
     await fetchContent();
     navigateLibrary();
 }
@@ -520,9 +588,8 @@ async function handleCreateAlbum(e) {
 async function handleUploadTrack(e) {
     e.preventDefault();
     const fd = new FormData(e.target);
-    fd.append('artist_id', state.user.id);
-    fd.append('artist_name', state.user.name);
-    await fetch(`${API_URL}/upload-track`, { method: 'POST', body: fd });
+    const res = await authFetch(`${API_URL}/upload-track`, { method: 'POST', body: fd });
+    if (!res.ok) return publishFailed(res);
     await fetchContent();
     navigateHome();
 }
@@ -530,9 +597,7 @@ async function handleUploadTrack(e) {
 async function handleCreatePlaylist(e) {
     e.preventDefault();
     const fd = new FormData(e.target);
-    fd.append('creator', state.user.name);
-    fd.append('creator_id', state.user.id);
-    await fetch(`${API_URL}/create-playlist`, { method: 'POST', body: fd });
+    await authFetch(`${API_URL}/create-playlist`, { method: 'POST', body: fd });
     closeModal('add-modal');
     await fetchContent();
     navigateLibrary();
@@ -541,28 +606,62 @@ async function handleCreatePlaylist(e) {
 async function handleUpdateProfile(e) {
     e.preventDefault();
     const fd = new FormData(e.target);
-    const data = { id: state.user.id, name: fd.get('name'), bio: fd.get('bio') };
+    const data = { name: fd.get('name'), bio: fd.get('bio') };
 
-    const res = await fetch(`${API_URL}/update-profile`, {
+    const res = await authFetch(`${API_URL}/update-profile`, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify(data)
     });
 
+    if (!res.ok) {
+        alert("Could not update the profile");
+        return;
+    }
     const u = await res.json();
-    localStorage.setItem('user', JSON.stringify(u));
-    state.user = u;
+    state.user = { ...u, token: state.user.token, refresh_token: state.user.refresh_token };
+    localStorage.setItem('user', JSON.stringify(state.user));
     setupUI();
     navigateHome();
 }
 
+// authFetch sends the access token from login and refreshes it once when the
+// server answers 401.
+async function authFetch(url, opts = {}) {
+    const send = () => fetch(url, {
+        ...opts,
+        headers: { ...(opts.headers || {}), 'Authorization': `Bearer ${state.user && state.user.token}` }
+    });
+    let res = await send();
+    if (res.status !== 401 || !state.user || !state.user.refresh_token) return res;
+
+    const r = await fetch(`${API_URL}/token/refresh`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ refresh_token: state.user.refresh_token })
+    });
+    if (!r.ok) return res;
+    const t = await r.json();
+    state.user = { ...state.user, token: t.token, refresh_token: t.refresh_token };
+    localStorage.setItem('user', JSON.stringify(state.user));
+    return send();
+}
+
 async function deleteTrack(id) {
     if (!confirm("Delete this track?")) return;
-    await fetch(`${API_URL}/delete-track`, {
+    const res = await authFetch(`${API_URL}/delete-track`, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify({ id })
     });
+    if (res.status === 401) {
+        alert("Please log in again");
+        return;
+    }
+    if (!res.ok) {
+        alert("You can't delete this track");
+        return;
+    }
     await fetchContent();
     navigateLibrary();
 }
@@ -583,7 +682,7 @@ async function handleSearch(q) {
     container.innerHTML = (tracks || []).map((t, i) => `
         <div class="playlist-item-add" style="justify-content:space-between;">
             <div onclick="playQueue(${i}, '${encodeURIComponent(JSON.stringify(tracks))}')" style="flex:1;display:flex;align-items:center;gap:12px;cursor:pointer;min-width:0;">
-                <img src="${t.cover_url || ''}" style="width:46px;height:46px;border-radius:16px;object-fit:cover;border:1px solid var(--stroke);">
+                <img src="${coverSrc(t, 64)}" style="width:46px;height:46px;border-radius:16px;object-fit:cover;border:1px solid var(--stroke);">
                 <div style="min-width:0;">
                     <div style="font-weight:1100;white-space:nowrap;overflow:hidden;text-overflow:ellipsis;">${escapeHtml(t.title || '')}</div>
                     <div style="font-size:12px;font-weight:900;color:var(--muted2);white-space:nowrap;overflow:hidden;text-overflow:ellipsis;">${escapeHtml(t.artist || '')}</div>
@@ -615,7 +714,7 @@ async function openPage(type, id, fromRoute = false) {
         </div>
 
         <div class="upload-container" style="margin-top:6px;text-align:center;">
-            <img src="${info.cover_url || ''}" style="width:200px;height:200px;border-radius:26px;box-shadow:0 34px 120px rgba(0,0,0,0.55);object-fit:cover;border:1px solid var(--stroke);">
+            <img src="${coverSrc(info, 400)}" style="width:200px;height:200px;border-radius:26px;box-shadow:0 34px 120px rgba(0,0,0,0.55);object-fit:cover;border:1px solid var(--stroke);">
             <h2 style="margin:16px 0 0;font-size:24px;line-height:1.2;font-weight:1150;">${escapeHtml(info.title || '')}</h2>
             <p style="margin:6px 0 0;font-weight:900;color:var(--muted);">${escapeHtml(info.artist || info.creator || '')}</p>
             <p style="margin:6px 0 0;font-size:12px;font-weight:900;color:var(--muted2);">${type === 'album' ? (info.is_single ? 'Single' : 'Album') : 'Playlist'}</p>
@@ -724,7 +823,7 @@ function loadTrack(t) {
     if (!t) return;
     state.currentTrackId = t.id;
     state.lastOpenedTrack = t;
-    audio.src = t.audio_url;
+    audio.src = t.stream_url || t.audio_url;
     audio.play();
     state.isPlaying = true;
     updatePlayerUI(t);
@@ -774,10 +873,10 @@ function updatePlayerUI(t) {
     const fpBg = document.getElementById('fp-bg');
     const lyricBg = document.getElementById('lyric-bg');
 
-    if (mpCover) mpCover.src = t.cover_url || '';
-    if (fpCover) fpCover.src = t.cover_url || '';
-    if (fpBg) fpBg.src = t.cover_url || '';
-    if (lyricBg) lyricBg.src = t.cover_url || '';
+    if (mpCover) mpCover.src = coverSrc(t, 64);
+    if (fpCover) fpCover.src = coverSrc(t, 640);
+    if (fpBg) fpBg.src = coverSrc(t, 300);
+    if (lyricBg) lyricBg.src = coverSrc(t, 300);
 
     const mpTitle = document.getElementById('mp-title');
     const mpArtist = document.getElementById('mp-artist');
@@ -1039,7 +1138,7 @@ async function openAddToPlaylistModal(trackId) {
 
     list.innerHTML = playlists.map(p => `
         <div onclick="addToPlaylist('${p.id}')" class="playlist-item-add">
-            <img src="${p.cover_url || ''}">
+            <img src="${coverSrc(p, 64)}">
             <span style="font-weight:1100;">${escapeHtml(p.title || 'Untitled')}</span>
         </div>
     `).join('');
@@ -1049,7 +1148,7 @@ async function openAddToPlaylistModal(trackId) {
 }
 
 async function addToPlaylist(playlistId) {
-    await fetch(`${API_URL}/add-to-playlist`, {
+    await authFetch(`${API_URL}/add-to-playlist`, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify({ playlist_id: playlistId, track_id: state.currentTrackId })
@@ -1112,7 +1211,10 @@ async function doAuth(type) {
         });
 
         if (res.ok) {
-            const u = await res.json();
+            let u = await res.json();
+            if (u.mfa_required) u = await finishMFALogin(u.mfa_token);
+            if (!u) return;
+            if (u.mfa_setup_required) alert('Your role needs two-factor authentication. Turn it on in your profile.');
             localStorage.setItem('user', JSON.stringify(u));
             location.reload();
         } else {
@@ -1126,7 +1228,114 @@ async function doAuth(type) {
     }
 }
 
-function logout() {
+// loadOIDCProviders adds a sign-in button for every identity provider the
+// server has, which goes through /api/oidc/{name}/login.
+async function loadOIDCProviders() {
+    const box = document.getElementById('oidc-providers');
+    const res = await fetch(`${API_URL}/oidc/providers`).catch(() => null);
+    if (!box || !res || !res.ok) return;
+    const { providers } = await res.json();
+    if (!providers || !providers.length) return;
+    box.innerHTML = providers.map(p => `
+        <button type="button" class="glass-ghost" onclick="location.href='${API_URL}/oidc/${encodeURIComponent(p)}/login'">
+            Continue with ${escapeHtml(p)}
+        </button>
+    `).join('');
+    box.classList.remove('hidden');
+}
+
+// finishOIDCLogin keeps the login that the OIDC callback put in the
+// fragment as "oidc", or shows why it failed. It returns true while the page
+// is about to reload.
+function finishOIDCLogin() {
+    const params = new URLSearchParams(location.hash.slice(1));
+    const login = params.get('oidc');
+    const error = params.get('oidc_error');
+    if (login === null && error === null) return false;
+    history.replaceState(null, '', '/');
+    if (error !== null) {
+        alert(`Sign-in failed: ${error}`);
+        return false;
+    }
+    (async () => {
+        let u = JSON.parse(login);
+        if (u.mfa_required) u = await finishMFALogin(u.mfa_token);
+        if (!u) return location.reload();
+        if (u.mfa_setup_required) alert('Your role needs two-factor authentication. Turn it on in your profile.');
+        localStorage.setItem('user', JSON.stringify(u));
+        location.reload();
+    })();
+    return true;
+}
+
+// finishMFALogin asks for the code of a login that answered mfa_required
+// and returns the signed-in user, or null after showing why not.
+async function finishMFALogin(token) {
+    const errorBox = document.getElementById('auth-error');
+    const code = prompt('Code from your authenticator app or a recovery code');
+    if (!code) return null;
+    const res = await fetch(`${API_URL}/login/2fa`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ mfa_token: token, code })
+    });
+    if (res.ok) return res.json();
+    errorBox.innerText = ((await res.text()) || 'Auth error').replace(/["\n]/g, '');
+    errorBox.classList.remove('hidden');
+    return null;
+}
+
+// toggleTwoFactor turns two-factor authentication on, showing the secret
+// for the authenticator app and then the recovery codes, or off again.
+async function toggleTwoFactor() {
+    if (!state.user) return;
+    const post = (path, body) => authFetch(`${API_URL}/2fa/${path}`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(body || {})
+    });
+    let res;
+    if (state.user.totp_enabled) {
+        const code = prompt('Enter a code to turn two-factor authentication off');
+        if (!code) return;
+        res = await post('disable', { code });
+    } else {
+        res = await post('setup');
+        if (!res.ok) return alert((await res.text()).trim());
+        const s = await res.json();
+        const code = prompt(`Add this key to your authenticator app, then enter the code it shows:\n\n${s.secret}`);
+        if (!code) return;
+        res = await post('enable', { code });
+    }
+    if (!res.ok) return alert((await res.text()).trim());
+    const out = await res.json();
+    if (out.recovery_codes) alert(`Keep these recovery codes somewhere safe:\n\n${out.recovery_codes.join('\n')}`);
+    state.user = { ...state.user, totp_enabled: !state.user.totp_enabled, mfa_setup_required: false };
+    localStorage.setItem('user', JSON.stringify(state.user));
+}
+
+async function forgotPassword() {
+    const email = new FormData(document.getElementById('auth-form')).get('email');
+    const errorBox = document.getElementById('auth-error');
+    if (!email) {
+        errorBox.innerText = 'Enter your email first';
+        errorBox.classList.remove('hidden');
+        return;
+    }
+    const res = await fetch(`${API_URL}/password/forgot`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ email })
+    });
+    errorBox.innerText = res.ok ? 'If the account exists, a reset link was sent' : 'Try again later';
+    errorBox.classList.remove('hidden');
+}
+
+async function logout() {
+    if (state.user && state.user.token) {
+        // Signing out locally goes ahead whatever the server says.
+        await authFetch(`${API_URL}/logout`, { method: 'POST' }).catch(() => {});
+    }
     localStorage.removeItem('user');
     location.reload();
 }
@@ -1281,9 +1490,9 @@ async function saveTimedLyrics(trackId) {
     }
 
     const timed = document.getElementById('lyr-timed')?.value || '';
-    const payload = { track_id: trackId, user_id: state.user.id, lyrics: timed };
+    const payload = { track_id: trackId, lyrics: timed };
 
-    const res = await fetch(`${API_URL}/update-lyrics`, {
+    const res = await authFetch(`${API_URL}/update-lyrics`, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
         body: JSON.stringify(payload)